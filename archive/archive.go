@@ -0,0 +1,216 @@
+// Package archive indexes zip and tar archives so single members can be read
+// without unpacking the whole archive.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Format is an archive format.
+type Format int
+
+const (
+	Zip     Format = iota // .zip and zip-based packages such as .apk and .ipa
+	Tar                   // uncompressed .tar
+	TarGzip               // .tar.gz or .tgz
+)
+
+// zipExtensions are zip-based package formats
+var zipExtensions = map[string]bool{
+	".zip": true, ".apk": true, ".aab": true, ".apks": true, ".xapk": true,
+	".aar": true, ".jar": true, ".ipa": true,
+}
+
+// FormatOf returns the archive format of a file name, judged by its
+// extension.
+func FormatOf(name string) (Format, bool) {
+	lower := strings.ToLower(name)
+	switch {
+	case zipExtensions[path.Ext(lower)]:
+		return Zip, true
+	case strings.HasSuffix(lower, ".tar"):
+		return Tar, true
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return TarGzip, true
+	}
+	return 0, false
+}
+
+// Member is a regular file in an archive.
+type Member struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+
+	offset         int64  // of the data in the archive, or in the decompressed stream for TarGzip
+	compressedSize int64  // Zip only
+	method         uint16 // Zip only
+}
+
+// Index lists the members of an archive and where their data is. It holds no
+// reference to the archive itself, so it can be kept after the archive is
+// closed and used with any reader over the same bytes.
+type Index struct {
+	format  Format
+	members []Member // sorted by name
+}
+
+// ReadIndex reads the index of an archive of the given format, size bytes
+// long. For zip archives only the central directory is read; tar archives
+// are read in full.
+func ReadIndex(r io.ReaderAt, size int64, format Format) (*Index, error) {
+	var (
+		members []Member
+		err     error
+	)
+	switch format {
+	case Zip:
+		members, err = zipIndex(r, size)
+	case Tar:
+		members, err = tarIndex(io.NewSectionReader(r, 0, size), false)
+	case TarGzip:
+		members, err = tarIndex(io.NewSectionReader(r, 0, size), true)
+	default:
+		err = fmt.Errorf("unknown archive format %d", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return &Index{format: format, members: members}, nil
+}
+
+// Members returns every regular file in the archive, sorted by name.
+func (x *Index) Members() []Member {
+	return x.members
+}
+
+// Lookup returns the member with the given name. A leading slash is
+// ignored.
+func (x *Index) Lookup(name string) (Member, bool) {
+	name = strings.TrimPrefix(name, "/")
+	i := sort.Search(len(x.members), func(i int) bool { return x.members[i].Name >= name })
+	if i < len(x.members) && x.members[i].Name == name {
+		return x.members[i], true
+	}
+	return Member{}, false
+}
+
+// Open returns the contents of m, read from r, the archive the index was
+// made from. Members stored without compression are returned as an
+// *io.SectionReader, so they can be seeked.
+func (x *Index) Open(r io.ReaderAt, m Member) (io.Reader, error) {
+	switch x.format {
+	case Zip:
+		data := io.NewSectionReader(r, m.offset, m.compressedSize)
+		switch m.method {
+		case zip.Store:
+			return data, nil
+		case zip.Deflate:
+			return io.LimitReader(flate.NewReader(data), m.Size), nil
+		}
+		return nil, fmt.Errorf("unsupported compression method %d for %s", m.method, m.Name)
+
+	case Tar:
+		return io.NewSectionReader(r, m.offset, m.Size), nil
+
+	case TarGzip:
+		// gzip can't be read from the middle, so decompress up to the member
+		gz, err := gzip.NewReader(io.NewSectionReader(r, 0, 1<<63-1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		if _, err := io.CopyN(io.Discard, gz, m.offset); err != nil {
+			return nil, fmt.Errorf("failed to seek to %s: %w", m.Name, err)
+		}
+		return io.LimitReader(gz, m.Size), nil
+	}
+	return nil, fmt.Errorf("unknown archive format %d", x.format)
+}
+
+func zipIndex(r io.ReaderAt, size int64) ([]Member, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zip directory: %w", err)
+	}
+
+	members := make([]Member, 0, len(zr.File))
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate %s: %w", f.Name, err)
+		}
+		members = append(members, Member{
+			Name:           strings.TrimPrefix(f.Name, "/"),
+			Size:           int64(f.UncompressedSize64),
+			Modified:       f.Modified,
+			offset:         offset,
+			compressedSize: int64(f.CompressedSize64),
+			method:         f.Method,
+		})
+	}
+	return members, nil
+}
+
+func tarIndex(sr *io.SectionReader, compressed bool) ([]Member, error) {
+	// The tar reader reads whole blocks and nothing ahead, so the position
+	// in the (decompressed) stream after each header is where its data starts
+	var (
+		stream io.Reader = sr
+		pos              = func() int64 { n, _ := sr.Seek(0, io.SeekCurrent); return n }
+	)
+	if compressed {
+		gz, err := gzip.NewReader(sr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		counter := &countingReader{r: gz}
+		stream, pos = counter, func() int64 { return counter.n }
+	}
+
+	var members []Member
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		members = append(members, Member{
+			Name:     strings.TrimPrefix(path.Clean(header.Name), "/"),
+			Size:     header.Size,
+			Modified: header.ModTime,
+			offset:   pos(),
+		})
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/server"
+)
+
+// runBench measures how fast the cache directory can be written and read,
+// and how fast objects download from S3, so a new host can be checked
+// before it is put in rotation.
+func runBench(ctx context.Context, args []string) {
+	flagSet, flags := newFlagSet("bench")
+	sizeMB := flagSet.Int("size-mb", 1024, "size of the file written to and read from the cache directory")
+	key := flagSet.String("key", "", "S3 object to download, as bucket/path; skips the S3 benchmark if empty")
+	parallel := flagSet.Int("parallel", 4, "concurrent downloads of the S3 object")
+	flagSet.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("cache directory %s\n", cfg.Cache.Dir)
+	write, read, err := benchDisk(cfg.Cache.Dir, int64(*sizeMB)*1024*1024)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "disk benchmark failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("  write  %8.1f MB/s  (%d MB, flushed to disk)\n", write, *sizeMB)
+	fmt.Printf("  read   %8.1f MB/s  (from the page cache, unless the file doesn't fit in memory)\n", read)
+
+	if *key == "" {
+		return
+	}
+	fmt.Printf("\nS3 %s\n", *key)
+	awsCfg, err := server.LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load AWS config: %v\n", err)
+		os.Exit(1)
+	}
+	downloader := cache.NewS3Downloader(awsCfg, server.DownloaderOptions(cfg)...)
+
+	for _, n := range []int{1, max(*parallel, 1)} {
+		result, err := benchS3(ctx, downloader, *key, n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "S3 benchmark failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("  %d at once  %8.1f MB/s  (%.1f MB in %v, first byte after %v)\n",
+			n, result.mbps, float64(result.bytes)/(1024*1024), result.elapsed.Round(time.Millisecond), result.firstByte.Round(time.Millisecond))
+		if *parallel <= 1 {
+			break
+		}
+	}
+}
+
+// benchDisk writes a file of size bytes in dir, flushing it to disk, then
+// reads it back, and returns the throughput of each in MB/s
+func benchDisk(dir string, size int64) (write, read float64, err error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, 0, err
+	}
+	f, err := os.CreateTemp(dir, ".bench-*")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// Random data, so compressing filesystems can't flatter the result
+	buf := make([]byte, 4*1024*1024)
+	rand.Read(buf)
+
+	start := time.Now()
+	for written := int64(0); written < size; {
+		n := min(int64(len(buf)), size-written)
+		if _, err := f.Write(buf[:n]); err != nil {
+			return 0, 0, err
+		}
+		written += n
+	}
+	if err := f.Sync(); err != nil {
+		return 0, 0, err
+	}
+	write = mbPerSecond(size, time.Since(start))
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	start = time.Now()
+	n, err := io.CopyBuffer(io.Discard, f, buf)
+	if err != nil {
+		return 0, 0, err
+	}
+	read = mbPerSecond(n, time.Since(start))
+	return write, read, nil
+}
+
+// s3Bench is the outcome of downloading an object
+type s3Bench struct {
+	bytes     int64
+	elapsed   time.Duration
+	firstByte time.Duration // until the slowest download's response started
+	mbps      float64
+}
+
+// benchS3 downloads key n times at once and returns the combined throughput
+func benchS3(ctx context.Context, d *cache.S3Downloader, key string, n int) (s3Bench, error) {
+	var (
+		wg        sync.WaitGroup
+		total     atomic.Int64
+		mu        sync.Mutex
+		firstByte time.Duration
+		firstErr  error
+	)
+	start := time.Now()
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			written, err := func() (int64, error) {
+				body, _, err := d.Download(ctx, key)
+				if err != nil {
+					return 0, err
+				}
+				defer body.Close()
+				mu.Lock()
+				firstByte = max(firstByte, time.Since(start))
+				mu.Unlock()
+				return io.Copy(io.Discard, body)
+			}()
+			total.Add(written)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return s3Bench{}, firstErr
+	}
+
+	elapsed := time.Since(start)
+	return s3Bench{bytes: total.Load(), elapsed: elapsed, firstByte: firstByte, mbps: mbPerSecond(total.Load(), elapsed)}, nil
+}
+
+func mbPerSecond(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) / (1024 * 1024) / elapsed.Seconds()
+}
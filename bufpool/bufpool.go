@@ -0,0 +1,38 @@
+// Package bufpool shares copy buffers between transfers, so hundreds of
+// concurrent downloads and responses don't each allocate their own and
+// leave them for the garbage collector.
+package bufpool
+
+import (
+	"io"
+	"sync"
+)
+
+// Size is the size of pooled buffers. It is larger than io.Copy's 32 KB so
+// large transfers take fewer read and write calls.
+const Size = 256 << 10
+
+var buffers = sync.Pool{New: func() any {
+	buf := make([]byte, Size)
+	return &buf
+}}
+
+// Get returns a buffer of Size bytes. Return it with Put once done.
+func Get() *[]byte {
+	return buffers.Get().(*[]byte)
+}
+
+// Put returns a buffer from Get to the pool.
+func Put(buf *[]byte) {
+	buffers.Put(buf)
+}
+
+// Copy is like io.Copy, but copies through a pooled buffer. It doesn't use
+// dst's ReadFrom or src's WriteTo, which for files and network connections
+// fall back to allocating a buffer of their own when they can't use a system
+// call like sendfile. Callers that can use one should call io.Copy instead.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := Get()
+	defer Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
@@ -0,0 +1,88 @@
+// Package buildinfo reports which build of midway is running. Release builds
+// set the version, commit and build date with the linker:
+//
+//	go build -ldflags "-X github.com/autonoma-ai/midway/buildinfo.Version=v1.4.0 \
+//	  -X github.com/autonoma-ai/midway/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/autonoma-ai/midway/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" .
+//
+// Builds without them fall back to the VCS information the Go toolchain
+// embeds when building from a git checkout.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set with -ldflags "-X ..." at build time.
+var (
+	Version = ""
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running build.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`               // when it was built, or committed if unknown
+	Modified  bool   `json:"modified,omitempty"` // built from a checkout with uncommitted changes
+	GoVersion string `json:"goVersion"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the running build's information. Unknown fields are "unknown".
+func Get() Info {
+	once.Do(func() {
+		info = Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+				info.Version = bi.Main.Version
+			}
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					if info.Commit == "" {
+						info.Commit = s.Value
+					}
+				case "vcs.time":
+					if info.Date == "" {
+						info.Date = s.Value
+					}
+				case "vcs.modified":
+					info.Modified = s.Value == "true" && Commit == ""
+				}
+			}
+		}
+
+		for _, field := range []*string{&info.Version, &info.Commit, &info.Date} {
+			if *field == "" {
+				*field = "unknown"
+			}
+		}
+	})
+	return info
+}
+
+// Short returns the commit abbreviated to 12 characters.
+func (i Info) Short() string {
+	if len(i.Commit) > 12 {
+		return i.Commit[:12]
+	}
+	return i.Commit
+}
+
+// String describes the build in one line, e.g. for startup logs.
+func (i Info) String() string {
+	s := i.Version + " (commit " + i.Short()
+	if i.Modified {
+		s += "-dirty"
+	}
+	return s + ", built " + i.Date + ", " + i.GoVersion + ")"
+}
@@ -0,0 +1,140 @@
+package cache
+
+import "container/list"
+
+// ARCPolicy implements Adaptive Replacement Cache eviction. Entries seen once
+// live in T1 and entries seen more than once in T2; keys recently evicted from
+// each are remembered in ghost lists B1 and B2. A hit on a ghost key shifts the
+// target size p of T1, so the policy adapts between recency and frequency.
+// Sizes are counted in entries, not bytes; the cache still decides when to
+// evict based on its byte limit.
+type ARCPolicy struct {
+	t1, t2, b1, b2 *arcList
+	p              int // target number of entries in T1
+}
+
+// NewARCPolicy creates an empty adaptive replacement policy.
+func NewARCPolicy() *ARCPolicy {
+	return &ARCPolicy{
+		t1: newARCList(),
+		t2: newARCList(),
+		b1: newARCList(),
+		b2: newARCList(),
+	}
+}
+
+func (p *ARCPolicy) Name() string { return "arc" }
+
+func (p *ARCPolicy) Add(entry *Entry) {
+	key := entry.Key
+
+	switch {
+	case p.t1.contains(key) || p.t2.contains(key):
+		p.Access(entry)
+		return
+	case p.b1.contains(key):
+		// Recently evicted after one use: favor recency
+		p.p = min(p.p+max(1, p.b2.len()/max(1, p.b1.len())), p.capacity())
+		p.b1.remove(key)
+		p.t2.pushFront(key)
+	case p.b2.contains(key):
+		// Recently evicted after repeated use: favor frequency
+		p.p = max(p.p-max(1, p.b1.len()/max(1, p.b2.len())), 0)
+		p.b2.remove(key)
+		p.t2.pushFront(key)
+	default:
+		p.t1.pushFront(key)
+	}
+
+	p.trimGhosts()
+}
+
+func (p *ARCPolicy) Access(entry *Entry) {
+	key := entry.Key
+	if p.t1.contains(key) {
+		p.t1.remove(key)
+		p.t2.pushFront(key)
+		return
+	}
+	if p.t2.contains(key) {
+		p.t2.moveToFront(key)
+	}
+}
+
+func (p *ARCPolicy) Remove(key string) {
+	p.t1.remove(key)
+	p.t2.remove(key)
+}
+
+func (p *ARCPolicy) Evict() (string, bool) {
+	if p.t1.len() > 0 && (p.t1.len() > p.p || p.t2.len() == 0) {
+		key := p.t1.popBack()
+		p.b1.pushFront(key)
+		p.trimGhosts()
+		return key, true
+	}
+	if p.t2.len() > 0 {
+		key := p.t2.popBack()
+		p.b2.pushFront(key)
+		p.trimGhosts()
+		return key, true
+	}
+	return "", false
+}
+
+// capacity is the number of live entries, which bounds p and each ghost list
+func (p *ARCPolicy) capacity() int {
+	return max(1, p.t1.len()+p.t2.len())
+}
+
+func (p *ARCPolicy) trimGhosts() {
+	c := p.capacity()
+	for p.b1.len() > c {
+		p.b1.popBack()
+	}
+	for p.b2.len() > c {
+		p.b2.popBack()
+	}
+}
+
+// arcList is an ordered set of keys (front = most recent)
+type arcList struct {
+	order *list.List
+	elems map[string]*list.Element
+}
+
+func newARCList() *arcList {
+	return &arcList{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (l *arcList) len() int { return l.order.Len() }
+
+func (l *arcList) contains(key string) bool {
+	_, ok := l.elems[key]
+	return ok
+}
+
+func (l *arcList) pushFront(key string) {
+	l.elems[key] = l.order.PushFront(key)
+}
+
+func (l *arcList) moveToFront(key string) {
+	if elem, ok := l.elems[key]; ok {
+		l.order.MoveToFront(elem)
+	}
+}
+
+func (l *arcList) remove(key string) {
+	if elem, ok := l.elems[key]; ok {
+		l.order.Remove(elem)
+		delete(l.elems, key)
+	}
+}
+
+func (l *arcList) popBack() string {
+	elem := l.order.Back()
+	key := elem.Value.(string)
+	l.order.Remove(elem)
+	delete(l.elems, key)
+	return key
+}
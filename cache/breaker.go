@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// ErrCircuitOpen is returned, without contacting S3, for requests to a
+// bucket whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitOpenError is returned for requests to a bucket while its circuit
+// breaker is open.
+type CircuitOpenError struct {
+	Bucket     string
+	RetryAfter time.Duration // until the breaker lets a request through again
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("S3 is failing for bucket %s; not trying again for %v", e.Bucket, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool { return target == ErrCircuitOpen }
+
+// Breaker states, as reported by BreakerStats
+const (
+	BreakerClosed   = "closed"    // requests go to S3
+	BreakerOpen     = "open"      // requests fail fast until the cool-down ends
+	BreakerHalfOpen = "half-open" // one request is trying S3 again
+)
+
+// BreakerStats describes the circuit breaker of a bucket.
+type BreakerStats struct {
+	State     string    `json:"state"`
+	Failures  int       `json:"failures"` // consecutive requests S3 failed to answer
+	OpenUntil time.Time `json:"openUntil,omitzero"`
+	Trips     int64     `json:"trips"` // times the breaker opened since startup
+}
+
+// breakers fail requests to a bucket fast once S3 fails threshold requests
+// to it in a row, so requests don't pile up waiting on timeouts during an
+// outage. After coolDown, a single request is let through to try S3 again:
+// success closes the breaker, failure keeps it open for another coolDown.
+type breakers struct {
+	threshold int
+	coolDown  time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*breaker // buckets that have failed since startup
+}
+
+// breaker tracks one bucket
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool // a request is trying S3 after the cool-down
+	trips     int64
+}
+
+// WithCircuitBreaker fails requests to a bucket fast for coolDown once
+// threshold requests to it in a row failed because S3 couldn't be reached,
+// as judged by Unavailable. Failures such as a missing object don't count.
+func WithCircuitBreaker(threshold int, coolDown time.Duration) DownloaderOption {
+	return func(d *S3Downloader) {
+		d.breakers = &breakers{threshold: threshold, coolDown: coolDown, buckets: make(map[string]*breaker)}
+	}
+}
+
+// allow returns a *CircuitOpenError if requests to bucket are to fail fast.
+// A request it lets through must be followed by a call to record.
+func (b *breakers) allow(bucket string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.buckets[bucket]
+	if !ok || br.openUntil.IsZero() {
+		return nil
+	}
+	if wait := time.Until(br.openUntil); wait > 0 || br.probing {
+		return &CircuitOpenError{Bucket: bucket, RetryAfter: max(wait, time.Second)}
+	}
+	br.probing = true
+	return nil
+}
+
+// record counts the outcome of a request to bucket that allow let through
+func (b *breakers) record(bucket string, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br, ok := b.buckets[bucket]
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Says nothing about S3, but lets another request try it
+		if ok {
+			br.probing = false
+		}
+		return
+	case !Unavailable(err):
+		// S3 answered, even if with an error such as a missing object
+		if ok && !br.openUntil.IsZero() {
+			logger.Info().Emitf("S3 is answering for bucket %s again, closing its circuit breaker", bucket)
+		}
+		if ok {
+			br.failures, br.openUntil, br.probing = 0, time.Time{}, false
+		}
+		return
+	case !ok:
+		br = &breaker{}
+		b.buckets[bucket] = br
+	}
+
+	br.failures++
+	if br.probing || (br.openUntil.IsZero() && br.failures >= b.threshold) {
+		if br.openUntil.IsZero() {
+			br.trips++
+			logger.Warn().Emitf("S3 failed %d requests in a row for bucket %s, failing fast for %v: %v", br.failures, bucket, b.coolDown, err)
+		}
+		br.openUntil = time.Now().Add(b.coolDown)
+		br.probing = false
+	}
+}
+
+// stats returns the state of the breaker of every bucket that has failed
+func (b *breakers) stats() map[string]BreakerStats {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := make(map[string]BreakerStats, len(b.buckets))
+	for bucket, br := range b.buckets {
+		s := BreakerStats{State: BreakerClosed, Failures: br.failures, Trips: br.trips}
+		switch {
+		case br.probing:
+			s.State = BreakerHalfOpen
+		case !br.openUntil.IsZero():
+			s.State, s.OpenUntil = BreakerOpen, br.openUntil
+		}
+		stats[bucket] = s
+	}
+	return stats
+}
+
+// Breakers returns the state of the circuit breaker of each bucket S3 has
+// failed requests for, or nil if circuit breaking is disabled.
+func (d *S3Downloader) Breakers() map[string]BreakerStats {
+	return d.breakers.stats()
+}
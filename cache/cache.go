@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Cache is the store the handler serves files from. DiskLRUCache keeps
+// entries on disk across restarts; MemoryCache keeps them in RAM for tests
+// and short-lived jobs that don't want disk state.
+//
+// Get, Put and the other methods storing or looking up entries return a
+// reference to the cached contents, which only Open of the same cache can
+// read. For DiskLRUCache it is the path of the file on disk.
+type Cache interface {
+	// Lookups
+	Get(key string) (string, bool)
+	GetStale(key string) (string, bool)
+	GetFromMemory(key string) ([]byte, time.Time, bool)
+	Open(ref string) (File, error)
+	OpenChunked(ctx context.Context, d *S3Downloader, key string, info ObjectInfo, chunkSize int64) *ChunkedObject
+	Peek(key string) (Entry, bool)
+	Contains(key string) bool
+	Entries() []Entry
+	Size() int64
+	GetStats() Stats
+
+	// Storing entries
+	Put(ctx context.Context, key string, data io.Reader) (string, error)
+	PutDerived(ctx context.Context, key string, data io.Reader) (string, error)
+	PutResumable(ctx context.Context, key string, info ObjectInfo, offset int64, data io.Reader) (string, error)
+	Partial(key string) (offset int64, etag string)
+	DiscardPartial(key string)
+	SetSHA256(key, digest string) bool
+	Verifies(key string) bool
+
+	// Removing entries and limits
+	Remove(key string) bool
+	Clear() int
+	Resize(maxSizeBytes int64) int
+	SetMaxEntries(n int) int
+	Pin(key string) bool
+	Unpin(key string) bool
+
+	// Trash
+	TrashEnabled() bool
+	Trash(key string) bool
+	RestoreTrashed(key string) (bool, error)
+	TrashedEntries() []TrashedEntry
+
+	// Cold tier
+	ColdEntries() []Entry
+
+	// Namespaces
+	SetNamespaceLimits(limits map[string]int64) int
+	NamespaceStats(name string) NamespaceStats
+	Namespaces() map[string]NamespaceStats
+
+	Subscribe(fn func(Event)) (unsubscribe func())
+}
+
+var (
+	_ Cache = (*DiskLRUCache)(nil)
+	_ Cache = (*MemoryCache)(nil)
+)
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// ErrChecksumMismatch is returned when a downloaded object does not match the
+// checksum S3 reported for it. The download is discarded and not cached.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum is the expected digest of an object's full contents.
+type Checksum struct {
+	Algorithm string `json:"algorithm"` // SHA256, SHA1, CRC32C, CRC32 or MD5
+	Value     string `json:"value"`     // base64-encoded digest, as S3 reports it
+}
+
+// checksumFromResponse picks the strongest full-object checksum in a
+// GetObject response. For objects without an additional checksum, the ETag
+// is the MD5 of the contents unless the object was uploaded in parts or is
+// encrypted with SSE-KMS or SSE-C. Returns the zero Checksum if there is
+// nothing to verify against.
+func checksumFromResponse(out *s3.GetObjectOutput) Checksum {
+	if out.ChecksumType != types.ChecksumTypeComposite {
+		candidates := []Checksum{
+			{"SHA256", aws.ToString(out.ChecksumSHA256)},
+			{"SHA1", aws.ToString(out.ChecksumSHA1)},
+			{"CRC32C", aws.ToString(out.ChecksumCRC32C)},
+			{"CRC32", aws.ToString(out.ChecksumCRC32)},
+		}
+		for _, c := range candidates {
+			// Checksums of multipart uploads end in "-<parts>"
+			if c.Value != "" && !strings.Contains(c.Value, "-") {
+				return c
+			}
+		}
+	}
+
+	etag := strings.Trim(aws.ToString(out.ETag), `"`)
+	encrypted := out.SSECustomerAlgorithm != nil ||
+		(out.ServerSideEncryption != "" && out.ServerSideEncryption != types.ServerSideEncryptionAes256)
+	if raw, err := hex.DecodeString(etag); err == nil && len(raw) == md5.Size && !encrypted {
+		return Checksum{Algorithm: "MD5", Value: base64.StdEncoding.EncodeToString(raw)}
+	}
+	return Checksum{}
+}
+
+// newHash returns a hash for sum's algorithm, or nil if there is nothing to
+// verify against
+func (sum Checksum) newHash() hash.Hash {
+	switch sum.Algorithm {
+	case "SHA256":
+		return sha256.New()
+	case "SHA1":
+		return sha1.New()
+	case "CRC32C":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "CRC32":
+		return crc32.NewIEEE()
+	case "MD5":
+		return md5.New()
+	}
+	return nil
+}
+
+// check compares the digest in h, from newHash, against sum. A nil h always
+// verifies.
+func (sum Checksum) check(h hash.Hash) error {
+	if h == nil {
+		return nil
+	}
+	got := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	if got != sum.Value {
+		return fmt.Errorf("%w: %s is %s, expected %s", ErrChecksumMismatch, sum.Algorithm, got, sum.Value)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// ChunkKey returns the cache key under which a single range of a chunked
+// object is stored. The chunk size is part of the key so changing it never
+// mixes ranges of different sizes, and so is the object's ETag, so ranges of
+// an object that was replaced are never served with the new ones.
+func ChunkKey(key, etag string, chunkSize int64, index int64) string {
+	return DerivedKey(key, fmt.Sprintf(".chunk-%s-%d-%d", etagTag(etag), chunkSize, index))
+}
+
+// chunkSuffixPattern matches the suffix DerivedKey is given for a chunk
+var chunkSuffixPattern = regexp.MustCompile(`\.chunk-[0-9a-f]+-[0-9]+-[0-9]+$`)
+
+// ChunkObject returns the key of the object a chunk key holds a range of,
+// and false for keys that aren't chunks.
+func ChunkObject(chunkKey string) (string, bool) {
+	key, derived := SplitDerived(chunkKey)
+	if !derived {
+		return "", false
+	}
+	objectPath, versionID := SplitVersion(key)
+	loc := chunkSuffixPattern.FindStringIndex(objectPath)
+	if loc == nil || loc[0] == 0 {
+		return "", false
+	}
+	return VersionedKey(objectPath[:loc[0]], versionID), true
+}
+
+// etagTag shortens an ETag to a tag that is safe in a file name
+func etagTag(etag string) string {
+	sum := sha256.Sum256([]byte(etag))
+	return hex.EncodeToString(sum[:6])
+}
+
+// ChunkedObject is an io.ReadSeeker over an S3 object that is cached as
+// fixed-size ranges. Each range is a regular cache entry, fetched from S3
+// with a ranged GET the first time it is read and evicted independently, so
+// objects that are only partially read only occupy the space that was read.
+type ChunkedObject struct {
+	ctx        context.Context
+	cache      Cache
+	downloader *S3Downloader
+	key        string
+	size       int64
+	etag       string
+	chunkSize  int64
+	offset     int64
+
+	current      File // open file for chunk currentIndex
+	currentIndex int64
+}
+
+// OpenChunked returns a reader over key, the object info describes, cached
+// in ranges of chunkSize bytes. Reads fail with ErrObjectChanged once the
+// object no longer has info's ETag. The caller must Close it.
+func (c *DiskLRUCache) OpenChunked(ctx context.Context, d *S3Downloader, key string, info ObjectInfo, chunkSize int64) *ChunkedObject {
+	return newChunkedObject(ctx, c, d, key, info, chunkSize)
+}
+
+func newChunkedObject(ctx context.Context, c Cache, d *S3Downloader, key string, info ObjectInfo, chunkSize int64) *ChunkedObject {
+	return &ChunkedObject{
+		ctx:          ctx,
+		cache:        c,
+		downloader:   d,
+		key:          key,
+		size:         info.Size,
+		etag:         info.ETag,
+		chunkSize:    chunkSize,
+		currentIndex: -1,
+	}
+}
+
+// Read reads from the current offset, fetching the containing chunk if needed.
+func (o *ChunkedObject) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	index := o.offset / o.chunkSize
+	if err := o.openChunk(index); err != nil {
+		return 0, err
+	}
+
+	// Don't read past the end of this chunk
+	chunkOffset := o.offset - index*o.chunkSize
+	remaining := o.chunkLength(index) - chunkOffset
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := o.current.ReadAt(p, chunkOffset)
+	o.offset += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the offset for the next Read.
+func (o *ChunkedObject) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.offset + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position %d", abs)
+	}
+	o.offset = abs
+	return abs, nil
+}
+
+// Close releases the currently open chunk file.
+func (o *ChunkedObject) Close() error {
+	if o.current != nil {
+		err := o.current.Close()
+		o.current = nil
+		return err
+	}
+	return nil
+}
+
+// chunkLength returns the byte length of chunk index (the last may be short)
+func (o *ChunkedObject) chunkLength(index int64) int64 {
+	start := index * o.chunkSize
+	return min(o.chunkSize, o.size-start)
+}
+
+// openChunk makes chunk index the current file, downloading it if it isn't cached
+func (o *ChunkedObject) openChunk(index int64) error {
+	if o.current != nil && o.currentIndex == index {
+		return nil
+	}
+	o.Close()
+
+	chunkKey := ChunkKey(o.key, o.etag, o.chunkSize, index)
+	filePath, found := o.cache.Get(chunkKey)
+	if !found {
+		body, err := o.downloader.DownloadRange(o.ctx, o.key, o.etag, index*o.chunkSize, o.chunkLength(index))
+		if err != nil {
+			return fmt.Errorf("failed to download chunk %d of %s: %w", index, o.key, err)
+		}
+		defer body.Close()
+
+		// A range can't be checked against the object's signature
+		filePath, err = o.cache.PutDerived(o.ctx, chunkKey, body)
+		if err != nil {
+			return fmt.Errorf("failed to cache chunk %d of %s: %w", index, o.key, err)
+		}
+	}
+
+	// The file stays readable even if the chunk is evicted while open
+	file, err := o.cache.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open chunk %d of %s: %w", index, o.key, err)
+	}
+
+	o.current = file
+	o.currentIndex = index
+	return nil
+}
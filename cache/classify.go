@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrorClass groups the ways a request to S3 can fail by what the client
+// should make of it.
+type ErrorClass string
+
+const (
+	ErrorNotFound     ErrorClass = "not_found"     // the object, version or bucket doesn't exist
+	ErrorAccessDenied ErrorClass = "access_denied" // the credentials aren't allowed the object
+	ErrorThrottled    ErrorClass = "throttled"     // S3 asked for requests to slow down
+	ErrorTimeout      ErrorClass = "timeout"       // the request didn't finish in time
+	ErrorUnavailable  ErrorClass = "unavailable"   // S3 couldn't be reached or failed with a 5xx
+	ErrorCircuitOpen  ErrorClass = "circuit_open"  // the bucket's circuit breaker is open
+	ErrorRejected     ErrorClass = "rejected"      // S3 refused the request for another reason
+	ErrorOther        ErrorClass = "other"         // the request never got to S3, such as for a malformed key
+)
+
+// ErrorClasses lists every class ClassifyError returns.
+var ErrorClasses = []ErrorClass{
+	ErrorNotFound, ErrorAccessDenied, ErrorThrottled, ErrorTimeout,
+	ErrorUnavailable, ErrorCircuitOpen, ErrorRejected, ErrorOther,
+}
+
+// ClassifyError returns the class of err, an error from S3Downloader. It
+// goes by the S3 error code where it can, and by the status of the response
+// otherwise, as HEAD responses have no body to carry a code.
+func ClassifyError(err error) ErrorClass {
+	var sendErr *smithyhttp.RequestSendError
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return ErrorCircuitOpen
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &sendErr):
+		return ErrorUnavailable
+	}
+
+	switch S3ErrorCode(err) {
+	case "NoSuchKey", "NoSuchBucket", "NoSuchVersion", "NotFound":
+		return ErrorNotFound
+	case "AccessDenied", "Forbidden", "AllAccessDisabled", "AccountProblem", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken":
+		return ErrorAccessDenied
+	case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException", "RequestThrottled":
+		return ErrorThrottled
+	case "RequestTimeout", "RequestTimeoutException":
+		return ErrorTimeout
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if !errors.As(err, &respErr) {
+		return ErrorOther
+	}
+	switch status := respErr.HTTPStatusCode(); {
+	case status == http.StatusNotFound:
+		return ErrorNotFound
+	case status == http.StatusForbidden:
+		return ErrorAccessDenied
+	case status == http.StatusTooManyRequests:
+		return ErrorThrottled
+	case status >= 500:
+		return ErrorUnavailable
+	}
+	return ErrorRejected
+}
+
+// errorCounts counts failed requests to S3 by class
+type errorCounts struct {
+	mu     sync.Mutex
+	counts map[ErrorClass]int64
+}
+
+func (c *errorCounts) add(class ErrorClass) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[ErrorClass]int64)
+	}
+	c.counts[class]++
+}
+
+// record counts the outcome of a request to bucket, and passes it on to the
+// bucket's circuit breaker
+func (d *S3Downloader) record(bucket string, err error) {
+	d.breakers.record(bucket, err)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		d.errors.add(ClassifyError(err))
+	}
+}
+
+// Errors returns the number of requests to S3 that failed since startup, by
+// class. Requests failed fast by a circuit breaker aren't counted.
+func (d *S3Downloader) Errors() map[ErrorClass]int64 {
+	d.errors.mu.Lock()
+	defer d.errors.mu.Unlock()
+	counts := make(map[ErrorClass]int64, len(ErrorClasses))
+	for _, class := range ErrorClasses {
+		if class == ErrorCircuitOpen {
+			continue
+		}
+		counts[class] = d.errors.counts[class]
+	}
+	return counts
+}
@@ -0,0 +1,393 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// Entries evicted from a cache with a cold tier are moved to the cold tier,
+// typically a larger but slower disk, instead of being deleted, and moved
+// back into the cache when next requested. Like the trash, the cold tier
+// keeps files under files and their metadata in a .json file of the same
+// name under info. Cold entries don't count towards the cache size; the
+// cold tier drops the least recently evicted ones once over its own limit.
+const coldMetaSuffix = ".json"
+
+// Files of entries on their way into or out of the cold tier are renamed
+// with these prefixes in the directory they are moving to or from, so a new
+// file for the same key can't take their place meanwhile
+const (
+	demotePrefix  = ".demote"
+	promotePrefix = ".promote"
+)
+
+// coldTier holds entries evicted from the cache
+type coldTier struct {
+	dir     string // cold files; their metadata is in the sibling info directory
+	maxSize int64  // 0 when the cold tier is disabled
+	size    int64
+	entries map[string]*Entry
+	policy  Policy // which entry is dropped first, the least recently evicted
+	hits    int64  // entries promoted back into the cache
+
+	pending   map[string]*demotion     // evicted entries not yet moved in, by key
+	queue     []*demotion              // demotions for demoteLoop to carry out
+	wake      chan struct{}            // signals demoteLoop
+	promoting map[string]chan struct{} // closed once the key has been promoted
+	seq       int                      // numbers staged files
+}
+
+// demotion is an evicted entry being moved to the cold tier
+type demotion struct {
+	entry  Entry
+	staged string // the entry's file, renamed with demotePrefix
+}
+
+// WithColdTier moves evicted entries into dir, up to maxSizeBytes of them,
+// instead of deleting them. A Get of a cold entry moves it back into the
+// cache, which is quicker than downloading it again. Moving files to the
+// cold tier happens in the background, so it doesn't hold up the requests
+// that caused the eviction.
+func WithColdTier(dir string, maxSizeBytes int64) Option {
+	return func(c *DiskLRUCache) {
+		c.cold.dir = filepath.Join(dir, "files")
+		c.cold.maxSize = maxSizeBytes
+	}
+}
+
+// initCold loads the cold tier left by a previous run and deletes files
+// that were on their way in or out of it when that run stopped
+func (c *DiskLRUCache) initCold() error {
+	for _, dir := range append(c.storageDirs(), c.filesDir) {
+		for _, prefix := range []string{demotePrefix, promotePrefix} {
+			staged, _ := filepath.Glob(filepath.Join(dir, prefix+"*"))
+			for _, name := range staged {
+				os.Remove(name)
+			}
+		}
+	}
+	if c.cold.maxSize <= 0 {
+		return nil
+	}
+
+	c.cold.entries = make(map[string]*Entry)
+	c.cold.policy = NewLRUPolicy()
+	c.cold.pending = make(map[string]*demotion)
+	c.cold.promoting = make(map[string]chan struct{})
+	c.cold.wake = make(chan struct{}, 1)
+	infoDir := filepath.Join(filepath.Dir(c.cold.dir), "info")
+	for _, dir := range []string{c.cold.dir, infoDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create cold tier directory: %w", err)
+		}
+	}
+
+	names, err := filepath.Glob(filepath.Join(infoDir, "*"+coldMetaSuffix))
+	if err != nil {
+		return err
+	}
+	loaded := make([]*Entry, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		entry := &Entry{}
+		if err == nil {
+			err = json.Unmarshal(data, entry)
+		}
+		if err != nil || entry.Key == "" {
+			logger.Warn().Emitf("Dropping unreadable cold entry %s: %v", filepath.Base(name), err)
+			os.Remove(name)
+			os.Remove(filepath.Join(c.cold.dir, strings.TrimSuffix(filepath.Base(name), coldMetaSuffix)))
+			continue
+		}
+		info, err := os.Stat(filepath.Join(c.cold.dir, entry.Filename))
+		if err != nil || info.Size() != entry.Size {
+			c.deleteColdFiles(entry.Filename)
+			continue
+		}
+		loaded = append(loaded, entry)
+	}
+
+	// Cached again while cold, which a crash can leave behind
+	loaded = slices.DeleteFunc(loaded, func(entry *Entry) bool {
+		if _, cached := c.entries[entry.Key]; cached {
+			c.deleteColdFiles(entry.Filename)
+			return true
+		}
+		return false
+	})
+	slices.SortFunc(loaded, func(a, b *Entry) int {
+		return a.AccessTime.Compare(b.AccessTime)
+	})
+	for _, entry := range loaded {
+		c.addCold(entry)
+	}
+	c.evictCold()
+
+	go c.demoteLoop()
+	return nil
+}
+
+// ColdEntries returns the entries in the cold tier, including those still
+// being moved there.
+func (c *DiskLRUCache) ColdEntries() []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(c.cold.entries)+len(c.cold.pending))
+	for _, entry := range c.cold.entries {
+		entries = append(entries, *entry)
+	}
+	for _, d := range c.cold.pending {
+		entries = append(entries, d.entry)
+	}
+	return entries
+}
+
+// demote moves the file of the entry for key aside and queues it to be
+// moved into the cold tier by demoteLoop, as that may copy it to another
+// filesystem, which the lock mustn't be held for (must be called with lock
+// held)
+func (c *DiskLRUCache) demote(key string) {
+	entry := c.entries[key]
+	c.cold.seq++
+	path := c.filePath(entry)
+	staged := filepath.Join(filepath.Dir(path), fmt.Sprintf("%s%d-%s", demotePrefix, c.cold.seq, entry.Filename))
+	if err := os.Rename(path, staged); err != nil {
+		logger.Warn().Emitf("Failed to move %s to the cold tier, deleting it: %v", key, err)
+		c.removeEntry(key, EventEvict)
+		return
+	}
+
+	c.detachEntry(entry, EventEvict)
+	c.journalRemove(key)
+	d := &demotion{entry: *entry, staged: staged}
+	c.cold.pending[key] = d
+	c.cold.queue = append(c.cold.queue, d)
+	select {
+	case c.cold.wake <- struct{}{}:
+	default:
+	}
+}
+
+// demoteLoop moves queued demotions into the cold tier, until the process
+// exits
+func (c *DiskLRUCache) demoteLoop() {
+	for range c.cold.wake {
+		c.mu.Lock()
+		queue := c.cold.queue
+		c.cold.queue = nil
+		c.mu.Unlock()
+
+		for _, d := range queue {
+			c.finishDemotion(d)
+		}
+	}
+}
+
+// finishDemotion moves the staged file of d into the cold tier and adds its
+// entry, unless the key has since been cached again or removed
+func (c *DiskLRUCache) finishDemotion(d *demotion) {
+	key := d.entry.Key
+	tmpPath := filepath.Join(c.cold.dir, d.entry.Filename+".tmp")
+	err := moveFile(d.staged, tmpPath)
+	if err == nil {
+		err = c.syncFile(tmpPath)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cold.pending[key] != d {
+		os.Remove(d.staged)
+		os.Remove(tmpPath)
+		return
+	}
+	delete(c.cold.pending, key)
+	if err == nil {
+		// Evicted again, a newer copy replaces the cold one
+		c.dropCold(key)
+		var meta []byte
+		meta, err = json.Marshal(&d.entry)
+		if err == nil {
+			err = os.WriteFile(c.coldMetaPath(d.entry.Filename), meta, 0644)
+		}
+		if err == nil {
+			err = os.Rename(tmpPath, filepath.Join(c.cold.dir, d.entry.Filename))
+		}
+	}
+	if err != nil {
+		logger.Warn().Emitf("Failed to move %s to the cold tier, deleting it: %v", key, err)
+		os.Remove(d.staged)
+		os.Remove(tmpPath)
+		os.Remove(c.coldMetaPath(d.entry.Filename))
+		return
+	}
+
+	entry := d.entry
+	c.addCold(&entry)
+	c.evictCold()
+}
+
+// promote moves the entry for key back from the cold tier into the cache,
+// if it's cold and not cached. Other calls for the same key meanwhile wait
+// for it to finish.
+func (c *DiskLRUCache) promote(key string) {
+	c.mu.Lock()
+	if _, cached := c.entries[key]; cached {
+		c.mu.Unlock()
+		return
+	}
+	if done, ok := c.cold.promoting[key]; ok {
+		c.mu.Unlock()
+		<-done
+		return
+	}
+	cold, ok := c.cold.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+
+	// Take the entry out of the cold tier while its file is moved, so it
+	// isn't dropped meanwhile
+	done := make(chan struct{})
+	c.cold.promoting[key] = done
+	c.removeCold(cold)
+	os.Remove(c.coldMetaPath(cold.Filename))
+	dir := c.storageDir(cold.Filename, cold.Size)
+	c.cold.seq++
+	staged := filepath.Join(dir, fmt.Sprintf("%s%d-%s", promotePrefix, c.cold.seq, cold.Filename))
+	c.mu.Unlock()
+
+	err := moveFile(filepath.Join(c.cold.dir, cold.Filename), staged)
+	if err == nil {
+		// Flushed now, so commitFile finds nothing left to sync with the lock held
+		err = c.syncFile(staged)
+	}
+
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer func() {
+		if c.cold.promoting[key] == done {
+			delete(c.cold.promoting, key)
+		}
+		c.mu.Unlock()
+		close(done)
+	}()
+
+	if err != nil {
+		logger.Warn().Emitf("Failed to move %s back from the cold tier: %v", key, err)
+		os.Remove(staged)
+		return
+	}
+	// Removed or cached again meanwhile
+	if _, cached := c.entries[key]; cached || c.cold.promoting[key] != done {
+		os.Remove(staged)
+		return
+	}
+	content := contentInfo{
+		checksum:     cold.Checksum,
+		sha256:       cold.SHA256,
+		lastModified: cold.LastModified,
+		contentType:  cold.ContentType,
+		cached:       cold.CreateTime,
+	}
+	if _, err := c.commitFile(context.Background(), key, staged, cold.Size, content); err != nil {
+		logger.Warn().Emitf("Failed to move %s back from the cold tier: %v", key, err)
+		return
+	}
+	c.cold.hits++
+}
+
+// forgetCold deletes the cold copy of key, including one still being moved
+// into or out of the cold tier, returning whether there was one (must be
+// called with lock held)
+func (c *DiskLRUCache) forgetCold(key string) bool {
+	if c.cold.maxSize <= 0 {
+		return false
+	}
+	_, pending := c.cold.pending[key]
+	_, promoting := c.cold.promoting[key]
+	delete(c.cold.pending, key)
+	delete(c.cold.promoting, key)
+	return c.dropCold(key) || pending || promoting
+}
+
+// dropCold deletes the cold entry for key and its files, returning whether
+// there was one (must be called with lock held)
+func (c *DiskLRUCache) dropCold(key string) bool {
+	entry, ok := c.cold.entries[key]
+	if !ok {
+		return false
+	}
+	c.removeCold(entry)
+	c.deleteColdFiles(entry.Filename)
+	return true
+}
+
+// clearCold deletes every cold entry, returning how many there were (must
+// be called with lock held)
+func (c *DiskLRUCache) clearCold() int {
+	count := 0
+	for key := range c.cold.entries {
+		c.dropCold(key)
+		count++
+	}
+	for key := range c.cold.pending {
+		delete(c.cold.pending, key)
+		count++
+	}
+	for key := range c.cold.promoting {
+		delete(c.cold.promoting, key)
+		count++
+	}
+	return count
+}
+
+// evictCold drops the least recently evicted cold entries until the cold
+// tier is within its limit (must be called with lock held)
+func (c *DiskLRUCache) evictCold() {
+	for c.cold.size > c.cold.maxSize {
+		key, ok := c.cold.policy.Evict()
+		if !ok {
+			break
+		}
+		if entry, ok := c.cold.entries[key]; ok {
+			delete(c.cold.entries, key)
+			c.cold.size -= entry.Size
+			c.deleteColdFiles(entry.Filename)
+		}
+	}
+}
+
+// addCold and removeCold track a cold entry in the cold tier's size and
+// policy (must be called with lock held)
+func (c *DiskLRUCache) addCold(entry *Entry) {
+	c.cold.entries[entry.Key] = entry
+	c.cold.size += entry.Size
+	c.cold.policy.Add(entry)
+}
+
+func (c *DiskLRUCache) removeCold(entry *Entry) {
+	delete(c.cold.entries, entry.Key)
+	c.cold.size -= entry.Size
+	c.cold.policy.Remove(entry.Key)
+}
+
+// deleteColdFiles deletes the cold file filename and its metadata
+func (c *DiskLRUCache) deleteColdFiles(filename string) {
+	os.Remove(filepath.Join(c.cold.dir, filename))
+	os.Remove(c.coldMetaPath(filename))
+}
+
+// coldMetaPath returns the path of the metadata of the cold file filename
+func (c *DiskLRUCache) coldMetaPath(filename string) string {
+	return filepath.Join(filepath.Dir(c.cold.dir), "info", filename+coldMetaSuffix)
+}
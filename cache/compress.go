@@ -0,0 +1,323 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed files are split into frames that are compressed independently
+// with zstd, so any byte range can be read without decompressing the whole
+// file. A table of the compressed frame sizes at the end locates each frame.
+//
+//	magic (8) | frame 0 | frame 1 | ... | frame sizes (4 each) | frame count (4) | size (8)
+//
+// Each frame holds compressFrameSize bytes of plaintext, except the last.
+// Compression is applied before encryption. Compressed files are stored
+// with compressedSuffix appended to their name, which sanitizeFilename never
+// produces, so Open can tell them apart from files stored as is.
+const (
+	compressionMagic  = "MWAYZST1"
+	compressFrameSize = 256 * 1024
+	compressedTrailer = 4 + 8
+	compressedSuffix  = "~zst"
+
+	// Files whose first frame doesn't shrink below this fraction of its
+	// size are stored as is
+	minCompressionRatio = 0.9
+)
+
+// ErrDecompress is returned when a compressed cache file is damaged.
+var ErrDecompress = errors.New("failed to decompress cache file")
+
+// WithCompression compresses cached files at rest with zstd. Files in
+// formats that are already compressed, such as APKs, archives, images and
+// video, are detected by their contents and stored as is.
+func WithCompression() Option {
+	return func(c *DiskLRUCache) {
+		c.encoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	}
+}
+
+// decoder decompresses cached files. Files compressed earlier stay readable
+// after compression is turned off, so it is not tied to WithCompression.
+var decoder = sync.OnceValue(func() *zstd.Decoder {
+	d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	return d
+})
+
+// compressedMagics are signatures of formats that gain nothing from being
+// compressed again, with the offset they appear at
+var compressedMagics = []struct {
+	offset int
+	magic  string
+}{
+	{0, "PK\x03\x04"},         // zip, apk, aab, ipa, jar
+	{0, "PK\x05\x06"},         // empty zip
+	{0, "\x1f\x8b"},           // gzip
+	{0, "\x28\xb5\x2f\xfd"},   // zstd
+	{0, "\xfd7zXZ\x00"},       // xz
+	{0, "BZh"},                // bzip2
+	{0, "\x04\x22\x4d\x18"},   // lz4
+	{0, "7z\xbc\xaf\x27\x1c"}, // 7z
+	{0, "Rar!\x1a\x07"},       // rar
+	{0, "\x89PNG\r\n\x1a\n"},  // png
+	{0, "\xff\xd8\xff"},       // jpeg
+	{0, "GIF8"},               // gif
+	{8, "WEBP"},               // webp
+	{4, "ftyp"},               // mp4, mov, heic
+	{0, "\x1a\x45\xdf\xa3"},   // mkv, webm
+	{0, "OggS"},               // ogg
+	{0, "fLaC"},               // flac
+	{0, "ID3"},                // mp3
+	{0, compressionMagic},     // already one of ours
+}
+
+// shouldCompress reports whether a file starting with head, its first frame
+// or all of it if shorter, is worth compressing
+func (c *DiskLRUCache) shouldCompress(head []byte) bool {
+	if c.encoder == nil || len(head) == 0 {
+		return false
+	}
+	for _, m := range compressedMagics {
+		if bytes.HasPrefix(head[min(m.offset, len(head)):], []byte(m.magic)) {
+			return false
+		}
+	}
+
+	// Formats without a known signature are judged by how well the first
+	// frame compresses
+	compressed := c.encoder.EncodeAll(head, nil)
+	return float64(len(compressed)) < float64(len(head))*minCompressionRatio
+}
+
+// compressWriter compresses everything written to it into w. Close writes
+// the last frame and the frame table but does not close w.
+type compressWriter struct {
+	encoder *zstd.Encoder
+	w       io.Writer
+	buf     []byte
+	frames  []uint32 // compressed size of each frame
+	size    int64    // plaintext bytes written
+	out     []byte
+}
+
+func newCompressWriter(encoder *zstd.Encoder, w io.Writer) (*compressWriter, error) {
+	if _, err := io.WriteString(w, compressionMagic); err != nil {
+		return nil, err
+	}
+	return &compressWriter{
+		encoder: encoder,
+		w:       w,
+		buf:     make([]byte, 0, compressFrameSize),
+	}, nil
+}
+
+func (z *compressWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := min(len(p), compressFrameSize-len(z.buf))
+		z.buf = append(z.buf, p[:chunk]...)
+		p = p[chunk:]
+		n += chunk
+		if len(z.buf) == compressFrameSize {
+			if err := z.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the last frame and the frame table.
+func (z *compressWriter) Close() error {
+	if len(z.buf) > 0 {
+		if err := z.flush(); err != nil {
+			return err
+		}
+	}
+
+	trailer := make([]byte, 0, 4*len(z.frames)+compressedTrailer)
+	for _, size := range z.frames {
+		trailer = binary.LittleEndian.AppendUint32(trailer, size)
+	}
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(z.frames)))
+	trailer = binary.LittleEndian.AppendUint64(trailer, uint64(z.size))
+	_, err := z.w.Write(trailer)
+	return err
+}
+
+func (z *compressWriter) flush() error {
+	z.out = z.encoder.EncodeAll(z.buf, z.out[:0])
+	if _, err := z.w.Write(z.out); err != nil {
+		return err
+	}
+	z.frames = append(z.frames, uint32(len(z.out)))
+	z.size += int64(len(z.buf))
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// compressedFile decompresses a compressed cache file on read
+type compressedFile struct {
+	file    File
+	decoder *zstd.Decoder
+	offsets []int64 // of each frame in file, plus the end of the last
+	size    int64   // plaintext size
+	offset  int64
+
+	cached      []byte // plaintext of frame cachedIndex
+	cachedIndex int64
+}
+
+func openCompressed(decoder *zstd.Decoder, file File) (*compressedFile, error) {
+	stored, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to size compressed file: %w", err)
+	}
+	if stored < int64(len(compressionMagic)+compressedTrailer) {
+		return nil, fmt.Errorf("%w: truncated", ErrDecompress)
+	}
+
+	header := make([]byte, len(compressionMagic))
+	trailer := make([]byte, compressedTrailer)
+	if _, err := file.ReadAt(header, 0); err != nil || string(header) != compressionMagic {
+		return nil, fmt.Errorf("%w: missing header", ErrDecompress)
+	}
+	if _, err := file.ReadAt(trailer, stored-compressedTrailer); err != nil {
+		return nil, fmt.Errorf("%w: missing trailer", ErrDecompress)
+	}
+	count := int64(binary.LittleEndian.Uint32(trailer))
+	size := int64(binary.LittleEndian.Uint64(trailer[4:]))
+
+	tableStart := stored - compressedTrailer - 4*count
+	if tableStart < int64(len(compressionMagic)) || count != (size+compressFrameSize-1)/compressFrameSize {
+		return nil, fmt.Errorf("%w: bad frame table", ErrDecompress)
+	}
+	table := make([]byte, 4*count)
+	if _, err := file.ReadAt(table, tableStart); err != nil {
+		return nil, fmt.Errorf("%w: missing frame table", ErrDecompress)
+	}
+
+	offsets := make([]int64, count+1)
+	offsets[0] = int64(len(compressionMagic))
+	for i := range count {
+		offsets[i+1] = offsets[i] + int64(binary.LittleEndian.Uint32(table[4*i:]))
+	}
+	if offsets[count] != tableStart {
+		return nil, fmt.Errorf("%w: bad frame table", ErrDecompress)
+	}
+
+	return &compressedFile{
+		file:        file,
+		decoder:     decoder,
+		offsets:     offsets,
+		size:        size,
+		cachedIndex: -1,
+	}, nil
+}
+
+func (f *compressedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+
+	n := 0
+	for n < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+
+		index := off / compressFrameSize
+		plain, err := f.frame(index)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], plain[off-index*compressFrameSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (f *compressedFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *compressedFile) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position %d", abs)
+	}
+	f.offset = abs
+	return abs, nil
+}
+
+func (f *compressedFile) Close() error {
+	return f.file.Close()
+}
+
+// frame returns the decompressed plaintext of frame index
+func (f *compressedFile) frame(index int64) ([]byte, error) {
+	if index == f.cachedIndex {
+		return f.cached, nil
+	}
+
+	compressed := make([]byte, f.offsets[index+1]-f.offsets[index])
+	if _, err := f.file.ReadAt(compressed, f.offsets[index]); err != nil {
+		return nil, fmt.Errorf("failed to read compressed file: %w", err)
+	}
+
+	plain, err := f.decoder.DecodeAll(compressed, f.cached[:0])
+	want := min(compressFrameSize, f.size-index*compressFrameSize)
+	if err == nil && int64(len(plain)) != want {
+		err = fmt.Errorf("frame is %d bytes, want %d", len(plain), want)
+	}
+	if err != nil {
+		f.cachedIndex = -1
+		return nil, fmt.Errorf("%w: frame %d: %v", ErrDecompress, index, err)
+	}
+
+	f.cached = plain
+	f.cachedIndex = index
+	return plain, nil
+}
+
+// isCompressed reports whether the file at path is stored compressed
+func isCompressed(path string) bool {
+	return strings.HasSuffix(path, compressedSuffix)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
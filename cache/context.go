@@ -0,0 +1,21 @@
+package cache
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails reads once ctx is done, so a copy into the cache stops
+// at the next read even if the source doesn't watch ctx itself, or is
+// outpacing a slow disk
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,28 @@
+package cache
+
+import "strings"
+
+// Entries computed from objects, such as the chunks of objects cached in
+// ranges, delta patches and decompressed forms, are kept apart from the
+// objects themselves: their keys start with derivedPrefix after any
+// namespace, as in ~bucket/path.decompressed or @team-a/~bucket/path.delta-1f.
+// S3 bucket names can't contain "~", so no file request can address them.
+const derivedPrefix = "~"
+
+// DerivedKey returns the cache key of an entry derived from the object key,
+// told apart from others derived from it by suffix, e.g. ".decompressed".
+// The namespace and version of key carry over.
+func DerivedKey(key, suffix string) string {
+	ns, rest := SplitNamespace(key)
+	objectPath, versionID := SplitVersion(rest)
+	return NamespacedKey(ns, VersionedKey(derivedPrefix+objectPath+suffix, versionID))
+}
+
+// SplitDerived returns key without the mark of a derived entry, which then
+// starts with the key of the object it was derived from, and whether key
+// was a derived entry's at all.
+func SplitDerived(key string) (string, bool) {
+	ns, rest := SplitNamespace(key)
+	rest, derived := strings.CutPrefix(rest, derivedPrefix)
+	return NamespacedKey(ns, rest), derived
+}
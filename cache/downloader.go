@@ -2,98 +2,330 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 // S3Downloader handles downloading objects from S3 with automatic region detection.
 type S3Downloader struct {
 	cfg           aws.Config
 	bucketRegions sync.Map // bucket name -> region
+	roles         []bucketRole
+	customerKeys  []sseCustomer
+	bandwidth     *bandwidth
+	accelerate    []string // bucket patterns using Transfer Acceleration
+	dualStack     []string // bucket patterns using dual-stack endpoints
+	proxies       []bucketProxy
+	breakers      *breakers // nil when circuit breaking is disabled
+	errors        errorCounts
 }
 
+// DownloaderOption configures an S3Downloader.
+type DownloaderOption func(*S3Downloader)
+
 // NewS3Downloader creates a new S3 downloader that auto-detects bucket regions.
-func NewS3Downloader(cfg aws.Config) *S3Downloader {
-	return &S3Downloader{
-		cfg: cfg,
+func NewS3Downloader(cfg aws.Config, opts ...DownloaderOption) *S3Downloader {
+	d := &S3Downloader{
+		cfg:       cfg,
+		bandwidth: newBandwidth(),
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	return d
 }
 
 func (d *S3Downloader) getClientForBucket(ctx context.Context, bucket string) (*s3.Client, error) {
+	cfg := d.configForBucket(bucket)
+
 	// Check cache first
 	if region, ok := d.bucketRegions.Load(bucket); ok {
-		cfg := d.cfg.Copy()
 		cfg.Region = region.(string)
-		return s3.NewFromConfig(cfg, func(o *s3.Options) {
-			o.DisableLogOutputChecksumValidationSkipped = true
-		}), nil
+	} else {
+		cfg.Region = d.detectRegion(ctx, cfg, bucket)
+	}
+
+	return s3.NewFromConfig(cfg, d.clientOptions(bucket, false)), nil
+}
+
+// Download downloads an object from S3 and returns a reader.
+// The key should be in format "bucket/path/to/file.apk".
+func (d *S3Downloader) Download(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	bucket, objectKey, versionID, err := parseS3Key(key)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse S3 key: %w", err)
+	}
+	if err := d.breakers.allow(bucket); err != nil {
+		return nil, 0, err
+	}
+
+	client, err := d.getClientForBucket(ctx, bucket)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
 	}
 
-	// Detect bucket region using us-east-1 (GetBucketLocation works globally from us-east-1)
-	cfg := d.cfg.Copy()
-	cfg.Region = "us-east-1"
-	client := s3.NewFromConfig(cfg)
+	sse := d.customerKey(bucket)
 
-	location, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
-		Bucket: aws.String(bucket),
+	result, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(objectKey),
+		VersionId:    optionalString(versionID),
+		ChecksumMode: types.ChecksumModeEnabled,
+
+		SSECustomerAlgorithm: sse.algorithm,
+		SSECustomerKey:       sse.key,
+		SSECustomerKeyMD5:    sse.keyMD5,
 	})
+	d.record(bucket, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get bucket location: %w", err)
+		return nil, 0, fmt.Errorf("failed to download from S3: %w", explainS3Error(err, bucket))
 	}
 
-	// Empty location means us-east-1
-	region := string(location.LocationConstraint)
-	if region == "" {
-		region = "us-east-1"
+	size := int64(0)
+	if result.ContentLength != nil {
+		size = *result.ContentLength
+	}
+
+	return d.bandwidth.throttle(ctx, result.Body), size, nil
+}
+
+// ErrObjectChanged is returned by DownloadFrom and DownloadRange when the
+// object no longer matches the ETag a resumed or chunked download was
+// started with.
+var ErrObjectChanged = errors.New("object changed since download started")
+
+// DownloadFrom downloads an object starting at offset. When offset is
+// non-zero, etag must be the ETag of the object the earlier bytes came from;
+// if the object has changed since, ErrObjectChanged is returned and the
+// download must restart from zero. The returned ObjectInfo describes the full
+// object, not just the requested range.
+func (d *S3Downloader) DownloadFrom(ctx context.Context, key string, offset int64, etag string) (io.ReadCloser, ObjectInfo, error) {
+	bucket, objectKey, versionID, err := parseS3Key(key)
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("failed to parse S3 key: %w", err)
+	}
+	if err := d.breakers.allow(bucket); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	client, err := d.getClientForBucket(ctx, bucket)
+	if err != nil {
+		return nil, ObjectInfo{}, fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
+	}
+
+	sse := d.customerKey(bucket)
+
+	input := &s3.GetObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(objectKey),
+		VersionId:    optionalString(versionID),
+		ChecksumMode: types.ChecksumModeEnabled,
+
+		SSECustomerAlgorithm: sse.algorithm,
+		SSECustomerKey:       sse.key,
+		SSECustomerKeyMD5:    sse.keyMD5,
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+		input.IfMatch = aws.String(etag)
+	}
+
+	result, err := client.GetObject(ctx, input)
+	d.record(bucket, err)
+	if err != nil {
+		var apiErr smithy.APIError
+		if offset > 0 && errors.As(err, &apiErr) && (apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "InvalidRange") {
+			return nil, ObjectInfo{}, ErrObjectChanged
+		}
+		return nil, ObjectInfo{}, fmt.Errorf("failed to download from S3: %w", explainS3Error(err, bucket))
+	}
+
+	info := ObjectInfo{
+		ContentType: aws.ToString(result.ContentType),
+		ETag:        aws.ToString(result.ETag),
+	}
+	if result.LastModified != nil {
+		info.LastModified = *result.LastModified
+	}
+	if result.ContentLength != nil {
+		info.Size = offset + *result.ContentLength
+	}
+	// Checksums describe the full object, so only use them for full responses
+	if offset == 0 {
+		info.Checksum = checksumFromResponse(result)
+	}
+	// Content-Range is "bytes start-end/total" for ranged responses
+	if cr := aws.ToString(result.ContentRange); cr != "" {
+		if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if total, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				info.Size = total
+			}
+		}
 	}
 
-	d.bucketRegions.Store(bucket, region)
+	return d.bandwidth.throttle(ctx, result.Body), info, nil
+}
 
-	cfg.Region = region
-	return s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.DisableLogOutputChecksumValidationSkipped = true
-	}), nil
+// ObjectInfo describes an S3 object without its contents.
+type ObjectInfo struct {
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"contentType"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+	Checksum     Checksum  `json:"checksum,omitzero"` // set by DownloadFrom for full downloads only
 }
 
-// Download downloads an object from S3 and returns a reader.
+// Head fetches an object's metadata without downloading it.
 // The key should be in format "bucket/path/to/file.apk".
-func (d *S3Downloader) Download(ctx context.Context, key string) (io.ReadCloser, int64, error) {
-	bucket, objectKey, err := parseS3Key(key)
+func (d *S3Downloader) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	bucket, objectKey, versionID, err := parseS3Key(key)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to parse S3 key: %w", err)
+		return ObjectInfo{}, fmt.Errorf("failed to parse S3 key: %w", err)
+	}
+	if err := d.breakers.allow(bucket); err != nil {
+		return ObjectInfo{}, err
 	}
 
 	client, err := d.getClientForBucket(ctx, bucket)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
+		return ObjectInfo{}, fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
 	}
 
-	result, err := client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(objectKey),
+	sse := d.customerKey(bucket)
+
+	result, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(objectKey),
+		VersionId: optionalString(versionID),
+
+		SSECustomerAlgorithm: sse.algorithm,
+		SSECustomerKey:       sse.key,
+		SSECustomerKeyMD5:    sse.keyMD5,
 	})
+	d.record(bucket, err)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to download from S3: %w", err)
+		return ObjectInfo{}, fmt.Errorf("failed to head S3 object: %w", explainS3Error(err, bucket))
 	}
 
-	size := int64(0)
+	info := ObjectInfo{
+		ContentType: aws.ToString(result.ContentType),
+		ETag:        aws.ToString(result.ETag),
+	}
 	if result.ContentLength != nil {
-		size = *result.ContentLength
+		info.Size = *result.ContentLength
+	}
+	if result.LastModified != nil {
+		info.LastModified = *result.LastModified
+	}
+	return info, nil
+}
+
+// DownloadRange downloads length bytes of an object starting at offset. With
+// an etag, it fails with ErrObjectChanged if the object no longer has it.
+func (d *S3Downloader) DownloadRange(ctx context.Context, key, etag string, offset, length int64) (io.ReadCloser, error) {
+	bucket, objectKey, versionID, err := parseS3Key(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse S3 key: %w", err)
+	}
+	if err := d.breakers.allow(bucket); err != nil {
+		return nil, err
+	}
+
+	client, err := d.getClientForBucket(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
 	}
 
-	return result.Body, size, nil
+	sse := d.customerKey(bucket)
+
+	result, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(objectKey),
+		VersionId: optionalString(versionID),
+		Range:     aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+		IfMatch:   optionalString(etag),
+
+		SSECustomerAlgorithm: sse.algorithm,
+		SSECustomerKey:       sse.key,
+		SSECustomerKeyMD5:    sse.keyMD5,
+	})
+	d.record(bucket, err)
+	if S3ErrorCode(err) == "PreconditionFailed" {
+		return nil, fmt.Errorf("%w: %s no longer has ETag %s", ErrObjectChanged, key, etag)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download range from S3: %w", explainS3Error(err, bucket))
+	}
+
+	return d.bandwidth.throttle(ctx, result.Body), nil
+}
+
+// versionSuffix separates an object path from its S3 version ID in a cache key
+const versionSuffix = "?versionId="
+
+// VersionedKey returns the cache key for a specific version of the object at
+// key. An empty versionID refers to the latest version and returns key as is.
+func VersionedKey(key, versionID string) string {
+	if versionID == "" {
+		return key
+	}
+	return key + versionSuffix + versionID
 }
 
-// parseS3Key parses a key in format "bucket/path/to/file" into bucket and object key
-func parseS3Key(key string) (bucket, objectKey string, err error) {
-	parts := strings.SplitN(key, "/", 2)
+// SplitVersion splits a cache key into the object path and the S3 version ID
+// it is pinned to, which is empty for unversioned keys.
+func SplitVersion(key string) (objectPath, versionID string) {
+	objectPath, versionID, _ = strings.Cut(key, versionSuffix)
+	return objectPath, versionID
+}
+
+// parseS3Key parses a key in format "[@namespace/]bucket/path/to/file[?versionId=id]"
+// into bucket, object key and version ID
+func parseS3Key(key string) (bucket, objectKey, versionID string, err error) {
+	_, objectPath := SplitNamespace(key)
+	objectPath, versionID = SplitVersion(objectPath)
+	parts := strings.SplitN(objectPath, "/", 2)
 	if len(parts) != 2 {
-		return "", "", fmt.Errorf("invalid key format, expected bucket/path: %s", key)
+		return "", "", "", fmt.Errorf("invalid key format, expected bucket/path: %s", key)
+	}
+	return parts[0], parts[1], versionID, nil
+}
+
+// Unavailable reports whether err means S3 couldn't be reached or failed to
+// answer, rather than that it refused the request: the request couldn't be
+// sent, timed out, was throttled or got a 5xx response, or the bucket's
+// circuit breaker is open.
+func Unavailable(err error) bool {
+	switch ClassifyError(err) {
+	case ErrorUnavailable, ErrorTimeout, ErrorThrottled, ErrorCircuitOpen:
+		return true
+	}
+	return false
+}
+
+// S3ErrorCode returns the error code S3 answered a request with, such as
+// NoSuchKey or AccessDenied, or "" if err isn't an answer from S3.
+func S3ErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
 	}
-	return parts[0], parts[1], nil
+	return aws.String(s)
 }
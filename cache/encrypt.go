@@ -0,0 +1,417 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/autonoma-ai/midway/bufpool"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// Encrypted files are split into segments that are sealed independently with
+// AES-256-GCM, so any byte range can be read without decrypting the whole
+// file. The nonce of each segment is the file's random prefix, the segment
+// index and a flag marking the final segment, which prevents segments from
+// being reordered or the file from being truncated undetected.
+//
+//	magic (8) | nonce prefix (7) | segment 0 | segment 1 | ... | final segment
+//
+// Each segment holds up to segmentSize bytes of plaintext plus a 16-byte tag.
+// Every file has a final segment, which is empty for empty files.
+const (
+	encryptionMagic   = "MWAYENC1"
+	noncePrefixSize   = 7
+	encryptedHeader   = len(encryptionMagic) + noncePrefixSize
+	segmentSize       = 64 * 1024
+	segmentTagSize    = 16
+	sealedSegmentSize = segmentSize + segmentTagSize
+)
+
+// ErrDecrypt is returned when an encrypted cache file fails authentication,
+// e.g. because it was modified on disk or written with a different key.
+var ErrDecrypt = errors.New("failed to decrypt cache file")
+
+// File is a cached file opened for reading.
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// WithEncryption encrypts cached files at rest with key, a 256-bit AES key.
+// Files are decrypted when opened with Open. Partial downloads are kept in
+// plaintext until they complete.
+func WithEncryption(key []byte) Option {
+	return func(c *DiskLRUCache) {
+		c.encryptionKey = key
+	}
+}
+
+// Open opens a file returned by Get or Put for reading, decrypting and
+// decompressing it as needed.
+func (c *DiskLRUCache) Open(filePath string) (File, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File = file
+	if c.aead != nil {
+		if f, err = openEncrypted(c.aead, file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if isCompressed(filePath) {
+		z, err := openCompressed(decoder(), f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f = z
+	}
+	return f, nil
+}
+
+// readFile returns the plaintext contents of a cached file
+func (c *DiskLRUCache) readFile(filePath string) ([]byte, error) {
+	file, err := c.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// sealFile compresses and encrypts the plaintext file at srcPath into a new
+// file, as configured, and removes srcPath, returning the new path and its
+// size. If the file is stored as is, it returns srcPath unchanged.
+func (c *DiskLRUCache) sealFile(srcPath string) (string, int64, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file for sealing: %w", err)
+	}
+	defer src.Close()
+
+	compress := false
+	if c.encoder != nil {
+		head := make([]byte, compressFrameSize)
+		n, _ := io.ReadFull(src, head)
+		compress = c.shouldCompress(head[:n])
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return "", 0, fmt.Errorf("failed to rewind file: %w", err)
+		}
+	}
+
+	if c.aead == nil && !compress {
+		info, err := src.Stat()
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to stat file: %w", err)
+		}
+		return srcPath, info.Size(), nil
+	}
+
+	dstPath := srcPath + ".enc"
+	if compress {
+		dstPath += compressedSuffix
+	}
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create sealed file: %w", err)
+	}
+
+	size, err := c.writeSealed(dst, src, compress)
+	dst.Close()
+	if err != nil {
+		os.Remove(dstPath)
+		return "", 0, fmt.Errorf("failed to seal file: %w", err)
+	}
+
+	os.Remove(srcPath)
+	return dstPath, size, nil
+}
+
+// writeSealed writes src to dst in the form it is stored in the cache:
+// compressed if compress is set, then encrypted if the cache is encrypted.
+// It returns the number of bytes written to dst.
+func (c *DiskLRUCache) writeSealed(dst io.Writer, src io.Reader, compress bool) (int64, error) {
+	counter := &countingWriter{w: dst}
+
+	var (
+		w   io.Writer = counter
+		enc *encryptWriter
+		z   *compressWriter
+		err error
+	)
+	if c.aead != nil {
+		if enc, err = newEncryptWriter(c.aead, w); err != nil {
+			return 0, err
+		}
+		w = enc
+	}
+	if compress {
+		if z, err = newCompressWriter(c.encoder, w); err != nil {
+			return 0, err
+		}
+		w = z
+	}
+
+	if _, err := bufpool.Copy(w, src); err != nil {
+		return 0, err
+	}
+	if z != nil {
+		if err := z.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return 0, err
+		}
+	}
+	return counter.n, nil
+}
+
+// initEncryption sets up the cipher and clears the cache if its files were
+// written with a different key or without encryption. The key ID stored in
+// the cache directory is a hash of the key, not the key itself.
+func (c *DiskLRUCache) initEncryption() error {
+	keyID := "none"
+	if c.encryptionKey != nil {
+		block, err := aes.NewCipher(c.encryptionKey)
+		if err != nil {
+			return fmt.Errorf("invalid encryption key: %w", err)
+		}
+		c.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("failed to initialize encryption: %w", err)
+		}
+		sum := sha256.Sum256(append([]byte("midway cache key id\n"), c.encryptionKey...))
+		keyID = hex.EncodeToString(sum[:8])
+	}
+
+	markerPath := filepath.Join(c.cacheDir, "encryption")
+	previous := "none"
+	if data, err := os.ReadFile(markerPath); err == nil {
+		previous = strings.TrimSpace(string(data))
+	}
+
+	if previous != keyID && c.Len() > 0 {
+		cleared := c.Clear()
+		c.clearPartials()
+		logger.Warn().Emitf("Cache encryption key changed, cleared %d unreadable entries", cleared)
+	}
+
+	if err := os.WriteFile(markerPath, []byte(keyID+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write encryption marker: %w", err)
+	}
+	return nil
+}
+
+// clearPartials removes all partial downloads
+func (c *DiskLRUCache) clearPartials() {
+	names, _ := os.ReadDir(c.partialDir)
+	for _, name := range names {
+		os.Remove(filepath.Join(c.partialDir, name.Name()))
+	}
+}
+
+// encryptWriter seals everything written to it into w. Close writes the
+// final segment but does not close w.
+type encryptWriter struct {
+	aead   cipher.AEAD
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+	index  uint32
+}
+
+func newEncryptWriter(aead cipher.AEAD, w io.Writer) (*encryptWriter, error) {
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := append([]byte(encryptionMagic), prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{
+		aead:   aead,
+		w:      w,
+		prefix: prefix,
+		buf:    make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		// A full buffer is only sealed once more data arrives, because the
+		// last segment must be sealed with the final flag
+		if len(e.buf) == segmentSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		chunk := min(len(p), segmentSize-len(e.buf))
+		e.buf = append(e.buf, p[:chunk]...)
+		p = p[chunk:]
+		n += chunk
+	}
+	return n, nil
+}
+
+// Close seals the final segment.
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(final bool) error {
+	sealed := e.aead.Seal(nil, segmentNonce(e.prefix, e.index, final), e.buf, nil)
+	if _, err := e.w.Write(sealed); err != nil {
+		return err
+	}
+	e.index++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// encryptedFile decrypts an encrypted cache file on read
+type encryptedFile struct {
+	file     *os.File
+	aead     cipher.AEAD
+	prefix   []byte
+	segments int64
+	size     int64 // plaintext size
+	offset   int64
+
+	cached      []byte // plaintext of segment cachedIndex
+	cachedIndex int64
+}
+
+func openEncrypted(aead cipher.AEAD, file *os.File) (*encryptedFile, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat encrypted file: %w", err)
+	}
+
+	header := make([]byte, encryptedHeader)
+	if _, err := file.ReadAt(header, 0); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, fmt.Errorf("%w: missing header", ErrDecrypt)
+	}
+
+	body := info.Size() - int64(encryptedHeader)
+	segments := (body + sealedSegmentSize - 1) / sealedSegmentSize
+	if segments == 0 {
+		return nil, fmt.Errorf("%w: truncated", ErrDecrypt)
+	}
+
+	return &encryptedFile{
+		file:        file,
+		aead:        aead,
+		prefix:      header[len(encryptionMagic):],
+		segments:    segments,
+		size:        body - segments*segmentTagSize,
+		cachedIndex: -1,
+	}, nil
+}
+
+func (f *encryptedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+
+	n := 0
+	for n < len(p) {
+		if off >= f.size {
+			return n, io.EOF
+		}
+
+		index := off / segmentSize
+		plain, err := f.segment(index)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], plain[off-index*segmentSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (f *encryptedFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.offset)
+	f.offset += int64(n)
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *encryptedFile) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.offset + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position %d", abs)
+	}
+	f.offset = abs
+	return abs, nil
+}
+
+func (f *encryptedFile) Close() error {
+	return f.file.Close()
+}
+
+// segment returns the decrypted plaintext of segment index
+func (f *encryptedFile) segment(index int64) ([]byte, error) {
+	if index == f.cachedIndex {
+		return f.cached, nil
+	}
+
+	sealed := make([]byte, sealedSegmentSize)
+	n, err := f.file.ReadAt(sealed, int64(encryptedHeader)+index*sealedSegmentSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+
+	final := index == f.segments-1
+	plain, err := f.aead.Open(sealed[:0], segmentNonce(f.prefix, uint32(index), final), sealed[:n], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: segment %d: %v", ErrDecrypt, index, err)
+	}
+
+	f.cached = plain
+	f.cachedIndex = index
+	return plain, nil
+}
+
+func segmentNonce(prefix []byte, index uint32, final bool) []byte {
+	nonce := make([]byte, 0, noncePrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if final {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// WithAccelerate downloads from buckets matching any of patterns (bucket
+// names or path.Match patterns) through S3 Transfer Acceleration endpoints.
+// Acceleration must be enabled on the bucket.
+func WithAccelerate(patterns []string) DownloaderOption {
+	return func(d *S3Downloader) {
+		d.accelerate = patterns
+	}
+}
+
+// WithDualStack uses dual-stack (IPv4 and IPv6) S3 endpoints for buckets
+// matching any of patterns, including for region detection.
+func WithDualStack(patterns []string) DownloaderOption {
+	return func(d *S3Downloader) {
+		d.dualStack = patterns
+	}
+}
+
+// clientOptions returns the S3 client options for bucket. detect is true for
+// region detection requests, which don't support Transfer Acceleration.
+func (d *S3Downloader) clientOptions(bucket string, detect bool) func(*s3.Options) {
+	accelerate := !detect && matchAny(d.accelerate, bucket)
+	dualStack := matchAny(d.dualStack, bucket)
+
+	return func(o *s3.Options) {
+		o.DisableLogOutputChecksumValidationSkipped = true
+		o.APIOptions = append(o.APIOptions, addTrace)
+		o.UseAccelerate = accelerate
+		if dualStack {
+			o.EndpointOptions.UseDualStackEndpoint = aws.DualStackEndpointStateEnabled
+		}
+	}
+}
+
+func matchAny(patterns []string, bucket string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, bucket); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened to a cache entry.
+type EventType string
+
+const (
+	EventInsert EventType = "insert" // entry stored (new or replaced)
+	EventEvict  EventType = "evict"  // entry evicted to make room
+	EventRemove EventType = "remove" // entry deleted explicitly or found missing on disk
+)
+
+// Event describes a change to the cache contents.
+type Event struct {
+	Type     EventType `json:"type"`
+	Key      string    `json:"key"`
+	Size     int64     `json:"size"`
+	Checksum Checksum  `json:"checksum,omitzero"`
+	Time     time.Time `json:"time"`
+}
+
+// eventBus delivers events to subscribers. Events raised while the cache lock
+// is held are queued and delivered after the lock is released, so callbacks
+// may safely call back into the cache.
+type eventBus struct {
+	mu      sync.Mutex
+	subs    map[int]func(Event)
+	nextID  int
+	pending []Event
+}
+
+// Subscribe registers fn to be called for every cache event. Callbacks run
+// on the goroutine that caused the change and should return quickly.
+// The returned function removes the subscription.
+func (c *DiskLRUCache) Subscribe(fn func(Event)) (unsubscribe func()) {
+	return c.events.subscribe(fn)
+}
+
+// subscribe registers fn with the bus and returns the function removing it
+func (b *eventBus) subscribe(fn func(Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs == nil {
+		b.subs = make(map[int]func(Event))
+	}
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = fn
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// OnInsert registers fn to be called whenever an entry is stored.
+func (c *DiskLRUCache) OnInsert(fn func(Event)) (unsubscribe func()) {
+	return c.subscribeType(EventInsert, fn)
+}
+
+// OnEvict registers fn to be called whenever an entry is evicted.
+func (c *DiskLRUCache) OnEvict(fn func(Event)) (unsubscribe func()) {
+	return c.subscribeType(EventEvict, fn)
+}
+
+// OnRemove registers fn to be called whenever an entry is deleted.
+func (c *DiskLRUCache) OnRemove(fn func(Event)) (unsubscribe func()) {
+	return c.subscribeType(EventRemove, fn)
+}
+
+func (c *DiskLRUCache) subscribeType(t EventType, fn func(Event)) func() {
+	return c.Subscribe(func(e Event) {
+		if e.Type == t {
+			fn(e)
+		}
+	})
+}
+
+// emit queues an event for delivery (called with the cache lock held)
+func (b *eventBus) emit(t EventType, entry *Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.subs) == 0 {
+		return
+	}
+	b.pending = append(b.pending, Event{Type: t, Key: entry.Key, Size: entry.Size, Checksum: entry.Checksum, Time: time.Now()})
+}
+
+// dispatch delivers queued events (called without the cache lock held)
+func (b *eventBus) dispatch() {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	subs := make([]func(Event), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.mu.Unlock()
+
+	for _, e := range pending {
+		for _, fn := range subs {
+			fn(e)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WithFsync flushes each file to disk before it is committed to the cache,
+// and the directory it is renamed into afterwards, so a power loss can't
+// leave an entry whose file is missing or cut short. Without it, the
+// operating system writes files back in its own time, and validateFiles
+// drops entries whose file didn't survive intact.
+func WithFsync() Option {
+	return func(c *DiskLRUCache) {
+		c.fsync = true
+	}
+}
+
+// syncFile flushes the file at path to disk, if the cache is set to
+func (c *DiskLRUCache) syncFile(path string) error {
+	if !c.fsync {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir makes the creation, renaming or removal of files in the
+// directory at path durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncCommitted flushes the file at path, just renamed or copied into
+// place, and its directory, if the cache is set to
+func (c *DiskLRUCache) syncCommitted(path string) error {
+	if !c.fsync {
+		return nil
+	}
+	if err := c.syncFile(path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
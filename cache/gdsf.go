@@ -0,0 +1,112 @@
+package cache
+
+import "container/heap"
+
+// GDSFPolicy implements Greedy-Dual-Size-Frequency eviction. Each entry has
+// priority L + frequency/size, where L is the priority of the last evicted
+// entry. Large files that are read once get a low priority and are evicted
+// before small, frequently read ones, which keeps one-shot downloads of huge
+// artifacts from flushing the rest of the cache.
+type GDSFPolicy struct {
+	items    gdsfHeap
+	index    map[string]*gdsfItem
+	inflator float64 // L
+}
+
+type gdsfItem struct {
+	key      string
+	size     int64
+	freq     int64
+	priority float64
+	pos      int
+}
+
+// NewGDSFPolicy creates an empty size-aware GDSF policy.
+func NewGDSFPolicy() *GDSFPolicy {
+	return &GDSFPolicy{
+		index: make(map[string]*gdsfItem),
+	}
+}
+
+func (p *GDSFPolicy) Name() string { return "gdsf" }
+
+func (p *GDSFPolicy) Add(entry *Entry) {
+	freq := entry.AccessCount
+	if freq < 1 {
+		freq = 1
+	}
+
+	if item, ok := p.index[entry.Key]; ok {
+		item.size = entry.Size
+		item.freq = freq
+		item.priority = p.priorityOf(item)
+		heap.Fix(&p.items, item.pos)
+		return
+	}
+
+	item := &gdsfItem{key: entry.Key, size: entry.Size, freq: freq}
+	item.priority = p.priorityOf(item)
+	heap.Push(&p.items, item)
+	p.index[entry.Key] = item
+}
+
+func (p *GDSFPolicy) Access(entry *Entry) {
+	if item, ok := p.index[entry.Key]; ok {
+		item.freq++
+		item.priority = p.priorityOf(item)
+		heap.Fix(&p.items, item.pos)
+	}
+}
+
+func (p *GDSFPolicy) Remove(key string) {
+	if item, ok := p.index[key]; ok {
+		heap.Remove(&p.items, item.pos)
+		delete(p.index, key)
+	}
+}
+
+func (p *GDSFPolicy) Evict() (string, bool) {
+	if p.items.Len() == 0 {
+		return "", false
+	}
+	item := heap.Pop(&p.items).(*gdsfItem)
+	delete(p.index, item.key)
+	p.inflator = item.priority
+	return item.key, true
+}
+
+func (p *GDSFPolicy) priorityOf(item *gdsfItem) float64 {
+	size := item.size
+	if size < 1 {
+		size = 1
+	}
+	// Scale to MB so priorities stay in a readable range
+	return p.inflator + float64(item.freq)/(float64(size)/(1024*1024))
+}
+
+// gdsfHeap is a min-heap ordered by priority
+type gdsfHeap []*gdsfItem
+
+func (h gdsfHeap) Len() int           { return len(h) }
+func (h gdsfHeap) Less(i, j int) bool { return h[i].priority < h[j].priority }
+
+func (h gdsfHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *gdsfHeap) Push(x any) {
+	item := x.(*gdsfItem)
+	item.pos = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *gdsfHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,315 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// janitorCheckInterval is how often the janitor checks whether quiet hours
+// have begun
+const janitorCheckInterval = time.Minute
+
+// partialMaxAge is how long a partial download can go untouched before the
+// janitor treats it as abandoned
+const partialMaxAge = 24 * time.Hour
+
+// QuietHours is a daily window of local time, such as 01:00 to 05:00, in
+// which background maintenance can't get in the way of traffic.
+type QuietHours struct {
+	Start time.Duration // since midnight
+	End   time.Duration // since midnight; before Start for a window spanning midnight
+}
+
+// ParseQuietHours parses a window written as "HH:MM-HH:MM".
+func ParseQuietHours(s string) (QuietHours, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return QuietHours{}, fmt.Errorf("quiet hours must be HH:MM-HH:MM, got %q", s)
+	}
+	var q QuietHours
+	for _, part := range []struct {
+		text string
+		dst  *time.Duration
+	}{{start, &q.Start}, {end, &q.End}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(part.text))
+		if err != nil {
+			return QuietHours{}, fmt.Errorf("quiet hours must be HH:MM-HH:MM, got %q", s)
+		}
+		*part.dst = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if q.Start == q.End {
+		return QuietHours{}, fmt.Errorf("quiet hours must not start and end at the same time, got %q", s)
+	}
+	return q, nil
+}
+
+func (q QuietHours) String() string {
+	format := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return format(q.Start) + "-" + format(q.End)
+}
+
+// window returns the start and end of the window t falls in, and false if
+// it falls in none
+func (q QuietHours) window(t time.Time) (start, end time.Time, ok bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	// A window spanning midnight may have started the day before
+	for _, day := range []time.Time{midnight, midnight.AddDate(0, 0, -1)} {
+		start = day.Add(q.Start)
+		end = day.Add(q.End)
+		if q.End < q.Start {
+			end = day.AddDate(0, 0, 1).Add(q.End)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return start, end, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// JanitorStats describes what the janitor has done since startup.
+type JanitorStats struct {
+	QuietHours      string    `json:"quietHours"`
+	Runs            int64     `json:"runs"`
+	LastRun         time.Time `json:"lastRun,omitzero"`
+	LastDurationMs  int64     `json:"lastDurationMs"`
+	PartialsRemoved int64     `json:"partialsRemoved"` // abandoned partial downloads deleted
+	TrashRemoved    int64     `json:"trashRemoved"`    // expired trash entries and stray trash files deleted
+	Verified        int64     `json:"verified"`        // entries whose contents were hashed again
+	Corrupt         int64     `json:"corrupt"`         // entries dropped as their contents no longer matched
+	Compactions     int64     `json:"compactions"`     // rounds of metadata compaction, which write nothing if it is already compact
+}
+
+// janitorState holds the janitor's schedule and progress
+type janitorState struct {
+	hours      *QuietHours // nil when the janitor is disabled
+	verifyFrom string      // key verification resumes after, empty to start over
+	stats      JanitorStats
+}
+
+// WithJanitor runs maintenance once during each window of hours: it deletes
+// abandoned partial downloads and expired or stray trash, hashes the
+// contents of entries again and drops those that no longer match, and
+// compacts the metadata. Verification stops when the window ends and picks
+// up where it left off in the next one.
+func WithJanitor(hours QuietHours) Option {
+	return func(c *DiskLRUCache) {
+		c.janitor.hours = &hours
+		c.janitor.stats.QuietHours = hours.String()
+	}
+}
+
+// janitorLoop runs the janitor once per quiet window, until the process
+// exits
+func (c *DiskLRUCache) janitorLoop() {
+	ticker := time.NewTicker(janitorCheckInterval)
+	defer ticker.Stop()
+
+	var last time.Time
+	for now := range ticker.C {
+		start, end, ok := c.janitor.hours.window(now)
+		if !ok || start.Equal(last) {
+			continue
+		}
+		last = start
+		ctx, cancel := context.WithDeadline(context.Background(), end)
+		c.runJanitor(ctx)
+		cancel()
+	}
+}
+
+// runJanitor carries out one round of maintenance, stopping early once ctx
+// is done
+func (c *DiskLRUCache) runJanitor(ctx context.Context) {
+	start := time.Now()
+	partials := c.removeAbandonedPartials()
+	trash := c.trimTrash()
+	verified, corrupt := c.reverifyEntries(ctx)
+	compactions := int64(0)
+	if err := c.snapshotMetadata(); err != nil {
+		logger.Warn().Emitf("Janitor failed to save cache metadata: %v", err)
+	} else {
+		compactions++
+	}
+
+	c.mu.Lock()
+	stats := &c.janitor.stats
+	stats.Runs++
+	stats.LastRun = start
+	stats.LastDurationMs = time.Since(start).Milliseconds()
+	stats.PartialsRemoved += partials
+	stats.TrashRemoved += trash
+	stats.Verified += verified
+	stats.Corrupt += corrupt
+	stats.Compactions += compactions
+	c.mu.Unlock()
+
+	logger.Info().Emitf("Janitor finished in %v: %d abandoned partial downloads and %d trash files deleted, %d entries verified, %d corrupt",
+		time.Since(start).Round(time.Millisecond), partials, trash, verified, corrupt)
+}
+
+// removeAbandonedPartials deletes partial downloads that haven't been
+// written to for partialMaxAge, skipping any being resumed, and returns how
+// many it deleted
+func (c *DiskLRUCache) removeAbandonedPartials() int64 {
+	names, _ := filepath.Glob(filepath.Join(c.partialDir, "*.part.json"))
+	removed := int64(0)
+	for _, infoPath := range names {
+		dataPath := strings.TrimSuffix(infoPath, ".json")
+		info, err := os.Stat(dataPath)
+		if err == nil && time.Since(info.ModTime()) < partialMaxAge {
+			continue
+		}
+
+		var partial partialInfo
+		raw, err := os.ReadFile(infoPath)
+		if err == nil {
+			err = json.Unmarshal(raw, &partial)
+		}
+		if err != nil || partial.Key == "" {
+			os.Remove(dataPath)
+			os.Remove(infoPath)
+			removed++
+			continue
+		}
+		if !c.partials.acquire(partial.Key) {
+			continue
+		}
+		c.DiscardPartial(partial.Key)
+		c.partials.release(partial.Key)
+		removed++
+	}
+	return removed
+}
+
+// trimTrash deletes expired trash entries, and files in the trash that no
+// trashed entry refers to, left behind by a crash while trashing, and
+// returns how many files it deleted
+func (c *DiskLRUCache) trimTrash() int64 {
+	if c.trash.retention <= 0 {
+		return 0
+	}
+
+	dirs := []string{c.trash.dir}
+	for _, dir := range c.storageDirs() {
+		dirs = append(dirs, filepath.Join(dir, tierTrashDir))
+	}
+	var files []string
+	for _, dir := range dirs {
+		names, _ := os.ReadDir(dir)
+		for _, name := range names {
+			files = append(files, filepath.Join(dir, name.Name()))
+		}
+	}
+
+	// Entries are trashed with the lock held, so a file trashed since the
+	// directories were read is among the entries by now
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := int64(c.expireTrash())
+	kept := make(map[string]bool, len(c.trash.entries))
+	for _, trashed := range c.trash.entries {
+		kept[c.trashFilePath(&trashed.Entry)] = true
+	}
+	for _, file := range files {
+		if !kept[file] && os.Remove(file) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// reverifyEntries hashes the contents of entries again, in key order from
+// where the last run stopped, and drops those whose hash no longer matches
+// the one recorded when they were cached. Entries without a hash get one.
+// Returns how many entries it verified and how many it dropped.
+func (c *DiskLRUCache) reverifyEntries(ctx context.Context) (verified, corrupt int64) {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.entries))
+	for key := range c.entries {
+		if key > c.janitor.verifyFrom {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return verified, corrupt
+		}
+
+		c.mu.RLock()
+		entry, ok := c.entries[key]
+		var filePath, want string
+		if ok {
+			filePath, want = c.filePath(entry), entry.SHA256
+		}
+		c.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		got, err := c.hashContents(filePath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // replaced or removed meanwhile
+		}
+
+		c.mu.Lock()
+		c.janitor.verifyFrom = key
+		// Skip entries replaced or removed while they were hashed
+		if c.entries[key] == entry {
+			switch {
+			case err != nil:
+				logger.Warn().Emitf("Dropping %s: its contents can't be read: %v", key, err)
+				c.removeEntry(key, EventRemove)
+				corrupt++
+			case want == "":
+				entry.SHA256 = got
+				c.journalPut(entry)
+			case got != want:
+				logger.Warn().Emitf("Dropping %s: its contents no longer match their SHA-256", key)
+				c.removeEntry(key, EventRemove)
+				corrupt++
+			}
+			verified++
+		}
+		c.mu.Unlock()
+		c.events.dispatch()
+	}
+
+	c.mu.Lock()
+	c.janitor.verifyFrom = ""
+	c.mu.Unlock()
+	return verified, corrupt
+}
+
+// hashContents returns the hex SHA-256 of the plaintext contents of the
+// cached file at filePath
+func (c *DiskLRUCache) hashContents(filePath string) (string, error) {
+	file, err := c.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
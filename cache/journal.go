@@ -0,0 +1,294 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// Metadata is persisted as a snapshot, metadata.json, plus a journal of the
+// changes made since, metadata.journal. Each change appends one line to the
+// journal while the cache lock is held, which costs a small write instead of
+// rewriting the whole snapshot. A background flusher folds the journal into a
+// new snapshot every so often. A crash loses nothing that was journaled, as
+// startup replays the journal over the snapshot.
+//
+// To snapshot, the journal is renamed to metadata.journal.old and a new one
+// started, under the lock, so nothing is appended to the old journal once
+// the entries are copied. The copy is then written to a temporary file and
+// renamed over metadata.json, and only then is the old journal removed. If
+// the process dies in between, the old journal is replayed too; replaying
+// changes already in the snapshot is harmless.
+const (
+	metadataFile   = "metadata.json"
+	journalFile    = "metadata.journal"
+	oldJournalFile = "metadata.journal.old"
+)
+
+// metadataFlushInterval is how often the flusher checks whether a new
+// snapshot is due; access times and counts are only persisted by snapshots
+const metadataFlushInterval = 30 * time.Second
+
+// journalCompactRecords is how many journal records prompt a snapshot before
+// the next interval
+const journalCompactRecords = 10000
+
+// journalRecord is one change in the journal
+type journalRecord struct {
+	Op    string `json:"op"` // put, remove or clear
+	Entry *Entry `json:"entry,omitempty"`
+	Key   string `json:"key,omitempty"`
+}
+
+// metadataJournal appends changes to the journal file
+type metadataJournal struct {
+	file    *os.File
+	records int           // appended since the last snapshot
+	dirty   bool          // entries changed in ways not journaled, like access times
+	compact chan struct{} // signals the flusher that the journal has grown
+}
+
+// openJournal opens the journal for appending, creating it if needed
+func (c *DiskLRUCache) openJournal() error {
+	file, err := os.OpenFile(filepath.Join(c.cacheDir, journalFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open metadata journal: %w", err)
+	}
+	c.journal.file = file
+	if c.journal.compact == nil {
+		c.journal.compact = make(chan struct{}, 1)
+	}
+	return nil
+}
+
+// journalPut records that entry was stored or changed (must be called with
+// lock held)
+func (c *DiskLRUCache) journalPut(entry *Entry) {
+	c.appendJournal(journalRecord{Op: "put", Entry: entry})
+}
+
+// journalRemove records that key was removed (must be called with lock held)
+func (c *DiskLRUCache) journalRemove(key string) {
+	c.appendJournal(journalRecord{Op: "remove", Key: key})
+}
+
+func (c *DiskLRUCache) appendJournal(record journalRecord) {
+	j := &c.journal
+	if j.file == nil {
+		j.dirty = true // persisted by the next snapshot instead
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		logger.Warn().Emitf("Failed to append to metadata journal: %v", err)
+		j.dirty = true
+		return
+	}
+	j.records++
+	if j.records == journalCompactRecords {
+		select {
+		case j.compact <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// flushMetadata writes a new snapshot whenever the journal has grown or
+// entries have changed, until the process exits
+func (c *DiskLRUCache) flushMetadata() {
+	ticker := time.NewTicker(metadataFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.journal.compact:
+		}
+		if err := c.snapshotMetadata(); err != nil {
+			logger.Warn().Emitf("Failed to save cache metadata: %v", err)
+		}
+	}
+}
+
+// Flush writes a snapshot of the cache metadata now, folding in the journal.
+// Changes are journaled as they happen, so this is only needed to persist
+// access times before a planned shutdown.
+func (c *DiskLRUCache) Flush() error {
+	return c.snapshotMetadata()
+}
+
+// snapshotMetadata writes every entry to a new metadata.json and drops the
+// journal records it covers. The lock is only held while entries are copied.
+func (c *DiskLRUCache) snapshotMetadata() error {
+	c.snapshotMu.Lock()
+	defer c.snapshotMu.Unlock()
+
+	c.mu.Lock()
+	if c.journal.records == 0 && !c.journal.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	entries := make([]Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, *entry)
+	}
+	err := c.rotateJournal()
+	c.journal.records, c.journal.dirty = 0, false
+	c.mu.Unlock()
+	if err != nil {
+		c.mu.Lock()
+		c.journal.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+
+	if err := writeSnapshot(filepath.Join(c.cacheDir, metadataFile), entries); err != nil {
+		c.mu.Lock()
+		c.journal.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+	if err := os.Remove(filepath.Join(c.cacheDir, oldJournalFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old metadata journal: %w", err)
+	}
+	return nil
+}
+
+// rotateJournal moves the journal aside as the old journal and starts a new
+// one (must be called with lock held). If an old journal is left over from a
+// snapshot that failed, the journal is appended to it instead, as the
+// snapshot that would have covered it was never written.
+func (c *DiskLRUCache) rotateJournal() error {
+	journalPath := filepath.Join(c.cacheDir, journalFile)
+	oldPath := filepath.Join(c.cacheDir, oldJournalFile)
+
+	if c.journal.file != nil {
+		c.journal.file.Close()
+		c.journal.file = nil
+	}
+
+	if _, err := os.Stat(oldPath); err == nil {
+		if err := appendFile(oldPath, journalPath); err != nil {
+			c.openJournal()
+			return err
+		}
+		if err := os.Remove(journalPath); err != nil && !os.IsNotExist(err) {
+			c.openJournal()
+			return fmt.Errorf("failed to rotate metadata journal: %w", err)
+		}
+	} else if err := os.Rename(journalPath, oldPath); err != nil && !os.IsNotExist(err) {
+		c.openJournal()
+		return fmt.Errorf("failed to rotate metadata journal: %w", err)
+	}
+	return c.openJournal()
+}
+
+// appendFile appends the contents of src to dst
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to rotate metadata journal: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to rotate metadata journal: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to rotate metadata journal: %w", err)
+	}
+	return out.Close()
+}
+
+// writeSnapshot atomically replaces the snapshot at path with entries
+func writeSnapshot(path string, entries []Entry) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata file: %w", err)
+	}
+	w := bufio.NewWriterSize(file, 1<<20)
+	err = json.NewEncoder(w).Encode(entries)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace metadata file: %w", err)
+	}
+
+	// Make the rename itself durable
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// replayJournal applies the changes recorded in the journal at path to the
+// entries loaded from the snapshot and returns how many there were. A line
+// cut short by a crash ends the replay, and is cut from the file so that
+// records appended after it can be read back.
+func replayJournal(path string, entries map[string]*Entry) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open metadata journal: %w", err)
+	}
+	defer file.Close()
+
+	replayed := 0
+	var offset int64 // end of the last complete record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			logger.Warn().Emitf("Ignoring the end of %s: %v", filepath.Base(path), err)
+			if err := os.Truncate(path, offset); err != nil {
+				return replayed, fmt.Errorf("failed to truncate metadata journal: %w", err)
+			}
+			return replayed, nil
+		}
+		offset += int64(len(scanner.Bytes())) + 1
+		replayed++
+		switch record.Op {
+		case "put":
+			if record.Entry != nil && record.Entry.Key != "" {
+				entries[record.Entry.Key] = record.Entry
+			}
+		case "remove":
+			delete(entries, record.Key)
+		case "clear":
+			clear(entries)
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return replayed, fmt.Errorf("failed to read metadata journal: %w", err)
+	}
+	return replayed, nil
+}
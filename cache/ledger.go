@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+)
+
+// ledger is the bookkeeping DiskLRUCache and MemoryCache share: the entries
+// they hold and the space those take, the eviction policies ranking them,
+// namespaces and statistics. Both embed it, and call its methods with their
+// lock held.
+type ledger struct {
+	maxSizeBytes int64
+	maxEntries   int // 0 for no limit on the number of entries
+	currentSize  int64
+	entries      map[string]*Entry // key -> entry
+	policy       Policy
+	stats        Stats
+
+	rules []EvictionRule // set by WithEvictionRules
+	first Policy         // entries evicted before the policy's picks, nil without rules
+
+	namespaces map[string]*namespace // by name, created on first use
+
+	// evict removes the entry for key to make room, however the cache
+	// stores it
+	evict func(key string)
+}
+
+// evictIfNeeded removes entries chosen by the policy until there's room for
+// a new entry for key of newSize, or until the cache is within its limits if
+// key is "". An entry in a namespace with a budget first makes room within
+// the namespace, from its own entries. It stops early, returning ctx's
+// error, once ctx is done.
+func (l *ledger) evictIfNeeded(ctx context.Context, key string, newSize int64) error {
+	if ns := l.namespaceOf(key); ns != nil {
+		l.evictNamespace(ns, newSize)
+	}
+
+	newEntries := 0
+	if key != "" {
+		newEntries = 1
+	}
+	for len(l.entries) > 0 && (l.currentSize+newSize > l.maxSizeBytes ||
+		l.maxEntries > 0 && len(l.entries)+newEntries > l.maxEntries) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !l.evictOne() {
+			break
+		}
+	}
+
+	return nil
+}
+
+// evictOne removes an entry an eviction rule puts first or, failing that,
+// the one chosen by the policy, returning false if there is none to evict
+func (l *ledger) evictOne() bool {
+	key, ok := "", false
+	if l.first != nil {
+		key, ok = l.first.Evict()
+	}
+	if !ok {
+		key, ok = l.policy.Evict()
+	}
+	if !ok {
+		return false
+	}
+
+	if ns := l.namespaceOf(key); ns != nil {
+		ns.stats.Evictions++
+	}
+	l.evict(key)
+	l.stats.Evictions++
+	return true
+}
+
+// evictNamespace removes entries of ns chosen by its policy until there's
+// room for newSize bytes within its budget
+func (l *ledger) evictNamespace(ns *namespace, newSize int64) {
+	if ns.stats.MaxBytes <= 0 {
+		return
+	}
+	for ns.stats.TotalBytes+newSize > ns.stats.MaxBytes && ns.stats.EntryCount > 0 {
+		key, ok := ns.policy.Evict()
+		if !ok {
+			break
+		}
+		l.evict(key)
+		l.stats.Evictions++
+		ns.stats.Evictions++
+	}
+}
+
+// trackEntry adds entry to the cache's size, its namespace's and the
+// eviction policies
+func (l *ledger) trackEntry(entry *Entry) {
+	l.entries[entry.Key] = entry
+	l.currentSize += entry.Size
+	if ns := l.namespaceOf(entry.Key); ns != nil {
+		ns.stats.TotalBytes += entry.Size
+		ns.stats.EntryCount++
+	}
+	if !entry.Pinned {
+		l.policyAdd(entry)
+	}
+}
+
+// untrackEntry undoes trackEntry
+func (l *ledger) untrackEntry(entry *Entry) {
+	delete(l.entries, entry.Key)
+	l.currentSize -= entry.Size
+	l.policyRemove(entry.Key)
+	if ns := l.namespaceOf(entry.Key); ns != nil {
+		ns.stats.TotalBytes -= entry.Size
+		ns.stats.EntryCount--
+	}
+}
+
+// recordAccess marks entry as just used
+func (l *ledger) recordAccess(entry *Entry) {
+	entry.AccessTime = time.Now()
+	entry.AccessCount++
+	if !entry.Pinned {
+		l.policyAccess(entry)
+	}
+}
+
+// policyAdd, policyAccess and policyRemove keep the cache's policy, or the
+// one of entries evicted first, and that of the entry's namespace in step.
+// Entries an eviction rule keeps are in none of them.
+func (l *ledger) policyAdd(entry *Entry) {
+	switch l.evictionAction(entry.Key) {
+	case EvictNever:
+		return
+	case EvictFirst:
+		l.first.Add(entry)
+	default:
+		l.policy.Add(entry)
+	}
+	if ns := l.namespaceOf(entry.Key); ns != nil {
+		ns.policy.Add(entry)
+	}
+}
+
+func (l *ledger) policyAccess(entry *Entry) {
+	switch l.evictionAction(entry.Key) {
+	case EvictNever:
+		return
+	case EvictFirst:
+		l.first.Access(entry)
+	default:
+		l.policy.Access(entry)
+	}
+	if ns := l.namespaceOf(entry.Key); ns != nil {
+		ns.policy.Access(entry)
+	}
+}
+
+func (l *ledger) policyRemove(key string) {
+	l.policy.Remove(key)
+	if l.first != nil {
+		l.first.Remove(key)
+	}
+	if ns := l.namespaceOf(key); ns != nil {
+		ns.policy.Remove(key)
+	}
+}
+
+// evictionAction returns the action of the first rule matching key, or ""
+// if none does
+func (l *ledger) evictionAction(key string) EvictionAction {
+	if len(l.rules) == 0 {
+		return ""
+	}
+	// Entries derived from an object fall under the object's rules, as far
+	// as patterns match them
+	key, _ = SplitDerived(key)
+	_, objectPath := SplitNamespace(key)
+	objectPath, _ = SplitVersion(objectPath)
+	for _, rule := range l.rules {
+		name := objectPath
+		if !strings.Contains(rule.Pattern, "/") {
+			name = path.Base(objectPath)
+		}
+		if ok, _ := path.Match(rule.Pattern, name); ok {
+			return rule.Action
+		}
+	}
+	return ""
+}
+
+// countHit and countMiss record a lookup of key in the cache's statistics
+// and its namespace's
+func (l *ledger) countHit(key string) {
+	l.stats.Hits++
+	if ns := l.namespaceOf(key); ns != nil {
+		ns.stats.Hits++
+	}
+}
+
+func (l *ledger) countMiss(key string) {
+	l.stats.Misses++
+	if ns := l.namespaceOf(key); ns != nil {
+		ns.stats.Misses++
+	}
+}
+
+// namespace returns the state of the named namespace, creating it on first
+// use
+func (l *ledger) namespace(name string) *namespace {
+	ns, ok := l.namespaces[name]
+	if !ok {
+		// Each namespace ranks its entries the way the cache does
+		policy, err := NewPolicy(l.policy.Name())
+		if err != nil {
+			policy = NewLRUPolicy()
+		}
+		ns = &namespace{policy: policy}
+		if l.namespaces == nil {
+			l.namespaces = make(map[string]*namespace)
+		}
+		l.namespaces[name] = ns
+	}
+	return ns
+}
+
+// namespaceOf returns the namespace key belongs to, or nil if none
+func (l *ledger) namespaceOf(key string) *namespace {
+	name, _ := SplitNamespace(key)
+	if name == "" {
+		return nil
+	}
+	return l.namespace(name)
+}
+
+// setNamespaceLimits replaces the budgets of namespaces, evicting from those
+// now over theirs, and returns the number of entries evicted
+func (l *ledger) setNamespaceLimits(limits map[string]int64) int {
+	for _, ns := range l.namespaces {
+		ns.stats.MaxBytes = 0
+	}
+	before := len(l.entries)
+	for name, maxBytes := range limits {
+		ns := l.namespace(name)
+		ns.stats.MaxBytes = maxBytes
+		l.evictNamespace(ns, 0)
+	}
+	return before - len(l.entries)
+}
+
+// namespaceStats returns the statistics of every namespace that has entries
+// or a budget, by name
+func (l *ledger) namespaceStats() map[string]NamespaceStats {
+	all := make(map[string]NamespaceStats, len(l.namespaces))
+	for name, ns := range l.namespaces {
+		if ns.stats.EntryCount > 0 || ns.stats.MaxBytes > 0 {
+			all[name] = ns.stats
+		}
+	}
+	return all
+}
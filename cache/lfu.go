@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// LFUPolicy evicts the least frequently used entry, breaking ties by
+// least recent access. Frequencies are seeded from Entry.AccessCount so
+// they survive restarts.
+type LFUPolicy struct {
+	items lfuHeap
+	index map[string]*lfuItem
+}
+
+type lfuItem struct {
+	key        string
+	count      int64
+	accessTime time.Time
+	pos        int
+}
+
+// NewLFUPolicy creates an empty least-frequently-used policy.
+func NewLFUPolicy() *LFUPolicy {
+	return &LFUPolicy{
+		index: make(map[string]*lfuItem),
+	}
+}
+
+func (p *LFUPolicy) Name() string { return "lfu" }
+
+func (p *LFUPolicy) Add(entry *Entry) {
+	if item, ok := p.index[entry.Key]; ok {
+		item.count = entry.AccessCount
+		item.accessTime = entry.AccessTime
+		heap.Fix(&p.items, item.pos)
+		return
+	}
+	item := &lfuItem{key: entry.Key, count: entry.AccessCount, accessTime: entry.AccessTime}
+	heap.Push(&p.items, item)
+	p.index[entry.Key] = item
+}
+
+func (p *LFUPolicy) Access(entry *Entry) {
+	if item, ok := p.index[entry.Key]; ok {
+		item.count++
+		item.accessTime = entry.AccessTime
+		heap.Fix(&p.items, item.pos)
+	}
+}
+
+func (p *LFUPolicy) Remove(key string) {
+	if item, ok := p.index[key]; ok {
+		heap.Remove(&p.items, item.pos)
+		delete(p.index, key)
+	}
+}
+
+func (p *LFUPolicy) Evict() (string, bool) {
+	if p.items.Len() == 0 {
+		return "", false
+	}
+	item := heap.Pop(&p.items).(*lfuItem)
+	delete(p.index, item.key)
+	return item.key, true
+}
+
+// lfuHeap is a min-heap ordered by (count, accessTime)
+type lfuHeap []*lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].accessTime.Before(h[j].accessTime)
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	item := x.(*lfuItem)
+	item.pos = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ObjectSummary describes an object returned by List.
+type ObjectSummary struct {
+	Key          string    `json:"key"` // bucket/path
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// List returns the objects in bucket whose keys start with prefix, in key
+// order. Folder placeholder objects (keys ending in /) are left out.
+func (d *S3Downloader) List(ctx context.Context, bucket, prefix string) ([]ObjectSummary, error) {
+	if err := d.breakers.allow(bucket); err != nil {
+		return nil, err
+	}
+	client, err := d.getClientForBucket(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
+	}
+
+	var objects []ObjectSummary
+	pages := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: optionalString(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			d.record(bucket, err)
+			return nil, fmt.Errorf("failed to list S3 objects: %w", explainS3Error(err, bucket))
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if key == "" || key[len(key)-1] == '/' {
+				continue
+			}
+			objects = append(objects, ObjectSummary{
+				Key:          bucket + "/" + key,
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	d.record(bucket, nil)
+	return objects, nil
+}
+
+// CheckAccess lists at most one object in bucket, returning an error if the
+// credentials can't.
+func (d *S3Downloader) CheckAccess(ctx context.Context, bucket string) error {
+	client, err := d.getClientForBucket(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
+	}
+	_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int32(1),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list S3 objects: %w", explainS3Error(err, bucket))
+	}
+	return nil
+}
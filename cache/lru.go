@@ -1,25 +1,51 @@
 package cache
 
 import (
-	"container/list"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/autonoma-ai/midway/logger"
+	"github.com/klauspost/compress/zstd"
 )
 
 // Entry represents a single cached file with its metadata.
 type Entry struct {
-	Key        string    `json:"key"`        // bucket/path (e.g., "bucket/folder/file")
-	Filename   string    `json:"filename"`   // local filename
-	Size       int64     `json:"size"`       // file size in bytes
-	AccessTime time.Time `json:"accessTime"` // last access time
-	CreateTime time.Time `json:"createTime"` // when file was cached
+	Key         string    `json:"key"`               // bucket/path (e.g., "bucket/folder/file")
+	Filename    string    `json:"filename"`          // local filename
+	Dir         string    `json:"dir,omitempty"`     // directory of the file in a storage tier, empty for the cache's files directory
+	Size        int64     `json:"size"`              // file size in bytes
+	AccessTime  time.Time `json:"accessTime"`        // last access time
+	CreateTime  time.Time `json:"createTime"`        // when file was cached
+	AccessCount int64     `json:"accessCount"`       // number of cache hits
+	Pinned      bool      `json:"pinned,omitempty"`  // never evicted
+	Checksum    Checksum  `json:"checksum,omitzero"` // S3 checksum the file was verified against, if any
+
+	SHA256       string    `json:"sha256,omitempty"`      // hex SHA-256 of the contents
+	LastModified time.Time `json:"lastModified,omitzero"` // of the S3 object, if known
+	ContentType  string    `json:"contentType,omitempty"` // of the S3 object, if known
+}
+
+// contentInfo describes the contents of a file being committed to the cache
+type contentInfo struct {
+	checksum     Checksum // S3 checksum the file was verified against, if any
+	sha256       string
+	lastModified time.Time
+	contentType  string
+	cached       time.Time // when the contents were first cached, if not now
 }
 
 // Stats contains cache performance metrics and current state information.
@@ -30,147 +56,484 @@ type Stats struct {
 	TotalBytes int64  `json:"totalBytes"`
 	MaxBytes   int64  `json:"maxBytes"`
 	EntryCount int    `json:"entryCount"`
+	MaxEntries int    `json:"maxEntries,omitempty"` // entry limit, 0 for none
 	CacheDir   string `json:"cacheDir"`
+	Policy     string `json:"policy"`
+
+	MemoryHits    int64 `json:"memoryHits"`    // hits served from the in-memory tier
+	MemoryBytes   int64 `json:"memoryBytes"`   // bytes held in the in-memory tier
+	MemoryEntries int   `json:"memoryEntries"` // entries held in the in-memory tier
+
+	TrashBytes   int64 `json:"trashBytes"`   // bytes of entries awaiting restore in the trash
+	TrashEntries int   `json:"trashEntries"` // entries awaiting restore in the trash
+
+	ColdBytes   int64 `json:"coldBytes,omitempty"`   // bytes of evicted entries kept in the cold tier
+	ColdEntries int   `json:"coldEntries,omitempty"` // evicted entries kept in the cold tier
+	ColdHits    int64 `json:"coldHits,omitempty"`    // cold entries moved back into the cache on access
+
+	Volumes []VolumeStats `json:"volumes,omitempty"` // directories files are spread over, if set
+
+	Janitor *JanitorStats `json:"janitor,omitempty"` // quiet-hours maintenance, if enabled
 }
 
-// DiskLRUCache is a disk-backed LRU cache for storing files locally.
-// It automatically evicts least recently used entries when the cache
-// exceeds its configured maximum size.
+// VolumeStats describes how full one cache volume is.
+type VolumeStats struct {
+	Dir        string `json:"dir"`
+	TotalBytes int64  `json:"totalBytes"`
+	MaxBytes   int64  `json:"maxBytes"`
+}
+
+// DiskLRUCache is a disk-backed cache for storing files locally.
+// It automatically evicts entries when the cache exceeds its configured
+// maximum size, choosing victims with its eviction Policy (LRU by default).
 type DiskLRUCache struct {
-	mu           sync.RWMutex
-	cacheDir     string
-	filesDir     string
-	maxSizeBytes int64
-	currentSize  int64
-	entries      map[string]*Entry        // key -> entry
-	accessOrder  *list.List               // LRU tracking (front = most recent)
-	accessMap    map[string]*list.Element // key -> list element
-	stats        Stats
-}
-
-// NewDiskLRUCache creates a new disk-backed LRU cache at the specified directory
+	ledger
+
+	mu         sync.RWMutex
+	cacheDir   string
+	filesDir   string
+	partialDir string // interrupted downloads awaiting resume
+	tiers      []StorageTier
+	volumes    []*volume   // set by WithVolumes
+	memory     *memoryTier // optional hot tier, nil when disabled
+	events     eventBus
+	partials   partialSet
+
+	encryptionKey []byte      // set by WithEncryption
+	aead          cipher.AEAD // nil when files are stored in plaintext
+
+	encoder *zstd.Encoder // set by WithCompression, nil when files are stored uncompressed
+
+	fsync bool // set by WithFsync
+
+	verifier Verifier // set by WithVerifier
+
+	trash trashState // entries kept for restoring after a purge
+	cold  coldTier   // evicted entries kept on slower storage, if enabled
+
+	janitor janitorState // set by WithJanitor
+
+	journal    metadataJournal // changes since the last metadata snapshot
+	snapshotMu sync.Mutex      // held while a snapshot is written
+}
+
+// Option configures optional DiskLRUCache behavior.
+type Option func(*DiskLRUCache)
+
+// WithPolicy sets the eviction policy. The default is LRU.
+func WithPolicy(p Policy) Option {
+	return func(c *DiskLRUCache) {
+		c.policy = p
+	}
+}
+
+// WithMemoryTier enables an in-memory hot tier of up to maxBytes. Files no
+// larger than maxEntryBytes are copied into memory once they have been hit
+// promoteAfter times, and are demoted (dropped from memory) least frequently
+// used first when the tier is full.
+func WithMemoryTier(maxBytes, maxEntryBytes, promoteAfter int64) Option {
+	return func(c *DiskLRUCache) {
+		c.memory = newMemoryTier(maxBytes, maxEntryBytes, promoteAfter)
+	}
+}
+
+// WithMaxEntries limits the number of entries, evicting by policy when a new
+// one would exceed it, in addition to the size limit. This bounds metadata
+// memory and the size of the files directory for caches of many small files.
+func WithMaxEntries(n int) Option {
+	return func(c *DiskLRUCache) {
+		c.maxEntries = n
+	}
+}
+
+// NewDiskLRUCache creates a new disk-backed cache at the specified directory
 // with a maximum size limit in gigabytes. It loads any existing cached entries
 // from disk on initialization.
-func NewDiskLRUCache(cacheDir string, maxSizeGB int64) (*DiskLRUCache, error) {
+func NewDiskLRUCache(cacheDir string, maxSizeGB int64, opts ...Option) (*DiskLRUCache, error) {
 	filesDir := filepath.Join(cacheDir, "files")
 	if err := os.MkdirAll(filesDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create cache directory: %w", err)
 	}
+	partialDir := filepath.Join(cacheDir, "partial")
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create partial download directory: %w", err)
+	}
 
 	cache := &DiskLRUCache{
-		cacheDir:     cacheDir,
-		filesDir:     filesDir,
-		maxSizeBytes: maxSizeGB * 1024 * 1024 * 1024, // GB to bytes
-		entries:      make(map[string]*Entry),
-		accessOrder:  list.New(),
-		accessMap:    make(map[string]*list.Element),
-		stats: Stats{
-			MaxBytes: maxSizeGB * 1024 * 1024 * 1024,
-			CacheDir: cacheDir,
+		ledger: ledger{
+			maxSizeBytes: maxSizeGB * 1024 * 1024 * 1024, // GB to bytes
+			entries:      make(map[string]*Entry),
+			policy:       NewLRUPolicy(),
+			stats: Stats{
+				MaxBytes: maxSizeGB * 1024 * 1024 * 1024,
+				CacheDir: cacheDir,
+			},
 		},
+		cacheDir:   cacheDir,
+		filesDir:   filesDir,
+		partialDir: partialDir,
+	}
+	cache.evict = cache.evictEntry
+	for _, opt := range opts {
+		opt(cache)
+	}
+	cache.stats.Policy = cache.policy.Name()
+	cache.stats.MaxEntries = cache.maxEntries
+	if err := cache.initStorage(); err != nil {
+		return nil, err
 	}
 
 	if err := cache.loadFromDisk(); err != nil {
 		// Log warning but continue - cache will rebuild
 		logger.Warn().Emitf("Failed to load cache metadata: %v", err)
+		cache.journal.dirty = true
+	}
+	if err := cache.openJournal(); err != nil {
+		return nil, err
+	}
+	go cache.flushMetadata()
+	if len(cache.entries) > 0 {
+		go cache.validateFiles()
+	}
+
+	if err := cache.initEncryption(); err != nil {
+		return nil, err
+	}
+	if err := cache.initTrash(); err != nil {
+		return nil, err
+	}
+	if err := cache.initCold(); err != nil {
+		return nil, err
+	}
+	if cache.janitor.hours != nil {
+		go cache.janitorLoop()
+	}
+	if cache.memory != nil {
+		cache.memory.readFile = cache.readFile
 	}
 
 	return cache, nil
 }
 
 // Get retrieves the local file path for a cached entry by its key.
-// It updates the entry's access time and moves it to the front of the LRU list.
+// It updates the entry's access time and records the hit with the eviction policy.
 // Returns the file path and true if found, or an empty string and false if not.
+// An entry in the cold tier is moved back into the cache first.
 func (c *DiskLRUCache) Get(key string) (string, bool) {
+	if c.cold.maxSize > 0 {
+		c.promote(key)
+	}
+
+	filePath, promote, ok := c.get(key)
+	if promote != nil {
+		// Read without the lock held, as the file may need decrypting and
+		// decompressing
+		data, err := c.memory.readFile(filePath)
+		if err != nil {
+			data = nil
+		}
+		c.mu.Lock()
+		// Unless it was evicted or replaced meanwhile
+		if c.entries[key] != promote {
+			data = nil
+		}
+		c.memory.promote(promote, data)
+		c.mu.Unlock()
+	}
+	return filePath, ok
+}
+
+// get looks up key for Get, returning the entry if it is to be promoted to
+// the in-memory tier
+func (c *DiskLRUCache) get(key string) (string, *Entry, bool) {
+	defer c.events.dispatch()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	entry, exists := c.entries[key]
 	if !exists {
-		c.stats.Misses++
-		return "", false
+		c.countMiss(key)
+		return "", nil, false
 	}
 
-	// Verify file still exists
-	filePath := filepath.Join(c.filesDir, entry.Filename)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		// File was deleted externally, remove from cache
-		c.removeEntry(key)
-		c.stats.Misses++
-		return "", false
+	// Verify file still exists, and wasn't cut short by a crash before
+	// validateFiles got to it
+	filePath := c.filePath(entry)
+	if info, err := os.Stat(filePath); os.IsNotExist(err) || (err == nil && info.Size() != entry.Size) {
+		// File was deleted or damaged externally, remove from cache
+		c.removeEntry(key, EventRemove)
+		c.countMiss(key)
+		return "", nil, false
 	}
 
-	// Update access time and move to front of LRU
-	entry.AccessTime = time.Now()
-	if elem, ok := c.accessMap[key]; ok {
-		c.accessOrder.MoveToFront(elem)
+	// Update access time and record the hit
+	c.recordAccess(entry)
+
+	var promote *Entry
+	if c.memory != nil && c.memory.shouldPromote(entry) {
+		promote = entry
 	}
 
-	c.stats.Hits++
-	return filePath, true
+	c.countHit(key)
+	return filePath, promote, true
 }
 
-// Put stores a file in the cache by reading from the provided io.Reader.
-// If the key already exists, the old entry is replaced. The cache will
-// automatically evict least recently used entries if needed to make room.
-// Returns the local file path where the data was stored.
-func (c *DiskLRUCache) Put(key string, data io.Reader) (string, error) {
+// GetFromMemory returns the contents of an entry held in the in-memory tier,
+// along with the time it was cached. A false result is not counted as a miss;
+// callers should fall back to Get.
+func (c *DiskLRUCache) GetFromMemory(key string) ([]byte, time.Time, bool) {
+	if c.memory == nil {
+		return nil, time.Time{}, false
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// If key already exists, remove old entry
-	if _, exists := c.entries[key]; exists {
-		c.removeEntry(key)
+	entry, exists := c.entries[key]
+	if !exists {
+		return nil, time.Time{}, false
 	}
 
-	// Create a safe filename from the key
-	filename := sanitizeFilename(key)
-	filePath := filepath.Join(c.filesDir, filename)
+	item, ok := c.memory.get(entry)
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	c.recordAccess(entry)
+
+	c.countHit(key)
+	c.stats.MemoryHits++
+	return item.data, item.modTime, true
+}
+
+// Peek returns a copy of the entry for key without updating its access time,
+// the eviction policy, or hit/miss statistics.
+func (c *DiskLRUCache) Peek(key string) (Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// SetSHA256 records the hex SHA-256 of the contents of the entry for key,
+// for entries cached before hashes were recorded. Entries that already have
+// one are left alone. Returns false if key is not cached.
+func (c *DiskLRUCache) SetSHA256(key, digest string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if entry.SHA256 == "" {
+		entry.SHA256 = digest
+		c.journalPut(entry)
+	}
+	return true
+}
+
+// Contains reports whether key is cached, without affecting eviction order
+// or statistics.
+func (c *DiskLRUCache) Contains(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, exists := c.entries[key]
+	return exists
+}
 
-	// Write to temp file first, then rename (atomic)
-	tmpPath := filePath + ".tmp"
-	file, err := os.Create(tmpPath)
+// Touch marks an entry as used, as a hit would, without counting it in
+// hit/miss statistics. Returns false if the key is not cached.
+func (c *DiskLRUCache) Touch(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+
+	c.recordAccess(entry)
+	return true
+}
+
+// Len returns the number of cached entries.
+func (c *DiskLRUCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Size returns the total size of cached entries in bytes.
+func (c *DiskLRUCache) Size() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.currentSize
+}
+
+// Put stores a file in the cache by reading from the provided io.Reader.
+// If the key already exists, the old entry is replaced. The cache will
+// automatically evict entries chosen by its policy if needed to make room.
+// Returns the local file path where the data was stored. If ctx is done
+// before the file is committed, the write stops and its temp file is removed.
+func (c *DiskLRUCache) Put(ctx context.Context, key string, data io.Reader) (string, error) {
+	return c.put(ctx, key, data, true)
+}
+
+// PutDerived is like Put for data computed from other cached entries, such
+// as delta patches. The cache's Verifier is not applied to it.
+func (c *DiskLRUCache) PutDerived(ctx context.Context, key string, data io.Reader) (string, error) {
+	return c.put(ctx, key, data, false)
+}
+
+// put writes data to a temp file, compressing, sealing and verifying it
+// without the lock held, so a slow reader doesn't hold up the cache, then
+// commits it under the lock
+func (c *DiskLRUCache) put(ctx context.Context, key string, data io.Reader, verify bool) (string, error) {
+	digest := sha256.New()
+	data = io.TeeReader(contextReader{ctx, data}, digest)
+
+	// The first frame decides whether the file is worth compressing
+	compress := false
+	if c.encoder != nil {
+		head := make([]byte, compressFrameSize)
+		n, err := io.ReadFull(data, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", fmt.Errorf("failed to write file: %w", err)
+		}
+		compress = c.shouldCompress(head[:n])
+		data = io.MultiReader(bytes.NewReader(head[:n]), data)
+	}
+
+	// Write to a temp file of its own, as puts of the same key may overlap,
+	// then rename it into place
+	pattern := sanitizeFilename(key) + ".*.tmp"
+	if compress {
+		pattern += compressedSuffix
+	}
+	file, err := os.CreateTemp(c.filesDir, pattern)
 	if err != nil {
 		return "", fmt.Errorf("failed to create temp file: %w", err)
 	}
+	tmpPath := file.Name()
+	// CreateTemp makes files only their owner can read
+	file.Chmod(0644)
 
-	size, err := io.Copy(file, data)
+	size, err := c.writeSealed(file, data, compress)
 	file.Close()
 	if err != nil {
 		os.Remove(tmpPath)
 		return "", fmt.Errorf("failed to write file: %w", err)
 	}
+	if verify {
+		if err := c.verify(key, tmpPath, true); err != nil {
+			os.Remove(tmpPath)
+			return "", err
+		}
+	}
+	if tmpPath, err = c.stageInStorage(key, tmpPath, size); err != nil {
+		return "", err
+	}
+	// Synced before taking the lock, leaving commitFile's sync nothing to do
+	if err := c.syncFile(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.commitFile(ctx, key, tmpPath, size, contentInfo{sha256: hex.EncodeToString(digest.Sum(nil))})
+}
+
+// commitFile moves a fully written file at srcPath into the cache as key,
+// evicting entries as needed, unless ctx is done first. srcPath is removed on
+// failure (must be called with lock held)
+func (c *DiskLRUCache) commitFile(ctx context.Context, key, srcPath string, size int64, content contentInfo) (string, error) {
+	if err := ctx.Err(); err != nil {
+		os.Remove(srcPath)
+		return "", err
+	}
+
+	// If key already exists, remove old entry
+	if _, exists := c.entries[key]; exists {
+		c.removeEntry(key, "")
+	}
+	c.forgetCold(key)
+
+	filename := entryFilename(key, srcPath)
 
 	// Evict entries if needed to make room
-	if err := c.evictIfNeeded(size); err != nil {
-		os.Remove(tmpPath)
+	if err := c.evictIfNeeded(ctx, key, size); err != nil {
+		os.Remove(srcPath)
 		return "", fmt.Errorf("failed to evict entries: %w", err)
 	}
 
+	// A file already staged in a volume stays there if it still fits.
+	// Otherwise, with volumes, evict until one has room for it.
+	dir := c.storageDir(filename, size)
+	if staged := filepath.Dir(srcPath); c.volumeOf(staged) != nil && c.volumeOf(dir) != nil && c.roomFor(staged, size) {
+		dir = staged
+	}
+	for !c.roomFor(dir, size) && c.evictOne() {
+		dir = c.storageDir(filename, size)
+	}
+	if err := ctx.Err(); err != nil {
+		os.Remove(srcPath)
+		return "", err
+	}
+	filePath := filepath.Join(dir, filename)
+
+	// The contents must be on disk before the rename is, or a power loss
+	// could leave the entry with a file cut short
+	if err := c.syncFile(srcPath); err != nil {
+		os.Remove(srcPath)
+		return "", fmt.Errorf("failed to sync temp file: %w", err)
+	}
+
 	// Rename temp file to final path
-	if err := os.Rename(tmpPath, filePath); err != nil {
-		os.Remove(tmpPath)
+	if err := moveFile(srcPath, filePath); err != nil {
+		os.Remove(srcPath)
 		return "", fmt.Errorf("failed to rename temp file: %w", err)
 	}
+	if err := c.syncCommitted(filePath); err != nil {
+		os.Remove(filePath)
+		return "", fmt.Errorf("failed to sync cached file: %w", err)
+	}
+	if dir == c.filesDir {
+		dir = ""
+	}
 
 	// Create entry
+	created := time.Now()
+	if !content.cached.IsZero() {
+		created = content.cached
+	}
 	entry := &Entry{
-		Key:        key,
-		Filename:   filename,
-		Size:       size,
-		AccessTime: time.Now(),
-		CreateTime: time.Now(),
+		Key:          key,
+		Filename:     filename,
+		Dir:          dir,
+		Size:         size,
+		AccessTime:   time.Now(),
+		CreateTime:   created,
+		Checksum:     content.checksum,
+		SHA256:       content.sha256,
+		LastModified: content.lastModified,
+		ContentType:  content.contentType,
 	}
 
-	c.entries[key] = entry
-	elem := c.accessOrder.PushFront(key)
-	c.accessMap[key] = elem
-	c.currentSize += size
+	c.trackEntry(entry)
 	c.stats.TotalBytes = c.currentSize
 	c.stats.EntryCount = len(c.entries)
 
-	// Persist metadata
-	c.saveMetadata()
+	c.events.emit(EventInsert, entry)
+	c.journalPut(entry)
 
 	return filePath, nil
 }
@@ -184,133 +547,305 @@ func (c *DiskLRUCache) GetStats() Stats {
 	stats := c.stats
 	stats.TotalBytes = c.currentSize
 	stats.EntryCount = len(c.entries)
+	if c.memory != nil {
+		stats.MemoryBytes = c.memory.size
+		stats.MemoryEntries = len(c.memory.items)
+	}
+	stats.TrashBytes = c.trash.size
+	stats.TrashEntries = len(c.trash.entries)
+	stats.ColdBytes = c.cold.size
+	stats.ColdEntries = len(c.cold.entries)
+	stats.ColdHits = c.cold.hits
+	if c.janitor.hours != nil {
+		janitor := c.janitor.stats
+		stats.Janitor = &janitor
+	}
+	for _, v := range c.volumes {
+		stats.Volumes = append(stats.Volumes, VolumeStats{Dir: filepath.Dir(v.filesDir), TotalBytes: v.used, MaxBytes: v.maxSize})
+	}
 	return stats
 }
 
-// evictIfNeeded removes least recently used entries until there's room for newSize
-func (c *DiskLRUCache) evictIfNeeded(newSize int64) error {
-	for c.currentSize+newSize > c.maxSizeBytes && c.accessOrder.Len() > 0 {
-		// Get least recently used (back of list)
-		elem := c.accessOrder.Back()
-		if elem == nil {
-			break
-		}
+// Resize changes the maximum cache size at runtime, evicting entries if the
+// cache is now over the limit. Returns the number of
+// entries evicted.
+func (c *DiskLRUCache) Resize(maxSizeBytes int64) int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := len(c.entries)
+	c.maxSizeBytes = maxSizeBytes
+	c.stats.MaxBytes = maxSizeBytes
+	c.evictIfNeeded(context.Background(), "", 0)
+
+	return before - len(c.entries)
+}
+
+// SetMaxEntries changes the entry limit at runtime, 0 for none, evicting
+// entries if the cache is now over it. Returns the number of entries evicted.
+func (c *DiskLRUCache) SetMaxEntries(n int) int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := len(c.entries)
+	c.maxEntries = n
+	c.stats.MaxEntries = n
+	c.evictIfNeeded(context.Background(), "", 0)
+
+	return before - len(c.entries)
+}
+
+// Entries returns a snapshot of all cached entries, most recently used first.
+func (c *DiskLRUCache) Entries() []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
-		key := elem.Value.(string)
-		c.removeEntry(key)
-		c.stats.Evictions++
+	entries := make([]Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, *entry)
 	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AccessTime.After(entries[j].AccessTime)
+	})
+	return entries
+}
 
-	return nil
+// Remove deletes a single entry and its file from the cache, along with any
+// copy in the cold tier. Returns false if the key was not cached.
+func (c *DiskLRUCache) Remove(key string) bool {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists {
+		return c.forgetCold(key)
+	}
+
+	c.removeEntry(key, EventRemove)
+	return true
+}
+
+// Clear deletes every entry and file from the cache, and the cold tier, and
+// returns the number of entries removed.
+func (c *DiskLRUCache) Clear() int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := len(c.entries) + c.clearCold()
+	for key := range c.entries {
+		c.unlinkEntry(key, EventRemove)
+	}
+	c.appendJournal(journalRecord{Op: "clear"})
+	return count
+}
+
+// evictEntry removes the entry for key to make room, moving it to the cold
+// tier if there is one (must be called with lock held)
+func (c *DiskLRUCache) evictEntry(key string) {
+	if c.cold.maxSize > 0 {
+		c.demote(key)
+	} else {
+		c.removeEntry(key, EventEvict)
+	}
 }
 
-// removeEntry removes an entry from the cache (must be called with lock held)
-func (c *DiskLRUCache) removeEntry(key string) {
+// removeEntry removes an entry from the cache, journals the removal and
+// raises an event of the given type, or none if reason is empty (must be
+// called with lock held)
+func (c *DiskLRUCache) removeEntry(key string, reason EventType) {
+	if _, exists := c.entries[key]; exists {
+		c.unlinkEntry(key, reason)
+		c.journalRemove(key)
+	}
+}
+
+// unlinkEntry is removeEntry without journaling (must be called with lock
+// held)
+func (c *DiskLRUCache) unlinkEntry(key string, reason EventType) {
 	entry, exists := c.entries[key]
 	if !exists {
 		return
 	}
 
 	// Remove file
-	filePath := filepath.Join(c.filesDir, entry.Filename)
-	os.Remove(filePath)
+	os.Remove(c.filePath(entry))
+
+	c.detachEntry(entry, reason)
+}
 
-	// Remove from data structures
-	if elem, ok := c.accessMap[key]; ok {
-		c.accessOrder.Remove(elem)
-		delete(c.accessMap, key)
+// detachEntry removes entry from the cache's data structures, leaving its
+// file alone (must be called with lock held)
+func (c *DiskLRUCache) detachEntry(entry *Entry, reason EventType) {
+	c.untrackEntry(entry)
+	if c.memory != nil {
+		c.memory.remove(entry.Key)
+	}
+
+	if reason != "" {
+		c.events.emit(reason, entry)
 	}
-	delete(c.entries, key)
-	c.currentSize -= entry.Size
 }
 
-// loadFromDisk rebuilds cache state from existing files and metadata
+// loadFromDisk rebuilds cache state from the metadata snapshot and journal.
+// Files are not checked here, so startup time doesn't grow with the number
+// of entries on disk; validateFiles does that in the background once the
+// cache is serving.
 func (c *DiskLRUCache) loadFromDisk() error {
-	metadataPath := filepath.Join(c.cacheDir, "metadata.json")
-	data, err := os.ReadFile(metadataPath)
+	loaded := make(map[string]*Entry)
+	snapshotErr := loadSnapshot(filepath.Join(c.cacheDir, metadataFile), loaded)
+
+	// Changes made since the snapshot, including any from a snapshot that
+	// was interrupted
+	for _, name := range []string{oldJournalFile, journalFile} {
+		replayed, err := replayJournal(filepath.Join(c.cacheDir, name), loaded)
+		if err != nil {
+			return err
+		}
+		if replayed > 0 {
+			c.journal.dirty = true
+		}
+	}
+
+	// Register with the policy oldest first, so it sees entries in the order
+	// they were used
+	entries := make([]*Entry, 0, len(loaded))
+	for _, entry := range loaded {
+		if entry.Key != "" && entry.Filename != "" {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b *Entry) int {
+		return a.AccessTime.Compare(b.AccessTime)
+	})
+	for _, entry := range entries {
+		c.trackEntry(entry)
+	}
+
+	c.stats.TotalBytes = c.currentSize
+	c.stats.EntryCount = len(c.entries)
+
+	return snapshotErr
+}
+
+// loadSnapshot decodes the entries in the snapshot at path into entries,
+// one at a time rather than holding the whole file in memory alongside them
+func loadSnapshot(path string, entries map[string]*Entry) error {
+	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil // No metadata yet, fresh cache
 		}
 		return fmt.Errorf("failed to read metadata file: %w", err)
 	}
+	defer file.Close()
 
-	entries := make([]*Entry, 0)
-	if err := json.Unmarshal(data, &entries); err != nil {
+	dec := json.NewDecoder(bufio.NewReaderSize(file, 1<<20))
+	if _, err := dec.Token(); err != nil {
 		return fmt.Errorf("failed to unmarshal metadata: %w", err)
 	}
-
-	// Rebuild cache from metadata, verifying files exist
-	for _, entry := range entries {
-		filePath := filepath.Join(c.filesDir, entry.Filename)
-		info, err := os.Stat(filePath)
-		if err != nil {
-			continue // File doesn't exist, skip
+	for dec.More() {
+		entry := &Entry{}
+		if err := dec.Decode(entry); err != nil {
+			return fmt.Errorf("failed to unmarshal metadata: %w", err)
 		}
+		entries[entry.Key] = entry
+	}
+	return nil
+}
 
-		// Update size in case it changed
-		entry.Size = info.Size()
+// validateBatch is how many entries validateFiles checks per lock hold
+const validateBatch = 512
 
-		c.entries[entry.Key] = entry
-		elem := c.accessOrder.PushBack(entry.Key) // Back = older
-		c.accessMap[entry.Key] = elem
-		c.currentSize += entry.Size
-	}
+// validateFiles checks that the file of every entry loaded at startup still
+// exists and has the size recorded in the metadata, dropping entries whose
+// file is gone or was cut short, say by a power loss before it was written
+// back. It runs in the background, taking the lock only briefly per batch,
+// so requests are served meanwhile; a hit on such an entry is already turned
+// into a miss by Get.
+func (c *DiskLRUCache) validateFiles() {
+	start := time.Now()
 
-	// Sort by access time (most recent to front)
-	// Simple approach: rebuild list in sorted order
-	type entryWithTime struct {
-		key  string
-		time time.Time
+	c.mu.RLock()
+	loaded := make([]*Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		loaded = append(loaded, entry)
 	}
-	sorted := make([]entryWithTime, 0, len(c.entries))
-	for key, entry := range c.entries {
-		sorted = append(sorted, entryWithTime{key, entry.AccessTime})
+	c.mu.RUnlock()
+
+	type result struct {
+		entry *Entry
+		size  int64
+		found bool
 	}
-	// Sort by access time descending
-	for i := 0; i < len(sorted)-1; i++ {
-		for j := i + 1; j < len(sorted); j++ {
-			if sorted[i].time.Before(sorted[j].time) {
-				sorted[i], sorted[j] = sorted[j], sorted[i]
+	missing, damaged := 0, 0
+	results := make([]result, 0, validateBatch)
+	for len(loaded) > 0 {
+		batch := loaded[:min(validateBatch, len(loaded))]
+		loaded = loaded[len(batch):]
+
+		results = results[:0]
+		for _, entry := range batch {
+			info, err := os.Stat(c.filePath(entry))
+			if err == nil {
+				results = append(results, result{entry: entry, size: info.Size(), found: true})
+			} else if os.IsNotExist(err) {
+				results = append(results, result{entry: entry})
 			}
 		}
-	}
 
-	// Rebuild access order list
-	c.accessOrder = list.New()
-	c.accessMap = make(map[string]*list.Element)
-	for _, e := range sorted {
-		elem := c.accessOrder.PushBack(e.key)
-		c.accessMap[e.key] = elem
+		c.mu.Lock()
+		for _, r := range results {
+			// Skip entries replaced or removed since they were loaded
+			if c.entries[r.entry.Key] != r.entry {
+				continue
+			}
+			switch {
+			case !r.found:
+				c.removeEntry(r.entry.Key, EventRemove)
+				missing++
+			case r.size != r.entry.Size:
+				logger.Warn().Emitf("Dropping %s: its file is %d bytes, expected %d", r.entry.Key, r.size, r.entry.Size)
+				c.removeEntry(r.entry.Key, EventRemove)
+				damaged++
+			}
+		}
+		c.mu.Unlock()
+		c.events.dispatch()
 	}
 
-	c.stats.TotalBytes = c.currentSize
-	c.stats.EntryCount = len(c.entries)
-
-	return nil
+	logger.Info().Emitf("Validated cached files in %v: %d missing, %d with the wrong size", time.Since(start).Round(time.Millisecond), missing, damaged)
 }
 
-// saveMetadata persists cache metadata to disk
-func (c *DiskLRUCache) saveMetadata() error {
-	entries := make([]*Entry, 0, len(c.entries))
-	for _, entry := range c.entries {
-		entries = append(entries, entry)
-	}
-
-	data, err := json.MarshalIndent(entries, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
+// entryFilename returns the name the file at srcPath is stored under as key
+func entryFilename(key, srcPath string) string {
+	filename := sanitizeFilename(key)
+	if isCompressed(srcPath) {
+		filename += compressedSuffix
 	}
-
-	metadataPath := filepath.Join(c.cacheDir, "metadata.json")
-	return os.WriteFile(metadataPath, data, 0644)
+	return filename
 }
 
 // sanitizeFilename creates a safe filename from a cache key
 func sanitizeFilename(key string) string {
+	// Keep namespaces apart, so @a/b/c and @a_b/c don't collide
+	if ns, rest := SplitNamespace(key); ns != "" {
+		return namespacePrefix + ns + "_" + sanitizeFilename(rest)
+	}
+	// Keep derived entries apart from objects named like them
+	if rest, ok := strings.CutPrefix(key, derivedPrefix); ok {
+		return derivedPrefix + sanitizeFilename(rest)
+	}
+
 	// Replace path separators with underscores, keep the extension
+	key, versionID := SplitVersion(key)
 	ext := filepath.Ext(key)
 	base := key[:len(key)-len(ext)]
+	if versionID != "" {
+		base += "_v" + versionID
+	}
 
 	// Replace / with _ and remove any other unsafe characters
 	safe := ""
@@ -0,0 +1,453 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryRefPrefix starts the references MemoryCache returns for its entries
+const memoryRefPrefix = "memory:"
+
+// MemoryCache is a Cache that holds entries in RAM only, evicting least
+// recently used entries over its size limit. It keeps no state across
+// restarts and has no trash, partial downloads, verification, encryption or
+// compression, so the handler can be embedded in tests and short-lived jobs
+// without a cache directory.
+type MemoryCache struct {
+	ledger
+
+	mu     sync.RWMutex
+	data   map[string][]byte // key -> contents
+	events eventBus
+}
+
+// NewMemoryCache creates an empty in-memory cache holding up to maxSizeBytes.
+func NewMemoryCache(maxSizeBytes int64) *MemoryCache {
+	c := &MemoryCache{
+		ledger: ledger{
+			maxSizeBytes: maxSizeBytes,
+			entries:      make(map[string]*Entry),
+			policy:       NewLRUPolicy(),
+			stats: Stats{
+				MaxBytes: maxSizeBytes,
+				Policy:   "lru",
+			},
+		},
+		data: make(map[string][]byte),
+	}
+	c.evict = func(key string) { c.removeEntry(key, EventEvict) }
+	return c
+}
+
+// Get returns a reference to the contents of key for Open, recording a hit,
+// or false and a miss if key is not cached.
+func (c *MemoryCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		c.countMiss(key)
+		return "", false
+	}
+	c.recordAccess(entry)
+	c.countHit(key)
+	return memoryRefPrefix + key, true
+}
+
+// GetFromMemory always returns false: every entry is in memory, and served
+// through Get like those of a disk cache.
+func (c *MemoryCache) GetFromMemory(key string) ([]byte, time.Time, bool) {
+	return nil, time.Time{}, false
+}
+
+// Open opens the contents a reference returned by Get or Put refers to. They
+// stay readable if the entry is removed while open.
+func (c *MemoryCache) Open(ref string) (File, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key, _ := strings.CutPrefix(ref, memoryRefPrefix)
+	data, ok := c.data[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: ref, Err: fs.ErrNotExist}
+	}
+	return memoryFile{bytes.NewReader(data)}, nil
+}
+
+// memoryFile is a File over contents held in memory
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// OpenChunked returns a reader over key cached in ranges, as
+// DiskLRUCache.OpenChunked does.
+func (c *MemoryCache) OpenChunked(ctx context.Context, d *S3Downloader, key string, info ObjectInfo, chunkSize int64) *ChunkedObject {
+	return newChunkedObject(ctx, c, d, key, info, chunkSize)
+}
+
+// Peek returns a copy of the entry for key without counting a hit.
+func (c *MemoryCache) Peek(key string) (Entry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Contains reports whether key is cached.
+func (c *MemoryCache) Contains(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, exists := c.entries[key]
+	return exists
+}
+
+// Entries returns a snapshot of all cached entries, most recently used first.
+func (c *MemoryCache) Entries() []Entry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AccessTime.After(entries[j].AccessTime)
+	})
+	return entries
+}
+
+// Len returns the number of cached entries.
+func (c *MemoryCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Size returns the total size of cached entries in bytes.
+func (c *MemoryCache) Size() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.currentSize
+}
+
+// GetStats returns a snapshot of current cache statistics.
+func (c *MemoryCache) GetStats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := c.stats
+	stats.TotalBytes = c.currentSize
+	stats.EntryCount = len(c.entries)
+	return stats
+}
+
+// Put stores the contents of data as key, replacing any existing entry and
+// evicting others as needed to make room. Data larger than the whole cache
+// is refused, without reading more of it than fits.
+func (c *MemoryCache) Put(ctx context.Context, key string, data io.Reader) (string, error) {
+	maxSize := c.maxSize()
+	contents, err := io.ReadAll(io.LimitReader(contextReader{ctx, data}, maxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read data: %w", err)
+	}
+	if int64(len(contents)) > maxSize {
+		return "", fmt.Errorf("%s is larger than the cache's %d bytes", key, maxSize)
+	}
+	digest := sha256.Sum256(contents)
+	return c.commit(ctx, key, contents, contentInfo{sha256: hex.EncodeToString(digest[:])})
+}
+
+// PutDerived is the same as Put, as MemoryCache has no Verifier.
+func (c *MemoryCache) PutDerived(ctx context.Context, key string, data io.Reader) (string, error) {
+	return c.Put(ctx, key, data)
+}
+
+// PutResumable stores a download of key like Put, after checking it is
+// info.Size bytes long and matches info.Checksum, if set. Interrupted
+// downloads aren't kept, so offset must be 0.
+func (c *MemoryCache) PutResumable(ctx context.Context, key string, info ObjectInfo, offset int64, data io.Reader) (string, error) {
+	if offset != 0 {
+		return "", fmt.Errorf("no partial download of %s to resume at %d bytes", key, offset)
+	}
+	if maxSize := c.maxSize(); info.Size > maxSize {
+		return "", fmt.Errorf("%s is %d bytes, larger than the cache's %d", key, info.Size, maxSize)
+	}
+
+	digest := sha256.New()
+	hasher := io.Writer(digest)
+	checksum := info.Checksum.newHash()
+	if checksum != nil {
+		hasher = io.MultiWriter(digest, checksum)
+	}
+	// Reading one byte past the size tells a body that is too long
+	contents, err := io.ReadAll(io.TeeReader(io.LimitReader(contextReader{ctx, data}, info.Size+1), hasher))
+	if err != nil {
+		return "", fmt.Errorf("download interrupted at %d of %d bytes: %w", len(contents), info.Size, err)
+	}
+	if int64(len(contents)) != info.Size {
+		return "", fmt.Errorf("download incomplete: %d of %d bytes", len(contents), info.Size)
+	}
+	if err := info.Checksum.check(checksum); err != nil {
+		return "", err
+	}
+
+	return c.commit(ctx, key, contents, contentInfo{
+		checksum:     info.Checksum,
+		sha256:       hex.EncodeToString(digest.Sum(nil)),
+		lastModified: info.LastModified,
+		contentType:  info.ContentType,
+	})
+}
+
+// Partial always returns 0 and "", as interrupted downloads aren't kept.
+func (c *MemoryCache) Partial(key string) (offset int64, etag string) {
+	return 0, ""
+}
+
+// DiscardPartial does nothing, as interrupted downloads aren't kept.
+func (c *MemoryCache) DiscardPartial(key string) {}
+
+// maxSize returns the size limit of the cache
+func (c *MemoryCache) maxSize() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.maxSizeBytes
+}
+
+// commit stores contents as key, evicting entries as needed, unless ctx is
+// done first
+func (c *MemoryCache) commit(ctx context.Context, key string, contents []byte, content contentInfo) (string, error) {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; exists {
+		c.removeEntry(key, "")
+	}
+	size := int64(len(contents))
+	if err := c.evictIfNeeded(ctx, key, size); err != nil {
+		return "", fmt.Errorf("failed to evict entries: %w", err)
+	}
+
+	now := time.Now()
+	entry := &Entry{
+		Key:          key,
+		Size:         size,
+		AccessTime:   now,
+		CreateTime:   now,
+		Checksum:     content.checksum,
+		SHA256:       content.sha256,
+		LastModified: content.lastModified,
+		ContentType:  content.contentType,
+	}
+	c.data[key] = contents
+	c.trackEntry(entry)
+	c.events.emit(EventInsert, entry)
+
+	return memoryRefPrefix + key, nil
+}
+
+// SetSHA256 records the hex SHA-256 of key's contents if it has none yet.
+// Returns false if key is not cached.
+func (c *MemoryCache) SetSHA256(key, digest string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if entry.SHA256 == "" {
+		entry.SHA256 = digest
+	}
+	return true
+}
+
+// Verifies always returns false, as MemoryCache has no Verifier.
+func (c *MemoryCache) Verifies(key string) bool {
+	return false
+}
+
+// Remove deletes a single entry. Returns false if the key was not cached.
+func (c *MemoryCache) Remove(key string) bool {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists {
+		return false
+	}
+	c.removeEntry(key, EventRemove)
+	return true
+}
+
+// Clear deletes every entry and returns the number removed.
+func (c *MemoryCache) Clear() int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := len(c.entries)
+	for key := range c.entries {
+		c.removeEntry(key, EventRemove)
+	}
+	return count
+}
+
+// Resize changes the maximum cache size, evicting entries if the cache is
+// now over it. Returns the number of entries evicted.
+func (c *MemoryCache) Resize(maxSizeBytes int64) int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := len(c.entries)
+	c.maxSizeBytes = maxSizeBytes
+	c.stats.MaxBytes = maxSizeBytes
+	c.evictIfNeeded(context.Background(), "", 0)
+	return before - len(c.entries)
+}
+
+// SetMaxEntries changes the entry limit, 0 for none, evicting entries if the
+// cache is now over it. Returns the number of entries evicted.
+func (c *MemoryCache) SetMaxEntries(n int) int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	before := len(c.entries)
+	c.maxEntries = n
+	c.stats.MaxEntries = n
+	c.evictIfNeeded(context.Background(), "", 0)
+	return before - len(c.entries)
+}
+
+// Pin protects an entry from eviction until it is unpinned or removed.
+// Returns false if the key is not cached.
+func (c *MemoryCache) Pin(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if !entry.Pinned {
+		entry.Pinned = true
+		c.policyRemove(key)
+	}
+	return true
+}
+
+// Unpin makes a pinned entry evictable again. Returns false if the key is
+// not cached.
+func (c *MemoryCache) Unpin(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if entry.Pinned {
+		entry.Pinned = false
+		c.policyAdd(entry)
+	}
+	return true
+}
+
+// TrashEnabled always returns false: MemoryCache has no trash.
+func (c *MemoryCache) TrashEnabled() bool {
+	return false
+}
+
+// Trash removes an entry like Remove, as there is no trash to move it to.
+func (c *MemoryCache) Trash(key string) bool {
+	return c.Remove(key)
+}
+
+// RestoreTrashed always returns false, as there is no trash.
+func (c *MemoryCache) RestoreTrashed(key string) (bool, error) {
+	return false, nil
+}
+
+// GetStale always returns nothing, as there is no trash.
+func (c *MemoryCache) GetStale(key string) (string, bool) {
+	return "", false
+}
+
+// TrashedEntries always returns nothing, as there is no trash.
+func (c *MemoryCache) TrashedEntries() []TrashedEntry {
+	return nil
+}
+
+// ColdEntries always returns nothing, as there is no cold tier.
+func (c *MemoryCache) ColdEntries() []Entry {
+	return nil
+}
+
+// SetNamespaceLimits gives each namespace in limits a size budget in bytes,
+// as DiskLRUCache.SetNamespaceLimits does. Returns the number of entries
+// evicted.
+func (c *MemoryCache) SetNamespaceLimits(limits map[string]int64) int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.setNamespaceLimits(limits)
+}
+
+// NamespaceStats returns the statistics of one namespace.
+func (c *MemoryCache) NamespaceStats(name string) NamespaceStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if ns, ok := c.namespaces[name]; ok {
+		return ns.stats
+	}
+	return NamespaceStats{}
+}
+
+// Namespaces returns the statistics of every namespace that has entries or a
+// budget, by name.
+func (c *MemoryCache) Namespaces() map[string]NamespaceStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.namespaceStats()
+}
+
+// Subscribe registers fn to be called for every cache event, as
+// DiskLRUCache.Subscribe does.
+func (c *MemoryCache) Subscribe(fn func(Event)) (unsubscribe func()) {
+	return c.events.subscribe(fn)
+}
+
+// removeEntry drops an entry and its contents, raising an event of the
+// given type, or none if reason is empty (must be called with lock held)
+func (c *MemoryCache) removeEntry(key string, reason EventType) {
+	entry, exists := c.entries[key]
+	if !exists {
+		return
+	}
+	c.untrackEntry(entry)
+	delete(c.data, key)
+	if reason != "" {
+		c.events.emit(reason, entry)
+	}
+}
@@ -0,0 +1,101 @@
+package cache
+
+import (
+	"os"
+	"time"
+)
+
+// memoryTier keeps the contents of frequently served small files in RAM so
+// hits can be served without touching disk. It is a pure read-through copy:
+// every entry also exists on disk, so dropping an entry from memory (demotion)
+// never loses data. Not safe for concurrent use; DiskLRUCache calls it with
+// its lock held.
+type memoryTier struct {
+	maxBytes      int64
+	maxEntryBytes int64
+	promoteAfter  int64 // disk hits before an entry is copied into memory
+	size          int64
+	items         map[string]*memoryItem
+	promoting     map[string]bool // entries whose files are being read in
+	policy        Policy
+	readFile      func(path string) ([]byte, error) // returns plaintext contents
+}
+
+type memoryItem struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemoryTier(maxBytes, maxEntryBytes, promoteAfter int64) *memoryTier {
+	return &memoryTier{
+		maxBytes:      maxBytes,
+		maxEntryBytes: maxEntryBytes,
+		promoteAfter:  promoteAfter,
+		items:         make(map[string]*memoryItem),
+		promoting:     make(map[string]bool),
+		policy:        NewLFUPolicy(),
+		readFile:      os.ReadFile,
+	}
+}
+
+// get returns the in-memory contents of an entry
+func (m *memoryTier) get(entry *Entry) (*memoryItem, bool) {
+	item, ok := m.items[entry.Key]
+	if ok {
+		m.policy.Access(entry)
+	}
+	return item, ok
+}
+
+// shouldPromote reports whether an entry is small and popular enough to be
+// copied into memory, and marks it as being promoted if so, so concurrent
+// hits don't read it again. The caller reads its file without the lock held
+// and passes the contents to promote.
+func (m *memoryTier) shouldPromote(entry *Entry) bool {
+	if _, ok := m.items[entry.Key]; ok || m.promoting[entry.Key] {
+		return false
+	}
+	if entry.Size > m.maxEntryBytes || entry.Size > m.maxBytes || entry.AccessCount < m.promoteAfter {
+		return false
+	}
+	m.promoting[entry.Key] = true
+	return true
+}
+
+// promote adds the contents of an entry that shouldPromote chose, read from
+// its file, or just clears its mark if the read failed (data is nil)
+func (m *memoryTier) promote(entry *Entry, data []byte) {
+	delete(m.promoting, entry.Key)
+	if data == nil {
+		return
+	}
+	size := int64(len(data))
+
+	// Demote the least frequently used items until the new one fits
+	for m.size+size > m.maxBytes {
+		key, ok := m.policy.Evict()
+		if !ok {
+			break
+		}
+		m.drop(key)
+	}
+
+	m.items[entry.Key] = &memoryItem{data: data, modTime: entry.CreateTime}
+	m.size += size
+	m.policy.Add(entry)
+}
+
+// remove drops an entry, e.g. when it is evicted or replaced on disk
+func (m *memoryTier) remove(key string) {
+	if _, ok := m.items[key]; ok {
+		m.policy.Remove(key)
+		m.drop(key)
+	}
+}
+
+func (m *memoryTier) drop(key string) {
+	if item, ok := m.items[key]; ok {
+		m.size -= int64(len(item.data))
+		delete(m.items, key)
+	}
+}
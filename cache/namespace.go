@@ -0,0 +1,97 @@
+package cache
+
+import "strings"
+
+// Entries can belong to a namespace, a partition of the cache for one team
+// with its own size budget and statistics. Their keys start with
+// namespacePrefix and the namespace name, as in @team-a/bucket/path. S3
+// bucket names can't contain "@", so these never clash with other keys, and
+// the prefix is dropped again before S3 is asked for the object.
+const namespacePrefix = "@"
+
+// NamespacedKey returns the cache key for key within namespace, or key itself
+// for the empty namespace.
+func NamespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespacePrefix + namespace + "/" + key
+}
+
+// SplitNamespace splits a cache key into the namespace it belongs to, empty
+// if none, and the key within it.
+func SplitNamespace(key string) (namespace, rest string) {
+	if !strings.HasPrefix(key, namespacePrefix) {
+		return "", key
+	}
+	namespace, rest, found := strings.Cut(key[len(namespacePrefix):], "/")
+	if !found {
+		return "", key
+	}
+	return namespace, rest
+}
+
+// NamespaceStats describes the part of the cache used by one namespace.
+type NamespaceStats struct {
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	Evictions  int64 `json:"evictions"` // including those made for the cache's own limit
+	TotalBytes int64 `json:"totalBytes"`
+	MaxBytes   int64 `json:"maxBytes"` // 0 when only the cache's limit applies
+	EntryCount int   `json:"entryCount"`
+}
+
+// namespace tracks the entries of one namespace. Its policy holds the same
+// entries as the cache's, so the namespace can be brought under its budget
+// without touching anyone else's entries.
+type namespace struct {
+	policy Policy
+	stats  NamespaceStats
+}
+
+// SetNamespaceLimits gives each namespace in limits a size budget in bytes,
+// replacing any set before; 0 leaves a namespace with only the cache's limit.
+// Namespaces over their new budget are evicted from at once. Returns the
+// number of entries evicted.
+func (c *DiskLRUCache) SetNamespaceLimits(limits map[string]int64) int {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.setNamespaceLimits(limits)
+}
+
+// NamespaceStats returns the statistics of one namespace.
+func (c *DiskLRUCache) NamespaceStats(name string) NamespaceStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if ns, ok := c.namespaces[name]; ok {
+		return ns.stats
+	}
+	return NamespaceStats{}
+}
+
+// Namespaces returns the statistics of every namespace that has entries or a
+// budget, by name.
+func (c *DiskLRUCache) Namespaces() map[string]NamespaceStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.namespaceStats()
+}
+
+// trackEntry adds entry to the ledger and the used space of its volume
+// (must be called with lock held)
+func (c *DiskLRUCache) trackEntry(entry *Entry) {
+	c.ledger.trackEntry(entry)
+	if v := c.volumeOf(entry.Dir); v != nil {
+		v.used += entry.Size
+	}
+}
+
+// untrackEntry undoes trackEntry (must be called with lock held)
+func (c *DiskLRUCache) untrackEntry(entry *Entry) {
+	c.ledger.untrackEntry(entry)
+	if v := c.volumeOf(entry.Dir); v != nil {
+		v.used -= entry.Size
+	}
+}
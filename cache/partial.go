@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/bufpool"
+)
+
+// ErrPartialBusy is returned by PutResumable when another download of the
+// same key is already writing its partial file.
+var ErrPartialBusy = errors.New("partial download already in progress")
+
+// partialInfo is persisted next to each partial file so a resumed download
+// can check it is continuing the same version of the object.
+type partialInfo struct {
+	Key      string   `json:"key"`
+	ETag     string   `json:"etag"`
+	Size     int64    `json:"size"` // full object size
+	Checksum Checksum `json:"checksum,omitzero"`
+
+	LastModified time.Time `json:"lastModified,omitzero"`
+	ContentType  string    `json:"contentType,omitempty"`
+}
+
+// partialSet tracks keys whose partial file is currently being written
+type partialSet struct {
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+func (p *partialSet) acquire(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.busy == nil {
+		p.busy = make(map[string]bool)
+	}
+	if p.busy[key] {
+		return false
+	}
+	p.busy[key] = true
+	return true
+}
+
+func (p *partialSet) release(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.busy, key)
+}
+
+// Partial returns how many bytes of key have already been downloaded and the
+// ETag of the object they belong to. Returns 0 and "" if there is no partial
+// download.
+func (c *DiskLRUCache) Partial(key string) (offset int64, etag string) {
+	dataPath, infoPath := c.partialPaths(key)
+
+	raw, err := os.ReadFile(infoPath)
+	if err != nil {
+		return 0, ""
+	}
+	var info partialInfo
+	if err := json.Unmarshal(raw, &info); err != nil || info.Key != key {
+		return 0, ""
+	}
+
+	stat, err := os.Stat(dataPath)
+	if err != nil || stat.Size() > info.Size {
+		return 0, ""
+	}
+	return stat.Size(), info.ETag
+}
+
+// DiscardPartial deletes any partial download of key, e.g. because the
+// object changed in S3 since it was started.
+func (c *DiskLRUCache) DiscardPartial(key string) {
+	dataPath, infoPath := c.partialPaths(key)
+	os.Remove(dataPath)
+	os.Remove(infoPath)
+}
+
+// PutResumable appends data to the partial download of key, which must
+// already hold exactly offset bytes, and commits it to the cache once all
+// info.Size bytes have been written. If data fails partway through, the
+// bytes received so far are kept so a later call can resume from
+// Partial(key). info.ETag and info.Checksum are recorded when a download
+// starts at offset 0; the completed file is verified against that checksum
+// before it is committed, and discarded with ErrChecksumMismatch if it
+// doesn't match. Likewise, a file rejected by the cache's Verifier is
+// discarded with ErrUnverified. A download stopped because ctx is done is
+// kept for resuming too.
+func (c *DiskLRUCache) PutResumable(ctx context.Context, key string, info ObjectInfo, offset int64, data io.Reader) (string, error) {
+	if !c.partials.acquire(key) {
+		return "", ErrPartialBusy
+	}
+	defer c.partials.release(key)
+
+	dataPath, infoPath := c.partialPaths(key)
+
+	size := info.Size
+	partial := partialInfo{
+		Key:          key,
+		ETag:         info.ETag,
+		Size:         size,
+		Checksum:     info.Checksum,
+		LastModified: info.LastModified,
+		ContentType:  info.ContentType,
+	}
+	if offset == 0 {
+		c.DiscardPartial(key)
+		raw, _ := json.Marshal(partial)
+		if err := os.WriteFile(infoPath, raw, 0644); err != nil {
+			return "", fmt.Errorf("failed to write partial metadata: %w", err)
+		}
+	} else if raw, err := os.ReadFile(infoPath); err == nil {
+		// The checksum is only known from the response that started the download
+		json.Unmarshal(raw, &partial)
+	}
+
+	file, err := os.OpenFile(dataPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open partial file: %w", err)
+	}
+
+	// Discard anything past offset, e.g. a torn write from a crash
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+
+	// The file is hashed as it is written rather than read back once
+	// complete. A resumed download hashes what is already on disk first,
+	// which also leaves the file positioned at offset.
+	digest := sha256.New()
+	hasher := io.Writer(digest)
+	checksum := partial.Checksum.newHash()
+	if checksum != nil {
+		hasher = io.MultiWriter(digest, checksum)
+	}
+	if _, err := io.CopyN(hasher, file, offset); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to read partial file: %w", err)
+	}
+
+	written, err := bufpool.Copy(file, io.TeeReader(contextReader{ctx, data}, hasher))
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("download interrupted at %d of %d bytes: %w", offset+written, size, err)
+	}
+	if offset+written != size {
+		return "", fmt.Errorf("download incomplete: %d of %d bytes", offset+written, size)
+	}
+
+	if err := partial.Checksum.check(checksum); err != nil {
+		c.DiscardPartial(key)
+		return "", err
+	}
+	if err := c.verify(key, dataPath, false); err != nil {
+		c.DiscardPartial(key)
+		return "", err
+	}
+
+	sealedPath, storedSize, err := c.sealFile(dataPath)
+	if err != nil {
+		return "", err
+	}
+	if sealedPath, err = c.stageInStorage(key, sealedPath, storedSize); err != nil {
+		return "", err
+	}
+	// Synced before taking the lock, leaving commitFile's sync nothing to do
+	if err := c.syncFile(sealedPath); err != nil {
+		os.Remove(sealedPath)
+		return "", fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filePath, err := c.commitFile(ctx, key, sealedPath, storedSize, contentInfo{
+		checksum:     partial.Checksum,
+		sha256:       hex.EncodeToString(digest.Sum(nil)),
+		lastModified: partial.LastModified,
+		contentType:  partial.ContentType,
+	})
+	os.Remove(infoPath)
+	return filePath, err
+}
+
+// partialPaths returns the data and metadata paths for key's partial download
+func (c *DiskLRUCache) partialPaths(key string) (dataPath, infoPath string) {
+	base := filepath.Join(c.partialDir, sanitizeFilename(key))
+	return base + ".part", base + ".part.json"
+}
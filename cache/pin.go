@@ -0,0 +1,46 @@
+package cache
+
+// Pin protects an entry from eviction until it is unpinned or removed.
+// Pinned entries still count towards the size limit, so a cache full of
+// pinned entries can exceed it. Returns false if the key is not cached.
+func (c *DiskLRUCache) Pin(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if !entry.Pinned {
+		// Pinned entries are kept out of the policy so it never picks them
+		entry.Pinned = true
+		c.policyRemove(key)
+		c.journalPut(entry)
+	}
+	return true
+}
+
+// Unpin makes a pinned entry evictable again. Returns false if the key is
+// not cached.
+func (c *DiskLRUCache) Unpin(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return false
+	}
+	if entry.Pinned {
+		entry.Pinned = false
+		c.policyAdd(entry)
+		c.journalPut(entry)
+	}
+	return true
+}
+
+// recordAccess marks entry as just used, to be journaled (must be called
+// with lock held)
+func (c *DiskLRUCache) recordAccess(entry *Entry) {
+	c.ledger.recordAccess(entry)
+	c.journal.dirty = true
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+)
+
+// Policy decides which entry is evicted when the cache needs room.
+// Implementations are not safe for concurrent use; DiskLRUCache calls them
+// with its lock held.
+type Policy interface {
+	// Name returns the policy identifier used in configuration.
+	Name() string
+	// Add registers a newly inserted entry.
+	Add(entry *Entry)
+	// Access records a cache hit on an existing entry.
+	Access(entry *Entry)
+	// Remove forgets an entry that was deleted outside of eviction.
+	Remove(key string)
+	// Evict removes and returns the key of the next entry to evict.
+	// Returns false if the policy tracks no entries.
+	Evict() (string, bool)
+}
+
+// NewPolicy returns the eviction policy with the given name:
+// "lru", "lfu", "arc" or "gdsf".
+func NewPolicy(name string) (Policy, error) {
+	switch strings.ToLower(name) {
+	case "", "lru":
+		return NewLRUPolicy(), nil
+	case "lfu":
+		return NewLFUPolicy(), nil
+	case "arc":
+		return NewARCPolicy(), nil
+	case "gdsf":
+		return NewGDSFPolicy(), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q (want lru, lfu, arc or gdsf)", name)
+	}
+}
+
+// LRUPolicy evicts the least recently used entry.
+type LRUPolicy struct {
+	order *list.List               // front = most recent
+	elems map[string]*list.Element // key -> list element
+}
+
+// NewLRUPolicy creates an empty least-recently-used policy.
+func NewLRUPolicy() *LRUPolicy {
+	return &LRUPolicy{
+		order: list.New(),
+		elems: make(map[string]*list.Element),
+	}
+}
+
+func (p *LRUPolicy) Name() string { return "lru" }
+
+func (p *LRUPolicy) Add(entry *Entry) {
+	if elem, ok := p.elems[entry.Key]; ok {
+		p.order.MoveToFront(elem)
+		return
+	}
+	p.elems[entry.Key] = p.order.PushFront(entry.Key)
+}
+
+func (p *LRUPolicy) Access(entry *Entry) {
+	if elem, ok := p.elems[entry.Key]; ok {
+		p.order.MoveToFront(elem)
+	}
+}
+
+func (p *LRUPolicy) Remove(key string) {
+	if elem, ok := p.elems[key]; ok {
+		p.order.Remove(elem)
+		delete(p.elems, key)
+	}
+}
+
+func (p *LRUPolicy) Evict() (string, bool) {
+	elem := p.order.Back()
+	if elem == nil {
+		return "", false
+	}
+	key := elem.Value.(string)
+	p.order.Remove(elem)
+	delete(p.elems, key)
+	return key, true
+}
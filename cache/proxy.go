@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// BucketProxy routes S3 requests for matching buckets through a proxy of
+// their own, overriding the one every other bucket uses.
+type BucketProxy struct {
+	Pattern string   // bucket name or path.Match pattern, e.g. "lab-*"
+	URL     *url.URL // http, https, socks5 or socks5h proxy; nil connects directly
+}
+
+type bucketProxy struct {
+	pattern string
+	client  *awshttp.BuildableClient
+}
+
+// WithBucketProxies sends S3 requests for buckets matching proxies[i].Pattern
+// through proxies[i].URL, except to hosts matched by noProxy. The first
+// matching entry wins; other buckets use the base config's HTTP client. The
+// base client must be the SDK's buildable client, which the per-bucket
+// clients are derived from so they share its tuning.
+func WithBucketProxies(proxies []BucketProxy, noProxy []string) DownloaderOption {
+	return func(d *S3Downloader) {
+		base, ok := d.cfg.HTTPClient.(*awshttp.BuildableClient)
+		if !ok {
+			logger.Warn().Emitf("Ignoring per-bucket proxies: the AWS HTTP client can't be configured")
+			return
+		}
+		for _, p := range proxies {
+			proxy := ProxyFunc(p.URL, noProxy)
+			d.proxies = append(d.proxies, bucketProxy{
+				pattern: p.Pattern,
+				client: base.WithTransportOptions(func(tr *http.Transport) {
+					tr.Proxy = proxy
+				}),
+			})
+		}
+	}
+}
+
+// ProxyFunc returns an http.Transport Proxy function that sends requests
+// through proxy, or connects directly if proxy is nil, except for requests
+// to hosts matched by noProxy. Entries of noProxy follow the NO_PROXY
+// conventions: a host name also matches its subdomains, a leading dot
+// matches subdomains only, an IP address or CIDR range matches addresses,
+// an optional :port restricts the entry to that port, and * matches every
+// host.
+func ProxyFunc(proxy *url.URL, noProxy []string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if proxy == nil || bypassProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return proxy, nil
+	}
+}
+
+// bypassProxy reports whether u is matched by one of the noProxy entries
+func bypassProxy(u *url.URL, noProxy []string) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	ip := net.ParseIP(host)
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		entry = strings.Trim(entry, "[]")
+		if entryIP := net.ParseIP(entry); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if domain, ok := strings.CutPrefix(entry, "."); ok {
+			if strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+			continue
+		}
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClientForBucket returns the HTTP client of the first per-bucket proxy
+// matching bucket, or nil to use the base config's
+func (d *S3Downloader) httpClientForBucket(bucket string) *awshttp.BuildableClient {
+	for _, p := range d.proxies {
+		if ok, _ := path.Match(p.pattern, bucket); ok {
+			return p.client
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PutMode says how PutFile brings a local file into the cache.
+type PutMode int
+
+const (
+	// PutCopy copies the file, leaving the original untouched. On Linux the
+	// copy is made with copy_file_range, which filesystems such as Btrfs and
+	// XFS turn into a reflink that shares the data until either is modified.
+	PutCopy PutMode = iota
+	// PutLink hard links the file into the cache, so it shares the
+	// original's data. The original must not be modified in place afterwards,
+	// or the cached copy changes with it. Where the file can't be linked,
+	// such as across filesystems, it is copied.
+	PutLink
+	// PutMove is PutLink followed by removing the original once the file is
+	// in the cache, which amounts to a rename.
+	PutMove
+)
+
+// PutFile adds the local file at path to the cache as key, and returns the
+// cached file's path. Unlike Put, the bytes aren't passed through a reader
+// where the filesystem allows it, and the cache lock is only held to commit
+// the file. The file is read once, to hash it for its ETag and to run the
+// cache's Verifier.
+//
+// A cache that encrypts or compresses files at rest has to rewrite them, so
+// there the file is stored as Put would store it.
+func (c *DiskLRUCache) PutFile(key, path string, mode PutMode) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+
+	if c.aead != nil || c.encoder != nil {
+		return c.putFileSealed(key, path, mode)
+	}
+
+	tmpPath, err := c.stageFile(key, path, info.Size(), mode != PutCopy)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := hashFile(tmpPath)
+	if err == nil {
+		err = c.verify(key, tmpPath, false)
+	}
+	if err == nil {
+		// commitFile syncs it too, but while holding the lock
+		err = c.syncFile(tmpPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	c.mu.Lock()
+	filePath, err := c.commitFile(context.Background(), key, tmpPath, info.Size(), contentInfo{
+		sha256:       digest,
+		lastModified: info.ModTime(),
+	})
+	c.mu.Unlock()
+	c.events.dispatch()
+
+	if err == nil && mode == PutMove {
+		os.Remove(path)
+	}
+	return filePath, err
+}
+
+// putFileSealed stores the file at path through Put, for caches that
+// rewrite files as they store them
+func (c *DiskLRUCache) putFileSealed(key, path string, mode PutMode) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	filePath, err := c.Put(context.Background(), key, file)
+	file.Close()
+	if err == nil && mode == PutMove {
+		os.Remove(path)
+	}
+	return filePath, err
+}
+
+// stageFile brings the file at path, size bytes long, into the directory it
+// will be stored in under a temporary name, linking it if link is set and
+// that's possible, and copying it otherwise
+func (c *DiskLRUCache) stageFile(key, path string, size int64, link bool) (string, error) {
+	filename := sanitizeFilename(key)
+	c.mu.RLock()
+	dir := c.storageDir(filename, size)
+	c.mu.RUnlock()
+	tmp, err := os.CreateTemp(dir, filename+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+
+	if link {
+		// Linking needs the name to be free
+		tmp.Close()
+		os.Remove(tmpPath)
+		if err := os.Link(path, tmpPath); err == nil {
+			return tmpPath, nil
+		}
+
+		// A different filesystem, say, so copy after all
+		if tmp, err = os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err != nil {
+			return "", fmt.Errorf("failed to create temp file: %w", err)
+		}
+	} else {
+		// CreateTemp makes files only their owner can read
+		tmp.Chmod(0644)
+	}
+
+	if err := copyFile(tmp, path); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// copyFile copies the file at path into dst and closes dst. Copying from one
+// *os.File to another lets the kernel do it, without the data passing
+// through user space.
+func copyFile(dst *os.File, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for hashing: %w", err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("failed to read file for hashing: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/autonoma-ai/midway/logger"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// WithBucketRegions sets the region of known buckets up front, skipping
+// detection for them.
+func WithBucketRegions(regions map[string]string) DownloaderOption {
+	return func(d *S3Downloader) {
+		for bucket, region := range regions {
+			d.bucketRegions.Store(bucket, region)
+		}
+	}
+}
+
+// detectRegion finds a bucket's region. GetBucketLocation needs the
+// s3:GetBucketLocation permission, which is often missing for cross-account
+// buckets, so HeadBucket's x-amz-bucket-region header is tried next. S3
+// returns that header even on redirect and access denied responses. If both
+// fail, the default region is used for this request without being remembered,
+// so a transient failure doesn't pin the bucket to the wrong region.
+func (d *S3Downloader) detectRegion(ctx context.Context, cfg aws.Config, bucket string) string {
+	// GetBucketLocation and HeadBucket work globally from us-east-1
+	cfg.Region = "us-east-1"
+	client := s3.NewFromConfig(cfg, d.clientOptions(bucket, true))
+
+	location, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		// Empty location means us-east-1
+		region := string(location.LocationConstraint)
+		if region == "" {
+			region = "us-east-1"
+		}
+		d.bucketRegions.Store(bucket, region)
+		return region
+	}
+
+	region, headErr := headBucketRegion(ctx, client, bucket)
+	if headErr == nil {
+		d.bucketRegions.Store(bucket, region)
+		return region
+	}
+
+	logger.Warn().Emitf("Failed to detect region of bucket %s, using %s: %v", bucket, d.cfg.Region,
+		fmt.Errorf("failed to get bucket location: %w", errors.Join(err, headErr)))
+	return d.cfg.Region
+}
+
+// headBucketRegion reads the x-amz-bucket-region header of a HeadBucket response
+func headBucketRegion(ctx context.Context, client *s3.Client, bucket string) (string, error) {
+	out, err := client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		if region := aws.ToString(out.BucketRegion); region != "" {
+			return region, nil
+		}
+		return "", fmt.Errorf("HeadBucket response has no region")
+	}
+
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		if region := respErr.Response.Header.Get("X-Amz-Bucket-Region"); region != "" {
+			return region, nil
+		}
+	}
+	return "", fmt.Errorf("failed to head bucket: %w", err)
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ErrArchived is matched by errors for objects in an archive storage class
+// (Glacier Flexible Retrieval, Deep Archive, or an Intelligent-Tiering
+// archive tier) that must be restored before they can be downloaded.
+var ErrArchived = errors.New("object is archived")
+
+// ArchivedError is returned when S3 rejects a download with InvalidObjectState.
+type ArchivedError struct {
+	StorageClass string // e.g. GLACIER, DEEP_ARCHIVE or INTELLIGENT_TIERING
+	Err          error
+}
+
+func (e *ArchivedError) Error() string {
+	return fmt.Sprintf("object is archived in %s and must be restored first: %v", e.StorageClass, e.Err)
+}
+
+func (e *ArchivedError) Is(target error) bool { return target == ErrArchived }
+
+func (e *ArchivedError) Unwrap() error { return e.Err }
+
+// Restore asks S3 to restore an archived object for days days using the
+// given retrieval tier (Expedited, Standard or Bulk). Restoring an object
+// whose restore is already in progress is not an error.
+func (d *S3Downloader) Restore(ctx context.Context, key, storageClass string, days int, tier string) error {
+	bucket, objectKey, versionID, err := parseS3Key(key)
+	if err != nil {
+		return fmt.Errorf("failed to parse S3 key: %w", err)
+	}
+	if err := d.breakers.allow(bucket); err != nil {
+		return err
+	}
+
+	client, err := d.getClientForBucket(ctx, bucket)
+	if err != nil {
+		return fmt.Errorf("failed to get S3 client for bucket %s: %w", bucket, err)
+	}
+
+	request := &types.RestoreRequest{
+		GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(tier)},
+	}
+	// Intelligent-Tiering objects move back to a frequent access tier and
+	// stay there, so S3 rejects a restore duration for them
+	if storageClass != string(types.StorageClassIntelligentTiering) {
+		request.Days = aws.Int32(int32(days))
+	}
+
+	_, err = client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket:         aws.String(bucket),
+		Key:            aws.String(objectKey),
+		VersionId:      optionalString(versionID),
+		RestoreRequest: request,
+	})
+	d.record(bucket, err)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "RestoreAlreadyInProgress" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore S3 object: %w", explainS3Error(err, bucket))
+	}
+	return nil
+}
+
+// RestoreEstimate returns how long S3 typically takes to restore an object of
+// the given storage class with the given retrieval tier.
+func RestoreEstimate(storageClass, tier string) time.Duration {
+	deep := storageClass == string(types.StorageClassDeepArchive)
+	switch types.Tier(tier) {
+	case types.TierExpedited:
+		return 5 * time.Minute
+	case types.TierBulk:
+		if deep {
+			return 48 * time.Hour
+		}
+		return 12 * time.Hour
+	default:
+		if deep {
+			return 12 * time.Hour
+		}
+		return 5 * time.Hour
+	}
+}
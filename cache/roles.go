@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// BucketRole maps buckets to an IAM role that is assumed to access them.
+type BucketRole struct {
+	Pattern    string // bucket name or path.Match pattern, e.g. "team-*-artifacts"
+	RoleARN    string
+	ExternalID string // optional, required by some cross-account trust policies
+}
+
+type bucketRole struct {
+	pattern     string
+	credentials aws.CredentialsProvider
+}
+
+// WithBucketRoles makes the downloader assume roles[i].RoleARN through STS
+// for buckets matching roles[i].Pattern. The first matching role wins;
+// buckets that match no role use the base credentials. Assumed-role
+// credentials are cached and refreshed before they expire.
+func WithBucketRoles(roles []BucketRole) DownloaderOption {
+	return func(d *S3Downloader) {
+		stsClient := sts.NewFromConfig(d.cfg)
+		for _, role := range roles {
+			provider := stscreds.NewAssumeRoleProvider(stsClient, role.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+				o.RoleSessionName = "midway"
+				if role.ExternalID != "" {
+					o.ExternalID = aws.String(role.ExternalID)
+				}
+			})
+			d.roles = append(d.roles, bucketRole{
+				pattern:     role.Pattern,
+				credentials: aws.NewCredentialsCache(provider),
+			})
+		}
+	}
+}
+
+// configForBucket returns a copy of the base config with the credentials
+// and HTTP client to use for bucket
+func (d *S3Downloader) configForBucket(bucket string) aws.Config {
+	cfg := d.cfg.Copy()
+	for _, role := range d.roles {
+		if ok, _ := path.Match(role.pattern, bucket); ok {
+			cfg.Credentials = role.credentials
+			break
+		}
+	}
+	if client := d.httpClientForBucket(bucket); client != nil {
+		cfg.HTTPClient = client
+	}
+	return cfg
+}
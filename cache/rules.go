@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EvictionAction is what an EvictionRule does with the entries it matches.
+type EvictionAction string
+
+const (
+	// EvictNever keeps matching entries out of eviction, as if pinned.
+	EvictNever EvictionAction = "never"
+	// EvictFirst evicts matching entries, least recently used first, before
+	// the policy picks any others.
+	EvictFirst EvictionAction = "first"
+)
+
+// EvictionRule overrides the eviction policy for entries whose key matches
+// Pattern, a path.Match glob over bucket/path such as "releases/stable/*".
+// A pattern without a "/" is matched against the file name instead, so
+// "*.log" matches log files in any bucket. Namespaces and version IDs are
+// ignored.
+type EvictionRule struct {
+	Pattern string
+	Action  EvictionAction
+}
+
+// ParseEvictionAction returns the action named name: "never" or "first".
+func ParseEvictionAction(name string) (EvictionAction, error) {
+	switch action := EvictionAction(strings.ToLower(name)); action {
+	case EvictNever, EvictFirst:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown eviction action %q (want never or first)", name)
+	}
+}
+
+// WithEvictionRules applies rules to entries as they are cached, the first
+// matching rule winning. Entries kept by a rule still count towards the size
+// limit, like pinned ones. Within a namespace over its budget, entries
+// evicted first are picked like any others.
+func WithEvictionRules(rules []EvictionRule) Option {
+	return func(c *DiskLRUCache) {
+		c.rules = rules
+		c.first = NewLRUPolicy()
+	}
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Simulation tracks which objects a cache of a given size and eviction
+// policy would hold, without storing their contents. It answers what
+// requests would have been hits, how much would have been downloaded and
+// what evicted, to size caches and compare policies before deploying them.
+type Simulation struct {
+	mu       sync.Mutex
+	maxBytes int64
+	policy   Policy
+	entries  map[string]*Entry
+	size     int64
+	stats    SimulationStats
+}
+
+// SimulationStats describes the requests a Simulation has seen.
+type SimulationStats struct {
+	Policy          string  `json:"policy"`
+	MaxBytes        int64   `json:"maxBytes"`
+	Requests        int64   `json:"requests"`
+	Hits            int64   `json:"hits"`
+	HitRate         float64 `json:"hitRate"`         // fraction of requests that were hits
+	ByteHitRate     float64 `json:"byteHitRate"`     // fraction of bytes requested that were served from the cache
+	RequestedBytes  int64   `json:"requestedBytes"`  // total size of the objects requested
+	DownloadedBytes int64   `json:"downloadedBytes"` // bytes downloaded on misses
+	Evictions       int64   `json:"evictions"`
+	EvictedBytes    int64   `json:"evictedBytes"`
+	TotalBytes      int64   `json:"totalBytes"` // held at the end
+	EntryCount      int     `json:"entryCount"`
+}
+
+// NewSimulation creates an empty simulated cache holding up to maxBytes,
+// evicting with policy.
+func NewSimulation(maxBytes int64, policy Policy) *Simulation {
+	return &Simulation{
+		maxBytes: maxBytes,
+		policy:   policy,
+		entries:  make(map[string]*Entry),
+	}
+}
+
+// Access records a request at time at for key, an object of size bytes. It
+// reports whether the cache would have held it, and if not, the entries
+// that would have been evicted to make room for it. An object whose size
+// changed is taken to have been replaced, and is a miss; one larger than the
+// whole cache is never held.
+func (s *Simulation) Access(key string, size int64, at time.Time) (hit bool, evicted []Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.Requests++
+	s.stats.RequestedBytes += size
+
+	if entry, ok := s.entries[key]; ok {
+		if entry.Size == size {
+			entry.AccessTime = at
+			entry.AccessCount++
+			s.policy.Access(entry)
+			s.stats.Hits++
+			return true, nil
+		}
+		s.remove(key)
+		s.policy.Remove(key)
+	}
+
+	s.stats.DownloadedBytes += size
+	if size > s.maxBytes {
+		return false, nil
+	}
+	for s.size+size > s.maxBytes {
+		victim, ok := s.policy.Evict()
+		if !ok {
+			break
+		}
+		if entry, ok := s.entries[victim]; ok {
+			evicted = append(evicted, *entry)
+			s.stats.Evictions++
+			s.stats.EvictedBytes += entry.Size
+			s.remove(victim)
+		}
+	}
+
+	entry := &Entry{Key: key, Size: size, AccessTime: at, CreateTime: at}
+	s.entries[key] = entry
+	s.size += size
+	s.policy.Add(entry)
+	return false, evicted
+}
+
+// remove forgets key (must be called with lock held)
+func (s *Simulation) remove(key string) {
+	if entry, ok := s.entries[key]; ok {
+		s.size -= entry.Size
+		delete(s.entries, key)
+	}
+}
+
+// Stats returns what the simulated cache would have done so far.
+func (s *Simulation) Stats() SimulationStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Policy = s.policy.Name()
+	stats.MaxBytes = s.maxBytes
+	stats.TotalBytes = s.size
+	stats.EntryCount = len(s.entries)
+	if stats.Requests > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(stats.Requests)
+	}
+	if stats.RequestedBytes > 0 {
+		stats.ByteHitRate = 1 - float64(stats.DownloadedBytes)/float64(stats.RequestedBytes)
+	}
+	return stats
+}
@@ -0,0 +1,134 @@
+// Package simulator replays the requests recorded in midway's logs against
+// caches of different sizes and eviction policies, to plan the capacity of
+// new nodes from the traffic of existing ones.
+package simulator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// Request is a request for an object, as replayed against each cache.
+type Request struct {
+	Time time.Time
+	Key  string
+	Size int64
+}
+
+// Config describes a cache to simulate.
+type Config struct {
+	MaxBytes int64
+	Policy   string // lru, lfu, arc or gdsf
+}
+
+// Log is the requests read from midway's logs.
+type Log struct {
+	Requests []Request
+	Unsized  int // requests left out because the size of their object was never logged
+}
+
+// logTimeFormat is how midway timestamps its log lines, in local time
+const logTimeFormat = "2006-01-02 15:04:05"
+
+var (
+	// [2026-10-16 09:12:03] [INFO] "message" field=value...
+	logLine = regexp.MustCompile(`^\[([0-9-]+ [0-9:]+)\] \[[A-Z]+\] ("(?:[^"\\]|\\.)*")`)
+
+	// A file request served, from the cache or not
+	served = regexp.MustCompile(`^Served (\S+)(?: from memory| in chunks| from S3| from its owner node)? in \S+$`)
+
+	// Lines that give the size of an object, as it was downloaded
+	sized = []*regexp.Regexp{
+		regexp.MustCompile(`^Downloading (\S+) \(([0-9.]+) MB\)\.\.\.$`),
+		regexp.MustCompile(`^Resuming (\S+) at [0-9.]+ of ([0-9.]+) MB\.\.\.$`),
+		regexp.MustCompile(`^Dry run: would download (\S+) \(([0-9.]+) MB\)$`),
+	}
+)
+
+// ReadLog reads the file requests midway logged to r, in the format it logs
+// to stdout and log files. Object sizes are taken from the lines logged
+// when objects were downloaded, in MB to two decimals, so requests for
+// objects that were cached before the log starts and never downloaded
+// again are left out.
+func ReadLog(r io.Reader) (*Log, error) {
+	type request struct {
+		time time.Time
+		key  string
+	}
+	var requests []request
+	sizes := make(map[string]int64)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := logLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		message, err := strconv.Unquote(m[2])
+		if err != nil {
+			continue
+		}
+
+		if s := served.FindStringSubmatch(message); s != nil {
+			at, err := time.ParseInLocation(logTimeFormat, m[1], time.Local)
+			if err != nil {
+				continue
+			}
+			requests = append(requests, request{at, s[1]})
+			continue
+		}
+		for _, pattern := range sized {
+			if s := pattern.FindStringSubmatch(message); s != nil {
+				mb, _ := strconv.ParseFloat(s[2], 64)
+				sizes[s[1]] = int64(mb * 1024 * 1024)
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log: %w", err)
+	}
+
+	log := &Log{Requests: make([]Request, 0, len(requests))}
+	for _, req := range requests {
+		size, ok := sizes[req.key]
+		if !ok {
+			log.Unsized++
+			continue
+		}
+		log.Requests = append(log.Requests, Request{Time: req.time, Key: req.key, Size: size})
+	}
+	return log, nil
+}
+
+// Run replays requests against a cache of each of configs, returning what
+// each would have done in the same order.
+func Run(requests []Request, configs []Config) ([]cache.SimulationStats, error) {
+	sims := make([]*cache.Simulation, len(configs))
+	for i, cfg := range configs {
+		policy, err := cache.NewPolicy(cfg.Policy)
+		if err != nil {
+			return nil, err
+		}
+		sims[i] = cache.NewSimulation(cfg.MaxBytes, policy)
+	}
+
+	for _, req := range requests {
+		for _, sim := range sims {
+			sim.Access(req.Key, req.Size, req.Time)
+		}
+	}
+
+	stats := make([]cache.SimulationStats, len(sims))
+	for i, sim := range sims {
+		stats[i] = sim.Stats()
+	}
+	return stats, nil
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// CustomerKey is an SSE-C encryption key for the buckets matching Pattern.
+type CustomerKey struct {
+	Pattern string // bucket name or path.Match pattern
+	Key     []byte // 256-bit AES key
+}
+
+// sseCustomer holds the precomputed SSE-C request fields for one key. The
+// zero value leaves all fields nil, i.e. no customer key.
+type sseCustomer struct {
+	pattern   string
+	algorithm *string
+	key       *string
+	keyMD5    *string
+}
+
+// WithCustomerKeys makes the downloader send keys[i].Key with every request
+// for buckets matching keys[i].Pattern, so objects stored with SSE-C can be
+// read. The first matching key wins.
+func WithCustomerKeys(keys []CustomerKey) DownloaderOption {
+	return func(d *S3Downloader) {
+		for _, k := range keys {
+			sum := md5.Sum(k.Key)
+			d.customerKeys = append(d.customerKeys, sseCustomer{
+				pattern:   k.Pattern,
+				algorithm: aws.String("AES256"),
+				key:       aws.String(base64.StdEncoding.EncodeToString(k.Key)),
+				keyMD5:    aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+			})
+		}
+	}
+}
+
+// customerKey returns the SSE-C fields to send for bucket
+func (d *S3Downloader) customerKey(bucket string) sseCustomer {
+	for _, k := range d.customerKeys {
+		if ok, _ := path.Match(k.pattern, bucket); ok {
+			return k
+		}
+	}
+	return sseCustomer{}
+}
+
+// explainS3Error turns archived-object errors into ArchivedError and adds a
+// hint to errors caused by encryption settings, which S3 reports only as a
+// generic bad request or access denied
+func explainS3Error(err error, bucket string) error {
+	var archived *types.InvalidObjectState
+	if errors.As(err, &archived) {
+		return &ArchivedError{StorageClass: string(archived.StorageClass), Err: err}
+	}
+
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	message := strings.ToLower(apiErr.ErrorMessage())
+	switch {
+	case apiErr.ErrorCode() == "InvalidRequest" && strings.Contains(message, "server side encryption"):
+		return fmt.Errorf("object is encrypted with SSE-C; configure a customer key for bucket %s: %w", bucket, err)
+	case apiErr.ErrorCode() == "InvalidArgument" && strings.Contains(message, "encryption"):
+		return fmt.Errorf("customer key for bucket %s was rejected; the object may not use SSE-C or was stored with a different key: %w", bucket, err)
+	case apiErr.ErrorCode() == "BadRequest" && message == "":
+		// HEAD responses have no body, so SSE-C problems surface as a bare 400
+		return fmt.Errorf("bad request; the object may be encrypted with SSE-C without a matching customer key for bucket %s: %w", bucket, err)
+	case strings.HasPrefix(apiErr.ErrorCode(), "KMS."):
+		return fmt.Errorf("object's KMS key is unusable: %w", err)
+	case apiErr.ErrorCode() == "AccessDenied" && strings.Contains(message, "kms"):
+		return fmt.Errorf("credentials for bucket %s lack kms:Decrypt on the object's KMS key: %w", bucket, err)
+	}
+	return err
+}
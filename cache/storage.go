@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// StorageTier stores the files of entries within a size range outside the
+// cache directory, such as on a tmpfs for small files or a set of volumes
+// for large ones. Only where files are kept changes; entries are indexed and
+// evicted together with all others.
+type StorageTier struct {
+	Name    string
+	Dirs    []string // files are spread over these by name, e.g. one per volume
+	MinSize int64    // smallest entry stored here, in bytes
+	MaxSize int64    // largest entry stored here, in bytes; 0 for no limit
+}
+
+// Volume is a directory, typically on a disk of its own, that holds the
+// files of up to MaxSize bytes of entries.
+type Volume struct {
+	Dir     string // files are stored in its files subdirectory
+	MaxSize int64  // bytes
+}
+
+// volume tracks how full a Volume is
+type volume struct {
+	filesDir string
+	maxSize  int64
+	used     int64
+}
+
+// tierTrashDir is the directory within each storage tier and volume
+// directory that holds the trashed files of its entries, so trashing them is a rename
+const tierTrashDir = ".trash"
+
+// WithStorageTiers stores the files of entries whose stored size falls in
+// the range of one of tiers in that tier's directories, the first matching
+// tier winning. Other entries are stored in the cache directory. Entries
+// keep the directory they were stored in if the tiers change later.
+func WithStorageTiers(tiers []StorageTier) Option {
+	return func(c *DiskLRUCache) {
+		c.tiers = tiers
+	}
+}
+
+// WithVolumes spreads the files of entries that aren't in a storage tier over
+// volumes, each holding up to its MaxSize, instead of keeping them in the
+// cache directory. A new file goes to the volume with the most room left,
+// and entries are evicted by policy until one has room for it. A volume may
+// be the cache directory itself. The cache's size limit should be the total
+// of the volumes' limits.
+func WithVolumes(volumes []Volume) Option {
+	return func(c *DiskLRUCache) {
+		for _, v := range volumes {
+			c.volumes = append(c.volumes, &volume{filesDir: filepath.Join(v.Dir, "files"), maxSize: v.MaxSize})
+		}
+	}
+}
+
+// initStorage creates the directories of the storage tiers and volumes
+func (c *DiskLRUCache) initStorage() error {
+	for _, v := range c.volumes {
+		if err := os.MkdirAll(v.filesDir, 0755); err != nil {
+			return fmt.Errorf("failed to create cache volume directory: %w", err)
+		}
+	}
+	for _, tier := range c.tiers {
+		if len(tier.Dirs) == 0 {
+			return fmt.Errorf("storage tier %s has no directories", tier.Name)
+		}
+		for _, dir := range tier.Dirs {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create directory of storage tier %s: %w", tier.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// storageDir returns the directory a file named filename of size bytes is
+// stored in (must be called with lock held)
+func (c *DiskLRUCache) storageDir(filename string, size int64) string {
+	for _, tier := range c.tiers {
+		if size < tier.MinSize || (tier.MaxSize > 0 && size > tier.MaxSize) {
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(filename))
+		return tier.Dirs[h.Sum32()%uint32(len(tier.Dirs))]
+	}
+
+	// The volume with the most room left
+	var best *volume
+	for _, v := range c.volumes {
+		if best == nil || v.maxSize-v.used > best.maxSize-best.used {
+			best = v
+		}
+	}
+	if best != nil {
+		return best.filesDir
+	}
+	return c.filesDir
+}
+
+// storageDirs returns the directories files are stored in besides the
+// cache's files directory
+func (c *DiskLRUCache) storageDirs() []string {
+	var dirs []string
+	for _, tier := range c.tiers {
+		dirs = append(dirs, tier.Dirs...)
+	}
+	for _, v := range c.volumes {
+		if v.filesDir != c.filesDir {
+			dirs = append(dirs, v.filesDir)
+		}
+	}
+	return dirs
+}
+
+// volumeOf returns the volume holding the directory dir of an entry's file,
+// or nil if it isn't on one (must be called with lock held)
+func (c *DiskLRUCache) volumeOf(dir string) *volume {
+	if dir == "" {
+		dir = c.filesDir
+	}
+	for _, v := range c.volumes {
+		if v.filesDir == dir {
+			return v
+		}
+	}
+	return nil
+}
+
+// roomFor reports whether a file of size bytes fits in dir, which is always
+// the case for directories that aren't volumes (must be called with lock held)
+func (c *DiskLRUCache) roomFor(dir string, size int64) bool {
+	v := c.volumeOf(dir)
+	return v == nil || v.used+size <= v.maxSize
+}
+
+// filePath returns the path of the file of entry
+func (c *DiskLRUCache) filePath(entry *Entry) string {
+	if entry.Dir != "" {
+		return filepath.Join(entry.Dir, entry.Filename)
+	}
+	return filepath.Join(c.filesDir, entry.Filename)
+}
+
+// stageInStorage moves the finished file at srcPath, which is to be cached as
+// key, into the storage tier it will be stored in, keeping its name, so
+// committing it is a rename. Call it without the lock held, as moving to
+// another filesystem copies the file.
+func (c *DiskLRUCache) stageInStorage(key, srcPath string, size int64) (string, error) {
+	c.mu.RLock()
+	dir := c.storageDir(entryFilename(key, srcPath), size)
+	c.mu.RUnlock()
+	if dir == c.filesDir || dir == filepath.Dir(srcPath) {
+		return srcPath, nil
+	}
+	dstPath := filepath.Join(dir, filepath.Base(srcPath))
+	if err := moveFile(srcPath, dstPath); err != nil {
+		os.Remove(srcPath)
+		return "", fmt.Errorf("failed to move file to %s: %w", dir, err)
+	}
+	return dstPath, nil
+}
+
+// moveFile renames src to dst, copying it instead where they are on
+// different filesystems
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(out, src); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// throttleBurst is the most bytes a throttled body reads at once, which
+// bounds how far a download can run ahead of its rate limit
+const throttleBurst = 256 * 1024
+
+// bandwidth caps S3 download throughput across all downloads and per download
+type bandwidth struct {
+	total *rate.Limiter // shared by every download
+
+	mu         sync.RWMutex
+	perRequest rate.Limit // applied to downloads started after it is set
+}
+
+func newBandwidth() *bandwidth {
+	return &bandwidth{
+		total:      rate.NewLimiter(rate.Inf, throttleBurst),
+		perRequest: rate.Inf,
+	}
+}
+
+// WithBandwidthLimit caps S3 download throughput; see SetBandwidthLimit.
+func WithBandwidthLimit(totalBytesPerSec, perRequestBytesPerSec int64) DownloaderOption {
+	return func(d *S3Downloader) {
+		d.SetBandwidthLimit(totalBytesPerSec, perRequestBytesPerSec)
+	}
+}
+
+// SetBandwidthLimit caps the combined throughput of all S3 downloads at
+// totalBytesPerSec, and of each single download at perRequestBytesPerSec.
+// Zero removes a cap. The total applies immediately to downloads in
+// progress; the per-request cap applies to downloads started afterwards.
+func (d *S3Downloader) SetBandwidthLimit(totalBytesPerSec, perRequestBytesPerSec int64) {
+	d.bandwidth.total.SetLimit(bytesPerSec(totalBytesPerSec))
+
+	d.bandwidth.mu.Lock()
+	defer d.bandwidth.mu.Unlock()
+	d.bandwidth.perRequest = bytesPerSec(perRequestBytesPerSec)
+}
+
+// throttle wraps an S3 response body so reads wait for the bandwidth limits
+func (b *bandwidth) throttle(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	b.mu.RLock()
+	perRequest := b.perRequest
+	b.mu.RUnlock()
+
+	return &throttledBody{
+		ReadCloser: body,
+		ctx:        ctx,
+		total:      b.total,
+		request:    rate.NewLimiter(perRequest, throttleBurst),
+	}
+}
+
+type throttledBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	total   *rate.Limiter
+	request *rate.Limiter
+}
+
+func (t *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > throttleBurst {
+		p = p[:throttleBurst]
+	}
+
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := t.request.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+		if waitErr := t.total.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func bytesPerSec(n int64) rate.Limit {
+	if n <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(n)
+}
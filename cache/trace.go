@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Trace identifies the client request S3 requests are made for, so they can
+// be found in S3 server access logs and CloudTrail.
+type Trace struct {
+	RequestID   string // the X-Request-Id of the request
+	Traceparent string // its W3C traceparent header, if any
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context whose S3 requests carry trace in their
+// User-Agent.
+func WithTrace(ctx context.Context, trace Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, trace)
+}
+
+// TraceFrom returns the trace ctx carries, if any.
+func TraceFrom(ctx context.Context) (Trace, bool) {
+	trace, ok := ctx.Value(traceKey{}).(Trace)
+	return trace, ok
+}
+
+// maxTraceValue is the longest request ID or traceparent put in a
+// User-Agent; a traceparent is 55 characters
+const maxTraceValue = 64
+
+// userAgent is the User-Agent suffix for the trace, such as
+// "midway-request/5fe2be9302ea27d1 traceparent/00-...-01"
+func (t Trace) userAgent() string {
+	var parts []string
+	if id := userAgentValue(t.RequestID); id != "" {
+		parts = append(parts, "midway-request/"+id)
+	}
+	if tp := userAgentValue(t.Traceparent); tp != "" {
+		parts = append(parts, "traceparent/"+tp)
+	}
+	return strings.Join(parts, " ")
+}
+
+// userAgentValue keeps the characters of s that are safe in a User-Agent
+// product version, as the values come from clients
+func userAgentValue(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.', r == '_':
+			return r
+		}
+		return -1
+	}, s)
+	if len(s) > maxTraceValue {
+		s = s[:maxTraceValue]
+	}
+	return s
+}
+
+// addTrace appends the trace of the request's context to its User-Agent,
+// after the SDK has set it and before the request is signed
+func addTrace(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("MidwayTrace", func(
+		ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler,
+	) (middleware.BuildOutput, middleware.Metadata, error) {
+		if trace, ok := TraceFrom(ctx); ok {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				if suffix := trace.userAgent(); suffix != "" {
+					req.Header.Set("User-Agent", strings.TrimSpace(req.Header.Get("User-Agent")+" "+suffix))
+				}
+			}
+		}
+		return next.HandleBuild(ctx, in)
+	}), middleware.After)
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// Entries moved to the trash keep their file, under trash/files with the
+// same name (or .trash in their storage tier or volume directory), and their
+// metadata in a .json file of that name under trash/info. They no longer
+// count towards the cache size, and are deleted once the retention window
+// passes unless restored first.
+const trashMetaSuffix = ".json"
+
+// trashSweepInterval is how often expired trash is deleted
+const trashSweepInterval = time.Minute
+
+// TrashedEntry is an entry moved to the trash by Trash.
+type TrashedEntry struct {
+	Entry
+	TrashedAt time.Time `json:"trashedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// trashState holds entries moved to the trash
+type trashState struct {
+	dir       string        // trashed files; their metadata is in the sibling info directory
+	retention time.Duration // 0 when the trash is disabled
+	entries   map[string]*TrashedEntry
+	size      int64
+}
+
+// WithTrash keeps entries removed by Trash for retention, so they can be
+// restored with RestoreTrashed, instead of deleting them.
+func WithTrash(retention time.Duration) Option {
+	return func(c *DiskLRUCache) {
+		c.trash.retention = retention
+	}
+}
+
+// initTrash loads the trash left by a previous run, or deletes it if the
+// trash has since been disabled
+func (c *DiskLRUCache) initTrash() error {
+	root := filepath.Join(c.cacheDir, "trash")
+	c.trash.dir = filepath.Join(root, "files")
+	c.trash.entries = make(map[string]*TrashedEntry)
+	if c.trash.retention <= 0 {
+		for _, dir := range c.storageDirs() {
+			os.RemoveAll(filepath.Join(dir, tierTrashDir))
+		}
+		return os.RemoveAll(root)
+	}
+	dirs := []string{filepath.Join(root, "info"), c.trash.dir}
+	for _, dir := range c.storageDirs() {
+		dirs = append(dirs, filepath.Join(dir, tierTrashDir))
+	}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create trash directory: %w", err)
+		}
+	}
+
+	names, err := filepath.Glob(filepath.Join(root, "info", "*"+trashMetaSuffix))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		trashed := &TrashedEntry{}
+		if err == nil {
+			err = json.Unmarshal(data, trashed)
+		}
+		if err != nil || trashed.Key == "" {
+			logger.Warn().Emitf("Dropping unreadable trash entry %s: %v", filepath.Base(name), err)
+			os.Remove(name)
+			os.Remove(filepath.Join(c.trash.dir, strings.TrimSuffix(filepath.Base(name), trashMetaSuffix)))
+			continue
+		}
+		// A file cut short by a crash can't be restored
+		info, err := os.Stat(c.trashFilePath(&trashed.Entry))
+		if err != nil || info.Size() != trashed.Size {
+			os.Remove(name)
+			os.Remove(c.trashFilePath(&trashed.Entry))
+			continue
+		}
+		c.trash.entries[trashed.Key] = trashed
+		c.trash.size += trashed.Size
+	}
+
+	go c.sweepTrash()
+	return nil
+}
+
+// TrashEnabled reports whether Trash keeps entries for restoring.
+func (c *DiskLRUCache) TrashEnabled() bool {
+	return c.trash.retention > 0
+}
+
+// Trash removes an entry from the cache like Remove, but moves its file to
+// the trash, from which RestoreTrashed can bring it back until the retention
+// window passes. Without a trash the entry is deleted, as is a copy in the
+// cold tier. Returns false if the key was not cached.
+func (c *DiskLRUCache) Trash(key string) bool {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, exists := c.entries[key]
+	if !exists {
+		return c.forgetCold(key)
+	}
+	if c.trash.retention <= 0 {
+		c.removeEntry(key, EventRemove)
+		return true
+	}
+
+	// A copy trashed earlier is superseded
+	c.dropTrashed(key)
+
+	now := time.Now()
+	trashed := &TrashedEntry{Entry: *entry, TrashedAt: now, ExpiresAt: now.Add(c.trash.retention)}
+	meta, err := json.Marshal(trashed)
+	if err == nil {
+		err = os.WriteFile(c.trashMetaPath(entry.Filename), meta, 0644)
+	}
+	if err == nil {
+		err = os.Rename(c.filePath(entry), c.trashFilePath(entry))
+	}
+	if err != nil {
+		logger.Warn().Emitf("Failed to move %s to the trash, deleting it: %v", key, err)
+		os.Remove(c.trashMetaPath(entry.Filename))
+		c.removeEntry(key, EventRemove)
+		return true
+	}
+
+	c.detachEntry(entry, EventRemove)
+	c.journalRemove(key)
+	c.trash.entries[key] = trashed
+	c.trash.size += trashed.Size
+	return true
+}
+
+// RestoreTrashed moves a trashed entry back into the cache, evicting other
+// entries if needed to make room. Returns false if key is not in the trash,
+// or has been cached again since it was trashed, in which case the trashed
+// copy is kept until it expires.
+func (c *DiskLRUCache) RestoreTrashed(key string) (bool, error) {
+	defer c.events.dispatch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	trashed, ok := c.trash.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if _, cached := c.entries[key]; cached {
+		return false, nil
+	}
+
+	if err := c.evictIfNeeded(context.Background(), key, trashed.Size); err != nil {
+		return false, fmt.Errorf("failed to evict entries: %w", err)
+	}
+	if err := os.Rename(c.trashFilePath(&trashed.Entry), c.filePath(&trashed.Entry)); err != nil {
+		return false, fmt.Errorf("failed to restore %s from the trash: %w", key, err)
+	}
+	os.Remove(c.trashMetaPath(trashed.Filename))
+	delete(c.trash.entries, key)
+	c.trash.size -= trashed.Size
+	c.forgetCold(key)
+
+	entry := trashed.Entry
+	c.trackEntry(&entry)
+	c.events.emit(EventInsert, &entry)
+	c.journalPut(&entry)
+	return true, nil
+}
+
+// GetStale returns a reference to the trashed copy of key, for serving in
+// place of an error when the object can't be fetched again. Unlike Get, it
+// doesn't count as a hit and leaves the entry in the trash.
+func (c *DiskLRUCache) GetStale(key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	trashed, ok := c.trash.entries[key]
+	if !ok {
+		return "", false
+	}
+	return c.trashFilePath(&trashed.Entry), true
+}
+
+// TrashedEntries returns the entries in the trash, most recently trashed
+// first.
+func (c *DiskLRUCache) TrashedEntries() []TrashedEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]TrashedEntry, 0, len(c.trash.entries))
+	for _, trashed := range c.trash.entries {
+		entries = append(entries, *trashed)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].TrashedAt.After(entries[j].TrashedAt)
+	})
+	return entries
+}
+
+// dropTrashed deletes the trashed copy of key, if any (must be called with
+// lock held)
+func (c *DiskLRUCache) dropTrashed(key string) {
+	trashed, ok := c.trash.entries[key]
+	if !ok {
+		return
+	}
+	os.Remove(c.trashFilePath(&trashed.Entry))
+	os.Remove(c.trashMetaPath(trashed.Filename))
+	delete(c.trash.entries, key)
+	c.trash.size -= trashed.Size
+}
+
+// trashFilePath returns where the file of a trashed entry is kept: in the
+// trash directory, or within its storage tier or volume directory, so
+// trashing never copies between filesystems
+func (c *DiskLRUCache) trashFilePath(entry *Entry) string {
+	if entry.Dir != "" {
+		return filepath.Join(entry.Dir, tierTrashDir, entry.Filename)
+	}
+	return filepath.Join(c.trash.dir, entry.Filename)
+}
+
+// trashMetaPath returns the path of the metadata of the trashed file
+// filename
+func (c *DiskLRUCache) trashMetaPath(filename string) string {
+	return filepath.Join(filepath.Dir(c.trash.dir), "info", filename+trashMetaSuffix)
+}
+
+// expireTrash deletes trashed entries whose retention window has passed and
+// returns how many there were (must be called with lock held)
+func (c *DiskLRUCache) expireTrash() int {
+	now := time.Now()
+	expired := 0
+	for key, trashed := range c.trash.entries {
+		if now.After(trashed.ExpiresAt) {
+			c.dropTrashed(key)
+			expired++
+		}
+	}
+	return expired
+}
+
+// sweepTrash deletes trashed entries once their retention window has
+// passed, until the process exits
+func (c *DiskLRUCache) sweepTrash() {
+	ticker := time.NewTicker(trashSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		expired := c.expireTrash()
+		c.mu.Unlock()
+		if expired > 0 {
+			logger.Info().Emitf("Deleted %d expired entries from the trash", expired)
+		}
+	}
+}
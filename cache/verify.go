@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrUnverified is returned when a downloaded object fails verification by
+// the cache's Verifier. The download is discarded and not cached.
+var ErrUnverified = errors.New("verification failed")
+
+// Verifier checks downloaded objects before they are cached, e.g. their
+// signatures.
+type Verifier interface {
+	// Applies reports whether key must be verified. Objects that must be
+	// verified can't be served before they have been downloaded in full.
+	Applies(key string) bool
+	// Verify checks the plaintext contents of key, size bytes long.
+	Verify(key string, r io.ReaderAt, size int64) error
+}
+
+// WithVerifier checks every object v applies to before it is cached.
+func WithVerifier(v Verifier) Option {
+	return func(c *DiskLRUCache) {
+		c.verifier = v
+	}
+}
+
+// Verifies reports whether key is checked by the cache's Verifier before it
+// is cached.
+func (c *DiskLRUCache) Verifies(key string) bool {
+	_, objectKey := SplitNamespace(key)
+	return c.verifier != nil && c.verifier.Applies(objectKey)
+}
+
+// verify runs the Verifier, if it applies to key, on the file at path, which
+// is decrypted first if encrypted is set
+func (c *DiskLRUCache) verify(key, path string, encrypted bool) error {
+	if !c.Verifies(key) {
+		return nil
+	}
+
+	var file File
+	var err error
+	if encrypted {
+		file, err = c.Open(path)
+	} else {
+		file, err = os.Open(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s for verification: %w", key, err)
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for verification: %w", key, err)
+	}
+	_, objectKey := SplitNamespace(key)
+	if err := c.verifier.Verify(objectKey, file, size); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnverified, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// Package cluster lets midway nodes share their caches. On a local miss, a
+// node asks its peers for the object over an internal API before going to S3.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// PeerPath is the prefix of the internal API that serves a node's locally
+// cached objects to its peers: GET /internal/peer/{bucket}/{key...}
+const PeerPath = "/internal/peer/"
+
+// StatsPath is the internal endpoint that serves a node's cache statistics
+// to its peers, on the same listeners as PeerPath: GET /internal/stats
+const StatsPath = "/internal/stats"
+
+// ErrNotFound is returned by Fetch when no peer has the object cached.
+var ErrNotFound = errors.New("no peer has the object")
+
+// Config describes a node's view of the cluster.
+type Config struct {
+	Peers []string // base URLs of the other nodes, e.g. http://10.0.0.2:8900
+	Self  string   // this node's own base URL, skipped if listed in Peers
+	Token string   // shared secret sent to and required from peers
+
+	// ConsistentHash assigns every key to a single owner node. Requests for
+	// keys owned by another node are proxied to it instead of being cached
+	// locally. Has no effect until this node's URL is known, from Self or
+	// from DiscoverSRV.
+	ConsistentHash bool
+}
+
+// Cluster fetches cached objects from peer nodes.
+type Cluster struct {
+	token          string
+	consistentHash bool
+	client         *http.Client // for cache lookups, which peers answer immediately
+	fill           *http.Client // for owner requests, which may wait on S3
+
+	mu    sync.RWMutex
+	peers []string
+	self  string
+	ring  *Ring // nil unless routing by consistent hash
+}
+
+// New creates a Cluster for the given configuration.
+func New(cfg Config) *Cluster {
+	c := &Cluster{
+		token:          cfg.Token,
+		consistentHash: cfg.ConsistentHash,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:           (&net.Dialer{Timeout: time.Second}).DialContext,
+				ResponseHeaderTimeout: 2 * time.Second, // peers answer from disk or not at all
+				MaxIdleConnsPerHost:   16,
+				IdleConnTimeout:       90 * time.Second,
+			},
+		},
+		fill: &http.Client{
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: time.Second}).DialContext,
+				MaxIdleConnsPerHost: 64,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+	c.SetMembers(cfg.Peers, cfg.Self)
+	return c
+}
+
+// SetMembers replaces the cluster membership, e.g. after peer discovery.
+// self may be empty if this node's URL is unknown.
+func (c *Cluster) SetMembers(members []string, self string) {
+	self = strings.TrimRight(self, "/")
+
+	var peers []string
+	for _, peer := range members {
+		peer = strings.TrimRight(peer, "/")
+		if peer != "" && peer != self {
+			peers = append(peers, peer)
+		}
+	}
+
+	var ring *Ring
+	if c.consistentHash && self != "" {
+		ring = NewRing(append([]string{self}, peers...))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peers = peers
+	c.self = self
+	c.ring = ring
+}
+
+// Peers returns the base URLs of the other nodes.
+func (c *Cluster) Peers() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peers
+}
+
+// Self returns this node's base URL, or "" if it is unknown.
+func (c *Cluster) Self() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.self
+}
+
+// Token returns the shared secret peers must present.
+func (c *Cluster) Token() string {
+	return c.token
+}
+
+// Fetch asks each peer in turn for key and returns the body of the first one
+// that has it cached, along with its size and the peer's URL. Peers that are
+// down or slow to respond are skipped. Returns ErrNotFound if no peer has it.
+func (c *Cluster) Fetch(ctx context.Context, key string) (io.ReadCloser, int64, string, error) {
+	for _, peer := range c.Peers() {
+		body, size, err := c.fetchFrom(ctx, peer, key)
+		if err == nil {
+			return body, size, peer, nil
+		}
+		if ctx.Err() != nil {
+			return nil, 0, "", ctx.Err()
+		}
+	}
+	return nil, 0, "", ErrNotFound
+}
+
+// fetchFrom requests key from a single peer
+func (c *Cluster) fetchFrom(ctx context.Context, peer, key string) (io.ReadCloser, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, PeerURL(peer, key), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("peer %s responded %s", peer, resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// ConsistentHash reports whether keys are routed to a single owner node.
+func (c *Cluster) ConsistentHash() bool {
+	return c.consistentHash
+}
+
+// Owner returns the base URL of the node that owns key and whether that is
+// this node. Every node owns every key without consistent hashing, or while
+// this node's own URL is unknown.
+func (c *Cluster) Owner(key string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.ring == nil {
+		return c.self, true
+	}
+	owner := c.ring.Owner(key)
+	return owner, owner == c.self
+}
+
+// FromOwner requests key from its owner node, which downloads it from S3
+// first if it doesn't have it cached. header supplies Range and conditional
+// headers to pass along. The caller must close the response body.
+func (c *Cluster) FromOwner(ctx context.Context, owner, key string, header http.Header) (*http.Response, error) {
+	u := PeerURL(owner, key)
+	if strings.Contains(u, "?") {
+		u += "&fill=1"
+	} else {
+		u += "?fill=1"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range forwardHeaders {
+		if value := header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.fill.Do(req)
+}
+
+// forwardHeaders are the client request headers passed on to owner nodes,
+// including the request ID so both nodes log the same one and the download
+// priority
+var forwardHeaders = []string{"Range", "If-Range", "If-Modified-Since", "If-Unmodified-Since", "X-Request-Id", "X-Midway-Priority"}
+
+// PeerURL returns the internal API URL for key on the node at base.
+func PeerURL(base, key string) string {
+	objectPath, versionID := cache.SplitVersion(key)
+	u := base + PeerPath + (&url.URL{Path: objectPath}).EscapedPath()
+	if versionID != "" {
+		u += "?versionId=" + url.QueryEscape(versionID)
+	}
+	return u
+}
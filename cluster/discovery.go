@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// DiscoverSRV keeps the cluster membership in sync with the SRV records of
+// name, e.g. _midway._tcp.midway.lab.svc.cluster.local, so nodes can join and
+// leave without configuration changes. The records are resolved immediately
+// and then every interval until ctx is done. If a lookup fails, the previous
+// membership is kept.
+//
+// If no Self URL was configured, this node is recognized as the target that
+// resolves to one of its own addresses with port localPort.
+func (c *Cluster) DiscoverSRV(ctx context.Context, name string, interval time.Duration, localPort string) {
+	configuredSelf := c.Self()
+
+	var previous []string
+	for {
+		members, err := lookupSRV(ctx, name)
+		if err != nil {
+			logger.Warn().Emitf("Peer discovery via %s failed, keeping %d known peers: %v", name, len(c.Peers()), err)
+		} else if !slices.Equal(members, previous) {
+			self := configuredSelf
+			if self == "" {
+				self = findSelf(ctx, members, localPort)
+			}
+			c.SetMembers(members, self)
+			logger.Info().Emitf("Discovered %d peers via %s (self %q)", len(c.Peers()), name, self)
+			previous = members
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// lookupSRV returns the sorted base URLs of the targets of SRV record name
+func lookupSRV(ctx context.Context, name string) ([]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(records))
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		members = append(members, "http://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	slices.Sort(members)
+	return slices.Compact(members), nil
+}
+
+// findSelf returns the member that points at this host on localPort, or ""
+func findSelf(ctx context.Context, members []string, localPort string) string {
+	local := make(map[string]bool)
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				local[ipNet.IP.String()] = true
+			}
+		}
+	}
+
+	for _, member := range members {
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(member, "http://"))
+		if err != nil || port != localPort {
+			continue
+		}
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if local[ip.IP.String()] {
+				return member
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig describes where a node publishes its cache index.
+type RedisConfig struct {
+	Addr     string // host:port
+	Password string
+	DB       int
+	Prefix   string        // prepended to every Redis key, e.g. "midway:"
+	Node     string        // this node's base URL, as schedulers should use it
+	TTL      time.Duration // how long a node counts as alive after its last heartbeat
+}
+
+// IndexEntry is the value stored for each node holding an object.
+type IndexEntry struct {
+	Size     int64          `json:"size"`
+	Checksum cache.Checksum `json:"checksum,omitzero"`
+	CachedAt time.Time      `json:"cachedAt"`
+}
+
+// RedisIndex mirrors a node's cache contents into Redis, so schedulers
+// outside midway can send a download to the node that already holds the
+// artifact. For a cache key K and node URL N it maintains:
+//
+//	{prefix}object:K  hash of N -> IndexEntry JSON, one field per node holding K
+//	{prefix}node:N    set of the keys N holds
+//	{prefix}alive:N   present while N is running, expires after TTL
+//
+// Entries of nodes whose alive key has expired are stale and should be
+// ignored; they are cleaned up when that node starts again.
+type RedisIndex struct {
+	client *redis.Client
+	prefix string
+	node   string
+	ttl    time.Duration
+
+	events  chan cache.Event
+	dropped atomic.Bool // set when events were lost and a resync is needed
+}
+
+// redisQueueSize is how many cache events can wait to be written to Redis
+// before further events are dropped and the index is resynced instead
+const redisQueueSize = 4096
+
+// NewRedisIndex connects to Redis.
+func NewRedisIndex(ctx context.Context, cfg RedisConfig) (*RedisIndex, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis at %s: %w", cfg.Addr, err)
+	}
+
+	return &RedisIndex{
+		client: client,
+		prefix: cfg.Prefix,
+		node:   cfg.Node,
+		ttl:    cfg.TTL,
+		events: make(chan cache.Event, redisQueueSize),
+	}, nil
+}
+
+// Run publishes the contents of c and keeps them up to date until ctx is
+// done. Cache events are queued and written in the background, so cache
+// operations never wait on Redis.
+func (x *RedisIndex) Run(ctx context.Context, c cache.Cache) {
+	unsubscribe := c.Subscribe(func(e cache.Event) {
+		// Entries derived from objects are of no use to schedulers
+		if _, derived := cache.SplitDerived(e.Key); derived {
+			return
+		}
+		select {
+		case x.events <- e:
+		default:
+			x.dropped.Store(true)
+		}
+	})
+	defer unsubscribe()
+	defer x.client.Close()
+
+	if err := x.sync(ctx, c); err != nil {
+		logger.Warn().Emitf("Failed to publish cache index to Redis: %v", err)
+		x.dropped.Store(true)
+	}
+
+	heartbeat := time.NewTicker(x.ttl / 3)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-x.events:
+			if err := x.apply(ctx, e); err != nil {
+				logger.Warn().Emitf("Failed to update cache index in Redis: %v", err)
+				x.dropped.Store(true)
+			}
+		case <-heartbeat.C:
+			if x.dropped.Swap(false) {
+				if err := x.sync(ctx, c); err != nil {
+					logger.Warn().Emitf("Failed to resync cache index to Redis: %v", err)
+					x.dropped.Store(true)
+				}
+			}
+			if err := x.client.Set(ctx, x.aliveKey(), time.Now().UTC().Format(time.RFC3339), x.ttl).Err(); err != nil {
+				logger.Warn().Emitf("Failed to send Redis heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// sync replaces everything published for this node with the current cache
+// contents
+func (x *RedisIndex) sync(ctx context.Context, c cache.Cache) error {
+	previous, err := x.client.SMembers(ctx, x.nodeKey()).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := x.client.TxPipeline()
+	for _, key := range previous {
+		pipe.HDel(ctx, x.objectKey(key), x.node)
+	}
+	pipe.Del(ctx, x.nodeKey())
+	for _, entry := range c.Entries() {
+		if _, derived := cache.SplitDerived(entry.Key); derived {
+			continue
+		}
+		x.add(ctx, pipe, entry.Key, IndexEntry{Size: entry.Size, Checksum: entry.Checksum, CachedAt: entry.CreateTime})
+	}
+	pipe.Set(ctx, x.aliveKey(), time.Now().UTC().Format(time.RFC3339), x.ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// apply publishes a single cache event
+func (x *RedisIndex) apply(ctx context.Context, e cache.Event) error {
+	pipe := x.client.TxPipeline()
+	if e.Type == cache.EventInsert {
+		x.add(ctx, pipe, e.Key, IndexEntry{Size: e.Size, Checksum: e.Checksum, CachedAt: e.Time})
+	} else {
+		pipe.HDel(ctx, x.objectKey(e.Key), x.node)
+		pipe.SRem(ctx, x.nodeKey(), e.Key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (x *RedisIndex) add(ctx context.Context, pipe redis.Pipeliner, key string, entry IndexEntry) {
+	value, _ := json.Marshal(entry)
+	pipe.HSet(ctx, x.objectKey(key), x.node, value)
+	pipe.SAdd(ctx, x.nodeKey(), key)
+}
+
+func (x *RedisIndex) objectKey(key string) string { return x.prefix + "object:" + key }
+func (x *RedisIndex) nodeKey() string             { return x.prefix + "node:" + x.node }
+func (x *RedisIndex) aliveKey() string            { return x.prefix + "alive:" + x.node }
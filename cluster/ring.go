@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// ringReplicas is the number of points each node has on the ring. More points
+// spread keys more evenly at the cost of a larger ring.
+const ringReplicas = 128
+
+// Ring assigns each key to one node by consistent hashing, so adding or
+// removing a node only moves the keys that node owned.
+type Ring struct {
+	points []uint64
+	nodes  map[uint64]string
+}
+
+// NewRing builds a ring over nodes. The order of nodes doesn't matter, so
+// every node computes the same owners from the same list.
+func NewRing(nodes []string) *Ring {
+	r := &Ring{nodes: make(map[uint64]string, len(nodes)*ringReplicas)}
+	for _, node := range nodes {
+		for i := 0; i < ringReplicas; i++ {
+			point := hashKey(node + "#" + strconv.Itoa(i))
+			if _, taken := r.nodes[point]; taken {
+				continue
+			}
+			r.nodes[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the node responsible for key, or "" if the ring is empty.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[r.points[i]]
+}
+
+// hashKey places s on the ring. FNV and CRC cluster badly for node names that
+// differ only in a suffix, so this uses SHA-256.
+func hashKey(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
@@ -0,0 +1,133 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// NodeStats is one node's entry in the cluster-wide stats.
+type NodeStats struct {
+	Node    string       `json:"node"`
+	Healthy bool         `json:"healthy"`
+	Error   string       `json:"error,omitempty"`
+	HitRate float64      `json:"hitRate"`
+	Stats   *cache.Stats `json:"stats,omitempty"`
+}
+
+// Totals aggregates the stats of all healthy nodes.
+type Totals struct {
+	Nodes        int     `json:"nodes"`
+	HealthyNodes int     `json:"healthyNodes"`
+	Hits         int64   `json:"hits"`
+	Misses       int64   `json:"misses"`
+	HitRate      float64 `json:"hitRate"`
+	Evictions    int64   `json:"evictions"`
+	TotalBytes   int64   `json:"totalBytes"`
+	MaxBytes     int64   `json:"maxBytes"`
+	EntryCount   int     `json:"entryCount"`
+}
+
+// ClusterStats is the body of GET /stats/cluster.
+type ClusterStats struct {
+	Totals Totals      `json:"totals"`
+	Nodes  []NodeStats `json:"nodes"`
+}
+
+// Stats collects the stats of every peer in parallel and merges them with
+// local, this node's own stats. Peers that fail to respond are reported as
+// unhealthy and left out of the totals.
+func (c *Cluster) Stats(ctx context.Context, local cache.Stats) ClusterStats {
+	peers := c.Peers()
+	self := c.Self()
+	if self == "" {
+		self = "self"
+	}
+
+	nodes := make([]NodeStats, len(peers)+1)
+	nodes[0] = NodeStats{Node: self, Healthy: true, Stats: &local}
+
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node := NodeStats{Node: peer}
+			stats, err := c.peerStats(ctx, peer)
+			if err != nil {
+				node.Error = err.Error()
+			} else {
+				node.Healthy = true
+				node.Stats = &stats
+			}
+			nodes[i+1] = node
+		}()
+	}
+	wg.Wait()
+
+	result := ClusterStats{Nodes: nodes}
+	t := &result.Totals
+	for i := range nodes {
+		t.Nodes++
+		s := nodes[i].Stats
+		if s == nil {
+			continue
+		}
+		nodes[i].HitRate = hitRate(s.Hits, s.Misses)
+		t.HealthyNodes++
+		t.Hits += s.Hits
+		t.Misses += s.Misses
+		t.Evictions += s.Evictions
+		t.TotalBytes += s.TotalBytes
+		t.MaxBytes += s.MaxBytes
+		t.EntryCount += s.EntryCount
+	}
+	t.HitRate = hitRate(t.Hits, t.Misses)
+	return result
+}
+
+// peerStats fetches a peer's statistics from StatsPath, or from GET /stats
+// on nodes running a version without it
+func (c *Cluster) peerStats(ctx context.Context, peer string) (cache.Stats, error) {
+	var stats cache.Stats
+
+	resp, err := c.getStats(ctx, peer+StatsPath)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		resp, err = c.getStats(ctx, peer+"/stats")
+	}
+	if err != nil {
+		return stats, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return stats, fmt.Errorf("responded %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return stats, fmt.Errorf("failed to decode stats: %w", err)
+	}
+	return stats, nil
+}
+
+func (c *Cluster) getStats(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.client.Do(req)
+}
+
+func hitRate(hits, misses int64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
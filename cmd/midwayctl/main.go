@@ -0,0 +1,299 @@
+// Command midwayctl operates a running midway server through its admin API.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/autonoma-ai/midway/midwayclient"
+)
+
+const usage = `Usage: midwayctl [flags] command [args]
+
+Commands:
+  stats                   Show cache statistics
+  ls [PREFIX]             List cached entries, optionally only those under PREFIX
+  purge KEY...            Remove entries from the cache
+  purge --prefix PREFIX   Remove every entry under PREFIX
+        [--older-than AGE]  only those cached more than AGE ago, e.g. 72h
+  pin KEY...              Protect entries from eviction
+  unpin KEY...            Make pinned entries evictable again
+  prefetch KEY...         Download entries into the cache in the background
+  prefetch -f MANIFEST    Prefetch every key listed in MANIFEST (one per line, - for stdin)
+  preload PREFIX          Prefetch every object under PREFIX in S3
+        [--suffix .apk,.ipa] [--max-size BYTES]
+  events                  Print cache events as they happen
+
+Flags:
+  -server URL   midway server (default $MIDWAY_SERVER or http://localhost:8900)
+  -token TOKEN  admin token (default $ADMIN_TOKEN)
+  -json         print JSON instead of tables
+`
+
+func main() {
+	fs := flag.NewFlagSet("midwayctl", flag.ExitOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	server := fs.String("server", envOr("MIDWAY_SERVER", "http://localhost:8900"), "midway server URL")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "admin token")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	ctl := &ctl{
+		client: midwayclient.New(*server, midwayclient.WithToken(*token)),
+		json:   *asJSON,
+	}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	var err error
+	switch cmd {
+	case "stats":
+		err = ctl.stats(ctx)
+	case "ls":
+		err = ctl.list(ctx, args)
+	case "purge":
+		err = ctl.purge(ctx, args)
+	case "pin":
+		err = ctl.pin(ctx, args, true)
+	case "unpin":
+		err = ctl.pin(ctx, args, false)
+	case "prefetch":
+		err = ctl.prefetch(ctx, args)
+	case "preload":
+		err = ctl.preload(ctx, args)
+	case "events":
+		err = ctl.events(ctx)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	if err != nil && ctx.Err() == nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+type ctl struct {
+	client *midwayclient.Client
+	json   bool
+}
+
+func (c *ctl) stats(ctx context.Context) error {
+	stats, err := c.client.Stats(ctx)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(stats)
+	}
+
+	hitRate := 0.0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		hitRate = float64(stats.Hits) / float64(total) * 100
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if stats.MaxEntries > 0 {
+		fmt.Fprintf(w, "Entries\t%d of %d\n", stats.EntryCount, stats.MaxEntries)
+	} else {
+		fmt.Fprintf(w, "Entries\t%d\n", stats.EntryCount)
+	}
+	fmt.Fprintf(w, "Size\t%s of %s\n", formatBytes(stats.TotalBytes), formatBytes(stats.MaxBytes))
+	fmt.Fprintf(w, "Hits\t%d (%.1f%%)\n", stats.Hits, hitRate)
+	fmt.Fprintf(w, "Misses\t%d\n", stats.Misses)
+	fmt.Fprintf(w, "Evictions\t%d\n", stats.Evictions)
+	fmt.Fprintf(w, "Policy\t%s\n", stats.Policy)
+	for _, stage := range []string{"hit", "download", "request"} {
+		if l, ok := stats.Latency[stage]; ok {
+			fmt.Fprintf(w, "Latency (%s)\tp50 %.1fms  p95 %.1fms  p99 %.1fms\n", stage, l.P50Ms, l.P95Ms, l.P99Ms)
+		}
+	}
+	return w.Flush()
+}
+
+func (c *ctl) list(ctx context.Context, args []string) error {
+	prefix := ""
+	if len(args) > 0 {
+		prefix = args[0]
+	}
+
+	entries, err := c.client.Entries(ctx, prefix, 0)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return printJSON(entries)
+	}
+
+	var total int64
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSIZE\tHITS\tLAST ACCESS\tPINNED")
+	for _, entry := range entries {
+		pinned := ""
+		if entry.Pinned {
+			pinned = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", entry.Key, formatBytes(entry.Size), entry.AccessCount, entry.AccessTime.Format(time.RFC3339), pinned)
+		total += entry.Size
+	}
+	w.Flush()
+
+	fmt.Printf("\n%d entries, %s\n", len(entries), formatBytes(total))
+	return nil
+}
+
+func (c *ctl) purge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "remove every entry under this prefix")
+	olderThan := fs.Duration("older-than", 0, "with --prefix, only remove entries cached more than this long ago")
+	fs.Parse(args)
+
+	switch {
+	case *prefix != "":
+		result, err := c.client.PurgeOlderThan(ctx, *prefix, *olderThan)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged %d entries (%s)\n", result.Purged, formatBytes(result.BytesFreed))
+	case fs.NArg() > 0:
+		purged, err := c.client.Purge(ctx, fs.Args()...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("purged %d entries\n", purged)
+	default:
+		return fmt.Errorf("specify keys to purge or --prefix")
+	}
+	return nil
+}
+
+func (c *ctl) pin(ctx context.Context, keys []string, pin bool) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("specify keys")
+	}
+
+	if pin {
+		n, err := c.client.Pin(ctx, keys...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pinned %d of %d entries\n", n, len(keys))
+		return nil
+	}
+
+	n, err := c.client.Unpin(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("unpinned %d of %d entries\n", n, len(keys))
+	return nil
+}
+
+func (c *ctl) prefetch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("prefetch", flag.ExitOnError)
+	manifest := fs.String("f", "", "manifest file listing keys, - for stdin")
+	fs.Parse(args)
+
+	keys := fs.Args()
+	if *manifest != "" {
+		file := os.Stdin
+		if *manifest != "-" {
+			var err error
+			if file, err = os.Open(*manifest); err != nil {
+				return err
+			}
+			defer file.Close()
+		}
+
+		listed, err := midwayclient.ReadManifest(file)
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %w", err)
+		}
+		keys = append(keys, listed...)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("specify keys to prefetch or -f MANIFEST")
+	}
+
+	result, err := c.client.Prefetch(ctx, keys...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("queued %d keys, %d already cached\n", result.Queued, result.Cached)
+	return nil
+}
+
+func (c *ctl) preload(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("preload", flag.ExitOnError)
+	suffixes := fs.String("suffix", "", "comma-separated suffixes, only keys ending in one are preloaded")
+	maxSize := fs.Int64("max-size", 0, "only preload objects up to this many bytes")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("specify one prefix to preload")
+	}
+	opts := midwayclient.PreloadOptions{MaxSize: *maxSize}
+	if *suffixes != "" {
+		opts.Suffixes = strings.Split(*suffixes, ",")
+	}
+
+	result, err := c.client.Preload(ctx, fs.Arg(0), opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("listed %d objects, %d matched: queued %d, %d already cached\n", result.Listed, result.Matched, result.Queued, result.Cached)
+	return nil
+}
+
+func (c *ctl) events(ctx context.Context) error {
+	return c.client.Events(ctx, func(e midwayclient.Event) {
+		if c.json {
+			printJSON(e)
+			return
+		}
+		fmt.Printf("%s  %-6s  %s (%s)\n", e.Time.Local().Format("15:04:05.000"), e.Type, e.Key, formatBytes(e.Size))
+	})
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
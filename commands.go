@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/server"
+)
+
+// runValidateConfig loads the configuration and reports whether it is valid.
+func runValidateConfig(args []string) {
+	fs, flags := newFlagSet("validate-config")
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, line := range cfg.Lines() {
+		fmt.Println(line)
+	}
+	fmt.Println("configuration is valid")
+}
+
+// runCache dispatches the "cache" subcommands. These operate on the cache
+// directory directly, so they should not be run while a server is using it.
+func runCache(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "missing cache subcommand\n\n%s", usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "ls":
+		runCacheList(args[1:])
+	case "purge":
+		runCachePurge(args[1:])
+	case "import":
+		runCacheImport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown cache subcommand %q\n\n%s", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+func runCacheList(args []string) {
+	fs, flags := newFlagSet("cache ls")
+	fs.Parse(args)
+
+	c := openCache(flags)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSIZE\tLAST ACCESS")
+	for _, entry := range c.Entries() {
+		fmt.Fprintf(w, "%s\t%.2f MB\t%s\n", entry.Key, float64(entry.Size)/(1024*1024), entry.AccessTime.Format(time.RFC3339))
+	}
+	w.Flush()
+
+	stats := c.GetStats()
+	fmt.Printf("\n%d entries, %.2f MB\n", stats.EntryCount, float64(stats.TotalBytes)/(1024*1024))
+}
+
+func runCachePurge(args []string) {
+	fs, flags := newFlagSet("cache purge")
+	all := fs.Bool("all", false, "remove every entry")
+	fs.Parse(args)
+
+	if !*all && fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "specify keys to purge or --all")
+		os.Exit(2)
+	}
+
+	c := openCache(flags)
+
+	if *all {
+		fmt.Printf("purged %d entries\n", c.Clear())
+		return
+	}
+
+	for _, key := range fs.Args() {
+		if c.Remove(key) {
+			fmt.Printf("purged %s\n", key)
+		} else {
+			fmt.Printf("not cached: %s\n", key)
+		}
+	}
+}
+
+func runCacheImport(args []string) {
+	fs, flags := newFlagSet("cache import")
+	link := fs.Bool("link", false, "hard link the files into the cache rather than copying them; they must not be modified afterwards")
+	move := fs.Bool("move", false, "move the files into the cache")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 || fs.NArg()%2 != 0 {
+		fmt.Fprintln(os.Stderr, "specify pairs of KEY and FILE to import")
+		os.Exit(2)
+	}
+	if *link && *move {
+		fmt.Fprintln(os.Stderr, "--link and --move can't be used together")
+		os.Exit(2)
+	}
+	mode := cache.PutCopy
+	if *link {
+		mode = cache.PutLink
+	} else if *move {
+		mode = cache.PutMove
+	}
+
+	c := openCache(flags)
+
+	failed := false
+	for i := 0; i < fs.NArg(); i += 2 {
+		key, path := fs.Arg(i), fs.Arg(i+1)
+		if _, err := c.PutFile(key, path, mode); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import %s: %v\n", key, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("imported %s\n", key)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func openCache(flags *cliFlags) *cache.DiskLRUCache {
+	cfg, err := flags.load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// The key is needed even to list entries: opening the cache without it
+	// would discard them as unreadable
+	encryptionKey, err := server.LoadEncryptionKey(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load cache encryption key: %v\n", err)
+		os.Exit(1)
+	}
+
+	c, err := cache.NewDiskLRUCache(cfg.Cache.Dir, int64(cfg.Cache.MaxSizeGB), server.CacheOptions(cfg, encryptionKey)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open cache: %v\n", err)
+		os.Exit(1)
+	}
+	return c
+}
@@ -0,0 +1,1261 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete midway service configuration.
+type Config struct {
+	Server  ServerConfig  `yaml:"server" toml:"server"`
+	Cache   CacheConfig   `yaml:"cache" toml:"cache"`
+	AWS     AWSConfig     `yaml:"aws" toml:"aws"`
+	Cluster ClusterConfig `yaml:"cluster" toml:"cluster"`
+	Log     LogConfig     `yaml:"log" toml:"log"`
+}
+
+// ServerConfig controls the HTTP listener.
+type ServerConfig struct {
+	Port           string   `yaml:"port" toml:"port"`
+	Host           string   `yaml:"host" toml:"host"`                     // IP address, host name or network interface port is bound to; empty for all
+	IPFamily       string   `yaml:"ipFamily" toml:"ipFamily"`             // dual, ipv4 or ipv6: the IP versions port accepts
+	AdminToken     string   `yaml:"adminToken" toml:"adminToken"`         // required as a bearer token on /admin/* when set
+	AllowedBuckets []string `yaml:"allowedBuckets" toml:"allowedBuckets"` // empty allows every bucket
+	RateLimit      float64  `yaml:"rateLimit" toml:"rateLimit"`           // file requests per second, 0 disables
+	RateBurst      int      `yaml:"rateBurst" toml:"rateBurst"`
+
+	Admin     string           `yaml:"admin" toml:"admin"`         // how admin and debug endpoints are protected on port: token, none or off
+	Listeners []ListenerConfig `yaml:"listeners" toml:"listeners"` // more addresses to serve on besides port
+
+	AdminAddress string `yaml:"adminAddress" toml:"adminAddress"` // serve stats, metrics, admin and debug endpoints only here, e.g. 127.0.0.1:8901
+	AuditLog     string `yaml:"auditLog" toml:"auditLog"`         // file admin actions are appended to; empty keeps recent ones in memory only
+
+	MaxDownloads       int `yaml:"maxDownloads" toml:"maxDownloads"`             // concurrent S3 downloads, 0 for unlimited
+	MaxQueuedDownloads int `yaml:"maxQueuedDownloads" toml:"maxQueuedDownloads"` // downloads waiting for a slot before requests get 429
+
+	CompleteOnDisconnect bool `yaml:"completeOnDisconnect" toml:"completeOnDisconnect"` // keep downloading when the client aborts
+	CompressResponses    bool `yaml:"compressResponses" toml:"compressResponses"`       // gzip or deflate text responses for clients that accept it
+	ContentSHA256        bool `yaml:"contentSHA256" toml:"contentSHA256"`               // send the SHA-256 of cached files in X-Content-Sha256
+	ServeStale           bool `yaml:"serveStale" toml:"serveStale"`                     // serve stale copies when S3 can't be reached
+	TrustProxy           bool `yaml:"trustProxy" toml:"trustProxy"`                     // honor X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-For
+
+	BasePath string        `yaml:"basePath" toml:"basePath"` // path prefix file requests are served under, e.g. /artifacts
+	Rewrites []RewriteRule `yaml:"rewrites" toml:"rewrites"` // applied in order to file request paths; the first match wins
+
+	BucketAliases map[string]string `yaml:"bucketAliases" toml:"bucketAliases"` // short name used in paths -> S3 bucket
+	DefaultBucket string            `yaml:"defaultBucket" toml:"defaultBucket"` // bucket whole paths are keys in, instead of starting with the bucket
+
+	CacheHeaders []CacheHeaderRule `yaml:"cacheHeaders" toml:"cacheHeaders"` // caching headers of file responses; the first match wins
+
+	Latest   LatestConfig  `yaml:"latest" toml:"latest"`
+	CORS     CORSConfig    `yaml:"cors" toml:"cors"`
+	Timeouts TimeoutConfig `yaml:"timeouts" toml:"timeouts"`
+	Alerts   AlertsConfig  `yaml:"alerts" toml:"alerts"`
+}
+
+// AlertsConfig sets thresholds that log a warning, mark the node degraded on
+// /readyz and optionally call a webhook when crossed, so an undersized cache
+// shows up before clients start timing out.
+type AlertsConfig struct {
+	MinHitRatePercent    float64 `yaml:"minHitRatePercent" toml:"minHitRatePercent"`       // alert when the hit rate drops below this, 0 disables
+	HitRateWindowMinutes int     `yaml:"hitRateWindowMinutes" toml:"hitRateWindowMinutes"` // how far back the hit rate is measured
+	MaxDiskPercent       float64 `yaml:"maxDiskPercent" toml:"maxDiskPercent"`             // alert when the cache's filesystem is fuller than this, 0 disables
+	WebhookURL           string  `yaml:"webhookURL" toml:"webhookURL"`                     // alerts are posted here as JSON when they fire and resolve
+}
+
+// TimeoutConfig bounds how long requests may take. File responses, which can
+// run for hours, are bounded by how long the client stalls instead of their
+// total duration; control endpoints like /health and /stats by the total.
+type TimeoutConfig struct {
+	ReadHeaderSeconds int `yaml:"readHeaderSeconds" toml:"readHeaderSeconds"` // reading request headers, on every route
+	ControlSeconds    int `yaml:"controlSeconds" toml:"controlSeconds"`       // whole request on control endpoints
+	StallSeconds      int `yaml:"stallSeconds" toml:"stallSeconds"`           // file responses fail once the client accepts no bytes for this long
+	FileMaxSeconds    int `yaml:"fileMaxSeconds" toml:"fileMaxSeconds"`       // whole file response, 0 for no limit
+	IdleSeconds       int `yaml:"idleSeconds" toml:"idleSeconds"`             // keep-alive connections between requests
+}
+
+// ListenerConfig is an additional address the server listens on, with its
+// own access to admin endpoints.
+type ListenerConfig struct {
+	Address    string `yaml:"address" toml:"address"`       // host:port, :port, or unix:/path/to/socket
+	Admin      string `yaml:"admin" toml:"admin"`           // token (the default), none or off
+	SocketMode string `yaml:"socketMode" toml:"socketMode"` // permissions of a Unix socket in octal, e.g. 0660
+	IPFamily   string `yaml:"ipFamily" toml:"ipFamily"`     // dual (the default), ipv4 or ipv6
+}
+
+// RewriteRule maps file request paths to S3 keys. The part of the path,
+// without the base path and leading slash, that matches the regular
+// expression Match is replaced with Replace, which may refer to capture
+// groups as $1 or ${name}.
+type RewriteRule struct {
+	Match   string `yaml:"match" toml:"match"`     // e.g. ^android/(.+)$
+	Replace string `yaml:"replace" toml:"replace"` // e.g. com-acme-builds/android/$1
+}
+
+// CacheHeaderRule sets the headers that tell downstream HTTP caches, such as
+// CDNs and devices, how long they may keep file responses for keys starting
+// with Prefix.
+type CacheHeaderRule struct {
+	Prefix                string `yaml:"prefix" toml:"prefix"`                               // bucket/path, e.g. my-bucket/releases/; empty matches every key
+	CacheControl          string `yaml:"cacheControl" toml:"cacheControl"`                   // e.g. public, max-age=300
+	VersionedCacheControl string `yaml:"versionedCacheControl" toml:"versionedCacheControl"` // for requests pinned with ?versionId=, e.g. public, max-age=31536000, immutable; empty uses cacheControl
+	ExpiresSeconds        int    `yaml:"expiresSeconds" toml:"expiresSeconds"`               // Expires this long after the response, 0 for none
+}
+
+// CORSConfig controls cross-origin access to files and stats from browsers.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins" toml:"allowedOrigins"` // e.g. https://dashboard.example.com, or * for any; empty disables
+	AllowedMethods []string `yaml:"allowedMethods" toml:"allowedMethods"`
+	AllowedHeaders []string `yaml:"allowedHeaders" toml:"allowedHeaders"` // request headers browsers may send
+	MaxAgeSeconds  int      `yaml:"maxAgeSeconds" toml:"maxAgeSeconds"`   // how long browsers may cache a preflight response
+}
+
+// LatestConfig controls alias paths that resolve to the newest object in a
+// folder, e.g. /my-bucket/apps/myapp/latest.
+type LatestConfig struct {
+	Alias      string `yaml:"alias" toml:"alias"`           // last path segment acting as the alias, e.g. latest; empty disables
+	Order      string `yaml:"order" toml:"order"`           // modified (LastModified) or semver (version in the file name)
+	TTLSeconds int    `yaml:"ttlSeconds" toml:"ttlSeconds"` // how long a resolution is reused
+}
+
+// CacheConfig controls the on-disk cache.
+type CacheConfig struct {
+	Dir        string `yaml:"dir" toml:"dir"`
+	MaxSizeGB  int    `yaml:"maxSizeGB" toml:"maxSizeGB"`
+	MaxEntries int    `yaml:"maxEntries" toml:"maxEntries"` // limit on the number of entries, 0 for none
+	Policy     string `yaml:"policy" toml:"policy"`         // lru, lfu, arc or gdsf
+	DryRun     bool   `yaml:"dryRun" toml:"dryRun"`         // stream files from S3 and only record what maxSizeGB and policy would cache
+
+	EvictionRules []EvictionRuleConfig `yaml:"evictionRules" toml:"evictionRules"` // keys never evicted or evicted first, first match wins
+
+	Dirs []CacheDirConfig `yaml:"dirs" toml:"dirs"` // directories on separate disks to spread files over; replaces dir (the first) and maxSizeGB (the total)
+
+	MemoryMB           int `yaml:"memoryMB" toml:"memoryMB"`                     // in-memory hot tier size, 0 disables
+	MemoryMaxEntryKB   int `yaml:"memoryMaxEntryKB" toml:"memoryMaxEntryKB"`     // largest file kept in memory
+	MemoryPromoteAfter int `yaml:"memoryPromoteAfter" toml:"memoryPromoteAfter"` // disk hits before promotion
+
+	ChunkThresholdMB int `yaml:"chunkThresholdMB" toml:"chunkThresholdMB"` // objects larger than this are cached in chunks, 0 disables
+	ChunkSizeMB      int `yaml:"chunkSizeMB" toml:"chunkSizeMB"`
+
+	DeltaMaxSizeMB int `yaml:"deltaMaxSizeMB" toml:"deltaMaxSizeMB"` // largest file delta patches are generated for, 0 disables
+
+	Decompressed bool `yaml:"decompressed" toml:"decompressed"` // cache .gz/.zst objects requested with ?decompress=1 decompressed instead of as stored
+	Compress     bool `yaml:"compress" toml:"compress"`         // store cached files compressed with zstd unless already compressed
+	Fsync        bool `yaml:"fsync" toml:"fsync"`               // flush each cached file to disk before committing it
+
+	TrashRetentionHours int `yaml:"trashRetentionHours" toml:"trashRetentionHours"` // how long purged entries can be restored, 0 deletes them at once
+
+	Cold ColdConfig `yaml:"cold" toml:"cold"` // slower storage evicted entries are moved to
+
+	Janitor JanitorConfig `yaml:"janitor" toml:"janitor"`
+
+	Encryption EncryptionConfig `yaml:"encryption" toml:"encryption"`
+	Warmup     WarmupConfig     `yaml:"warmup" toml:"warmup"`
+	Refresh    []RefreshJob     `yaml:"refresh" toml:"refresh"` // periodic revalidation of cached keys
+	Verify     VerifyConfig     `yaml:"verify" toml:"verify"`
+
+	Namespaces []NamespaceConfig `yaml:"namespaces" toml:"namespaces"` // partitions of the cache for teams sharing the host
+
+	Storage []StorageTierConfig `yaml:"storage" toml:"storage"` // where files of entries are stored by size, first match wins; others go in dir or dirs
+}
+
+// EvictionRuleConfig overrides the eviction policy for matching keys.
+type EvictionRuleConfig struct {
+	Pattern string `yaml:"pattern" toml:"pattern"` // glob over bucket/path, or over the file name if it has no /, e.g. *.log
+	Action  string `yaml:"action" toml:"action"`   // never or first
+}
+
+// CacheDirConfig is one of several directories the cache spreads its files
+// over, such as one per SSD, with a size limit of its own.
+type CacheDirConfig struct {
+	Path      string `yaml:"path" toml:"path"`
+	MaxSizeGB int    `yaml:"maxSizeGB" toml:"maxSizeGB"`
+}
+
+// StorageTierConfig stores the files of entries within a size range outside
+// cache.dir, e.g. small files on a tmpfs or large ones on a set of volumes.
+type StorageTierConfig struct {
+	Name           string   `yaml:"name" toml:"name"`
+	Dirs           []string `yaml:"dirs" toml:"dirs"`                     // files are spread over these, e.g. one per volume
+	MinEntrySizeMB int      `yaml:"minEntrySizeMB" toml:"minEntrySizeMB"` // smallest entry stored here
+	MaxEntrySizeMB int      `yaml:"maxEntrySizeMB" toml:"maxEntrySizeMB"` // largest entry stored here, 0 for no limit
+}
+
+// namespaceName matches valid namespace names
+var namespaceName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// NamespaceConfig partitions the cache for one team. Requests carrying one of
+// Tokens as a bearer token, or under PathPrefix, are cached in the namespace,
+// which has its own size budget, statistics and purge scope. A namespace
+// with tokens can only be used with one of them.
+type NamespaceConfig struct {
+	Name       string   `yaml:"name" toml:"name"`             // lowercase letters, digits, - and _
+	Tokens     []string `yaml:"tokens" toml:"tokens"`         // bearer tokens that select the namespace
+	PathPrefix string   `yaml:"pathPrefix" toml:"pathPrefix"` // under the base path, e.g. /team-a; empty for none
+	MaxSizeGB  int      `yaml:"maxSizeGB" toml:"maxSizeGB"`   // size budget, 0 for only cache.maxSizeGB
+}
+
+// VerifyConfig controls signature verification of artifacts before they are
+// cached.
+type VerifyConfig struct {
+	Certificates []string `yaml:"certificates" toml:"certificates"` // PEM files of trusted signing certificates; enables verification
+	Buckets      []string `yaml:"buckets" toml:"buckets"`           // glob patterns of buckets to verify, empty verifies all
+	Detached     bool     `yaml:"detached" toml:"detached"`         // require a {key}.sig signature for files that aren't signed APKs
+}
+
+// RefreshJob revalidates the cached keys matching Pattern every
+// IntervalMinutes, downloading those that changed in S3 again.
+type RefreshJob struct {
+	Pattern         string `yaml:"pattern" toml:"pattern"` // glob over bucket/path, e.g. releases/latest/*
+	IntervalMinutes int    `yaml:"intervalMinutes" toml:"intervalMinutes"`
+}
+
+// WarmupConfig controls prefetching a list of keys at startup.
+type WarmupConfig struct {
+	Manifest    string `yaml:"manifest" toml:"manifest"`       // local path or s3://bucket/key of a file with one bucket/path per line
+	Concurrency int    `yaml:"concurrency" toml:"concurrency"` // keys downloaded at the same time
+}
+
+// ColdConfig sets up a cold tier on a larger, slower disk, which keeps
+// evicted entries until they are requested again instead of deleting them.
+type ColdConfig struct {
+	Dir       string `yaml:"dir" toml:"dir"`             // enables the cold tier
+	MaxSizeGB int    `yaml:"maxSizeGB" toml:"maxSizeGB"` // size limit of the cold tier
+}
+
+// JanitorConfig schedules background maintenance of the cache.
+type JanitorConfig struct {
+	QuietHours string `yaml:"quietHours" toml:"quietHours"` // local time window such as 01:00-05:00; enables the janitor
+}
+
+// EncryptionConfig controls encryption of cached files at rest.
+type EncryptionConfig struct {
+	KeyFile  string `yaml:"keyFile" toml:"keyFile"`   // enables encryption; holds the key, or the KMS-encrypted data key
+	KMSKeyID string `yaml:"kmsKeyId" toml:"kmsKeyId"` // KMS key protecting the data key in keyFile
+}
+
+// AWSConfig controls the S3 client.
+type AWSConfig struct {
+	Region        string            `yaml:"region" toml:"region"`
+	BucketRegions map[string]string `yaml:"bucketRegions" toml:"bucketRegions"` // bucket -> region, skips detection
+	Roles         []BucketRole      `yaml:"roles" toml:"roles"`                 // IAM roles to assume per bucket, first match wins
+
+	AccelerateBuckets []string `yaml:"accelerateBuckets" toml:"accelerateBuckets"` // use Transfer Acceleration, glob patterns
+	DualStackBuckets  []string `yaml:"dualStackBuckets" toml:"dualStackBuckets"`   // use IPv4/IPv6 endpoints, glob patterns
+
+	CustomerKeys []CustomerKey `yaml:"customerKeys" toml:"customerKeys"` // SSE-C keys per bucket, first match wins
+
+	MaxBandwidthMBps        float64 `yaml:"maxBandwidthMBps" toml:"maxBandwidthMBps"`               // all S3 downloads combined, 0 disables
+	MaxRequestBandwidthMBps float64 `yaml:"maxRequestBandwidthMBps" toml:"maxRequestBandwidthMBps"` // each S3 download, 0 disables
+
+	HTTP    HTTPClientConfig `yaml:"http" toml:"http"`
+	Proxy   ProxyConfig      `yaml:"proxy" toml:"proxy"`
+	Restore RestoreConfig    `yaml:"restore" toml:"restore"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuitBreaker" toml:"circuitBreaker"`
+}
+
+// CircuitBreakerConfig makes requests to a bucket fail fast while S3 keeps
+// failing them, instead of each waiting out its retries and timeouts.
+type CircuitBreakerConfig struct {
+	Failures        int `yaml:"failures" toml:"failures"`               // consecutive failures that open a bucket's breaker, 0 disables
+	CoolDownSeconds int `yaml:"coolDownSeconds" toml:"coolDownSeconds"` // how long it stays open before S3 is tried again
+}
+
+// ProxyConfig routes S3 traffic through an outbound proxy, for networks that
+// only reach AWS through one.
+type ProxyConfig struct {
+	URL     string        `yaml:"url" toml:"url"`         // http, https, socks5 or socks5h proxy for all AWS requests; empty leaves HTTPS_PROXY in charge
+	NoProxy []string      `yaml:"noProxy" toml:"noProxy"` // hosts, .domains, IPs or CIDRs reached directly, e.g. a VPC endpoint; * for all
+	Buckets []BucketProxy `yaml:"buckets" toml:"buckets"` // per-bucket overrides, first match wins
+}
+
+// BucketProxy maps a bucket name or glob pattern to a proxy of its own.
+type BucketProxy struct {
+	Bucket string `yaml:"bucket" toml:"bucket"`
+	URL    string `yaml:"url" toml:"url"` // proxy URL, or direct to connect without one
+}
+
+// RestoreConfig controls restores of objects in archive storage classes.
+type RestoreConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"` // restore on request instead of failing
+	Days    int    `yaml:"days" toml:"days"`       // how long the restored copy stays available
+	Tier    string `yaml:"tier" toml:"tier"`       // Expedited, Standard or Bulk
+}
+
+// HTTPClientConfig tunes the HTTP client used for AWS requests.
+type HTTPClientConfig struct {
+	MaxIdleConns          int  `yaml:"maxIdleConns" toml:"maxIdleConns"`
+	MaxIdleConnsPerHost   int  `yaml:"maxIdleConnsPerHost" toml:"maxIdleConnsPerHost"`
+	ConnectTimeoutSeconds int  `yaml:"connectTimeoutSeconds" toml:"connectTimeoutSeconds"`
+	ReadTimeoutSeconds    int  `yaml:"readTimeoutSeconds" toml:"readTimeoutSeconds"` // wait for response headers, 0 disables
+	TLSSessionReuse       bool `yaml:"tlsSessionReuse" toml:"tlsSessionReuse"`
+	HTTP2                 bool `yaml:"http2" toml:"http2"`
+}
+
+// CustomerKey maps a bucket name or glob pattern to a base64-encoded
+// 256-bit SSE-C key.
+type CustomerKey struct {
+	Bucket string `yaml:"bucket" toml:"bucket"`
+	Key    string `yaml:"key" toml:"key"`
+}
+
+// BucketRole maps a bucket name or glob pattern to an IAM role.
+type BucketRole struct {
+	Bucket     string `yaml:"bucket" toml:"bucket"`
+	RoleARN    string `yaml:"roleArn" toml:"roleArn"`
+	ExternalID string `yaml:"externalId" toml:"externalId"`
+}
+
+// ClusterConfig lists the other midway nodes to check for cached objects
+// before going to S3. Cluster mode is off when Peers and SRVRecord are empty.
+type ClusterConfig struct {
+	Peers []string `yaml:"peers" toml:"peers"` // base URLs, e.g. http://10.0.0.2:8900
+	Self  string   `yaml:"self" toml:"self"`   // this node's URL, skipped if it appears in peers
+	Token string   `yaml:"token" toml:"token"` // shared secret for the internal peer API, required in cluster mode
+
+	SRVRecord                string `yaml:"srvRecord" toml:"srvRecord"`                               // discover peers from this DNS SRV record instead of peers
+	DiscoveryIntervalSeconds int    `yaml:"discoveryIntervalSeconds" toml:"discoveryIntervalSeconds"` // how often srvRecord is resolved
+
+	ConsistentHash bool `yaml:"consistentHash" toml:"consistentHash"` // route each key to a single owner node
+
+	Redis RedisConfig `yaml:"redis" toml:"redis"`
+}
+
+// RedisConfig controls publishing the cache index to Redis, for schedulers
+// that route downloads to the node holding an artifact.
+type RedisConfig struct {
+	Addr       string `yaml:"addr" toml:"addr"` // host:port, empty disables
+	Password   string `yaml:"password" toml:"password"`
+	DB         int    `yaml:"db" toml:"db"`
+	Prefix     string `yaml:"prefix" toml:"prefix"`         // prepended to every Redis key
+	TTLSeconds int    `yaml:"ttlSeconds" toml:"ttlSeconds"` // a node's entries count as stale this long after its last heartbeat
+}
+
+// LogConfig controls log output destinations.
+type LogConfig struct {
+	Level       string           `yaml:"level" toml:"level"`
+	File        string           `yaml:"file" toml:"file"`
+	MaxSizeMB   int              `yaml:"maxSizeMB" toml:"maxSizeMB"`
+	MaxAgeHours int              `yaml:"maxAgeHours" toml:"maxAgeHours"`
+	MaxBackups  int              `yaml:"maxBackups" toml:"maxBackups"`
+	CloudWatch  CloudWatchConfig `yaml:"cloudwatch" toml:"cloudwatch"`
+}
+
+// CloudWatchConfig controls the optional CloudWatch Logs sink.
+type CloudWatchConfig struct {
+	LogGroup     string `yaml:"logGroup" toml:"logGroup"`
+	LogStream    string `yaml:"logStream" toml:"logStream"`
+	BatchSize    int    `yaml:"batchSize" toml:"batchSize"`
+	FlushSeconds int    `yaml:"flushSeconds" toml:"flushSeconds"`
+}
+
+// Default returns the configuration used when no file or env overrides are given.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:      "8900",
+			IPFamily:  "dual",
+			RateBurst: 100,
+			Admin:     "token",
+
+			MaxQueuedDownloads: 100,
+
+			CompleteOnDisconnect: true,
+
+			Latest: LatestConfig{
+				Order:      "modified",
+				TTLSeconds: 30,
+			},
+			Timeouts: TimeoutConfig{
+				ReadHeaderSeconds: 10,
+				ControlSeconds:    30,
+				StallSeconds:      120,
+				IdleSeconds:       60,
+			},
+			CORS: CORSConfig{
+				AllowedMethods: []string{"GET", "HEAD"},
+				AllowedHeaders: []string{"Range", "If-None-Match", "If-Modified-Since"},
+				MaxAgeSeconds:  600,
+			},
+			Alerts: AlertsConfig{
+				HitRateWindowMinutes: 15,
+			},
+		},
+		Cache: CacheConfig{
+			Dir:       defaultCacheDir(),
+			MaxSizeGB: 50,
+			Policy:    "lru",
+
+			MemoryMaxEntryKB:   4096,
+			MemoryPromoteAfter: 2,
+
+			ChunkSizeMB: 16,
+
+			DeltaMaxSizeMB: 128,
+
+			Warmup: WarmupConfig{
+				Concurrency: 4,
+			},
+		},
+		AWS: AWSConfig{
+			Region: "us-east-1",
+			HTTP: HTTPClientConfig{
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   100,
+				ConnectTimeoutSeconds: 10,
+				ReadTimeoutSeconds:    60,
+				TLSSessionReuse:       true,
+				HTTP2:                 true,
+			},
+			Restore: RestoreConfig{
+				Days: 7,
+				Tier: "Standard",
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				CoolDownSeconds: 30,
+			},
+		},
+		Cluster: ClusterConfig{
+			DiscoveryIntervalSeconds: 30,
+			Redis: RedisConfig{
+				Prefix:     "midway:",
+				TTLSeconds: 60,
+			},
+		},
+		Log: LogConfig{
+			Level:       "info",
+			MaxSizeMB:   100,
+			MaxAgeHours: 24,
+			MaxBackups:  7,
+			CloudWatch: CloudWatchConfig{
+				LogStream:    defaultLogStream(),
+				BatchSize:    1000,
+				FlushSeconds: 5,
+			},
+		},
+	}
+}
+
+// Load builds the effective configuration: defaults, then the file at path
+// (YAML or TOML, chosen by extension; skipped if path is empty), then
+// environment variable overrides. The result is validated before returning.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	cfg.resolveCacheDirs()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// resolveCacheDirs sets cache.dir and cache.maxSizeGB from cache.dirs, if
+// set: the first directory also holds the cache's metadata, and the limit is
+// the total of the directories'
+func (c *Config) resolveCacheDirs() {
+	if len(c.Cache.Dirs) == 0 {
+		return
+	}
+	c.Cache.Dir = c.Cache.Dirs[0].Path
+	c.Cache.MaxSizeGB = 0
+	for _, dir := range c.Cache.Dirs {
+		c.Cache.MaxSizeGB += dir.MaxSizeGB
+	}
+}
+
+// Validate checks that all values are usable.
+func (c *Config) Validate() error {
+	var problems []string
+
+	port, err := strconv.Atoi(c.Server.Port)
+	if err != nil || port < 1 || port > 65535 {
+		problems = append(problems, fmt.Sprintf("server.port must be between 1 and 65535, got %q", c.Server.Port))
+	}
+	if !validIPFamily(c.Server.IPFamily) {
+		problems = append(problems, fmt.Sprintf("server.ipFamily must be dual, ipv4 or ipv6, got %q", c.Server.IPFamily))
+	} else if problem := hostFamilyProblem(c.Server.Host, c.Server.IPFamily); problem != "" {
+		problems = append(problems, "server.host "+problem)
+	}
+	if !validAdminAccess(c.Server.Admin) {
+		problems = append(problems, fmt.Sprintf("server.admin must be token, none or off, got %q", c.Server.Admin))
+	}
+	if network, address := ListenAddress(c.Server.AdminAddress); c.Server.AdminAddress != "" && (address == "" || (network == "tcp" && !strings.Contains(address, ":"))) {
+		problems = append(problems, fmt.Sprintf("server.adminAddress must be host:port or unix:/path, got %q", c.Server.AdminAddress))
+	}
+	for i, l := range c.Server.Listeners {
+		if network, address := ListenAddress(l.Address); address == "" || (network == "tcp" && !strings.Contains(address, ":")) {
+			problems = append(problems, fmt.Sprintf("server.listeners[%d].address must be host:port or unix:/path, got %q", i, l.Address))
+		}
+		if l.IPFamily != "" && !validIPFamily(l.IPFamily) {
+			problems = append(problems, fmt.Sprintf("server.listeners[%d].ipFamily must be dual, ipv4 or ipv6, got %q", i, l.IPFamily))
+		} else if network, address := ListenAddress(l.Address); network == "tcp" {
+			host, _, _ := net.SplitHostPort(address)
+			if problem := hostFamilyProblem(host, l.IPFamily); problem != "" {
+				problems = append(problems, fmt.Sprintf("server.listeners[%d].address %s", i, problem))
+			}
+		}
+		if l.Admin != "" && !validAdminAccess(l.Admin) {
+			problems = append(problems, fmt.Sprintf("server.listeners[%d].admin must be token, none or off, got %q", i, l.Admin))
+		}
+		if mode, err := strconv.ParseUint(l.SocketMode, 8, 32); l.SocketMode != "" && (err != nil || mode > 0777) {
+			problems = append(problems, fmt.Sprintf("server.listeners[%d].socketMode must be octal permissions like 0660, got %q", i, l.SocketMode))
+		}
+	}
+	if c.Server.RateLimit < 0 {
+		problems = append(problems, fmt.Sprintf("server.rateLimit must not be negative, got %g", c.Server.RateLimit))
+	}
+	if c.Server.RateLimit > 0 && c.Server.RateBurst <= 0 {
+		problems = append(problems, "server.rateBurst must be positive when rateLimit is set")
+	}
+	if c.Server.MaxDownloads < 0 {
+		problems = append(problems, fmt.Sprintf("server.maxDownloads must not be negative, got %d", c.Server.MaxDownloads))
+	}
+	if c.Server.MaxQueuedDownloads < 0 {
+		problems = append(problems, fmt.Sprintf("server.maxQueuedDownloads must not be negative, got %d", c.Server.MaxQueuedDownloads))
+	}
+	if c.Server.Latest.Order != "modified" && c.Server.Latest.Order != "semver" {
+		problems = append(problems, fmt.Sprintf("server.latest.order must be modified or semver, got %q", c.Server.Latest.Order))
+	}
+	if strings.ContainsAny(c.Server.Latest.Alias, "/?") {
+		problems = append(problems, fmt.Sprintf("server.latest.alias must be a single path segment, got %q", c.Server.Latest.Alias))
+	}
+	if c.Server.Latest.TTLSeconds < 0 {
+		problems = append(problems, fmt.Sprintf("server.latest.ttlSeconds must not be negative, got %d", c.Server.Latest.TTLSeconds))
+	}
+	if c.Server.BasePath != "" && (!strings.HasPrefix(c.Server.BasePath, "/") || strings.ContainsAny(c.Server.BasePath, "?#") || strings.Trim(c.Server.BasePath, "/") == "") {
+		problems = append(problems, fmt.Sprintf("server.basePath must be a path like /artifacts, got %q", c.Server.BasePath))
+	}
+	for i, rule := range c.Server.Rewrites {
+		if _, err := regexp.Compile(rule.Match); err != nil || rule.Match == "" {
+			problems = append(problems, fmt.Sprintf("server.rewrites[%d].match must be a regular expression, got %q", i, rule.Match))
+		}
+	}
+	for i, rule := range c.Server.CacheHeaders {
+		if rule.CacheControl == "" && rule.VersionedCacheControl == "" && rule.ExpiresSeconds == 0 {
+			problems = append(problems, fmt.Sprintf("server.cacheHeaders[%d] must set cacheControl, versionedCacheControl or expiresSeconds", i))
+		}
+		if strings.ContainsAny(rule.CacheControl+rule.VersionedCacheControl, "\r\n") {
+			problems = append(problems, fmt.Sprintf("server.cacheHeaders[%d] must not contain line breaks", i))
+		}
+		if rule.ExpiresSeconds < 0 {
+			problems = append(problems, fmt.Sprintf("server.cacheHeaders[%d].expiresSeconds must not be negative, got %d", i, rule.ExpiresSeconds))
+		}
+	}
+	if t := c.Server.Timeouts; t.ReadHeaderSeconds <= 0 || t.ControlSeconds <= 0 || t.StallSeconds <= 0 || t.IdleSeconds <= 0 {
+		problems = append(problems, "server.timeouts.readHeaderSeconds, controlSeconds, stallSeconds and idleSeconds must be positive")
+	}
+	if c.Server.Timeouts.FileMaxSeconds < 0 {
+		problems = append(problems, fmt.Sprintf("server.timeouts.fileMaxSeconds must not be negative, got %d", c.Server.Timeouts.FileMaxSeconds))
+	}
+	for alias, bucket := range c.Server.BucketAliases {
+		if alias == "" || bucket == "" || strings.Contains(alias, "/") || strings.Contains(bucket, "/") {
+			problems = append(problems, fmt.Sprintf("server.bucketAliases entries need an alias and a bucket without slashes, got %q: %q", alias, bucket))
+		}
+	}
+	if strings.Contains(c.Server.DefaultBucket, "/") {
+		problems = append(problems, fmt.Sprintf("server.defaultBucket must be a bucket name, got %q", c.Server.DefaultBucket))
+	}
+	if c.Server.DefaultBucket != "" && len(c.Server.BucketAliases) > 0 {
+		problems = append(problems, "server.bucketAliases can't be used with server.defaultBucket, as paths don't name buckets")
+	}
+	for _, origin := range c.Server.CORS.AllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			problems = append(problems, fmt.Sprintf("server.cors.allowedOrigins must be * or start with http:// or https://, got %q", origin))
+		}
+	}
+	if c.Server.CORS.MaxAgeSeconds < 0 {
+		problems = append(problems, fmt.Sprintf("server.cors.maxAgeSeconds must not be negative, got %d", c.Server.CORS.MaxAgeSeconds))
+	}
+	if a := c.Server.Alerts; a.MinHitRatePercent < 0 || a.MinHitRatePercent > 100 || a.MaxDiskPercent < 0 || a.MaxDiskPercent > 100 {
+		problems = append(problems, "server.alerts.minHitRatePercent and server.alerts.maxDiskPercent must be between 0 and 100")
+	}
+	if c.Server.Alerts.HitRateWindowMinutes < 1 {
+		problems = append(problems, fmt.Sprintf("server.alerts.hitRateWindowMinutes must be positive, got %d", c.Server.Alerts.HitRateWindowMinutes))
+	}
+	if u := c.Server.Alerts.WebhookURL; u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		problems = append(problems, fmt.Sprintf("server.alerts.webhookURL must be an http or https URL, got %q", redactWebhook(u)))
+	}
+	if c.Cache.Dir == "" {
+		problems = append(problems, "cache.dir must not be empty")
+	}
+	if c.Cache.MaxSizeGB <= 0 {
+		problems = append(problems, fmt.Sprintf("cache.maxSizeGB must be positive, got %d", c.Cache.MaxSizeGB))
+	}
+	paths := map[string]bool{}
+	for i, dir := range c.Cache.Dirs {
+		if dir.Path == "" || paths[filepath.Clean(dir.Path)] {
+			problems = append(problems, fmt.Sprintf("cache.dirs[%d].path must be set and unique, got %q", i, dir.Path))
+		}
+		paths[filepath.Clean(dir.Path)] = true
+		if dir.MaxSizeGB <= 0 {
+			problems = append(problems, fmt.Sprintf("cache.dirs[%d].maxSizeGB must be positive, got %d", i, dir.MaxSizeGB))
+		}
+	}
+	if c.Cache.MaxEntries < 0 {
+		problems = append(problems, fmt.Sprintf("cache.maxEntries must not be negative, got %d", c.Cache.MaxEntries))
+	}
+	if c.Cache.MemoryMB < 0 || c.Cache.MemoryMaxEntryKB < 0 || c.Cache.MemoryPromoteAfter < 0 {
+		problems = append(problems, "cache.memoryMB, cache.memoryMaxEntryKB and cache.memoryPromoteAfter must not be negative")
+	}
+	if c.Cache.ChunkThresholdMB < 0 {
+		problems = append(problems, fmt.Sprintf("cache.chunkThresholdMB must not be negative, got %d", c.Cache.ChunkThresholdMB))
+	}
+	if c.Cache.ChunkThresholdMB > 0 && c.Cache.ChunkSizeMB <= 0 {
+		problems = append(problems, "cache.chunkSizeMB must be positive when chunkThresholdMB is set")
+	}
+	if c.Cache.DeltaMaxSizeMB < 0 || c.Cache.DeltaMaxSizeMB > 2047 {
+		problems = append(problems, fmt.Sprintf("cache.deltaMaxSizeMB must be between 0 and 2047, got %d", c.Cache.DeltaMaxSizeMB))
+	}
+	if c.Cache.Encryption.KMSKeyID != "" && c.Cache.Encryption.KeyFile == "" {
+		problems = append(problems, "cache.encryption.keyFile must be set when kmsKeyId is set")
+	}
+	if c.Cache.Warmup.Manifest != "" && c.Cache.Warmup.Concurrency <= 0 {
+		problems = append(problems, fmt.Sprintf("cache.warmup.concurrency must be positive, got %d", c.Cache.Warmup.Concurrency))
+	}
+	if location, ok := strings.CutPrefix(c.Cache.Warmup.Manifest, "s3://"); ok && !strings.Contains(strings.Trim(location, "/"), "/") {
+		problems = append(problems, fmt.Sprintf("cache.warmup.manifest must be s3://bucket/key, got %q", c.Cache.Warmup.Manifest))
+	}
+	for i, job := range c.Cache.Refresh {
+		if _, err := path.Match(job.Pattern, ""); err != nil || job.Pattern == "" {
+			problems = append(problems, fmt.Sprintf("cache.refresh[%d].pattern must be a glob pattern, got %q", i, job.Pattern))
+		}
+		if job.IntervalMinutes <= 0 {
+			problems = append(problems, fmt.Sprintf("cache.refresh[%d].intervalMinutes must be positive, got %d", i, job.IntervalMinutes))
+		}
+	}
+	if v := c.Cache.Verify; len(v.Certificates) == 0 && (len(v.Buckets) > 0 || v.Detached) {
+		problems = append(problems, "cache.verify.certificates must be set when buckets or detached is set")
+	}
+	for _, pattern := range c.Cache.Verify.Buckets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("cache.verify.buckets must be bucket names or glob patterns, got %q", pattern))
+		}
+	}
+	if c.Cache.TrashRetentionHours < 0 {
+		problems = append(problems, fmt.Sprintf("cache.trashRetentionHours must not be negative, got %d", c.Cache.TrashRetentionHours))
+	}
+	if cold := c.Cache.Cold; cold.Dir != "" && cold.MaxSizeGB <= 0 {
+		problems = append(problems, fmt.Sprintf("cache.cold.maxSizeGB must be positive when cache.cold.dir is set, got %d", cold.MaxSizeGB))
+	} else if cold.Dir == "" && cold.MaxSizeGB != 0 {
+		problems = append(problems, "cache.cold.dir must be set when cache.cold.maxSizeGB is")
+	}
+	if hours := c.Cache.Janitor.QuietHours; hours != "" {
+		if _, err := cache.ParseQuietHours(hours); err != nil {
+			problems = append(problems, fmt.Sprintf("cache.janitor.quietHours: %v", err))
+		}
+	}
+	names, tokens, prefixes := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for i, ns := range c.Cache.Namespaces {
+		if !namespaceName.MatchString(ns.Name) || names[ns.Name] {
+			problems = append(problems, fmt.Sprintf("cache.namespaces[%d].name must be unique and made of lowercase letters, digits, - and _, got %q", i, ns.Name))
+		}
+		names[ns.Name] = true
+		if len(ns.Tokens) == 0 && ns.PathPrefix == "" {
+			problems = append(problems, fmt.Sprintf("cache.namespaces[%d] must have tokens or a pathPrefix", i))
+		}
+		for _, token := range ns.Tokens {
+			if token == "" || tokens[token] || token == c.Server.AdminToken {
+				problems = append(problems, fmt.Sprintf("cache.namespaces[%d].tokens must be non-empty and unique, and differ from server.adminToken", i))
+			}
+			tokens[token] = true
+		}
+		if prefix := strings.TrimRight(ns.PathPrefix, "/"); ns.PathPrefix != "" && (!strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "?#") || prefixes[prefix]) {
+			problems = append(problems, fmt.Sprintf("cache.namespaces[%d].pathPrefix must be a unique path like /team-a, got %q", i, ns.PathPrefix))
+		} else {
+			prefixes[prefix] = true
+		}
+		if ns.MaxSizeGB < 0 {
+			problems = append(problems, fmt.Sprintf("cache.namespaces[%d].maxSizeGB must not be negative, got %d", i, ns.MaxSizeGB))
+		}
+	}
+	for i, tier := range c.Cache.Storage {
+		if tier.Name == "" {
+			problems = append(problems, fmt.Sprintf("cache.storage[%d].name must be set", i))
+		}
+		if len(tier.Dirs) == 0 || slices.Contains(tier.Dirs, "") {
+			problems = append(problems, fmt.Sprintf("cache.storage[%d].dirs must be a non-empty list of directories", i))
+		}
+		if tier.MinEntrySizeMB < 0 || tier.MaxEntrySizeMB < 0 || (tier.MaxEntrySizeMB > 0 && tier.MaxEntrySizeMB < tier.MinEntrySizeMB) {
+			problems = append(problems, fmt.Sprintf("cache.storage[%d] must have a non-negative minEntrySizeMB up to maxEntrySizeMB, got %d and %d", i, tier.MinEntrySizeMB, tier.MaxEntrySizeMB))
+		}
+	}
+	if len(tokens) > 0 && c.Server.AdminToken == "" {
+		problems = append(problems, "server.adminToken must be set when namespaces have tokens, or any client could purge every namespace")
+	}
+	if _, err := cache.NewPolicy(c.Cache.Policy); err != nil {
+		problems = append(problems, fmt.Sprintf("cache.policy: %v", err))
+	}
+	for i, rule := range c.Cache.EvictionRules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+			problems = append(problems, fmt.Sprintf("cache.evictionRules[%d].pattern must be a glob pattern, got %q", i, rule.Pattern))
+		}
+		if _, err := cache.ParseEvictionAction(rule.Action); err != nil {
+			problems = append(problems, fmt.Sprintf("cache.evictionRules[%d].action: %v", i, err))
+		}
+	}
+	if c.AWS.Region == "" {
+		problems = append(problems, "aws.region must not be empty")
+	}
+	for bucket, region := range c.AWS.BucketRegions {
+		if bucket == "" || region == "" {
+			problems = append(problems, fmt.Sprintf("aws.bucketRegions entries need a bucket and a region, got %q: %q", bucket, region))
+		}
+	}
+	if h := c.AWS.HTTP; h.MaxIdleConns < 0 || h.MaxIdleConnsPerHost < 0 || h.ReadTimeoutSeconds < 0 {
+		problems = append(problems, "aws.http.maxIdleConns, aws.http.maxIdleConnsPerHost and aws.http.readTimeoutSeconds must not be negative")
+	}
+	if c.AWS.HTTP.ConnectTimeoutSeconds <= 0 {
+		problems = append(problems, fmt.Sprintf("aws.http.connectTimeoutSeconds must be positive, got %d", c.AWS.HTTP.ConnectTimeoutSeconds))
+	}
+	switch c.AWS.Restore.Tier {
+	case "Expedited", "Standard", "Bulk":
+	default:
+		problems = append(problems, fmt.Sprintf("aws.restore.tier must be Expedited, Standard or Bulk, got %q", c.AWS.Restore.Tier))
+	}
+	if c.AWS.CircuitBreaker.Failures < 0 {
+		problems = append(problems, fmt.Sprintf("aws.circuitBreaker.failures must not be negative, got %d", c.AWS.CircuitBreaker.Failures))
+	}
+	if c.AWS.CircuitBreaker.Failures > 0 && c.AWS.CircuitBreaker.CoolDownSeconds <= 0 {
+		problems = append(problems, fmt.Sprintf("aws.circuitBreaker.coolDownSeconds must be positive, got %d", c.AWS.CircuitBreaker.CoolDownSeconds))
+	}
+	if c.AWS.Restore.Days <= 0 {
+		problems = append(problems, fmt.Sprintf("aws.restore.days must be positive, got %d", c.AWS.Restore.Days))
+	}
+	if c.AWS.MaxBandwidthMBps < 0 || c.AWS.MaxRequestBandwidthMBps < 0 {
+		problems = append(problems, "aws.maxBandwidthMBps and aws.maxRequestBandwidthMBps must not be negative")
+	}
+	for _, pattern := range append(append([]string{}, c.AWS.AccelerateBuckets...), c.AWS.DualStackBuckets...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("aws.accelerateBuckets and aws.dualStackBuckets must be bucket names or glob patterns, got %q", pattern))
+		}
+	}
+	for i, role := range c.AWS.Roles {
+		if _, err := path.Match(role.Bucket, ""); err != nil || role.Bucket == "" {
+			problems = append(problems, fmt.Sprintf("aws.roles[%d].bucket must be a bucket name or glob pattern, got %q", i, role.Bucket))
+		}
+		if !strings.HasPrefix(role.RoleARN, "arn:") {
+			problems = append(problems, fmt.Sprintf("aws.roles[%d].roleArn must be an IAM role ARN, got %q", i, role.RoleARN))
+		}
+	}
+	if c.AWS.Proxy.URL != "" && !validProxyURL(c.AWS.Proxy.URL) {
+		problems = append(problems, fmt.Sprintf("aws.proxy.url must be an http, https, socks5 or socks5h URL, got %q", redactURL(c.AWS.Proxy.URL)))
+	}
+	for i, proxy := range c.AWS.Proxy.Buckets {
+		if _, err := path.Match(proxy.Bucket, ""); err != nil || proxy.Bucket == "" {
+			problems = append(problems, fmt.Sprintf("aws.proxy.buckets[%d].bucket must be a bucket name or glob pattern, got %q", i, proxy.Bucket))
+		}
+		if proxy.URL != "direct" && !validProxyURL(proxy.URL) {
+			problems = append(problems, fmt.Sprintf("aws.proxy.buckets[%d].url must be an http, https, socks5 or socks5h URL, or direct, got %q", i, redactURL(proxy.URL)))
+		}
+	}
+	for i, key := range c.AWS.CustomerKeys {
+		if _, err := path.Match(key.Bucket, ""); err != nil || key.Bucket == "" {
+			problems = append(problems, fmt.Sprintf("aws.customerKeys[%d].bucket must be a bucket name or glob pattern, got %q", i, key.Bucket))
+		}
+		if raw, err := base64.StdEncoding.DecodeString(key.Key); err != nil || len(raw) != 32 {
+			problems = append(problems, fmt.Sprintf("aws.customerKeys[%d].key must be a base64-encoded 256-bit key", i))
+		}
+	}
+	for _, peer := range c.Cluster.Peers {
+		if u, err := url.Parse(peer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("cluster.peers must be http:// or https:// URLs, got %q", peer))
+		}
+	}
+	if (len(c.Cluster.Peers) > 0 || c.Cluster.SRVRecord != "") && c.Cluster.Token == "" {
+		problems = append(problems, "cluster.token must be set in cluster mode, as the peer API is served on the file port")
+	}
+	if c.Cluster.ConsistentHash && c.Cluster.Self == "" && c.Cluster.SRVRecord == "" {
+		problems = append(problems, "cluster.self must be set when consistentHash is enabled without srvRecord")
+	}
+	if c.Cluster.SRVRecord != "" && c.Cluster.DiscoveryIntervalSeconds <= 0 {
+		problems = append(problems, fmt.Sprintf("cluster.discoveryIntervalSeconds must be positive, got %d", c.Cluster.DiscoveryIntervalSeconds))
+	}
+	if c.Cluster.Redis.Addr != "" && c.Cluster.Redis.TTLSeconds < 3 {
+		problems = append(problems, fmt.Sprintf("cluster.redis.ttlSeconds must be at least 3, got %d", c.Cluster.Redis.TTLSeconds))
+	}
+	if _, err := logger.ParseLevel(c.Log.Level); err != nil {
+		problems = append(problems, fmt.Sprintf("log.level: %v", err))
+	}
+	if c.Log.MaxSizeMB < 0 || c.Log.MaxAgeHours < 0 || c.Log.MaxBackups < 0 {
+		problems = append(problems, "log.maxSizeMB, log.maxAgeHours and log.maxBackups must not be negative")
+	}
+	if c.Log.CloudWatch.LogGroup != "" && c.Log.CloudWatch.LogStream == "" {
+		problems = append(problems, "log.cloudwatch.logStream must be set when logGroup is set")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// validAdminAccess reports whether access is a known way of protecting
+// admin endpoints on a listener
+func validAdminAccess(access string) bool {
+	return access == "token" || access == "none" || access == "off"
+}
+
+// ListenAddress splits a listener address into the network and address to
+// pass to net.Listen: unix:/path is a Unix socket, anything else TCP.
+func ListenAddress(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return "unix", strings.TrimPrefix(path, "//")
+	}
+	return "tcp", address
+}
+
+// TCPNetwork returns the net.Listen network accepting the IP versions of
+// family: tcp4, tcp6 (IPv6 only, even on the unspecified address) or tcp.
+func TCPNetwork(family string) string {
+	switch family {
+	case "ipv4":
+		return "tcp4"
+	case "ipv6":
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+func validIPFamily(family string) bool {
+	return family == "dual" || family == "ipv4" || family == "ipv6"
+}
+
+// hostFamilyProblem describes why host can't be listened on with family, or
+// returns "" if it can
+func hostFamilyProblem(host, family string) string {
+	if host == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return fmt.Sprintf("must not include a port, got %q", host)
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	switch {
+	case ip == nil:
+		return ""
+	case family == "ipv4" && ip.To4() == nil:
+		return fmt.Sprintf("%s is not an IPv4 address, as ipFamily ipv4 requires", host)
+	case family == "ipv6" && ip.To4() != nil:
+		return fmt.Sprintf("%s is not an IPv6 address, as ipFamily ipv6 requires", host)
+	}
+	return ""
+}
+
+// Lines renders the configuration as YAML, one line per element, for logging
+// the effective configuration at startup. Secrets are redacted.
+func (c *Config) Lines() []string {
+	redacted := *c
+	if redacted.Server.AdminToken != "" {
+		redacted.Server.AdminToken = "<redacted>"
+	}
+	if redacted.Cluster.Token != "" {
+		redacted.Cluster.Token = "<redacted>"
+	}
+	if redacted.Cluster.Redis.Password != "" {
+		redacted.Cluster.Redis.Password = "<redacted>"
+	}
+	if len(redacted.AWS.CustomerKeys) > 0 {
+		keys := make([]CustomerKey, len(redacted.AWS.CustomerKeys))
+		for i, key := range redacted.AWS.CustomerKeys {
+			keys[i] = CustomerKey{Bucket: key.Bucket, Key: "<redacted>"}
+		}
+		redacted.AWS.CustomerKeys = keys
+	}
+	if redacted.Server.Alerts.WebhookURL != "" {
+		redacted.Server.Alerts.WebhookURL = redactWebhook(redacted.Server.Alerts.WebhookURL)
+	}
+	if redacted.AWS.Proxy.URL != "" {
+		redacted.AWS.Proxy.URL = redactURL(redacted.AWS.Proxy.URL)
+	}
+	if len(redacted.AWS.Proxy.Buckets) > 0 {
+		proxies := make([]BucketProxy, len(redacted.AWS.Proxy.Buckets))
+		for i, proxy := range redacted.AWS.Proxy.Buckets {
+			proxies[i] = BucketProxy{Bucket: proxy.Bucket, URL: redactURL(proxy.URL)}
+		}
+		redacted.AWS.Proxy.Buckets = proxies
+	}
+	if len(redacted.Cache.Namespaces) > 0 {
+		namespaces := make([]NamespaceConfig, len(redacted.Cache.Namespaces))
+		for i, ns := range redacted.Cache.Namespaces {
+			namespaces[i] = ns
+			namespaces[i].Tokens = nil
+			for range ns.Tokens {
+				namespaces[i].Tokens = append(namespaces[i].Tokens, "<redacted>")
+			}
+		}
+		redacted.Cache.Namespaces = namespaces
+	}
+
+	var buf strings.Builder
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&redacted); err != nil {
+		return []string{fmt.Sprintf("failed to render configuration: %v", err)}
+	}
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// loadFile decodes a YAML or TOML file over the current values
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return fmt.Errorf("failed to parse YAML config %s: %w", path, err)
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, c); err != nil {
+			return fmt.Errorf("failed to parse TOML config %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q (want .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+
+	return nil
+}
+
+// applyEnv overrides values from environment variables
+func (c *Config) applyEnv() error {
+	var errs []string
+
+	envString := func(key string, dst *string) {
+		if value := os.Getenv(key); value != "" {
+			*dst = value
+		}
+	}
+	envInt := func(key string, dst *int) {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s must be an integer, got %q", key, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	envFloat := func(key string, dst *float64) {
+		if value := os.Getenv(key); value != "" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s must be a number, got %q", key, value))
+				return
+			}
+			*dst = f
+		}
+	}
+	envBool := func(key string, dst *bool) {
+		if value := os.Getenv(key); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s must be true or false, got %q", key, value))
+				return
+			}
+			*dst = b
+		}
+	}
+	envList := func(key string, dst *[]string) {
+		if value := os.Getenv(key); value != "" {
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			*dst = items
+		}
+	}
+
+	envString("PORT", &c.Server.Port)
+	envString("LISTEN_HOST", &c.Server.Host)
+	envString("LISTEN_IP_FAMILY", &c.Server.IPFamily)
+	envString("ADMIN_TOKEN", &c.Server.AdminToken)
+	envString("ADMIN_ACCESS", &c.Server.Admin)
+	envString("ADMIN_ADDRESS", &c.Server.AdminAddress)
+	envString("AUDIT_LOG", &c.Server.AuditLog)
+	if value := os.Getenv("LISTENERS"); value != "" {
+		c.Server.Listeners = nil
+		for _, item := range strings.Split(value, ",") {
+			// An optional =admin suffix sets the listener's admin access
+			address, admin, _ := strings.Cut(strings.TrimSpace(item), "=")
+			c.Server.Listeners = append(c.Server.Listeners, ListenerConfig{Address: address, Admin: admin})
+		}
+	}
+	envList("ALLOWED_BUCKETS", &c.Server.AllowedBuckets)
+	envFloat("RATE_LIMIT_RPS", &c.Server.RateLimit)
+	envInt("RATE_LIMIT_BURST", &c.Server.RateBurst)
+	envInt("MAX_DOWNLOADS", &c.Server.MaxDownloads)
+	envInt("MAX_QUEUED_DOWNLOADS", &c.Server.MaxQueuedDownloads)
+	envBool("COMPLETE_ON_DISCONNECT", &c.Server.CompleteOnDisconnect)
+	envBool("COMPRESS_RESPONSES", &c.Server.CompressResponses)
+	envBool("CONTENT_SHA256", &c.Server.ContentSHA256)
+	envBool("SERVE_STALE", &c.Server.ServeStale)
+	envBool("TRUST_PROXY", &c.Server.TrustProxy)
+	envString("LATEST_ALIAS", &c.Server.Latest.Alias)
+	envString("LATEST_ORDER", &c.Server.Latest.Order)
+	envInt("LATEST_TTL_SECONDS", &c.Server.Latest.TTLSeconds)
+	envString("BASE_PATH", &c.Server.BasePath)
+	envString("DEFAULT_BUCKET", &c.Server.DefaultBucket)
+	if value := os.Getenv("REWRITES"); value != "" {
+		c.Server.Rewrites = nil
+		for _, item := range strings.Split(value, ",") {
+			match, replace, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				errs = append(errs, fmt.Sprintf("REWRITES entries must be match=replace, got %q", item))
+				continue
+			}
+			c.Server.Rewrites = append(c.Server.Rewrites, RewriteRule{Match: match, Replace: replace})
+		}
+	}
+	if value := os.Getenv("BUCKET_ALIASES"); value != "" {
+		c.Server.BucketAliases = make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			alias, bucket, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				errs = append(errs, fmt.Sprintf("BUCKET_ALIASES entries must be alias=bucket, got %q", item))
+				continue
+			}
+			c.Server.BucketAliases[alias] = bucket
+		}
+	}
+	envInt("TIMEOUT_READ_HEADER_SECONDS", &c.Server.Timeouts.ReadHeaderSeconds)
+	envInt("TIMEOUT_CONTROL_SECONDS", &c.Server.Timeouts.ControlSeconds)
+	envInt("TIMEOUT_STALL_SECONDS", &c.Server.Timeouts.StallSeconds)
+	envInt("TIMEOUT_FILE_MAX_SECONDS", &c.Server.Timeouts.FileMaxSeconds)
+	envInt("TIMEOUT_IDLE_SECONDS", &c.Server.Timeouts.IdleSeconds)
+	envList("CORS_ALLOWED_ORIGINS", &c.Server.CORS.AllowedOrigins)
+	envList("CORS_ALLOWED_METHODS", &c.Server.CORS.AllowedMethods)
+	envList("CORS_ALLOWED_HEADERS", &c.Server.CORS.AllowedHeaders)
+	envInt("CORS_MAX_AGE_SECONDS", &c.Server.CORS.MaxAgeSeconds)
+	envFloat("ALERT_MIN_HIT_RATE_PERCENT", &c.Server.Alerts.MinHitRatePercent)
+	envInt("ALERT_HIT_RATE_WINDOW_MINUTES", &c.Server.Alerts.HitRateWindowMinutes)
+	envFloat("ALERT_MAX_DISK_PERCENT", &c.Server.Alerts.MaxDiskPercent)
+	envString("ALERT_WEBHOOK_URL", &c.Server.Alerts.WebhookURL)
+	envString("CACHE_DIR", &c.Cache.Dir)
+	envInt("CACHE_MAX_SIZE_GB", &c.Cache.MaxSizeGB)
+	if value := os.Getenv("CACHE_DIRS"); value != "" {
+		c.Cache.Dirs = nil
+		for _, item := range strings.Split(value, ",") {
+			// Paths may contain ':', sizes never do
+			item = strings.TrimSpace(item)
+			i := strings.LastIndex(item, ":")
+			size, err := strconv.Atoi(item[i+1:])
+			if i < 0 || err != nil {
+				errs = append(errs, fmt.Sprintf("CACHE_DIRS entries must be path:maxSizeGB, got %q", item))
+				continue
+			}
+			c.Cache.Dirs = append(c.Cache.Dirs, CacheDirConfig{Path: item[:i], MaxSizeGB: size})
+		}
+	}
+	envInt("CACHE_MAX_ENTRIES", &c.Cache.MaxEntries)
+	envString("CACHE_POLICY", &c.Cache.Policy)
+	envInt("CACHE_MEMORY_MB", &c.Cache.MemoryMB)
+	envInt("CACHE_MEMORY_MAX_ENTRY_KB", &c.Cache.MemoryMaxEntryKB)
+	envInt("CACHE_MEMORY_PROMOTE_AFTER", &c.Cache.MemoryPromoteAfter)
+	envInt("CACHE_CHUNK_THRESHOLD_MB", &c.Cache.ChunkThresholdMB)
+	envInt("CACHE_CHUNK_SIZE_MB", &c.Cache.ChunkSizeMB)
+	envInt("CACHE_DELTA_MAX_SIZE_MB", &c.Cache.DeltaMaxSizeMB)
+	envBool("CACHE_DECOMPRESSED", &c.Cache.Decompressed)
+	envBool("CACHE_COMPRESS", &c.Cache.Compress)
+	envBool("CACHE_FSYNC", &c.Cache.Fsync)
+	envBool("CACHE_DRY_RUN", &c.Cache.DryRun)
+	envInt("CACHE_TRASH_RETENTION_HOURS", &c.Cache.TrashRetentionHours)
+	envString("CACHE_COLD_DIR", &c.Cache.Cold.Dir)
+	envInt("CACHE_COLD_MAX_SIZE_GB", &c.Cache.Cold.MaxSizeGB)
+	envString("CACHE_JANITOR_HOURS", &c.Cache.Janitor.QuietHours)
+	envString("CACHE_ENCRYPTION_KEY_FILE", &c.Cache.Encryption.KeyFile)
+	envString("CACHE_ENCRYPTION_KMS_KEY_ID", &c.Cache.Encryption.KMSKeyID)
+	envString("WARMUP_MANIFEST", &c.Cache.Warmup.Manifest)
+	envInt("WARMUP_CONCURRENCY", &c.Cache.Warmup.Concurrency)
+	envList("VERIFY_CERTIFICATES", &c.Cache.Verify.Certificates)
+	envList("VERIFY_BUCKETS", &c.Cache.Verify.Buckets)
+	envBool("VERIFY_DETACHED", &c.Cache.Verify.Detached)
+	if value := os.Getenv("CACHE_EVICTION_RULES"); value != "" {
+		c.Cache.EvictionRules = nil
+		for _, item := range strings.Split(value, ",") {
+			pattern, action, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				errs = append(errs, fmt.Sprintf("CACHE_EVICTION_RULES entries must be pattern=action, got %q", item))
+				continue
+			}
+			c.Cache.EvictionRules = append(c.Cache.EvictionRules, EvictionRuleConfig{Pattern: pattern, Action: action})
+		}
+	}
+	if value := os.Getenv("CACHE_REFRESH"); value != "" {
+		c.Cache.Refresh = nil
+		for _, item := range strings.Split(value, ",") {
+			pattern, minutes, ok := strings.Cut(strings.TrimSpace(item), "=")
+			interval, err := strconv.Atoi(minutes)
+			if !ok || err != nil {
+				errs = append(errs, fmt.Sprintf("CACHE_REFRESH entries must be pattern=minutes, got %q", item))
+				continue
+			}
+			c.Cache.Refresh = append(c.Cache.Refresh, RefreshJob{Pattern: pattern, IntervalMinutes: interval})
+		}
+	}
+	envString("AWS_REGION", &c.AWS.Region)
+	if value := os.Getenv("AWS_BUCKET_REGIONS"); value != "" {
+		c.AWS.BucketRegions = make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			bucket, region, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				errs = append(errs, fmt.Sprintf("AWS_BUCKET_REGIONS entries must be bucket=region, got %q", item))
+				continue
+			}
+			c.AWS.BucketRegions[bucket] = region
+		}
+	}
+	envFloat("S3_MAX_BANDWIDTH_MBPS", &c.AWS.MaxBandwidthMBps)
+	envFloat("S3_MAX_REQUEST_BANDWIDTH_MBPS", &c.AWS.MaxRequestBandwidthMBps)
+	envInt("S3_MAX_IDLE_CONNS", &c.AWS.HTTP.MaxIdleConns)
+	envInt("S3_MAX_IDLE_CONNS_PER_HOST", &c.AWS.HTTP.MaxIdleConnsPerHost)
+	envInt("S3_CONNECT_TIMEOUT_SECONDS", &c.AWS.HTTP.ConnectTimeoutSeconds)
+	envInt("S3_READ_TIMEOUT_SECONDS", &c.AWS.HTTP.ReadTimeoutSeconds)
+	envBool("S3_TLS_SESSION_REUSE", &c.AWS.HTTP.TLSSessionReuse)
+	envBool("S3_HTTP2", &c.AWS.HTTP.HTTP2)
+	envList("S3_ACCELERATE_BUCKETS", &c.AWS.AccelerateBuckets)
+	envList("S3_DUALSTACK_BUCKETS", &c.AWS.DualStackBuckets)
+	envBool("S3_RESTORE_ARCHIVED", &c.AWS.Restore.Enabled)
+	envInt("S3_RESTORE_DAYS", &c.AWS.Restore.Days)
+	envString("S3_RESTORE_TIER", &c.AWS.Restore.Tier)
+	envInt("S3_BREAKER_FAILURES", &c.AWS.CircuitBreaker.Failures)
+	envInt("S3_BREAKER_COOLDOWN_SECONDS", &c.AWS.CircuitBreaker.CoolDownSeconds)
+	envString("S3_PROXY_URL", &c.AWS.Proxy.URL)
+	envList("S3_NO_PROXY", &c.AWS.Proxy.NoProxy)
+	if value := os.Getenv("S3_BUCKET_PROXIES"); value != "" {
+		c.AWS.Proxy.Buckets = nil
+		for _, item := range strings.Split(value, ",") {
+			// Bucket names never contain '=', proxy URLs may
+			bucket, proxyURL, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				errs = append(errs, fmt.Sprintf("S3_BUCKET_PROXIES entries must be bucket=url, got %q", redactURL(item)))
+				continue
+			}
+			c.AWS.Proxy.Buckets = append(c.AWS.Proxy.Buckets, BucketProxy{Bucket: bucket, URL: proxyURL})
+		}
+	}
+	if value := os.Getenv("AWS_BUCKET_ROLES"); value != "" {
+		c.AWS.Roles = nil
+		for _, item := range strings.Split(value, ",") {
+			bucket, roleARN, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				errs = append(errs, fmt.Sprintf("AWS_BUCKET_ROLES entries must be bucket=roleArn, got %q", item))
+				continue
+			}
+			c.AWS.Roles = append(c.AWS.Roles, BucketRole{Bucket: bucket, RoleARN: roleARN})
+		}
+	}
+	if value := os.Getenv("AWS_SSE_CUSTOMER_KEYS"); value != "" {
+		c.AWS.CustomerKeys = nil
+		for _, item := range strings.Split(value, ",") {
+			// Base64 keys may end in '=', bucket names never contain it
+			bucket, key, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				errs = append(errs, "AWS_SSE_CUSTOMER_KEYS entries must be bucket=base64Key")
+				continue
+			}
+			c.AWS.CustomerKeys = append(c.AWS.CustomerKeys, CustomerKey{Bucket: bucket, Key: key})
+		}
+	}
+	envList("CLUSTER_PEERS", &c.Cluster.Peers)
+	envString("CLUSTER_SELF", &c.Cluster.Self)
+	envString("CLUSTER_TOKEN", &c.Cluster.Token)
+	envString("CLUSTER_SRV_RECORD", &c.Cluster.SRVRecord)
+	envInt("CLUSTER_DISCOVERY_INTERVAL_SECONDS", &c.Cluster.DiscoveryIntervalSeconds)
+	envBool("CLUSTER_CONSISTENT_HASH", &c.Cluster.ConsistentHash)
+	envString("REDIS_ADDR", &c.Cluster.Redis.Addr)
+	envString("REDIS_PASSWORD", &c.Cluster.Redis.Password)
+	envInt("REDIS_DB", &c.Cluster.Redis.DB)
+	envString("REDIS_PREFIX", &c.Cluster.Redis.Prefix)
+	envInt("REDIS_TTL_SECONDS", &c.Cluster.Redis.TTLSeconds)
+	envString("LOG_LEVEL", &c.Log.Level)
+	envString("LOG_FILE", &c.Log.File)
+	envInt("LOG_MAX_SIZE_MB", &c.Log.MaxSizeMB)
+	envInt("LOG_MAX_AGE_HOURS", &c.Log.MaxAgeHours)
+	envInt("LOG_MAX_BACKUPS", &c.Log.MaxBackups)
+	envString("CLOUDWATCH_LOG_GROUP", &c.Log.CloudWatch.LogGroup)
+	envString("CLOUDWATCH_LOG_STREAM", &c.Log.CloudWatch.LogStream)
+	envInt("CLOUDWATCH_BATCH_SIZE", &c.Log.CloudWatch.BatchSize)
+	envInt("CLOUDWATCH_FLUSH_SECONDS", &c.Log.CloudWatch.FlushSeconds)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func defaultCacheDir() string {
+	if cacheDir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(cacheDir, "midway")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".cache", "midway")
+}
+
+func defaultLogStream() string {
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "midway"
+}
+
+// validProxyURL reports whether value is a proxy URL the HTTP client can use
+func validProxyURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return true
+	}
+	return false
+}
+
+// redactURL hides the password of a URL, such as a proxy's credentials
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+	return u.Redacted()
+}
+
+// redactWebhook keeps only the scheme and host of a webhook URL, as
+// webhooks like Slack's carry their secret in the path
+func redactWebhook(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return "<redacted>"
+	}
+	return u.Scheme + "://" + u.Host + "/<redacted>"
+}
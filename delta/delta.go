@@ -0,0 +1,298 @@
+// Package delta creates and applies binary patches between two versions of
+// a file, so a device holding the old version only needs to download the
+// difference.
+//
+// Patches use the bsdiff algorithm. A patch is a zstd-compressed stream
+// holding the 16-byte magic "MIDWAY/BSDIFFZ01", the size and SHA-256 of the
+// new file, and a sequence of control triples, each followed by its data:
+//
+//	add    length x of bytes added to the old file, followed by those x bytes
+//	copy   length y of bytes inserted verbatim, followed by those y bytes
+//	seek   offset z moving the position in the old file after both
+//
+// All integers are 8 bytes, little endian, with the sign in the top bit, as
+// in bsdiff.
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const magic = "MIDWAY/BSDIFFZ01"
+
+// MaxSize is the largest old file Diff accepts. Suffix sorting uses 32-bit
+// indexes.
+const MaxSize = 1<<31 - 2
+
+// ErrCorrupt is returned by Apply for patches that are malformed or were
+// made from a different old file.
+var ErrCorrupt = errors.New("corrupt patch")
+
+// Diff writes a patch that turns old into new to w. It needs about nine
+// times the size of old in memory, plus both files.
+func Diff(old, new []byte, w io.Writer) error {
+	if len(old) > MaxSize {
+		return fmt.Errorf("old file too large for delta: %d bytes", len(old))
+	}
+
+	enc, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriterSize(enc, 64*1024)
+
+	bw.WriteString(magic)
+	writeInt(bw, int64(len(new)))
+	sum := sha256.Sum256(new)
+	bw.Write(sum[:])
+
+	sa := suffixSort(old)
+	var diff []byte
+	emit := func(add, copy, seek int, oldPos, newPos int) {
+		writeInt(bw, int64(add))
+		writeInt(bw, int64(copy))
+		writeInt(bw, int64(seek))
+		diff = diff[:0]
+		for i := range add {
+			diff = append(diff, new[newPos+i]-old[oldPos+i])
+		}
+		bw.Write(diff)
+		bw.Write(new[newPos+add : newPos+add+copy])
+	}
+	bsdiff(old, new, sa, emit)
+
+	if err := bw.Flush(); err != nil {
+		enc.Close()
+		return err
+	}
+	return enc.Close()
+}
+
+// bsdiff finds the approximate matches between old and new and calls emit
+// for each control triple, as in bsdiff 4.3
+func bsdiff(old, new []byte, sa []int32, emit func(add, copy, seek, oldPos, newPos int)) {
+	oldSize, newSize := len(old), len(new)
+	var scan, length, pos, lastScan, lastPos, lastOffset int
+
+	for scan < newSize {
+		oldScore := 0
+		scan += length
+		for scsc := scan; scan < newSize; scan++ {
+			length, pos = search(sa, old, new[scan:], 0, oldSize)
+
+			for ; scsc < scan+length; scsc++ {
+				if scsc+lastOffset < oldSize && old[scsc+lastOffset] == new[scsc] {
+					oldScore++
+				}
+			}
+			if (length == oldScore && length != 0) || length > oldScore+8 {
+				break
+			}
+			if scan+lastOffset < oldSize && old[scan+lastOffset] == new[scan] {
+				oldScore--
+			}
+		}
+
+		if length == oldScore && scan != newSize {
+			continue
+		}
+
+		// Extend the previous match forwards...
+		s, sf, lenf := 0, 0, 0
+		for i := 0; lastScan+i < scan && lastPos+i < oldSize; {
+			if old[lastPos+i] == new[lastScan+i] {
+				s++
+			}
+			i++
+			if s*2-i > sf*2-lenf {
+				sf, lenf = s, i
+			}
+		}
+
+		// ...and the next one backwards
+		lenb := 0
+		if scan < newSize {
+			s, sb := 0, 0
+			for i := 1; scan >= lastScan+i && pos >= i; i++ {
+				if old[pos-i] == new[scan-i] {
+					s++
+				}
+				if s*2-i > sb*2-lenb {
+					sb, lenb = s, i
+				}
+			}
+		}
+
+		// Split any overlap where it scores best
+		if lastScan+lenf > scan-lenb {
+			overlap := (lastScan + lenf) - (scan - lenb)
+			s, ss, lens := 0, 0, 0
+			for i := range overlap {
+				if new[lastScan+lenf-overlap+i] == old[lastPos+lenf-overlap+i] {
+					s++
+				}
+				if new[scan-lenb+i] == old[pos-lenb+i] {
+					s--
+				}
+				if s > ss {
+					ss, lens = s, i+1
+				}
+			}
+			lenf += lens - overlap
+			lenb -= lens
+		}
+
+		emit(lenf, (scan-lenb)-(lastScan+lenf), (pos-lenb)-(lastPos+lenf), lastPos, lastScan)
+
+		lastScan = scan - lenb
+		lastPos = pos - lenb
+		lastOffset = pos - scan
+	}
+}
+
+// search returns the length and position of the longest prefix of new found
+// in old, using the suffix array of old between st and en
+func search(sa []int32, old, new []byte, st, en int) (length, pos int) {
+	for en-st >= 2 {
+		x := st + (en-st)/2
+		suffix := old[sa[x]:]
+		if bytes.Compare(suffix[:min(len(suffix), len(new))], new[:min(len(suffix), len(new))]) < 0 {
+			st = x
+		} else {
+			en = x
+		}
+	}
+
+	x := matchLen(old[sa[st]:], new)
+	y := matchLen(old[sa[en]:], new)
+	if x > y {
+		return x, int(sa[st])
+	}
+	return y, int(sa[en])
+}
+
+func matchLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+// Apply reads a patch made by Diff and writes the new file to w. old is the
+// file the patch was made from. The result is checked against the checksum
+// in the patch as it is written, so on error w may hold a partial or wrong
+// file that should be discarded.
+func Apply(old io.ReaderAt, patch io.Reader, w io.Writer) error {
+	dec, err := zstd.NewReader(patch, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	r := bufio.NewReaderSize(dec, 64*1024)
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != magic {
+		return fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	newSize, err := readInt(r)
+	if err != nil || newSize < 0 {
+		return fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	var want [sha256.Size]byte
+	if _, err := io.ReadFull(r, want[:]); err != nil {
+		return fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	hash := sha256.New()
+	w = io.MultiWriter(w, hash)
+
+	var oldPos, written int64
+	buf := make([]byte, 64*1024)
+	oldBuf := make([]byte, len(buf))
+	for written < newSize {
+		var ctrl [3]int64
+		for i := range ctrl {
+			if ctrl[i], err = readInt(r); err != nil {
+				return fmt.Errorf("%w: truncated", ErrCorrupt)
+			}
+		}
+		add, copy, seek := ctrl[0], ctrl[1], ctrl[2]
+		if add < 0 || copy < 0 || written+add+copy > newSize {
+			return fmt.Errorf("%w: bad control data", ErrCorrupt)
+		}
+
+		// Bytes added to the old file
+		for add > 0 {
+			n := min(add, int64(len(buf)))
+			if _, err := io.ReadFull(r, buf[:n]); err != nil {
+				return fmt.Errorf("%w: truncated", ErrCorrupt)
+			}
+			if oldPos < 0 {
+				return fmt.Errorf("%w: bad control data", ErrCorrupt)
+			}
+			if m, err := old.ReadAt(oldBuf[:n], oldPos); int64(m) < n {
+				return fmt.Errorf("%w: old file too short: %v", ErrCorrupt, err)
+			}
+			for i := range n {
+				buf[i] += oldBuf[i]
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			add -= n
+			oldPos += n
+			written += n
+		}
+
+		// Bytes inserted verbatim
+		if _, err := io.CopyN(w, r, copy); err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("%w: truncated", ErrCorrupt)
+			}
+			return err
+		}
+		written += copy
+		oldPos += seek
+	}
+
+	if !bytes.Equal(hash.Sum(nil), want[:]) {
+		return fmt.Errorf("%w: result doesn't match, the old file differs from the one the patch was made from", ErrCorrupt)
+	}
+	return nil
+}
+
+// writeInt writes x in bsdiff's sign-magnitude format
+func writeInt(w io.Writer, x int64) {
+	var buf [8]byte
+	if x < 0 {
+		binary.LittleEndian.PutUint64(buf[:], uint64(-x))
+		buf[7] |= 0x80
+	} else {
+		binary.LittleEndian.PutUint64(buf[:], uint64(x))
+	}
+	w.Write(buf[:])
+}
+
+func readInt(r io.Reader) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	negative := buf[7]&0x80 != 0
+	buf[7] &^= 0x80
+	x := int64(binary.LittleEndian.Uint64(buf[:]))
+	if negative {
+		x = -x
+	}
+	return x, nil
+}
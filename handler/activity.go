@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// ActivityEvent is an event in the GET /events stream.
+type ActivityEvent struct {
+	Type     string    `json:"type"` // hit, miss, download_started, download_completed, download_failed, insert, evict or remove
+	Key      string    `json:"key"`
+	Size     int64     `json:"size,omitempty"`
+	Source   string    `json:"source,omitempty"`     // hits: memory, disk or chunked
+	Duration float64   `json:"durationMs,omitempty"` // finished downloads
+	Error    string    `json:"error,omitempty"`      // failed downloads
+	Time     time.Time `json:"time"`
+}
+
+// activityHub delivers request and download events to /events subscribers.
+// Events are dropped for subscribers that read too slowly rather than
+// holding up requests.
+type activityHub struct {
+	mu     sync.Mutex
+	subs   map[int]chan ActivityEvent
+	nextID int
+}
+
+func (a *activityHub) subscribe() (<-chan ActivityEvent, func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.subs == nil {
+		a.subs = make(map[int]chan ActivityEvent)
+	}
+	id := a.nextID
+	a.nextID++
+	ch := make(chan ActivityEvent, 256)
+	a.subs[id] = ch
+
+	return ch, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		delete(a.subs, id)
+	}
+}
+
+func (a *activityHub) publish(e ActivityEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(a.subs) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, ch := range a.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// sseHeartbeat is how often a comment is sent on an idle stream, so proxies
+// don't close it
+const sseHeartbeat = 15 * time.Second
+
+// HandleActivity streams hits, misses, downloads and cache changes as
+// server-sent events until the client disconnects: GET /events
+//
+// ?types=hit,miss limits the stream to those event types.
+func (h *Handler) HandleActivity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	var types map[string]bool
+	if value := r.URL.Query().Get("types"); value != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(value, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	events, unsubscribe := h.activity.subscribe()
+	defer unsubscribe()
+
+	// Cache changes come from the cache's own event bus
+	changes := make(chan cache.Event, 256)
+	unsubscribeCache := h.cache.Subscribe(func(e cache.Event) {
+		select {
+		case changes <- e:
+		default:
+		}
+	})
+	defer unsubscribeCache()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep nginx from buffering the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	var id int64
+	for {
+		var e ActivityEvent
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			continue
+		case e = <-events:
+		case c := <-changes:
+			e = ActivityEvent{Type: string(c.Type), Key: c.Key, Size: c.Size, Time: c.Time}
+		}
+		if types != nil && !types[e.Type] {
+			continue
+		}
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		id++
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, e.Type, data); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
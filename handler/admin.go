@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// RequireAdmin wraps an admin endpoint so it is only reachable with the
+// configured bearer token. When no token is configured, every request is
+// refused, until a reload sets one; listeners meant to be open use admin
+// access none instead.
+func (h *Handler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		token := h.settings.AdminToken
+		h.mu.RUnlock()
+
+		if token == "" {
+			writeError(w, http.StatusForbidden, "Admin token not configured")
+			return
+		}
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// HandleReload reloads runtime configuration: POST /admin/reload
+func (h *Handler) HandleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	h.mu.RLock()
+	reload := h.reload
+	h.mu.RUnlock()
+
+	if reload == nil {
+		writeError(w, http.StatusNotImplemented, "Reload not supported")
+		return
+	}
+
+	if err := reload(); err != nil {
+		h.auditRequest(w, r, AuditRecord{Action: "reload", Error: err.Error()})
+		logger.FromContext(r.Context()).Error().Emitf("Config reload failed: %v", err)
+		writeError(w, http.StatusBadRequest, "Reload failed: "+err.Error())
+		return
+	}
+	h.auditRequest(w, r, AuditRecord{Action: "reload"})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "reloaded",
+	})
+}
+
+// HandleResize changes the cache size limit at runtime:
+// POST /admin/cache/resize?maxSizeGB=N (or ?maxBytes=N)
+// Entries are evicted immediately if the cache is over the new limit.
+func (h *Handler) HandleResize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var maxBytes int64
+	query := r.URL.Query()
+	switch {
+	case query.Get("maxBytes") != "":
+		n, err := strconv.ParseInt(query.Get("maxBytes"), 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, "maxBytes must be a positive integer")
+			return
+		}
+		maxBytes = n
+	case query.Get("maxSizeGB") != "":
+		// Whole gigabytes, like the configuration, and no more than fit in
+		// an int64 of bytes
+		gb, err := strconv.ParseInt(query.Get("maxSizeGB"), 10, 64)
+		if err != nil || gb <= 0 || gb > math.MaxInt64>>30 {
+			writeError(w, http.StatusBadRequest, "maxSizeGB must be a positive integer")
+			return
+		}
+		maxBytes = gb * 1024 * 1024 * 1024
+	default:
+		writeError(w, http.StatusBadRequest, "maxSizeGB or maxBytes is required")
+		return
+	}
+
+	evicted := h.cache.Resize(maxBytes)
+	stats := h.cache.GetStats()
+	h.auditRequest(w, r, AuditRecord{Action: "resize", Detail: fmt.Sprintf("maxBytes %d, %d entries evicted", maxBytes, evicted)})
+
+	logger.FromContext(r.Context()).Info().Emitf("Cache resized to %d bytes (%d entries evicted)", maxBytes, evicted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"maxBytes":   stats.MaxBytes,
+		"totalBytes": stats.TotalBytes,
+		"evicted":    int64(evicted),
+	})
+}
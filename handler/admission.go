@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// downloadSlots limits how many S3 downloads run at once. Downloads beyond
+// the limit wait in a queue, and once the queue is full further requests are
+// turned away with 429 instead of piling up until the host runs out of file
+// descriptors.
+type downloadSlots struct {
+	mu       sync.Mutex
+	limit    int // 0 for unlimited
+	maxQueue int // requests allowed to wait for a slot
+	active   int
+	waiting  []*slotWaiter // in arrival order
+	avgHold  time.Duration // moving average of how long a download holds a slot
+	rejected atomic.Int64
+}
+
+type slotWaiter struct {
+	ready    chan struct{} // closed when the waiter is granted a slot
+	priority priority
+	acquired time.Time
+}
+
+// overloadedError is returned when the download queue is full
+type overloadedError struct {
+	retryAfter time.Duration
+}
+
+func (e *overloadedError) Error() string {
+	return "Too many downloads in progress, retry later"
+}
+
+// PriorityHeader sets how urgently a file request's S3 download is needed
+// when every download slot is taken: high, normal or low. The priority query
+// parameter does the same for clients that can't set headers.
+const PriorityHeader = "X-Midway-Priority"
+
+// priority orders downloads waiting for a slot; higher goes first
+type priority int
+
+const (
+	priorityLow priority = iota
+	priorityNormal
+	priorityHigh
+)
+
+func (p priority) String() string {
+	switch p {
+	case priorityHigh:
+		return "high"
+	case priorityLow:
+		return "low"
+	}
+	return "normal"
+}
+
+// parsePriority reads a priority name, "" meaning normal
+func parsePriority(name string) (priority, bool) {
+	switch strings.ToLower(name) {
+	case "high", "interactive":
+		return priorityHigh, true
+	case "", "normal":
+		return priorityNormal, true
+	case "low", "background":
+		return priorityLow, true
+	}
+	return 0, false
+}
+
+// requestPriority returns the priority a file request asked for
+func requestPriority(r *http.Request) (priority, bool) {
+	name := r.Header.Get(PriorityHeader)
+	if name == "" {
+		name = r.URL.Query().Get("priority")
+	}
+	return parsePriority(name)
+}
+
+// withRequestPriority returns r with the priority it asked for set on its
+// context, responding 400 and returning nil if it is not a known priority
+func withRequestPriority(w http.ResponseWriter, r *http.Request) *http.Request {
+	p, ok := requestPriority(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid priority, must be high, normal or low")
+		return nil
+	}
+	return r.WithContext(withPriority(r.Context(), p))
+}
+
+type priorityKey struct{}
+
+// withPriority sets the priority of downloads made with ctx
+func withPriority(ctx context.Context, p priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityOf(ctx context.Context) priority {
+	if p, ok := ctx.Value(priorityKey{}).(priority); ok {
+		return p
+	}
+	return priorityNormal
+}
+
+// backgroundKey marks contexts of prefetch and refresh jobs, which wait at
+// low priority for a slot however long the queue is instead of being turned
+// away
+type backgroundKey struct{}
+
+func withBackground(ctx context.Context) context.Context {
+	return context.WithValue(withPriority(ctx, priorityLow), backgroundKey{}, true)
+}
+
+func isBackground(ctx context.Context) bool {
+	background, _ := ctx.Value(backgroundKey{}).(bool)
+	return background
+}
+
+// defaultHold is assumed for a download before any has finished
+const defaultHold = 5 * time.Second
+
+// setLimits changes the limits, granting slots to waiters if the limit grew
+func (s *downloadSlots) setLimits(limit, maxQueue int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit, s.maxQueue = limit, maxQueue
+	s.grantLocked()
+}
+
+// acquire waits for a download slot and returns a func releasing it.
+// Waiters are served by priority, then in arrival order. When maxQueue
+// requests would be served first it returns an *overloadedError without
+// waiting, so lower priority waiters never cause higher ones to be turned
+// away.
+func (s *downloadSlots) acquire(ctx context.Context) (func(), error) {
+	p := priorityOf(ctx)
+
+	s.mu.Lock()
+	if s.limit <= 0 || (s.active < s.limit && len(s.waiting) == 0) {
+		s.active++
+		s.mu.Unlock()
+		return s.releaser(time.Now()), nil
+	}
+	ahead := s.aheadLocked(p)
+	if ahead >= s.maxQueue && !isBackground(ctx) {
+		retryAfter := s.retryAfterLocked(ahead)
+		s.mu.Unlock()
+		s.rejected.Add(1)
+		return nil, &overloadedError{retryAfter: retryAfter}
+	}
+	waiter := &slotWaiter{ready: make(chan struct{}), priority: p}
+	s.waiting = slices.Insert(s.waiting, ahead, waiter)
+	s.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return s.releaser(waiter.acquired), nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-waiter.ready:
+		// Granted a slot just as the context ended; hand it on
+		s.active--
+		s.grantLocked()
+	default:
+		for i, w := range s.waiting {
+			if w == waiter {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil, ctx.Err()
+}
+
+func (s *downloadSlots) releaser(acquired time.Time) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			held := time.Since(acquired)
+			if s.avgHold == 0 {
+				s.avgHold = held
+			} else {
+				s.avgHold = (s.avgHold*7 + held) / 8
+			}
+			s.active--
+			s.grantLocked()
+		})
+	}
+}
+
+// aheadLocked returns how many waiters would be served before one with
+// priority p, which is also where it goes in the queue
+func (s *downloadSlots) aheadLocked(p priority) int {
+	for i, w := range s.waiting {
+		if w.priority < p {
+			return i
+		}
+	}
+	return len(s.waiting)
+}
+
+// grantLocked hands free slots to waiters at the front of the queue
+func (s *downloadSlots) grantLocked() {
+	for len(s.waiting) > 0 && (s.limit <= 0 || s.active < s.limit) {
+		waiter := s.waiting[0]
+		s.waiting = s.waiting[1:]
+		s.active++
+		waiter.acquired = time.Now()
+		close(waiter.ready)
+	}
+}
+
+// retryAfterLocked estimates when a slot will be free for a new request
+// behind ahead waiters, which drain limit downloads per average download time
+func (s *downloadSlots) retryAfterLocked(ahead int) time.Duration {
+	hold := s.avgHold
+	if hold == 0 {
+		hold = defaultHold
+	}
+	rounds := math.Ceil(float64(ahead+1) / float64(max(s.limit, 1)))
+	return min(max(time.Duration(rounds)*hold, time.Second), 5*time.Minute)
+}
+
+// depth returns the number of downloads running and waiting for a slot
+func (s *downloadSlots) depth() (active, queued int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, len(s.waiting)
+}
+
+// acquireSlot waits for a download slot, returning the HTTP status to
+// respond with when none can be had
+func (h *Handler) acquireSlot(ctx context.Context) (func(), int, error) {
+	release, err := h.slots.acquire(ctx)
+	var overloaded *overloadedError
+	switch {
+	case errors.As(err, &overloaded):
+		return nil, http.StatusTooManyRequests, err
+	case err != nil:
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("gave up waiting for a download slot: %w", err)
+	}
+	return release, 0, nil
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// Alert is an alert threshold the cache is currently past.
+type Alert struct {
+	Name      string    `json:"name"` // hit_rate or disk
+	Message   string    `json:"message"`
+	Value     float64   `json:"value"`     // percent
+	Threshold float64   `json:"threshold"` // percent
+	Since     time.Time `json:"since"`
+}
+
+// Alert names
+const (
+	AlertHitRate = "hit_rate"
+	AlertDisk    = "disk"
+)
+
+// alertCheckInterval is how often thresholds are checked
+const alertCheckInterval = time.Minute
+
+// alertMinRequests is how many file requests a window needs before its hit
+// rate is judged, so an idle cache doesn't alert on a handful of misses
+const alertMinRequests = 20
+
+// alertState holds the alerts currently firing and the hit counts the hit
+// rate window is measured from
+type alertState struct {
+	mu      sync.Mutex
+	samples []hitSample
+	firing  map[string]Alert
+}
+
+// hitSample is the cache's hit and miss counts at one check
+type hitSample struct {
+	time         time.Time
+	hits, misses int64
+}
+
+// RunAlerts checks the alert thresholds every minute until ctx is done.
+// Crossing one logs a warning, marks the node degraded on /readyz and, with
+// a webhook, posts the alert to it; so does recovering.
+func (h *Handler) RunAlerts(ctx context.Context) {
+	ticker := time.NewTicker(alertCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.checkAlerts(now)
+		}
+	}
+}
+
+// checkAlerts compares the cache against the alert thresholds once
+func (h *Handler) checkAlerts(now time.Time) {
+	h.mu.RLock()
+	s := h.settings
+	h.mu.RUnlock()
+	stats := h.cache.GetStats()
+
+	h.alerts.mu.Lock()
+	defer h.alerts.mu.Unlock()
+
+	// Keep the newest sample at or before the start of the window as the
+	// baseline, and judge the hit rate once the window is covered
+	h.alerts.samples = append(h.alerts.samples, hitSample{time: now, hits: stats.Hits, misses: stats.Misses})
+	start := now.Add(-s.AlertHitRateWindow)
+	for len(h.alerts.samples) > 1 && !h.alerts.samples[1].time.After(start) {
+		h.alerts.samples = h.alerts.samples[1:]
+	}
+	if s.AlertMinHitRate <= 0 {
+		h.resolveAlert(AlertHitRate, s.AlertWebhook)
+	} else if base := h.alerts.samples[0]; !base.time.After(start) {
+		hits, misses := stats.Hits-base.hits, stats.Misses-base.misses
+		if hits+misses >= alertMinRequests {
+			rate := float64(hits) / float64(hits+misses) * 100
+			if rate < s.AlertMinHitRate {
+				h.fireAlert(Alert{
+					Name:      AlertHitRate,
+					Message:   fmt.Sprintf("hit rate %.1f%% over the last %v is below %.1f%%", rate, s.AlertHitRateWindow, s.AlertMinHitRate),
+					Value:     rate,
+					Threshold: s.AlertMinHitRate,
+				}, s.AlertWebhook)
+			} else {
+				h.resolveAlert(AlertHitRate, s.AlertWebhook)
+			}
+		}
+	}
+
+	if s.AlertMaxDiskPercent <= 0 {
+		h.resolveAlert(AlertDisk, s.AlertWebhook)
+	} else if used, err := diskUsedPercent(stats.CacheDir); err != nil {
+		logger.Warn().Emitf("Failed to check disk usage of %s: %v", stats.CacheDir, err)
+	} else if used > s.AlertMaxDiskPercent {
+		h.fireAlert(Alert{
+			Name:      AlertDisk,
+			Message:   fmt.Sprintf("disk holding %s is %.1f%% full, above %.1f%%", stats.CacheDir, used, s.AlertMaxDiskPercent),
+			Value:     used,
+			Threshold: s.AlertMaxDiskPercent,
+		}, s.AlertWebhook)
+	} else {
+		h.resolveAlert(AlertDisk, s.AlertWebhook)
+	}
+}
+
+// fireAlert records alert as firing, notifying if it wasn't already (must be
+// called with the alerts lock held)
+func (h *Handler) fireAlert(alert Alert, webhook string) {
+	if h.alerts.firing == nil {
+		h.alerts.firing = make(map[string]Alert)
+	}
+	previous, ok := h.alerts.firing[alert.Name]
+	if ok {
+		alert.Since = previous.Since
+		h.alerts.firing[alert.Name] = alert
+		return
+	}
+	alert.Since = time.Now().UTC()
+	h.alerts.firing[alert.Name] = alert
+
+	logger.Warn().Emitf("Alert %s: %s", alert.Name, alert.Message)
+	notifyAlert(webhook, "firing", alert)
+}
+
+// resolveAlert clears the alert name, notifying if it was firing (must be
+// called with the alerts lock held)
+func (h *Handler) resolveAlert(name, webhook string) {
+	alert, ok := h.alerts.firing[name]
+	if !ok {
+		return
+	}
+	delete(h.alerts.firing, name)
+
+	logger.Info().Emitf("Alert %s resolved after %v", name, time.Since(alert.Since).Round(time.Second))
+	notifyAlert(webhook, "resolved", alert)
+}
+
+// FiringAlerts returns the alerts currently firing, by name.
+func (h *Handler) FiringAlerts() []Alert {
+	h.alerts.mu.Lock()
+	defer h.alerts.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(h.alerts.firing))
+	for _, alert := range h.alerts.firing {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Name < alerts[j].Name
+	})
+	return alerts
+}
+
+// HandleReady reports whether the node is within its alert thresholds:
+// GET /readyz. It answers 503 with the firing alerts while it is degraded.
+func (h *Handler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	alerts := h.FiringAlerts()
+	status := "ready"
+	w.Header().Set("Content-Type", "application/json")
+	if len(alerts) > 0 {
+		status = "degraded"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": status,
+		"alerts": alerts,
+	})
+}
+
+// alertNotification is the body posted to the alert webhook
+type alertNotification struct {
+	Status   string `json:"status"` // firing or resolved
+	Instance string `json:"instance"`
+	Alert
+}
+
+// alertClient posts alert notifications
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifyAlert posts alert to webhook in the background, if one is set
+func notifyAlert(webhook, status string, alert Alert) {
+	if webhook == "" {
+		return
+	}
+	instance, _ := os.Hostname()
+	body, err := json.Marshal(alertNotification{Status: status, Instance: instance, Alert: alert})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		resp, err := alertClient.Post(webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			// The URL in the error may hold the webhook's secret
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			logger.Warn().Emitf("Failed to send alert %s to webhook: %v", alert.Name, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logger.Warn().Emitf("Alert webhook answered %s for %s", resp.Status, alert.Name)
+		}
+	}()
+}
+
+// diskUsedPercent returns how full the filesystem holding dir is, as df
+// reports it
+func diskUsedPercent(dir string) (float64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err != nil {
+		return 0, err
+	}
+	used := fs.Blocks - fs.Bfree
+	if used+fs.Bavail == 0 {
+		return 0, nil
+	}
+	return float64(used) / float64(used+fs.Bavail) * 100, nil
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/archive"
+	"github.com/autonoma-ai/midway/bufpool"
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// maxArchiveIndexes bounds how many archive indexes are kept in memory
+const maxArchiveIndexes = 256
+
+// archiveIndexes remembers the indexes of archives members were served
+// from, so later requests don't read the archive's directory again
+type archiveIndexes struct {
+	mu      sync.Mutex
+	entries map[string]*archive.Index // key and version of the cached archive -> index
+}
+
+func (a *archiveIndexes) get(id string) (*archive.Index, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	index, ok := a.entries[id]
+	return index, ok
+}
+
+func (a *archiveIndexes) put(id string, index *archive.Index) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.entries == nil {
+		a.entries = make(map[string]*archive.Index)
+	}
+	if len(a.entries) >= maxArchiveIndexes {
+		// Drop an arbitrary index; it is rebuilt if needed again
+		for other := range a.entries {
+			delete(a.entries, other)
+			break
+		}
+	}
+	a.entries[id] = index
+}
+
+// serveArchiveMember serves one file from within an archive, or lists the
+// archive's files if member is empty: GET /{bucket}/{key...}!/{member...}
+func (h *Handler) serveArchiveMember(w http.ResponseWriter, r *http.Request, key, member string) {
+	log := logger.FromContext(r.Context())
+	startTime := time.Now()
+
+	objectPath, _ := cache.SplitVersion(key)
+	format, ok := archive.FormatOf(objectPath)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Not a supported archive (zip, apk, ipa, jar, tar, tar.gz)")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	file, status, err := h.openCached(ctx, key)
+	if errors.Is(err, cache.ErrArchived) {
+		h.serveArchived(w, r, key, err)
+		return
+	}
+	if err != nil {
+		log.Error().Emitf("Failed to open archive %s: %v", key, err)
+		writeFetchError(w, key, err, status)
+		return
+	}
+	defer file.close()
+
+	id := key + "@" + file.version
+	index, ok := h.archives.get(id)
+	if !ok {
+		index, err = archive.ReadIndex(file, file.size, format)
+		if err != nil {
+			log.Warn().Emitf("Failed to index archive %s: %v", key, err)
+			writeError(w, http.StatusUnprocessableEntity, "Not a valid archive: "+err.Error())
+			return
+		}
+		h.archives.put(id, index)
+	}
+
+	if member == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(index.Members())
+		return
+	}
+
+	m, ok := index.Lookup(member)
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such file in archive")
+		return
+	}
+	contents, err := index.Open(file, m)
+	if err != nil {
+		log.Error().Emitf("Failed to read %s from %s: %v", m.Name, key, err)
+		writeError(w, http.StatusInternalServerError, "Failed to read file from archive")
+		return
+	}
+
+	// Uncompressed members support range requests
+	w.Header().Set("Content-Type", resolveContentType("", m.Name))
+	h.setCacheHeaders(w, key)
+	if rs, ok := contents.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(m.Name), m.Modified, rs)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(m.Size, 10))
+		if !m.Modified.IsZero() {
+			w.Header().Set("Last-Modified", m.Modified.UTC().Format(http.TimeFormat))
+		}
+		if _, err := bufpool.Copy(w, contents); err != nil {
+			log.Warn().Emitf("Failed to serve %s from %s: %v", m.Name, key, err)
+			return
+		}
+	}
+	log.Info().Emitf("Served %s from %s in %v", m.Name, key, time.Since(startTime))
+}
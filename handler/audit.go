@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// AuditRecord is an admin action in the audit log, GET /admin/audit.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`             // purge, untrash, pin, unpin, prefetch, preload, reload or resize
+	Actor     string    `json:"actor"`              // client certificate CN, admin token fingerprint, or anonymous
+	ClientIP  string    `json:"clientIp,omitempty"` // from X-Forwarded-For with TRUST_PROXY, otherwise the remote address
+	RemoteIP  string    `json:"remoteIp,omitempty"` // address of the connection the request came in on
+	RequestID string    `json:"requestId,omitempty"`
+	Keys      []string  `json:"keys,omitempty"`
+	Prefix    string    `json:"prefix,omitempty"`
+	Detail    string    `json:"detail,omitempty"` // e.g. how many entries were affected
+	Error     string    `json:"error,omitempty"`  // set when the action failed
+}
+
+// auditLog records admin actions. Records are appended to a file when one is
+// configured, and the most recent are also kept in memory.
+type auditLog struct {
+	mu     sync.Mutex
+	file   *os.File
+	path   string
+	recent []AuditRecord
+}
+
+// auditRecent is how many records are kept in memory
+const auditRecent = 1000
+
+// OpenAuditLog appends audit records to the file at path, creating it if
+// needed. Without it, only recent records are kept, in memory.
+func (h *Handler) OpenAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	h.audit.mu.Lock()
+	defer h.audit.mu.Unlock()
+	h.audit.file, h.audit.path = file, path
+	return nil
+}
+
+// Audit records an admin action. Actions not made through an HTTP request,
+// such as a reload on SIGHUP, are recorded with it directly.
+func (h *Handler) Audit(record AuditRecord) {
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+
+	h.audit.mu.Lock()
+	defer h.audit.mu.Unlock()
+
+	h.audit.recent = append(h.audit.recent, record)
+	if len(h.audit.recent) > auditRecent {
+		h.audit.recent = h.audit.recent[len(h.audit.recent)-auditRecent:]
+	}
+
+	if h.audit.file == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	if _, err := h.audit.file.Write(append(line, '\n')); err != nil {
+		logger.Error().Emitf("Failed to write audit record for %s by %s: %v", record.Action, record.Actor, err)
+	}
+}
+
+// auditRequest records an admin action made by the request r
+func (h *Handler) auditRequest(w http.ResponseWriter, r *http.Request, record AuditRecord) {
+	record.Actor = auditActor(r)
+	record.ClientIP = clientIP(r, h.trustProxy())
+	record.RemoteIP = remoteHost(r)
+	record.RequestID = w.Header().Get(RequestIDHeader)
+	h.Audit(record)
+}
+
+// auditActor identifies who made an admin request: the common name of its
+// TLS client certificate, or a fingerprint of the bearer token it presented,
+// so the log never holds the token itself
+func auditActor(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return "cn:" + r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:4])
+	}
+	return "anonymous"
+}
+
+// HandleAudit lists recorded admin actions, oldest first:
+// GET /admin/audit?action=purge&limit=N
+//
+// With an audit log file, records are read from it and so survive restarts.
+func (h *Handler) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	action := r.URL.Query().Get("action")
+	limit := 100
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+
+	records, err := h.auditRecords()
+	if err != nil {
+		logger.FromContext(r.Context()).Error().Emitf("Failed to read audit log: %v", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	matched := []AuditRecord{}
+	for _, record := range records {
+		if action == "" || record.Action == action {
+			matched = append(matched, record)
+		}
+	}
+	// Keep the most recent when limited
+	if limit > 0 && len(matched) > limit {
+		matched = matched[len(matched)-limit:]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matched)
+}
+
+// auditRecords returns every record in the audit log file, or the recent
+// records in memory without one
+func (h *Handler) auditRecords() ([]AuditRecord, error) {
+	h.audit.mu.Lock()
+	path := h.audit.path
+	if path == "" {
+		records := append([]AuditRecord(nil), h.audit.recent...)
+		h.audit.mu.Unlock()
+		return records, nil
+	}
+	h.audit.mu.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // a line cut short by a crash
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// CacheHeaderRule sets the caching headers of file responses for keys
+// starting with Prefix, so CDNs and devices can keep immutable artifacts
+// for long and revalidate mutable ones.
+type CacheHeaderRule struct {
+	Prefix                string        // bucket/path; "" matches every key
+	CacheControl          string        // "" for no Cache-Control header
+	VersionedCacheControl string        // for keys pinned to an S3 version, "" to use CacheControl
+	Expires               time.Duration // Expires this long after the response, 0 for none
+}
+
+// setCacheHeaders sets the Cache-Control and Expires headers of the first
+// rule matching key, if any
+func (h *Handler) setCacheHeaders(w http.ResponseWriter, key string) {
+	h.mu.RLock()
+	rules := h.settings.CacheHeaders
+	h.mu.RUnlock()
+	if len(rules) == 0 {
+		return
+	}
+
+	// Rules refer to objects, whichever namespace caches them, and apply to
+	// entries derived from them too
+	key, _ = cache.SplitDerived(key)
+	_, key = cache.SplitNamespace(key)
+	objectPath, versionID := cache.SplitVersion(key)
+	for _, rule := range rules {
+		if !strings.HasPrefix(objectPath, rule.Prefix) {
+			continue
+		}
+		cacheControl := rule.CacheControl
+		if versionID != "" && rule.VersionedCacheControl != "" {
+			cacheControl = rule.VersionedCacheControl
+		}
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		if rule.Expires > 0 {
+			w.Header().Set("Expires", time.Now().Add(rule.Expires).UTC().Format(http.TimeFormat))
+		}
+		return
+	}
+}
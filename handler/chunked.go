@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// chunkSettings returns the chunk size and the object size above which
+// objects are cached in chunks, or zeros if chunked mode is disabled
+func (h *Handler) chunkSettings() (threshold, chunkSize int64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.settings.ChunkThreshold <= 0 || h.settings.ChunkSize <= 0 {
+		return 0, 0
+	}
+	return h.settings.ChunkThreshold, h.settings.ChunkSize
+}
+
+// maxChunkedObjects bounds how many objects served in chunks are remembered
+const maxChunkedObjects = 10000
+
+// chunkedObjects remembers the metadata of objects served in chunks, so they
+// aren't looked up in S3 on every request. The least recently stored are
+// forgotten beyond maxChunkedObjects.
+type chunkedObjects struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // key -> element of order
+	order   list.List                // chunkedObject, most recently stored first
+}
+
+type chunkedObject struct {
+	key  string
+	info cache.ObjectInfo
+}
+
+func (c *chunkedObjects) load(key string) (cache.ObjectInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		return e.Value.(chunkedObject).info, true
+	}
+	return cache.ObjectInfo{}, false
+}
+
+func (c *chunkedObjects) store(key string, info cache.ObjectInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[key] = c.order.PushFront(chunkedObject{key, info})
+	for c.order.Len() > maxChunkedObjects {
+		oldest := c.order.Remove(c.order.Back()).(chunkedObject)
+		delete(c.entries, oldest.key)
+	}
+}
+
+// forget drops the remembered metadata of every key matching match
+func (c *chunkedObjects) forget(match func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if match(key) {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// forgetChunked makes the next request for key look up the object in S3
+// again, if it was served in chunks
+func (h *Handler) forgetChunked(key string) {
+	h.chunked.forget(func(k string) bool { return k == key })
+}
+
+// chunkedEvent forgets an object once any of its chunks leaves the cache,
+// evicted or purged, so its size and ETag are looked up again
+func (h *Handler) chunkedEvent(e cache.Event) {
+	if e.Type == cache.EventInsert {
+		return
+	}
+	if key, ok := cache.ChunkObject(e.Key); ok {
+		h.forgetChunked(key)
+	}
+}
+
+// chunkedInfo returns the remembered metadata of an object served in chunks
+func (h *Handler) chunkedInfo(key string) (cache.ObjectInfo, bool) {
+	if threshold, _ := h.chunkSettings(); threshold == 0 {
+		return cache.ObjectInfo{}, false
+	}
+	return h.chunked.load(key)
+}
+
+// openChunked opens an object cached in chunks, forgetting what is known of
+// it if S3 reports it changed while it is read
+func (h *Handler) openChunked(ctx context.Context, key string, info cache.ObjectInfo) io.ReadSeekCloser {
+	_, chunkSize := h.chunkSettings()
+	return &chunkedReader{ChunkedObject: h.cache.OpenChunked(ctx, h.downloader, key, info, chunkSize), h: h, key: key}
+}
+
+type chunkedReader struct {
+	*cache.ChunkedObject
+	h   *Handler
+	key string
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	n, err := c.ChunkedObject.Read(p)
+	if errors.Is(err, cache.ErrObjectChanged) {
+		c.h.forgetChunked(c.key)
+	}
+	return n, err
+}
+
+// serveChunked streams an object cached as fixed-size ranges. Range requests
+// from the client only fetch the chunks they cover.
+func (h *Handler) serveChunked(w http.ResponseWriter, r *http.Request, key string, info cache.ObjectInfo) {
+	obj := h.openChunked(r.Context(), key, info)
+	defer obj.Close()
+
+	// Set the type up front so ServeContent doesn't read the first chunk to sniff it
+	w.Header().Set("Content-Type", resolveContentType(info.ContentType, baseName(key)))
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	h.setCacheHeaders(w, key)
+
+	reader := &errRecorder{ReadSeeker: obj}
+	http.ServeContent(w, r, baseName(key), info.LastModified, reader)
+
+	if reader.err != nil {
+		logger.FromContext(r.Context()).Error().Emitf("Failed to serve chunked %s: %v", key, reader.err)
+	}
+}
+
+// errRecorder remembers the first read error, which http.ServeContent
+// otherwise swallows
+type errRecorder struct {
+	io.ReadSeeker
+	err error
+}
+
+func (e *errRecorder) Read(p []byte) (int, error) {
+	n, err := e.ReadSeeker.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && e.err == nil {
+		e.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/autonoma-ai/midway/bufpool"
+)
+
+// minCompressSize is the smallest response worth compressing; below it the
+// encoding overhead outweighs the savings
+const minCompressSize = 1024
+
+var (
+	gzipWriters  = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression); return w }}
+	flateWriters = sync.Pool{New: func() any { w, _ := flate.NewWriter(nil, flate.DefaultCompression); return w }}
+)
+
+// compressibleTypes are content types compressed in responses, besides
+// text/* and types with a +json or +xml suffix
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/yaml":       true,
+	"application/x-yaml":     true,
+	"application/x-tar":      true,
+	"application/wasm":       true,
+	"image/svg+xml":          true,
+}
+
+// compressible reports whether responses of contentType are worth
+// compressing. Binaries that are already compressed, like APKs, archives
+// and images, are not.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip, or returns "" if the client accepts neither
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			q, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		}
+		accepted[coding] = q > 0
+	}
+	for _, coding := range []string{"gzip", "deflate"} {
+		if accepted[coding] {
+			return coding
+		}
+	}
+	return ""
+}
+
+// CompressResponses wraps an endpoint so compressible responses are sent
+// gzip or deflate encoded to clients that accept it. Range requests are
+// served as is, because ranges refer to the unencoded file. When response
+// compression is disabled, requests pass through untouched.
+func (h *Handler) CompressResponses(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		enabled := h.settings.CompressResponses
+		h.mu.RUnlock()
+
+		if !enabled || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next(w, r)
+			return
+		}
+
+		cw := &encodingWriter{ResponseWriter: w, encoding: negotiateEncoding(r.Header.Get("Accept-Encoding"))}
+		defer cw.Close()
+		next(cw, r)
+	}
+}
+
+// encodingWriter decides when the status is written whether to compress
+// the response, based on its type and size
+type encodingWriter struct {
+	http.ResponseWriter
+	encoding    string // negotiated with the client, "" for none
+	wroteHeader bool
+	w           io.WriteCloser // nil unless compressing
+}
+
+func (c *encodingWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+
+	header := c.Header()
+	if status == http.StatusOK && header.Get("Content-Encoding") == "" && compressible(header.Get("Content-Type")) {
+		// Caches must keep encoded and unencoded responses apart
+		header.Add("Vary", "Accept-Encoding")
+
+		size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if c.encoding != "" && (err != nil || size >= minCompressSize) {
+			c.start()
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// start switches the response to the negotiated encoding
+func (c *encodingWriter) start() {
+	header := c.Header()
+	header.Set("Content-Encoding", c.encoding)
+	header.Del("Content-Length")
+	// The encoded bytes differ from the file's, so its strong ETag becomes
+	// weak. If-None-Match compares weakly, so 304 responses still work.
+	if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	switch c.encoding {
+	case "gzip":
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(c.ResponseWriter)
+		c.w = gz
+	case "deflate":
+		fl := flateWriters.Get().(*flate.Writer)
+		fl.Reset(c.ResponseWriter)
+		c.w = fl
+	}
+}
+
+func (c *encodingWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		// Set the type now, as the server would, so it can be checked
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.w == nil {
+		return c.ResponseWriter.Write(p)
+	}
+	return c.w.Write(p)
+}
+
+// ReadFrom passes responses that aren't compressed to the underlying writer,
+// so cached files are still sent with sendfile rather than copied through
+// memory.
+func (c *encodingWriter) ReadFrom(src io.Reader) (int64, error) {
+	if c.wroteHeader && c.w == nil {
+		if rf, ok := c.ResponseWriter.(io.ReaderFrom); ok {
+			return rf.ReadFrom(src)
+		}
+	}
+	return bufpool.Copy(c, src)
+}
+
+// Flush sends buffered compressed data to the client.
+func (c *encodingWriter) Flush() {
+	if f, ok := c.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (c *encodingWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// Close finishes the encoded stream and returns its writer to the pool.
+func (c *encodingWriter) Close() error {
+	if c.w == nil {
+		return nil
+	}
+	err := c.w.Close()
+	switch w := c.w.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(w)
+	case *flate.Writer:
+		flateWriters.Put(w)
+	}
+	c.w = nil
+	return err
+}
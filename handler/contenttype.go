@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// contentTypes maps extensions the mime package doesn't know, or gets wrong
+// for mobile artifacts, to their content types. Mapping opaque formats to
+// application/octet-stream explicitly keeps them from being sniffed as text.
+var contentTypes = map[string]string{
+	".apk":   "application/vnd.android.package-archive",
+	".aab":   "application/x-android-app-bundle",
+	".apks":  "application/zip",
+	".ipa":   "application/x-ios-app",
+	".obb":   "application/octet-stream",
+	".plist": "application/xml",
+	".dmg":   "application/x-apple-diskimage",
+}
+
+// genericContentTypes say nothing about an object's format; S3 assigns
+// binary/octet-stream to objects uploaded without a type
+var genericContentTypes = map[string]bool{
+	"":                         true,
+	"binary/octet-stream":      true,
+	"application/octet-stream": true,
+}
+
+// resolveContentType returns the content type to serve a file named name
+// with: stored, the type the object was uploaded with, unless it is generic,
+// then the type of its extension, then application/octet-stream.
+func resolveContentType(stored, name string) string {
+	if !genericContentTypes[strings.ToLower(stored)] {
+		return stored
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if contentType, ok := contentTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
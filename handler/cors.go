@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// corsExposedHeaders are the response headers browser scripts may read in
+// cross-origin responses, beyond the few always exposed
+var corsExposedHeaders = strings.Join([]string{
+	"Content-Length", "Content-Range", "Content-Disposition", "Content-Location",
+	"Accept-Ranges", "ETag", "Retry-After", RequestIDHeader, "X-Delta-From", ContentSHA256Header,
+}, ", ")
+
+// AllowCORS wraps an endpoint so browsers on the configured origins can call
+// it, answering preflight requests itself. When no origins are configured,
+// requests pass through untouched.
+func (h *Handler) AllowCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		origins := h.settings.CORSOrigins
+		methods := h.settings.CORSMethods
+		headers := h.settings.CORSHeaders
+		maxAge := h.settings.CORSMaxAge
+		h.mu.RUnlock()
+
+		if len(origins) == 0 {
+			next(w, r)
+			return
+		}
+
+		// The response depends on the origin unless every origin is allowed
+		anyOrigin := slices.Contains(origins, "*")
+		if !anyOrigin {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && (anyOrigin || slices.Contains(origins, origin))
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if allowed {
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+
+		if preflight {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				if len(headers) > 0 {
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+				}
+				if maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+				}
+			}
+			// Disallowed origins get no CORS headers, which the browser
+			// treats as a refusal
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+		next(w, r)
+	}
+}
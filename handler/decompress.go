@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/bufpool"
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressors open a decompressing reader for each supported extension
+var decompressors = map[string]func(io.Reader) (io.ReadCloser, error){
+	".gz": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	".zst": func(r io.Reader) (io.ReadCloser, error) {
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	},
+}
+
+// maxDecompressionRatio bounds the decompressed form of an object that is
+// cached, as a multiple of its compressed size, so a small compression bomb
+// can't fill the cache. Logs and dumps rarely compress beyond 20:1.
+const maxDecompressionRatio = 100
+
+// errDecompressedTooLarge means an object decompresses to more than
+// maxDecompressionRatio times its size
+var errDecompressedTooLarge = errors.New("decompressed size exceeds the limit")
+
+// boundedReader reads from r, failing with errDecompressedTooLarge once
+// more than n bytes have been read
+type boundedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.n -= int64(n)
+	if b.n < 0 {
+		return n, errDecompressedTooLarge
+	}
+	return n, err
+}
+
+// decompressedKey returns the cache key under which the decompressed form of
+// key is stored
+func decompressedKey(key string) string {
+	return cache.DerivedKey(key, ".decompressed")
+}
+
+func (h *Handler) cacheDecompressed() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.settings.CacheDecompressed
+}
+
+// serveDecompressed serves a gzip or zstd compressed object decompressed:
+// GET /{bucket}/{key...}.gz?decompress=1
+func (h *Handler) serveDecompressed(w http.ResponseWriter, r *http.Request, key string) {
+	log := logger.FromContext(r.Context())
+	startTime := time.Now()
+
+	name := baseName(key)
+	ext := strings.ToLower(path.Ext(name))
+	open, ok := decompressors[ext]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Only .gz and .zst objects can be decompressed")
+		return
+	}
+
+	// The type of the decompressed file, e.g. app.log.gz is text/plain
+	name = strings.TrimSuffix(name, path.Ext(name))
+	w.Header().Set("Content-Type", resolveContentType("", name))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	// Verified objects are only cached in the form their signature covers
+	if h.cacheDecompressed() && !h.cache.Verifies(key) {
+		dkey := decompressedKey(key)
+		filePath, found := h.cache.Get(dkey)
+		if !found {
+			var (
+				status int
+				err    error
+			)
+			filePath, status, err = h.downloadDecompressed(ctx, key, dkey, open)
+			if err != nil {
+				log.Error().Emitf("Failed to fetch %s: %v", key, err)
+				writeFetchError(w, key, err, status)
+				return
+			}
+		}
+		h.serveFile(w, r, dkey, filePath)
+		log.Info().Emitf("Served %s decompressed in %v", key, time.Since(startTime))
+		return
+	}
+
+	obj, status, err := h.openCached(ctx, key)
+	if errors.Is(err, cache.ErrArchived) {
+		h.serveArchived(w, r, key, err)
+		return
+	}
+	if err != nil {
+		log.Error().Emitf("Failed to fetch %s: %v", key, err)
+		writeFetchError(w, key, err, status)
+		return
+	}
+	defer obj.close()
+
+	decompressor, err := open(io.NewSectionReader(obj, 0, obj.size))
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid compressed data: "+err.Error())
+		return
+	}
+	defer decompressor.Close()
+
+	// Catch data that is corrupt from the start while an error can still be
+	// sent
+	reader := bufio.NewReader(decompressor)
+	if _, err := reader.Peek(1); err != nil && err != io.EOF {
+		writeError(w, http.StatusUnprocessableEntity, "Invalid compressed data: "+err.Error())
+		return
+	}
+
+	// The decompressed size isn't known up front, so the response is
+	// streamed without Content-Length or range support
+	if !obj.modTime.IsZero() {
+		w.Header().Set("Last-Modified", obj.modTime.UTC().Format(http.TimeFormat))
+	}
+	h.setCacheHeaders(w, key)
+	if _, err := bufpool.Copy(w, reader); err != nil {
+		log.Warn().Emitf("Failed to serve %s decompressed: %v", key, err)
+		return
+	}
+	log.Info().Emitf("Served %s decompressed in %v", key, time.Since(startTime))
+}
+
+// downloadDecompressed fetches key from S3 and caches only its decompressed
+// form, as dkey
+func (h *Handler) downloadDecompressed(ctx context.Context, key, dkey string, open func(io.Reader) (io.ReadCloser, error)) (filePath string, status int, err error) {
+	release, status, err := h.acquireSlot(ctx)
+	if err != nil {
+		return "", status, err
+	}
+	defer release()
+
+	ctx, dl := h.startDownload(ctx, key)
+	defer func() { h.finishDownload(dl, err) }()
+
+	body, size, err := h.downloader.Download(ctx, key)
+	if err != nil {
+		return "", downloadStatus(err), fmt.Errorf("failed to download: %w", err)
+	}
+	defer body.Close()
+
+	reader, err := open(dl.wrap(body, 0, size))
+	if err != nil {
+		return "", http.StatusUnprocessableEntity, fmt.Errorf("invalid compressed data: %w", err)
+	}
+	defer reader.Close()
+
+	// A stream that turns out corrupt partway through fails here too
+	filePath, err = h.cache.PutDerived(ctx, dkey, &boundedReader{r: reader, n: size * maxDecompressionRatio})
+	if errors.Is(err, errDecompressedTooLarge) {
+		return "", http.StatusUnprocessableEntity, fmt.Errorf("failed to decompress %s: %w, %d times its compressed size", key, err, maxDecompressionRatio)
+	}
+	if err != nil {
+		return "", http.StatusBadGateway, fmt.Errorf("failed to decompress %s: %w", key, err)
+	}
+	return filePath, 0, nil
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/delta"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// serveDelta responds with a patch turning a cached previous version of an
+// artifact into the requested one, generating and caching it on first use:
+// GET /{bucket}/{key...}?deltaFrom={path}[&deltaFromVersionId={id}]
+func (h *Handler) serveDelta(w http.ResponseWriter, r *http.Request, key string) {
+	log := logger.FromContext(r.Context())
+
+	maxSize := h.deltaMaxSize()
+	if maxSize == 0 {
+		writeError(w, http.StatusNotFound, "Delta patches are disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	ns, objectKey := cache.SplitNamespace(key)
+	bucket, _, _ := strings.Cut(objectKey, "/")
+	fromPath := strings.TrimPrefix(query.Get("deltaFrom"), "/")
+	if fromPath == "" {
+		writeError(w, http.StatusBadRequest, "deltaFrom must be a path in the same bucket")
+		return
+	}
+	fromKey := cache.NamespacedKey(ns, cache.VersionedKey(bucket+"/"+fromPath, query.Get("deltaFromVersionId")))
+	if fromKey == key {
+		writeError(w, http.StatusBadRequest, "deltaFrom must differ from the requested object")
+		return
+	}
+
+	// Patches are only made from versions devices have downloaded through
+	// this cache, so the base is always available locally
+	from, ok := h.cache.Peek(fromKey)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Previous version not cached")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	if !h.cache.Contains(key) {
+		if _, status, err := h.downloadToCache(ctx, key); err != nil {
+			log.Error().Emitf("Failed to fetch %s: %v", key, err)
+			writeFetchError(w, key, err, status)
+			return
+		}
+	}
+	to, ok := h.cache.Peek(key)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, "Requested version was evicted, try again")
+		return
+	}
+
+	if from.Size > maxSize || to.Size > maxSize {
+		writeError(w, http.StatusUnprocessableEntity, "Too large for a delta patch, download the full file")
+		return
+	}
+
+	patchKey := deltaKey(key, from, to)
+	filePath, found := h.cache.Get(patchKey)
+	if !found {
+		start := time.Now()
+		var err error
+		filePath, err = h.generateDelta(ctx, patchKey, fromKey, key)
+		if err != nil {
+			log.Error().Emitf("Failed to generate delta from %s to %s: %v", fromKey, key, err)
+			writeError(w, http.StatusInternalServerError, "Failed to generate delta patch")
+			return
+		}
+		log.Info().Emitf("Generated delta from %s to %s in %v", fromKey, key, time.Since(start))
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Delta-From", fromKey)
+	h.serveFile(w, r, patchKey, filePath)
+}
+
+func (h *Handler) deltaMaxSize() int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.settings.DeltaMaxSize
+}
+
+// deltaKey returns the cache key of the patch between two cached entries.
+// The cache times of both are part of the key, so re-downloading either
+// version never serves a stale patch.
+func deltaKey(key string, from, to cache.Entry) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\n%d\n%d", from.Key, from.CreateTime.UnixNano(), to.CreateTime.UnixNano()))
+	return cache.DerivedKey(key, ".delta-"+hex.EncodeToString(sum[:8]))
+}
+
+// generateDelta diffs two cached files and caches the patch as patchKey.
+// Diffing needs several times the files' size in memory, so only one patch
+// is generated at a time.
+func (h *Handler) generateDelta(ctx context.Context, patchKey, fromKey, toKey string) (string, error) {
+	select {
+	case h.deltas <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-h.deltas }()
+
+	// Another request may have generated it while this one waited
+	if filePath, found := h.cache.Get(patchKey); found {
+		return filePath, nil
+	}
+
+	old, err := h.readCached(fromKey)
+	if err != nil {
+		return "", err
+	}
+	new, err := h.readCached(toKey)
+	if err != nil {
+		return "", err
+	}
+
+	var patch bytes.Buffer
+	if err := delta.Diff(old, new, &patch); err != nil {
+		return "", fmt.Errorf("failed to diff: %w", err)
+	}
+	return h.cache.PutDerived(ctx, patchKey, &patch)
+}
+
+// readCached returns the plaintext contents of a cached entry
+func (h *Handler) readCached(key string) ([]byte, error) {
+	filePath, found := h.cache.Get(key)
+	if !found {
+		return nil, fmt.Errorf("%s is no longer cached", key)
+	}
+	file, err := h.cache.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cached %s: %w", key, err)
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
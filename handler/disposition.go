@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxFilenameLength bounds the filename sent in Content-Disposition, in bytes
+const maxFilenameLength = 255
+
+// setContentDisposition sets Content-Disposition from the download and
+// filename query parameters: ?download=1 makes clients save the response as
+// a file instead of displaying it, and ?filename= names that file, defaulting
+// to name. Responses without either are left as they are.
+func setContentDisposition(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	download := query.Get("download") == "1"
+	if !download && !query.Has("filename") {
+		return
+	}
+
+	if override := sanitizeDownloadName(query.Get("filename")); override != "" {
+		name = override
+	}
+	disposition := "inline"
+	if download {
+		disposition = "attachment"
+	}
+	// FormatMediaType encodes names that aren't plain ASCII per RFC 2231
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": name}); value != "" {
+		w.Header().Set("Content-Disposition", value)
+	}
+}
+
+// sanitizeDownloadName reduces a client-supplied filename to a safe base
+// name: no directories, control characters or quotes, and no longer than
+// maxFilenameLength. Returns "" if nothing usable is left.
+func sanitizeDownloadName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+
+	// Shorten long names before the extension, a whole rune at a time so
+	// the name stays valid UTF-8
+	ext := path.Ext(name)
+	if len(ext) > maxFilenameLength/2 {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+	for len(stem)+len(ext) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(stem)
+		stem = stem[:len(stem)-size]
+	}
+	return stem + ext
+}
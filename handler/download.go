@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// downloadToCache fetches key from S3 into the cache and returns its local
+// path. It waits for a download slot first. Interrupted downloads are
+// resumed from where they stopped, and downloads that fail checksum
+// verification are retried from scratch. On failure it returns the HTTP
+// status to respond with.
+func (h *Handler) downloadToCache(ctx context.Context, key string) (filePath string, status int, err error) {
+	log := logger.FromContext(ctx)
+
+	if h.dryRun != nil {
+		status, err := h.dryRunDownload(ctx, key)
+		return "", status, err
+	}
+
+	release, status, err := h.acquireSlot(ctx)
+	if err != nil {
+		return "", status, err
+	}
+	defer release()
+
+	ctx, dl := h.startDownload(ctx, key)
+	defer func() { h.finishDownload(dl, err) }()
+
+	for attempt := 1; ; attempt++ {
+		filePath, status, err = h.downloadAttempt(ctx, dl, key)
+		if !errors.Is(err, cache.ErrChecksumMismatch) || attempt > checksumRetries {
+			return filePath, status, err
+		}
+		log.Warn().Emitf("Discarded corrupt download of %s (%v), retrying", key, err)
+	}
+}
+
+// checksumRetries is how many times a download that fails checksum
+// verification is retried before giving up
+const checksumRetries = 2
+
+// downloadAttempt makes a single attempt at downloading key into the cache
+func (h *Handler) downloadAttempt(ctx context.Context, dl *trackedDownload, key string) (string, int, error) {
+	log := logger.FromContext(ctx)
+
+	offset, etag := h.cache.Partial(key)
+	reader, info, err := h.downloader.DownloadFrom(ctx, key, offset, etag)
+	if errors.Is(err, cache.ErrObjectChanged) {
+		log.Info().Emitf("%s changed since its partial download, restarting", key)
+		h.cache.DiscardPartial(key)
+		offset = 0
+		reader, info, err = h.downloader.DownloadFrom(ctx, key, 0, "")
+	}
+	if err != nil {
+		return "", downloadStatus(err), fmt.Errorf("failed to download: %w", err)
+	}
+	defer reader.Close()
+
+	reader = dl.wrap(reader, offset, info.Size)
+
+	if offset > 0 {
+		log.Info().Emitf("Resuming %s at %.2f of %.2f MB...", key, float64(offset)/(1024*1024), float64(info.Size)/(1024*1024))
+	} else {
+		log.Info().Emitf("Downloading %s (%.2f MB)...", key, float64(info.Size)/(1024*1024))
+	}
+
+	filePath, err := h.cache.PutResumable(ctx, key, info, offset, reader)
+	if errors.Is(err, cache.ErrPartialBusy) {
+		// Another request owns the partial file; download a private copy
+		reader.Close()
+		return h.downloadWhole(ctx, dl, key)
+	}
+	if errors.Is(err, cache.ErrUnverified) {
+		log.Error().Emitf("Rejected %s: %v", key, err)
+		return "", http.StatusForbidden, err
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to cache: %w", err)
+	}
+
+	return filePath, 0, nil
+}
+
+// downloadWhole fetches key from S3 in a single non-resumable pass
+func (h *Handler) downloadWhole(ctx context.Context, dl *trackedDownload, key string) (string, int, error) {
+	reader, size, err := h.downloader.Download(ctx, key)
+	if err != nil {
+		return "", downloadStatus(err), fmt.Errorf("failed to download: %w", err)
+	}
+	defer reader.Close()
+	reader = dl.wrap(reader, 0, size)
+
+	filePath, err := h.cache.Put(ctx, key, reader)
+	if errors.Is(err, cache.ErrUnverified) {
+		logger.FromContext(ctx).Error().Emitf("Rejected %s: %v", key, err)
+		return "", http.StatusForbidden, err
+	}
+	if err != nil {
+		return "", http.StatusInternalServerError, fmt.Errorf("failed to cache: %w", err)
+	}
+	return filePath, 0, nil
+}
+
+// downloadStatus returns the HTTP status to respond with when a download
+// from S3 fails with err
+func downloadStatus(err error) int {
+	switch cache.ClassifyError(err) {
+	case cache.ErrorAccessDenied:
+		return http.StatusForbidden
+	case cache.ErrorThrottled:
+		return http.StatusTooManyRequests
+	case cache.ErrorTimeout:
+		return http.StatusGatewayTimeout
+	case cache.ErrorCircuitOpen:
+		return http.StatusServiceUnavailable
+	case cache.ErrorUnavailable, cache.ErrorRejected:
+		return http.StatusBadGateway
+	default:
+		return http.StatusNotFound // also for a key that isn't bucket/path
+	}
+}
+
+// cachedObject is an open object, either a cached file or a chunked object
+type cachedObject struct {
+	io.ReaderAt
+	size    int64
+	modTime time.Time
+	version string // changes whenever the object is cached again
+	close   func() error
+}
+
+// openCached opens a cached object for random access, downloading it on a
+// miss. Large objects are read in chunks when chunked caching is enabled, so
+// only the parts that are read are fetched. On failure it returns the HTTP
+// status to respond with.
+func (h *Handler) openCached(ctx context.Context, key string) (*cachedObject, int, error) {
+	info, chunked := h.chunkedInfo(key)
+	filePath, found := "", false
+	if !chunked {
+		filePath, found = h.cache.Get(key)
+	}
+
+	if !chunked && !found {
+		if threshold, _ := h.chunkSettings(); threshold > 0 && !h.cache.Verifies(key) {
+			head, err := h.downloader.Head(ctx, key)
+			if err == nil && head.Size > threshold {
+				h.chunked.store(key, head)
+				info, chunked = head, true
+			}
+		}
+	}
+	if chunked {
+		obj := h.openChunked(ctx, key, info)
+		return &cachedObject{ReaderAt: &seekReaderAt{rs: obj}, size: info.Size, modTime: info.LastModified, version: info.ETag, close: obj.Close}, 0, nil
+	}
+
+	if !found {
+		var (
+			status int
+			err    error
+		)
+		filePath, status, err = h.downloadToCache(ctx, key)
+		if err != nil {
+			return nil, status, err
+		}
+		h.restores.done(key)
+	}
+
+	entry, _ := h.cache.Peek(key)
+	file, err := h.cache.Open(filePath)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to open cached %s: %w", key, err)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read cached %s: %w", key, err)
+	}
+	var modTime time.Time
+	if info, err := os.Stat(filePath); err == nil {
+		modTime = info.ModTime()
+	}
+	version := strconv.FormatInt(entry.CreateTime.UnixNano(), 10)
+	return &cachedObject{ReaderAt: file, size: size, modTime: modTime, version: version, close: file.Close}, 0, nil
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// errDryRun is returned for downloads into the cache in dry-run mode, which
+// only records them
+var errDryRun = errors.New("not cached in dry-run mode")
+
+// SetDryRun puts the handler in dry-run mode: files are streamed from S3
+// without being cached, while sim records what a cache would have held, and
+// logs what it would have downloaded and evicted. Requests that need a
+// cached copy, such as for hashes or deltas, fail with 501 Not Implemented.
+func (h *Handler) SetDryRun(sim *cache.Simulation) {
+	h.dryRun = sim
+}
+
+// simulate records a request for key, of size bytes, in the dry-run cache
+func (h *Handler) simulate(ctx context.Context, key string, size int64) bool {
+	log := logger.FromContext(ctx)
+	hit, evicted := h.dryRun.Access(key, size, time.Now())
+	if hit {
+		log.Info().Emitf("Dry run: would serve %s from the cache", key)
+		return true
+	}
+	log.Info().Emitf("Dry run: would download %s (%.2f MB)", key, float64(size)/(1024*1024))
+	for _, entry := range evicted {
+		log.Info().Emitf("Dry run: would evict %s (%.2f MB), last used %s", entry.Key, float64(entry.Size)/(1024*1024), entry.AccessTime.Format(time.RFC3339))
+	}
+	return false
+}
+
+// serveDryRun streams key from S3 to the client, recording the request in
+// the dry-run cache
+func (h *Handler) serveDryRun(w http.ResponseWriter, r *http.Request, key string) {
+	log := logger.FromContext(r.Context())
+	startTime := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	release, status, err := h.acquireSlot(ctx)
+	if err != nil {
+		writeFetchError(w, key, err, status)
+		return
+	}
+	defer release()
+
+	reader, info, err := h.downloader.DownloadFrom(ctx, key, 0, "")
+	if err != nil {
+		log.Error().Emitf("Failed to fetch %s: %v", key, err)
+		writeFetchError(w, key, err, downloadStatus(err))
+		return
+	}
+	defer reader.Close()
+
+	hit := h.simulate(ctx, key, info.Size)
+
+	h.setCacheHeaders(w, key)
+	w.Header().Set("Content-Type", resolveContentType(info.ContentType, baseName(key)))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if !info.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Warn().Emitf("Failed to stream %s: %v", key, err)
+		return
+	}
+
+	if hit {
+		h.hitLatency.Since(startTime)
+	} else {
+		h.downloadLatency.Since(startTime)
+	}
+	log.Info().Emitf("Served %s from S3 in %v", key, time.Since(startTime))
+}
+
+// dryRunDownload records a download of key into the cache in the dry-run
+// cache instead of making it
+func (h *Handler) dryRunDownload(ctx context.Context, key string) (int, error) {
+	info, err := h.downloader.Head(ctx, key)
+	if err != nil {
+		return downloadStatus(err), err
+	}
+	h.simulate(ctx, key, info.Size)
+	return http.StatusNotImplemented, errDryRun
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// HandleEntries lists cached entries, most recently used first:
+// GET /admin/entries?prefix=bucket/path/&limit=N. Namespace tokens only see
+// the entries of their namespace, keyed as if it were the whole cache.
+func (h *Handler) HandleEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+
+	ns := scopedNamespace(r.Context())
+	prefix = cache.NamespacedKey(ns, prefix)
+
+	entries := []cache.Entry{}
+	for _, entry := range h.cache.Entries() {
+		if !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+		if ns != "" {
+			_, entry.Key = cache.SplitNamespace(entry.Key)
+		}
+		entries = append(entries, entry)
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// HandlePin protects entries from eviction: POST /admin/pin
+func (h *Handler) HandlePin(w http.ResponseWriter, r *http.Request) {
+	h.handlePinning(w, r, "pin", "pinned", h.cache.Pin)
+}
+
+// HandleUnpin makes pinned entries evictable again: POST /admin/unpin
+func (h *Handler) HandleUnpin(w http.ResponseWriter, r *http.Request) {
+	h.handlePinning(w, r, "unpin", "unpinned", h.cache.Unpin)
+}
+
+// handlePinning applies fn to every key in the request body and responds
+// with how many were cached. verb names the action in the audit log.
+func (h *Handler) handlePinning(w http.ResponseWriter, r *http.Request, verb, action string, fn func(string) bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req KeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Keys) == 0 {
+		writeError(w, http.StatusBadRequest, "Body must be a JSON object with a non-empty keys list")
+		return
+	}
+
+	count := 0
+	for _, key := range req.Keys {
+		if fn(strings.TrimPrefix(key, "/")) {
+			count++
+		}
+	}
+
+	h.auditRequest(w, r, AuditRecord{Action: verb, Keys: req.Keys, Detail: fmt.Sprintf("%d %s", count, action)})
+
+	logger.FromContext(r.Context()).Info().Emitf("%s %d cache entries", strings.ToUpper(action[:1])+action[1:], count)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		action: count,
+	})
+}
+
+// HandleEvents streams cache events as newline-delimited JSON until the
+// client disconnects: GET /admin/events
+func (h *Handler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	// Events are dropped rather than blocking the cache if the client is slow
+	events := make(chan cache.Event, 256)
+	unsubscribe := h.cache.Subscribe(func(e cache.Event) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
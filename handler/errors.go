@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// ErrorResponse is the body of every error response, so clients can tell
+// errors apart by code instead of matching messages.
+type ErrorResponse struct {
+	Code      string `json:"code"` // e.g. not_found, bucket_not_allowed or s3_unavailable
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+	Key       string `json:"key,omitempty"` // the object the request was for, if any
+}
+
+// Error codes more specific than the status a response has
+const (
+	CodeBucketNotAllowed    = "bucket_not_allowed"
+	CodeNamespaceNotAllowed = "namespace_not_allowed"
+	CodeRateLimited         = "rate_limited"
+	CodeDownloadQueueFull   = "download_queue_full"
+	CodeObjectArchived      = "object_archived"
+	CodeObjectNotFound      = "object_not_found"
+	CodeS3AccessDenied      = "s3_access_denied"
+	CodeS3Unavailable       = "s3_unavailable"
+	CodeS3Throttled         = "s3_throttled"
+	CodeS3Timeout           = "s3_timeout"
+	CodeS3CircuitOpen       = "s3_circuit_open"
+	CodeVerificationFailed  = "verification_failed"
+	CodeChecksumMismatch    = "checksum_mismatch"
+)
+
+// NotFound responds with a 404 ErrorResponse, in place of http.NotFound.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "Not found")
+}
+
+// writeError responds with status and message, coded after the status,
+// e.g. method_not_allowed for 405
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeErrorResponse(w, status, ErrorResponse{Code: statusCode(status), Message: message})
+}
+
+// writeKeyError responds with status and message about the object key,
+// which is reported without its namespace
+func writeKeyError(w http.ResponseWriter, status int, code, key, message string) {
+	_, key = cache.SplitNamespace(key)
+	writeErrorResponse(w, status, ErrorResponse{Code: code, Message: message, Key: key})
+}
+
+// writeErrorResponse sends resp with the request's ID. Like http.Error, it
+// drops headers describing a body that won't be sent.
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	resp.RequestID = w.Header().Get(RequestIDHeader)
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// statusCode returns the code of an error response with nothing more
+// specific to say than its status
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// writeFetchError responds to a request for key that failed to fetch it
+// with err, telling clients that were turned away when to retry
+func writeFetchError(w http.ResponseWriter, key string, err error, status int) {
+	var overloaded *overloadedError
+	if errors.As(err, &overloaded) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(overloaded.retryAfter.Seconds()))))
+	}
+	var circuitOpen *cache.CircuitOpenError
+	if errors.As(err, &circuitOpen) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(circuitOpen.RetryAfter.Seconds()))))
+	}
+	writeKeyError(w, status, fetchErrorCode(err, status), key, err.Error())
+}
+
+// fetchErrorCode returns the code of a response to a request whose object
+// failed to fetch with err
+func fetchErrorCode(err error, status int) string {
+	var overloaded *overloadedError
+	switch {
+	case errors.As(err, &overloaded):
+		return CodeDownloadQueueFull
+	case errors.Is(err, cache.ErrUnverified):
+		return CodeVerificationFailed
+	case errors.Is(err, cache.ErrChecksumMismatch):
+		return CodeChecksumMismatch
+	}
+	switch cache.ClassifyError(err) {
+	case cache.ErrorCircuitOpen:
+		return CodeS3CircuitOpen
+	case cache.ErrorTimeout:
+		return CodeS3Timeout
+	case cache.ErrorThrottled:
+		return CodeS3Throttled
+	case cache.ErrorUnavailable:
+		return CodeS3Unavailable
+	case cache.ErrorAccessDenied:
+		return CodeS3AccessDenied
+	}
+	if status == http.StatusNotFound {
+		return CodeObjectNotFound
+	}
+	return statusCode(status)
+}
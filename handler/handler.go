@@ -1,101 +1,525 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/autonoma-ai/midway/buildinfo"
 	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/cluster"
 	"github.com/autonoma-ai/midway/logger"
+	"github.com/autonoma-ai/midway/metrics"
+
+	"golang.org/x/time/rate"
 )
 
 type Handler struct {
-	cache      *cache.DiskLRUCache
+	cache      cache.Cache
 	downloader *cache.S3Downloader
+
+	mu       sync.RWMutex
+	settings Settings
+	allowed  map[string]bool
+	limiter  *rate.Limiter
+	reload   func() error
+	resolver KeyResolver      // maps request paths to objects, nil for the default
+	chunked  chunkedObjects   // objects served in chunks
+	latest   latestCache      // recently resolved latest aliases
+	archives archiveIndexes   // indexes of archives members were served from
+	cluster  *cluster.Cluster // peer nodes to check before S3, nil outside cluster mode
+
+	downloads downloadTracker   // in-flight S3 downloads
+	slots     downloadSlots     // limits concurrent S3 downloads
+	restores  restoreTracker    // restores of archived objects
+	audit     auditLog          // admin actions
+	misses    recentMisses      // shown on the dashboard
+	activity  activityHub       // events for GET /events
+	alerts    alertState        // alert thresholds currently crossed
+	deltas    chan struct{}     // held while a delta patch is generated
+	stale     atomic.Int64      // stale copies served because S3 couldn't be reached
+	retries   retryQueue        // prefetch and refresh work waiting for S3
+	dryRun    *cache.Simulation // what would be cached, nil outside dry-run mode
+
+	hitLatency      *metrics.Histogram // cache hit serve time
+	downloadLatency *metrics.Histogram // S3 download and store time
+	requestLatency  *metrics.Histogram // total file request time
+}
+
+// Settings holds handler behavior that can be changed at runtime.
+type Settings struct {
+	AdminToken     string   // bearer token required on /admin/* when set
+	AllowedBuckets []string // empty allows every bucket
+	RateLimit      float64  // file requests per second, 0 disables
+	RateBurst      int
+	ChunkThreshold int64 // objects larger than this many bytes are cached in chunks, 0 disables
+	ChunkSize      int64 // bytes per chunk
+	DeltaMaxSize   int64 // largest file delta patches are generated for, 0 disables
+
+	CacheDecompressed bool // cache the decompressed form of objects served with ?decompress=1
+
+	MaxDownloads       int // concurrent S3 downloads, 0 for unlimited
+	MaxQueuedDownloads int // downloads waiting for a slot before requests are turned away with 429
+
+	CompleteOnDisconnect bool // finish and cache S3 downloads when the client aborts
+	CompressResponses    bool // gzip or deflate compressible responses for clients that accept it
+	ContentSHA256        bool // send the SHA-256 of cached files in the X-Content-Sha256 header
+	ServeStale           bool // serve stale copies with a Warning header when S3 can't be reached
+	TrustProxy           bool // honor X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-For, set by a proxy in front
+
+	RestoreArchived bool   // start restores of archived objects instead of failing
+	RestoreDays     int    // days a restored copy stays available
+	RestoreTier     string // Expedited, Standard or Bulk
+
+	LatestAlias string        // last path segment resolving to the newest object in its folder, "" disables
+	LatestOrder string        // how the newest object is chosen: "modified" or "semver"
+	LatestTTL   time.Duration // how long a resolved alias is reused
+
+	BasePath string    // path prefix file requests are served under, e.g. /artifacts; "" for the root
+	Rewrites []Rewrite // applied in order to file request paths; the first match wins
+
+	BucketAliases map[string]string // short name used in paths -> S3 bucket
+	DefaultBucket string            // bucket whole paths are keys in; "" when paths start with the bucket
+
+	CacheHeaders []CacheHeaderRule // caching headers of file responses; the first match wins
+
+	CORSOrigins []string      // origins allowed to read files and stats from browsers, "*" for any; empty disables
+	CORSMethods []string      // methods allowed in cross-origin requests
+	CORSHeaders []string      // request headers allowed in cross-origin requests
+	CORSMaxAge  time.Duration // how long browsers may cache a preflight response
+
+	Namespaces []Namespace // partitions of the cache selected by token or path prefix
+
+	AlertMinHitRate     float64       // percent of file requests served from cache below which to alert, 0 disables
+	AlertHitRateWindow  time.Duration // how far back the hit rate is measured
+	AlertMaxDiskPercent float64       // percent full of the cache's filesystem above which to alert, 0 disables
+	AlertWebhook        string        // URL alerts are posted to as they fire and resolve, "" for none
 }
 
-func NewHandler(c *cache.DiskLRUCache, d *cache.S3Downloader) *Handler {
-	return &Handler{
+// StatsResponse is the body of GET /stats.
+type StatsResponse struct {
+	cache.Stats
+	Latency  map[string]metrics.Summary    `json:"latency"`            // hit, download, request
+	Breakers map[string]cache.BreakerStats `json:"breakers,omitempty"` // by bucket, for buckets S3 has failed requests for
+
+	RetryQueue RetryQueueStats        `json:"retryQueue"`
+	DryRun     *cache.SimulationStats `json:"dryRun,omitempty"` // what the cache would have done, in dry-run mode
+}
+
+func NewHandler(c cache.Cache, d *cache.S3Downloader) *Handler {
+	h := &Handler{
 		cache:      c,
 		downloader: d,
+		deltas:     make(chan struct{}, 1),
+
+		hitLatency:      metrics.NewHistogram(nil),
+		downloadLatency: metrics.NewHistogram(nil),
+		requestLatency:  metrics.NewHistogram(nil),
+	}
+	c.Subscribe(h.chunkedEvent)
+	return h
+}
+
+// ApplySettings replaces the runtime settings. It is safe to call while
+// requests are being served.
+func (h *Handler) ApplySettings(s Settings) {
+	var allowed map[string]bool
+	if len(s.AllowedBuckets) > 0 {
+		allowed = make(map[string]bool, len(s.AllowedBuckets))
+		for _, bucket := range s.AllowedBuckets {
+			allowed[bucket] = true
+		}
+	}
+
+	var limiter *rate.Limiter
+	if s.RateLimit > 0 {
+		limiter = rate.NewLimiter(rate.Limit(s.RateLimit), s.RateBurst)
+	}
+
+	h.slots.setLimits(s.MaxDownloads, s.MaxQueuedDownloads)
+	h.cache.SetNamespaceLimits(namespaceLimits(s.Namespaces))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.settings = s
+	h.allowed = allowed
+	h.limiter = limiter
+}
+
+// SetReloadFunc registers the function invoked by POST /admin/reload.
+func (h *Handler) SetReloadFunc(fn func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reload = fn
+}
+
+func (h *Handler) completeOnDisconnect() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.settings.CompleteOnDisconnect
+}
+
+func (h *Handler) trustProxy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.settings.TrustProxy
+}
+
+// admit applies the rate limit and bucket allowlist to a key, returning
+// the HTTP status to reject with, or 0 if the request may proceed
+func (h *Handler) admit(key string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.limiter != nil && !h.limiter.Allow() {
+		return http.StatusTooManyRequests
 	}
+
+	if !h.bucketAllowedLocked(key) {
+		return http.StatusForbidden
+	}
+
+	return 0
+}
+
+// bucketAllowed reports whether the allowlist permits key's bucket
+func (h *Handler) bucketAllowed(key string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.bucketAllowedLocked(key)
+}
+
+func (h *Handler) bucketAllowedLocked(key string) bool {
+	if h.allowed == nil {
+		return true
+	}
+	_, key = cache.SplitNamespace(key)
+	bucket, _, _ := strings.Cut(key, "/")
+	return h.allowed[bucket]
 }
 
 // HandleFile handles requests for cached files: GET /{bucket}/{key...}
 func (h *Handler) HandleFile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	// Extract bucket/key from URL path (remove leading /)
-	key := strings.TrimPrefix(r.URL.Path, "/")
-	if key == "" || key == "health" || key == "stats" {
-		http.NotFound(w, r)
+	// Find the object the URL path refers to, under the base path
+	ref, byPath, ok := h.resolvePath(r.URL.Path, r.URL.Query())
+	if !ok || ref.Bucket == "" || ref.Key == "" || strings.ContainsRune(ref.Bucket, '/') || strings.HasPrefix(ref.Bucket, "@") || strings.HasPrefix(ref.Bucket, "~") {
+		NotFound(w, r)
 		return
 	}
+	key := ref.Bucket + "/" + ref.Key
+	ns, ok := h.requestNamespace(r, byPath)
+	if !ok {
+		writeKeyError(w, http.StatusForbidden, CodeNamespaceNotAllowed, key, "Namespace not allowed")
+		return
+	}
+
+	// Files within archives are addressed as archive!/path/in/archive
+	key, member, isMember := strings.Cut(key, "!/")
+
+	// Pinned versions are cached separately from the latest version
+	key = cache.VersionedKey(key, ref.VersionID)
+
+	switch h.admit(key) {
+	case http.StatusTooManyRequests:
+		writeKeyError(w, http.StatusTooManyRequests, CodeRateLimited, key, "Rate limit exceeded")
+		return
+	case http.StatusForbidden:
+		writeKeyError(w, http.StatusForbidden, CodeBucketNotAllowed, key, "Bucket not allowed")
+		return
+	}
+
+	if r = withRequestPriority(w, r); r == nil {
+		return
+	}
+
+	// Entries of a namespace are kept apart from everyone else's
+	key = cache.NamespacedKey(ns, key)
+
+	if isMember {
+		setContentDisposition(w, r, path.Base(member))
+		h.serveArchiveMember(w, r, key, member)
+		return
+	}
+
+	if bucket, prefix, ext, ok := h.latestAlias(key); ok {
+		h.serveLatest(w, r, key, bucket, prefix, ext)
+		return
+	}
+
+	name := baseName(key)
+	if r.URL.Query().Get("decompress") == "1" {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	setContentDisposition(w, r, name)
+
+	if r.URL.Query().Get("meta") == "1" {
+		h.serveMeta(w, r, key)
+		return
+	}
+	if r.URL.Query().Has("hash") {
+		h.serveHash(w, r, key)
+		return
+	}
+	if r.URL.Query().Get("manifest") == "1" {
+		h.serveIPAManifest(w, r, key)
+		return
+	}
+	if r.URL.Query().Get("decompress") == "1" {
+		h.serveDecompressed(w, r, key)
+		return
+	}
+	if r.URL.Query().Has("deltaFrom") {
+		h.serveDelta(w, r, key)
+		return
+	}
+
+	h.serveObject(w, r, key, true)
+}
+
+// serveObject serves key from the cache, downloading it on a miss. With
+// useCluster set, misses are first routed to the key's owner node or looked
+// up on peers; requests that came from a peer pass false so they are never
+// forwarded again.
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, key string, useCluster bool) {
+	log := logger.FromContext(r.Context())
 
 	startTime := time.Now()
+	defer h.requestLatency.Since(startTime)
+
+	if h.dryRun != nil {
+		h.serveDryRun(w, r, key)
+		return
+	}
+
+	// Check in-memory tier
+	if data, modTime, ok := h.cache.GetFromMemory(key); ok {
+		modTime = h.setEntryHeaders(w, key, modTime)
+		http.ServeContent(w, r, baseName(key), modTime, bytes.NewReader(data))
+		h.hitLatency.Since(startTime)
+		h.activity.publish(ActivityEvent{Type: "hit", Key: key, Size: int64(len(data)), Source: "memory"})
+		log.Info().Emitf("Served %s from memory in %v", key, time.Since(startTime))
+		return
+	}
+
+	// Objects already known to be cached in chunks
+	if info, ok := h.chunkedInfo(key); ok {
+		h.serveChunked(w, r, key, info)
+		h.hitLatency.Since(startTime)
+		h.activity.publish(ActivityEvent{Type: "hit", Key: key, Size: info.Size, Source: "chunked"})
+		log.Info().Emitf("Served %s in chunks in %v", key, time.Since(startTime))
+		return
+	}
 
 	// Check cache
 	filePath, found := h.cache.Get(key)
 	if found {
-		logger.Info().Emitf("Served %s in %v", key, time.Since(startTime))
-		http.ServeFile(w, r, filePath)
+		h.serveFile(w, r, key, filePath)
+		h.hitLatency.Since(startTime)
+		entry, _ := h.cache.Peek(key)
+		h.activity.publish(ActivityEvent{Type: "hit", Key: key, Size: entry.Size, Source: "disk"})
+		log.Info().Emitf("Served %s in %v", key, time.Since(startTime))
+		return
+	}
+	h.misses.add(key)
+	h.activity.publish(ActivityEvent{Type: "miss", Key: key})
+
+	// Keys owned by another node are proxied to it instead of cached here
+	if useCluster && h.serveFromOwner(w, r, key) {
+		log.Info().Emitf("Served %s from its owner node in %v", key, time.Since(startTime))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	// Optionally keep downloading if the client goes away, so the next
+	// request for the same artifact is a hit
+	parent := r.Context()
+	if h.completeOnDisconnect() {
+		parent = context.WithoutCancel(parent)
+	}
+	ctx, cancel := context.WithTimeout(parent, 5*time.Minute)
 	defer cancel()
 
-	reader, size, err := h.downloader.Download(ctx, key)
-	if err != nil {
-		logger.Error().Emitf("Failed to download %s: %v", key, err)
-		http.Error(w, "Failed to download: "+err.Error(), http.StatusNotFound)
-		return
+	downloadStart := time.Now()
+
+	// A sibling node on the LAN may already have it
+	if useCluster {
+		filePath, err := h.fetchFromPeers(ctx, key)
+		if err == nil {
+			h.downloadLatency.Since(downloadStart)
+			h.serveFile(w, r, key, filePath)
+			log.Info().Emitf("Served %s in %v", key, time.Since(startTime))
+			return
+		}
+		if !errors.Is(err, cluster.ErrNotFound) {
+			log.Warn().Emitf("Peer fetch failed, falling back to S3: %v", err)
+		}
 	}
-	defer reader.Close()
 
-	logger.Info().Emitf("Downloading %s (%.2f MB)...", key, float64(size)/(1024*1024))
+	// Large objects are cached in chunks on demand instead of in full,
+	// unless they must be verified before they are served
+	if threshold, _ := h.chunkSettings(); threshold > 0 && !h.cache.Verifies(key) {
+		info, err := h.downloader.Head(ctx, key)
+		if err == nil && info.Size > threshold {
+			h.chunked.store(key, info)
+			log.Info().Emitf("Serving %s (%.2f MB) in chunks", key, float64(info.Size)/(1024*1024))
+			h.serveChunked(w, r, key, info)
+			log.Info().Emitf("Served %s in %v", key, time.Since(startTime))
+			return
+		}
+	}
 
-	// Store in cache
-	filePath, err = h.cache.Put(key, reader)
+	filePath, status, err := h.downloadToCache(ctx, key)
+	if errors.Is(err, cache.ErrArchived) {
+		h.serveArchived(w, r, key, err)
+		return
+	}
+	if err != nil && h.serveStale(w, r, key, err) {
+		return
+	}
 	if err != nil {
-		logger.Error().Emitf("Failed to cache %s: %v", key, err)
-		http.Error(w, "Failed to cache: "+err.Error(), http.StatusInternalServerError)
+		log.Error().Emitf("Failed to fetch %s: %v", key, err)
+		writeFetchError(w, key, err, status)
+		return
+	}
+	h.restores.done(key)
+	if r.Context().Err() != nil {
+		log.Info().Emitf("Client disconnected; finished caching %s in background", key)
 		return
 	}
+	h.downloadLatency.Since(downloadStart)
 
-	logger.Info().Emitf("Served %s in %v", key, time.Since(startTime))
+	log.Info().Emitf("Served %s in %v", key, time.Since(startTime))
 
 	// Serve the file
-	http.ServeFile(w, r, filePath)
+	h.serveFile(w, r, key, filePath)
 }
 
 // HandleHealth handles health check requests: GET /health
 func (h *Handler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
+	build := buildinfo.Get()
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
+		"status":  "ok",
+		"version": build.Version,
+		"commit":  build.Commit,
 	})
 }
 
+// HandleVersion reports which build is running: GET /version
+func (h *Handler) HandleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildinfo.Get())
+}
+
 // HandleStats handles stats requests: GET /stats
 func (h *Handler) HandleStats(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Teams only see their own namespace
+	if ns := h.tokenNamespace(r); ns != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(NamespaceStatsResponse{Namespace: ns.Name, NamespaceStats: h.cache.NamespaceStats(ns.Name)})
 		return
 	}
 
-	stats := h.cache.GetStats()
+	stats := StatsResponse{
+		Stats: h.cache.GetStats(),
+		Latency: map[string]metrics.Summary{
+			"hit":      h.hitLatency.Summary(),
+			"download": h.downloadLatency.Summary(),
+			"request":  h.requestLatency.Summary(),
+		},
+		Breakers:   h.downloader.Breakers(),
+		RetryQueue: h.retries.stats(),
+	}
+	if h.dryRun != nil {
+		dryRun := h.dryRun.Stats()
+		stats.DryRun = &dryRun
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
+
+// serveFile serves a file from the disk cache, decrypting it if the cache
+// is encrypted
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, key, filePath string) {
+	file, err := h.cache.Open(filePath)
+	if err != nil {
+		logger.FromContext(r.Context()).Error().Emitf("Failed to open cached %s: %v", key, err)
+		writeError(w, http.StatusInternalServerError, "Failed to read cached file")
+		return
+	}
+	defer file.Close()
+
+	var modTime time.Time
+	if info, err := os.Stat(filePath); err == nil {
+		modTime = info.ModTime()
+	}
+	modTime = h.setEntryHeaders(w, key, modTime)
+	http.ServeContent(w, r, baseName(key), modTime, file)
+}
+
+// ContentSHA256Header carries the hex SHA-256 of the whole cached file, when
+// enabled, so clients can verify what they downloaded.
+const ContentSHA256Header = "X-Content-Sha256"
+
+// setEntryHeaders sets the Content-Type of the cached entry for key, unless
+// the caller already set one, a strong ETag from its content hash, the
+// hash itself if enabled, and the configured caching headers. It
+// returns the entry's Last-Modified time: that of the S3 object if known,
+// otherwise modTime. http.ServeContent answers conditional requests with
+// these, responding 304 Not Modified when the client's copy is current.
+func (h *Handler) setEntryHeaders(w http.ResponseWriter, key string, modTime time.Time) time.Time {
+	h.setCacheHeaders(w, key)
+	entry, ok := h.cache.Peek(key)
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", resolveContentType(entry.ContentType, baseName(key)))
+	}
+	if !ok {
+		return modTime
+	}
+	if entry.SHA256 != "" {
+		w.Header().Set("ETag", `"`+entry.SHA256+`"`)
+		h.mu.RLock()
+		if h.settings.ContentSHA256 {
+			w.Header().Set(ContentSHA256Header, entry.SHA256)
+		}
+		h.mu.RUnlock()
+	}
+	if !entry.LastModified.IsZero() {
+		return entry.LastModified
+	}
+	return modTime
+}
+
+// baseName returns the file name of the object a cache key refers to
+func baseName(key string) string {
+	objectPath, _ := cache.SplitVersion(key)
+	return path.Base(objectPath)
+}
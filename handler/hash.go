@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// HashResponse is the body of GET /{bucket}/{key...}?hash=sha256.
+type HashResponse struct {
+	Key       string `json:"key"`
+	Algorithm string `json:"algorithm"` // sha256
+	Digest    string `json:"digest"`    // hex
+	Size      int64  `json:"size"`
+}
+
+// serveHash responds with the SHA-256 of an object's contents, caching the
+// object first on a miss. Hashes are recorded as files are cached; entries
+// cached before that are hashed once and the result kept
+func (h *Handler) serveHash(w http.ResponseWriter, r *http.Request, key string) {
+	log := logger.FromContext(r.Context())
+
+	algorithm := strings.ToLower(r.URL.Query().Get("hash"))
+	if algorithm != "sha256" {
+		writeError(w, http.StatusBadRequest, "hash must be sha256")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	obj, status, err := h.openCached(ctx, key)
+	if err != nil {
+		log.Error().Emitf("Failed to fetch %s: %v", key, err)
+		writeFetchError(w, key, err, status)
+		return
+	}
+	defer obj.close()
+
+	entry, whole := h.cache.Peek(key)
+	digest := entry.SHA256
+	if digest == "" {
+		// Chunked objects, fetching any chunks not yet cached, and entries
+		// cached before hashes were recorded
+		start := time.Now()
+		if digest, err = hashObject(obj); err != nil {
+			log.Error().Emitf("Failed to hash %s: %v", key, err)
+			writeError(w, http.StatusBadGateway, "Failed to read "+key)
+			return
+		}
+		if whole {
+			h.cache.SetSHA256(key, digest)
+		}
+		log.Info().Emitf("Hashed %s (%.2f MB) in %v", key, float64(obj.size)/(1024*1024), time.Since(start).Round(time.Millisecond))
+	}
+
+	_, objectKey := cache.SplitNamespace(key)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HashResponse{
+		Key:       objectKey,
+		Algorithm: algorithm,
+		Digest:    digest,
+		Size:      obj.size,
+	})
+}
+
+// hashObject returns the hex SHA-256 of obj's contents
+func hashObject(obj *cachedObject) (string, error) {
+	digest := sha256.New()
+	if _, err := io.Copy(digest, io.NewSectionReader(obj, 0, obj.size)); err != nil {
+		return "", fmt.Errorf("failed to read: %w", err)
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/ipa"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// serveIPAManifest responds with the itms-services manifest for a cached
+// .ipa file, pointing devices back at this server for the package itself:
+// GET /{bucket}/{key...}.ipa?manifest=1
+func (h *Handler) serveIPAManifest(w http.ResponseWriter, r *http.Request, key string) {
+	log := logger.FromContext(r.Context())
+
+	objectPath, versionID := cache.SplitVersion(key)
+	if !strings.EqualFold(path.Ext(objectPath), ".ipa") {
+		writeError(w, http.StatusBadRequest, "Manifests are only available for .ipa files")
+		return
+	}
+
+	var (
+		reader io.ReaderAt
+		size   int64
+	)
+	if info, ok := h.chunkedInfo(key); ok {
+		obj := h.openChunked(r.Context(), key, info)
+		defer obj.Close()
+		reader, size = &seekReaderAt{rs: obj}, info.Size
+	} else {
+		filePath, found := h.cache.Get(key)
+		if !found {
+			writeError(w, http.StatusNotFound, "Not cached; download the .ipa once before requesting its manifest")
+			return
+		}
+		file, err := h.cache.Open(filePath)
+		if err != nil {
+			log.Error().Emitf("Failed to open cached %s: %v", key, err)
+			writeError(w, http.StatusInternalServerError, "Failed to read cached file")
+			return
+		}
+		defer file.Close()
+		if size, err = file.Seek(0, io.SeekEnd); err != nil {
+			log.Error().Emitf("Failed to read cached %s: %v", key, err)
+			writeError(w, http.StatusInternalServerError, "Failed to read cached file")
+			return
+		}
+		reader = file
+	}
+
+	info, err := ipa.Read(reader, size)
+	if err != nil {
+		log.Warn().Emitf("Failed to read app metadata from %s: %v", key, err)
+		writeError(w, http.StatusUnprocessableEntity, "Not a valid .ipa file: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(ipa.Manifest(packageURL(r, h.publicPath(objectPath), versionID, h.trustProxy()), info))
+	log.Info().Emitf("Served install manifest for %s (%s %s)", key, info.BundleID, info.Version)
+}
+
+// packageURL is the URL a device downloads the object at publicPath from.
+// Behind a TLS-terminating proxy, X-Forwarded-Proto and X-Forwarded-Host
+// describe the address devices actually use; they are only honored with
+// trustProxy, as any client can send them.
+func packageURL(r *http.Request, publicPath, versionID string, trustProxy bool) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: publicPath}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); trustProxy && (proto == "http" || proto == "https") {
+		u.Scheme = proto
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); trustProxy && host != "" {
+		u.Host = host
+	}
+	if versionID != "" {
+		u.RawQuery = url.Values{"versionId": {versionID}}.Encode()
+	}
+	return u.String()
+}
+
+// seekReaderAt adapts a ReadSeeker to ReaderAt
+type seekReaderAt struct {
+	mu sync.Mutex
+	rs io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.rs, p)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	return n, err
+}
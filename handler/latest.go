@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// errNoLatest is returned when an alias's folder holds no matching objects
+var errNoLatest = errors.New("no objects match")
+
+// latestCache remembers resolved aliases for a short time, so devices
+// polling an alias don't each cause an S3 listing
+type latestCache struct {
+	mu      sync.Mutex
+	entries map[string]latestEntry
+}
+
+type latestEntry struct {
+	key     string
+	expires time.Time
+}
+
+// latestAlias splits a key whose last segment is the alias name, optionally
+// followed by an extension the match must have (latest.apk), into the bucket
+// and folder to search
+func (h *Handler) latestAlias(key string) (bucket, prefix, ext string, ok bool) {
+	h.mu.RLock()
+	alias := h.settings.LatestAlias
+	h.mu.RUnlock()
+	if alias == "" {
+		return "", "", "", false
+	}
+
+	if _, versionID := cache.SplitVersion(key); versionID != "" {
+		return "", "", "", false
+	}
+	_, key = cache.SplitNamespace(key)
+	bucket, objectPath, found := strings.Cut(key, "/")
+	if !found {
+		return "", "", "", false
+	}
+	dir, name := path.Split(objectPath)
+	ext, ok = strings.CutPrefix(name, alias)
+	if !ok || (ext != "" && ext[0] != '.') {
+		return "", "", "", false
+	}
+	return bucket, dir, ext, true
+}
+
+// resolveLatest returns the key of the newest object an alias points at,
+// from the cache of recent resolutions or by listing its folder in S3. With
+// stale set, the listing failed and the key is that of an expired
+// resolution.
+func (h *Handler) resolveLatest(ctx context.Context, alias, bucket, prefix, ext string) (key string, stale bool, err error) {
+	h.mu.RLock()
+	order, ttl := h.settings.LatestOrder, h.settings.LatestTTL
+	h.mu.RUnlock()
+
+	h.latest.mu.Lock()
+	entry, ok := h.latest.entries[alias]
+	h.latest.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.key, false, nil
+	}
+
+	objects, err := h.downloader.List(ctx, bucket, prefix)
+	if err != nil {
+		// The last resolution stands in while S3 can't be reached
+		if ok && h.serveStaleEnabled() && cache.Unavailable(err) {
+			logger.FromContext(ctx).Warn().Emitf("Resolving %s to %s from a stale resolution, as S3 can't be reached: %v", alias, entry.key, err)
+			return entry.key, true, nil
+		}
+		return "", false, err
+	}
+	newest, ok := pickLatest(objects, ext, order)
+	if !ok {
+		return "", false, errNoLatest
+	}
+
+	h.latest.mu.Lock()
+	if h.latest.entries == nil {
+		h.latest.entries = make(map[string]latestEntry)
+	}
+	h.latest.entries[alias] = latestEntry{key: newest.Key, expires: time.Now().Add(ttl)}
+	h.latest.mu.Unlock()
+
+	logger.FromContext(ctx).Info().Emitf("Resolved %s to %s", alias, newest.Key)
+	return newest.Key, false, nil
+}
+
+// serveLatest resolves an alias and serves the object it points at. The
+// resolved path is returned in Content-Location.
+func (h *Handler) serveLatest(w http.ResponseWriter, r *http.Request, key, bucket, prefix, ext string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	resolved, stale, err := h.resolveLatest(ctx, key, bucket, prefix, ext)
+	cancel()
+	if errors.Is(err, errNoLatest) {
+		writeKeyError(w, http.StatusNotFound, CodeObjectNotFound, key, "No objects under "+bucket+"/"+prefix)
+		return
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Error().Emitf("Failed to resolve %s: %v", key, err)
+		writeFetchError(w, key, fmt.Errorf("failed to resolve %s: %w", key, err), downloadStatus(err))
+		return
+	}
+
+	// The object is cached in the alias's namespace
+	ns, _ := cache.SplitNamespace(key)
+	resolved = cache.NamespacedKey(ns, resolved)
+
+	w.Header().Set("Content-Location", (&url.URL{Path: h.publicPath(resolved)}).EscapedPath())
+	if stale {
+		h.stale.Add(1)
+		w.Header().Set("Warning", staleWarning)
+	}
+	setContentDisposition(w, r, baseName(resolved))
+	switch {
+	case r.URL.Query().Get("meta") == "1":
+		h.serveMeta(w, r, resolved)
+	case r.URL.Query().Get("manifest") == "1":
+		h.serveIPAManifest(w, r, resolved)
+	default:
+		h.serveObject(w, r, resolved, true)
+	}
+}
+
+// pickLatest returns the newest object with extension ext (any if empty):
+// the one modified last, or with order "semver" the one with the highest
+// version number in its file name. Objects without a version number rank
+// below those with one, and ties go to the one modified last.
+func pickLatest(objects []cache.ObjectSummary, ext, order string) (cache.ObjectSummary, bool) {
+	var best cache.ObjectSummary
+	var bestVersion semver
+	found := false
+	for _, obj := range objects {
+		if ext != "" && !strings.EqualFold(path.Ext(obj.Key), ext) {
+			continue
+		}
+
+		var version semver
+		if order == "semver" {
+			version = parseSemver(path.Base(obj.Key))
+		}
+		if found {
+			if c := version.compare(bestVersion); c < 0 || (c == 0 && !obj.LastModified.After(best.LastModified)) {
+				continue
+			}
+		}
+		best, bestVersion, found = obj, version, true
+	}
+	return best, found
+}
+
+// semver is a version number found in a file name; the zero value ranks
+// below every version
+type semver struct {
+	ok                  bool
+	major, minor, patch int
+	prerelease          string
+}
+
+// semverPattern matches major.minor[.patch][-prerelease] not preceded by a
+// digit or dot
+var semverPattern = regexp.MustCompile(`(?:^|[^0-9.])v?(\d+)\.(\d+)(?:\.(\d+))?(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?`)
+
+// parseSemver finds the first version number in a file name, ignoring its
+// extension
+func parseSemver(name string) semver {
+	// An extension starts with a letter, unlike the tail of 1.0.0-rc
+	if ext := path.Ext(name); len(ext) > 1 && unicode.IsLetter(rune(ext[1])) && !strings.Contains(ext, "-") {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	m := semverPattern.FindStringSubmatch(name)
+	if m == nil {
+		return semver{}
+	}
+	v := semver{ok: true, prerelease: m[4]}
+	v.major, _ = strconv.Atoi(m[1])
+	v.minor, _ = strconv.Atoi(m[2])
+	v.patch, _ = strconv.Atoi(m[3])
+	return v
+}
+
+// compare orders versions by semantic versioning precedence
+func (v semver) compare(o semver) int {
+	switch {
+	case v.ok != o.ok:
+		return boolCompare(v.ok, o.ok)
+	case v.major != o.major:
+		return cmp.Compare(v.major, o.major)
+	case v.minor != o.minor:
+		return cmp.Compare(v.minor, o.minor)
+	case v.patch != o.patch:
+		return cmp.Compare(v.patch, o.patch)
+	case v.prerelease == o.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1 // a release ranks above its pre-releases
+	case o.prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.prerelease, "."), strings.Split(o.prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		na, errA := strconv.Atoi(a[i])
+		nb, errB := strconv.Atoi(b[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				return cmp.Compare(na, nb)
+			}
+		case errA == nil:
+			return -1 // numeric identifiers rank below alphanumeric ones
+		case errB == nil:
+			return 1
+		case a[i] != b[i]:
+			return strings.Compare(a[i], b[i])
+		}
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+func boolCompare(a, b bool) int {
+	if a == b {
+		return 0
+	}
+	if a {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// MetaResponse is the body of GET /{bucket}/{key...}?meta=1.
+type MetaResponse struct {
+	cache.ObjectInfo
+	Cached  bool `json:"cached"`  // the whole object is in the cache
+	Chunked bool `json:"chunked"` // the object is served in chunks, some of which may be cached
+}
+
+// serveMeta responds with an object's S3 metadata and cache state without
+// downloading it
+func (h *Handler) serveMeta(w http.ResponseWriter, r *http.Request, key string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	info, err := h.downloader.Head(ctx, key)
+	if err != nil {
+		logger.FromContext(r.Context()).Error().Emitf("Failed to fetch metadata for %s: %v", key, err)
+		writeFetchError(w, key, err, downloadStatus(err))
+		return
+	}
+
+	_, chunked := h.chunkedInfo(key)
+	if threshold, _ := h.chunkSettings(); threshold > 0 && info.Size > threshold {
+		chunked = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MetaResponse{
+		ObjectInfo: info,
+		Cached:     h.cache.Contains(key),
+		Chunked:    chunked,
+	})
+}
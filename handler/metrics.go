@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/autonoma-ai/midway/buildinfo"
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/metrics"
+)
+
+// HandleMetrics exposes cache counters and latency histograms in the
+// Prometheus text format: GET /metrics
+func (h *Handler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats := h.cache.GetStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	build := buildinfo.Get()
+	metrics.WriteHelp(w, "midway_build_info", "gauge", "Always 1, labelled with the running build.")
+	metrics.WriteValue(w, "midway_build_info", metrics.Labels{"version": build.Version, "commit": build.Commit, "goversion": build.GoVersion}, 1)
+
+	metrics.WriteHelp(w, "midway_cache_hits_total", "counter", "Cache hits.")
+	metrics.WriteValue(w, "midway_cache_hits_total", nil, float64(stats.Hits))
+	metrics.WriteHelp(w, "midway_cache_misses_total", "counter", "Cache misses.")
+	metrics.WriteValue(w, "midway_cache_misses_total", nil, float64(stats.Misses))
+	metrics.WriteHelp(w, "midway_cache_evictions_total", "counter", "Entries evicted to make room.")
+	metrics.WriteValue(w, "midway_cache_evictions_total", nil, float64(stats.Evictions))
+	metrics.WriteHelp(w, "midway_cache_bytes", "gauge", "Bytes stored in the cache.")
+	metrics.WriteValue(w, "midway_cache_bytes", nil, float64(stats.TotalBytes))
+	metrics.WriteHelp(w, "midway_cache_max_bytes", "gauge", "Cache size limit in bytes.")
+	metrics.WriteValue(w, "midway_cache_max_bytes", nil, float64(stats.MaxBytes))
+	metrics.WriteHelp(w, "midway_cache_entries", "gauge", "Entries stored in the cache.")
+	metrics.WriteValue(w, "midway_cache_entries", nil, float64(stats.EntryCount))
+	metrics.WriteHelp(w, "midway_cache_max_entries", "gauge", "Cache entry limit, 0 when there is none.")
+	metrics.WriteValue(w, "midway_cache_max_entries", nil, float64(stats.MaxEntries))
+
+	firing := make(map[string]bool)
+	for _, alert := range h.FiringAlerts() {
+		firing[alert.Name] = true
+	}
+	metrics.WriteHelp(w, "midway_alert_firing", "gauge", "1 while the alert's threshold is crossed, see /readyz.")
+	for _, name := range []string{AlertDisk, AlertHitRate} {
+		value := 0.0
+		if firing[name] {
+			value = 1
+		}
+		metrics.WriteValue(w, "midway_alert_firing", metrics.Labels{"alert": name}, value)
+	}
+
+	if namespaces := h.cache.Namespaces(); len(namespaces) > 0 {
+		names := slices.Sorted(maps.Keys(namespaces))
+		for _, m := range []struct {
+			name, kind, help string
+			value            func(cache.NamespaceStats) float64
+		}{
+			{"midway_namespace_hits_total", "counter", "Cache hits by namespace.", func(s cache.NamespaceStats) float64 { return float64(s.Hits) }},
+			{"midway_namespace_misses_total", "counter", "Cache misses by namespace.", func(s cache.NamespaceStats) float64 { return float64(s.Misses) }},
+			{"midway_namespace_evictions_total", "counter", "Entries evicted by namespace.", func(s cache.NamespaceStats) float64 { return float64(s.Evictions) }},
+			{"midway_namespace_bytes", "gauge", "Bytes stored by namespace.", func(s cache.NamespaceStats) float64 { return float64(s.TotalBytes) }},
+			{"midway_namespace_max_bytes", "gauge", "Size budget by namespace, 0 when only the cache's limit applies.", func(s cache.NamespaceStats) float64 { return float64(s.MaxBytes) }},
+			{"midway_namespace_entries", "gauge", "Entries stored by namespace.", func(s cache.NamespaceStats) float64 { return float64(s.EntryCount) }},
+		} {
+			metrics.WriteHelp(w, m.name, m.kind, m.help)
+			for _, name := range names {
+				metrics.WriteValue(w, m.name, metrics.Labels{"namespace": name}, m.value(namespaces[name]))
+			}
+		}
+	}
+
+	active, queued := h.slots.depth()
+	metrics.WriteHelp(w, "midway_downloads_in_flight", "gauge", "S3 downloads holding a download slot.")
+	metrics.WriteValue(w, "midway_downloads_in_flight", nil, float64(active))
+	metrics.WriteHelp(w, "midway_download_queue_depth", "gauge", "Downloads waiting for a download slot.")
+	metrics.WriteValue(w, "midway_download_queue_depth", nil, float64(queued))
+	metrics.WriteHelp(w, "midway_downloads_rejected_total", "counter", "Requests turned away with 429 because the download queue was full.")
+	metrics.WriteValue(w, "midway_downloads_rejected_total", nil, float64(h.slots.rejected.Load()))
+	if breakers := h.downloader.Breakers(); len(breakers) > 0 {
+		metrics.WriteHelp(w, "midway_s3_breaker_open", "gauge", "1 while requests to the bucket fail fast because S3 kept failing.")
+		for _, bucket := range slices.Sorted(maps.Keys(breakers)) {
+			value := 0.0
+			if breakers[bucket].State != cache.BreakerClosed {
+				value = 1
+			}
+			metrics.WriteValue(w, "midway_s3_breaker_open", metrics.Labels{"bucket": bucket}, value)
+		}
+	}
+	errs := h.downloader.Errors()
+	metrics.WriteHelp(w, "midway_s3_errors_total", "counter", "Requests to S3 that failed, by class.")
+	for _, class := range slices.Sorted(maps.Keys(errs)) {
+		metrics.WriteValue(w, "midway_s3_errors_total", metrics.Labels{"class": string(class)}, float64(errs[class]))
+	}
+	if h.dryRun != nil {
+		sim := h.dryRun.Stats()
+		for _, m := range []struct {
+			name, kind, help string
+			value            float64
+		}{
+			{"midway_dry_run_requests_total", "counter", "Requests recorded in dry-run mode.", float64(sim.Requests)},
+			{"midway_dry_run_hits_total", "counter", "Requests the cache would have served in dry-run mode.", float64(sim.Hits)},
+			{"midway_dry_run_downloaded_bytes_total", "counter", "Bytes the cache would have downloaded in dry-run mode.", float64(sim.DownloadedBytes)},
+			{"midway_dry_run_evictions_total", "counter", "Entries the cache would have evicted in dry-run mode.", float64(sim.Evictions)},
+			{"midway_dry_run_bytes", "gauge", "Bytes the cache would hold in dry-run mode.", float64(sim.TotalBytes)},
+		} {
+			metrics.WriteHelp(w, m.name, m.kind, m.help)
+			metrics.WriteValue(w, m.name, nil, m.value)
+		}
+	}
+	metrics.WriteHelp(w, "midway_stale_served_total", "counter", "Stale copies served because S3 couldn't be reached.")
+	metrics.WriteValue(w, "midway_stale_served_total", nil, float64(h.stale.Load()))
+
+	metrics.WriteHelp(w, "midway_request_duration_seconds", "histogram", "File request latency by stage.")
+	metrics.WriteHistogram(w, "midway_request_duration_seconds", metrics.Labels{"stage": "hit"}, h.hitLatency)
+	metrics.WriteHistogram(w, "midway_request_duration_seconds", metrics.Labels{"stage": "download"}, h.downloadLatency)
+	metrics.WriteHistogram(w, "midway_request_duration_seconds", metrics.Labels{"stage": "request"}, h.requestLatency)
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/bufpool"
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// RequestIDHeader is the header used to read and echo request IDs.
+const RequestIDHeader = "X-Request-Id"
+
+// WithRequestLogger wraps next so every request carries a logger tagged with
+// its request ID, method, path, and client IP. Handlers retrieve it with
+// logger.FromContext(r.Context()). The request ID and any traceparent are
+// also passed on to S3 in the User-Agent of the requests made for it.
+func (h *Handler) WithRequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		log := logger.Default().With(
+			"requestId", requestID,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"clientIp", clientIP(r, h.trustProxy()),
+		)
+
+		ctx := logger.NewContext(r.Context(), log)
+		ctx = cache.WithTrace(ctx, cache.Trace{RequestID: requestID, Traceparent: r.Header.Get("Traceparent")})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newRequestID returns a random 16-character hex identifier.
+func newRequestID() string {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// clientIP returns the originating client address: the first
+// X-Forwarded-For hop with trustProxy, as any client can send the header,
+// and otherwise the address the request came from.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); trustProxy && fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	return remoteHost(r)
+}
+
+// remoteHost returns the address of the connection r came in on, without
+// the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// WithStallTimeout wraps an endpoint that streams large or long-lived
+// responses, such as files, so the server's fixed read and write timeouts
+// don't apply to it. Instead, the response fails once the client accepts no
+// bytes for stall, however long the whole transfer takes. A non-zero limit
+// caps the whole response regardless.
+func WithStallTimeout(stall, limit time.Duration, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+
+		// Requests for files have no body, and an expired read deadline would
+		// cancel the request's context mid-transfer
+		rc.SetReadDeadline(time.Time{})
+
+		sw := &stallWriter{ResponseWriter: w, rc: rc, stall: stall}
+		if limit > 0 {
+			sw.limit = time.Now().Add(limit)
+		}
+		// Nothing is written while the object is fetched from S3, so the
+		// deadline only starts counting at the first write
+		rc.SetWriteDeadline(sw.limit)
+
+		next(sw, r)
+	}
+}
+
+// stallWriter moves the write deadline forward as bytes are written
+type stallWriter struct {
+	http.ResponseWriter
+	rc       *http.ResponseController
+	stall    time.Duration
+	limit    time.Time // zero for none
+	extended time.Time // when the deadline was last moved
+}
+
+// stallChunk is how much is handed to the connection at once by ReadFrom,
+// so the deadline is extended between chunks
+const stallChunk = 4 << 20
+
+// extend moves the write deadline to stall from now, at most a few times
+// per stall period so every small write doesn't cost a system call
+func (s *stallWriter) extend() {
+	now := time.Now()
+	if now.Sub(s.extended) < s.stall/4 {
+		return
+	}
+	deadline := now.Add(s.stall)
+	if !s.limit.IsZero() && deadline.After(s.limit) {
+		deadline = s.limit
+	}
+	s.rc.SetWriteDeadline(deadline)
+	s.extended = now
+}
+
+func (s *stallWriter) WriteHeader(status int) {
+	s.extend()
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *stallWriter) Write(p []byte) (int, error) {
+	s.extend()
+	return s.ResponseWriter.Write(p)
+}
+
+// ReadFrom keeps the connection's sendfile path for cached files, in chunks
+// so the deadline keeps moving. The connection only uses sendfile for a file
+// wrapped in at most one LimitedReader, so the limit http.ServeContent puts
+// on the file is folded into each chunk's rather than wrapped by it.
+func (s *stallWriter) ReadFrom(src io.Reader) (int64, error) {
+	limited, _ := src.(*io.LimitedReader)
+	inner := src
+	if limited != nil {
+		inner = limited.R
+	}
+	rf, ok := s.ResponseWriter.(io.ReaderFrom)
+	if _, isFile := inner.(*os.File); !ok || !isFile {
+		// The connection would copy anything else through a buffer it
+		// allocates for every chunk
+		return bufpool.Copy(s, src)
+	}
+
+	var total int64
+	for {
+		s.extend()
+		chunk := &io.LimitedReader{R: src, N: stallChunk}
+		if limited != nil {
+			chunk.R, chunk.N = limited.R, min(limited.N, stallChunk)
+		}
+		n, err := rf.ReadFrom(chunk)
+		total += n
+		if limited != nil {
+			limited.N -= n
+		}
+		if err != nil || n < stallChunk {
+			return total, err
+		}
+	}
+}
+
+// Flush sends buffered data to the client.
+func (s *stallWriter) Flush() {
+	s.extend()
+	s.rc.Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *stallWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
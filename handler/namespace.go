@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// Namespace partitions the cache for one team sharing the host. Requests
+// with one of its tokens, or under its path prefix, are cached in the
+// namespace, so they neither see nor evict other teams' entries.
+type Namespace struct {
+	Name       string
+	Tokens     []string // bearer tokens that select the namespace; if set, one is required
+	PathPrefix string   // path under the base path that selects the namespace, e.g. /team-a; "" for none
+	MaxBytes   int64    // size budget, 0 for only the cache's limit
+}
+
+// NamespaceStatsResponse is the body of GET /stats for a namespace token.
+type NamespaceStatsResponse struct {
+	Namespace string `json:"namespace"`
+	cache.NamespaceStats
+}
+
+// namespaceLimits returns the size budget of each namespace, by name
+func namespaceLimits(namespaces []Namespace) map[string]int64 {
+	limits := make(map[string]int64, len(namespaces))
+	for _, ns := range namespaces {
+		limits[ns.Name] = ns.MaxBytes
+	}
+	return limits
+}
+
+// pathNamespace returns the namespace whose path prefix p, a request path
+// under the base path, starts with, and p without the prefix
+func pathNamespace(namespaces []Namespace, p string) (*Namespace, string) {
+	for i, ns := range namespaces {
+		if ns.PathPrefix == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(p, ns.PathPrefix); ok && (rest == "" || rest[0] == '/') {
+			return &namespaces[i], rest
+		}
+	}
+	return nil, p
+}
+
+// tokenNamespace returns the namespace whose token r carries, or nil
+func (h *Handler) tokenNamespace(r *http.Request) *Namespace {
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || provided == "" {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for i, ns := range h.settings.Namespaces {
+		for _, token := range ns.Tokens {
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1 {
+				return &h.settings.Namespaces[i]
+			}
+		}
+	}
+	return nil
+}
+
+// requestNamespace returns the namespace a file request is cached in, from
+// its token and byPath, the namespace its path selected if any. Returns
+// false if the two disagree, or the path selects a namespace with tokens
+// and the request has none of them.
+func (h *Handler) requestNamespace(r *http.Request, byPath *Namespace) (string, bool) {
+	byToken := h.tokenNamespace(r)
+	switch {
+	case byPath == nil && byToken == nil:
+		return "", true
+	case byPath == nil:
+		return byToken.Name, true
+	case byToken == nil:
+		return byPath.Name, len(byPath.Tokens) == 0
+	default:
+		return byPath.Name, byPath.Name == byToken.Name
+	}
+}
+
+type namespaceKey struct{}
+
+// scopedNamespace returns the namespace an admin request is confined to, or
+// "" for the whole cache
+func scopedNamespace(ctx context.Context) string {
+	ns, _ := ctx.Value(namespaceKey{}).(string)
+	return ns
+}
+
+// AllowNamespace wraps an admin endpoint so a namespace token may also call
+// it, confined to its namespace: keys and prefixes in the request, and keys
+// in the response, are then relative to the namespace. Other requests must
+// pass protect.
+func (h *Handler) AllowNamespace(protect func(http.HandlerFunc) http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	protected := protect(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ns := h.tokenNamespace(r); ns != nil {
+			next(w, r.WithContext(context.WithValue(r.Context(), namespaceKey{}, ns.Name)))
+			return
+		}
+		protected(w, r)
+	}
+}
+
+// HandleNamespaces reports the statistics of every namespace:
+// GET /admin/namespaces
+func (h *Handler) HandleNamespaces(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.cache.Namespaces())
+}
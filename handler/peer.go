@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/bufpool"
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/cluster"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// SetCluster enables fetching cache misses from peer nodes before S3.
+func (h *Handler) SetCluster(c *cluster.Cluster) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cluster = c
+}
+
+func (h *Handler) peers() *cluster.Cluster {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cluster
+}
+
+// HandlePeer serves objects from the local cache to peer nodes:
+// GET /internal/peer/{bucket}/{key...}
+//
+// A miss is a 404, unless the request has fill=1, which the owner of a key
+// receives in consistent-hash mode: it then downloads the object like a
+// regular request. Either way it never asks other peers, so requests can't
+// loop between nodes.
+func (h *Handler) HandlePeer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	c := h.peers()
+	if c == nil {
+		NotFound(w, r)
+		return
+	}
+	if !peerAuthorized(c, r) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, cluster.PeerPath)
+	key = cache.VersionedKey(key, r.URL.Query().Get("versionId"))
+
+	// Entries derived from objects are only used by this node
+	if _, derived := cache.SplitDerived(key); derived {
+		NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("fill") == "1" {
+		// A fill downloads like a regular request, so it is admitted like one
+		switch h.admit(key) {
+		case http.StatusTooManyRequests:
+			writeKeyError(w, http.StatusTooManyRequests, CodeRateLimited, key, "Rate limit exceeded")
+			return
+		case http.StatusForbidden:
+			writeKeyError(w, http.StatusForbidden, CodeBucketNotAllowed, key, "Bucket not allowed")
+			return
+		}
+		if r = withRequestPriority(w, r); r == nil {
+			return
+		}
+		h.serveObject(w, r, key, false)
+		return
+	}
+
+	if !h.bucketAllowed(key) {
+		writeKeyError(w, http.StatusForbidden, CodeBucketNotAllowed, key, "Bucket not allowed")
+		return
+	}
+	if data, modTime, ok := h.cache.GetFromMemory(key); ok {
+		modTime = h.setEntryHeaders(w, key, modTime)
+		http.ServeContent(w, r, baseName(key), modTime, bytes.NewReader(data))
+		return
+	}
+
+	filePath, found := h.cache.Get(key)
+	if !found {
+		NotFound(w, r)
+		return
+	}
+	h.serveFile(w, r, key, filePath)
+}
+
+// ownerHeaders are the owner node's response headers passed on to clients
+var ownerHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag", ContentSHA256Header, "Retry-After"}
+
+// serveFromOwner proxies a request for key to the node that owns it in
+// consistent-hash mode, without caching a copy here. Returns false if this
+// node owns key, or the owner is unreachable or failing, in which case the
+// caller serves the request itself.
+func (h *Handler) serveFromOwner(w http.ResponseWriter, r *http.Request, key string) bool {
+	c := h.peers()
+	if c == nil || !c.ConsistentHash() {
+		return false
+	}
+	owner, self := c.Owner(key)
+	if self {
+		return false
+	}
+
+	log := logger.FromContext(r.Context())
+
+	// The owner queues its download at the priority this request was given,
+	// whether by header or query parameter
+	header := r.Header.Clone()
+	header.Set(PriorityHeader, priorityOf(r.Context()).String())
+
+	resp, err := c.FromOwner(r.Context(), owner, key, header)
+	if err != nil {
+		log.Warn().Emitf("Owner %s of %s unreachable, serving it locally: %v", owner, key, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		log.Warn().Emitf("Owner %s of %s responded %s, serving it locally", owner, key, resp.Status)
+		return false
+	}
+
+	for _, name := range ownerHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	bufpool.Copy(w, resp.Body)
+	return true
+}
+
+// fetchFromPeers copies key into the cache from the first peer node that has
+// it cached. Returns cluster.ErrNotFound if no peer has it or cluster mode is
+// off. In consistent-hash mode only the owner is asked, by serveFromOwner.
+func (h *Handler) fetchFromPeers(ctx context.Context, key string) (string, error) {
+	c := h.peers()
+	if c == nil || c.ConsistentHash() {
+		return "", cluster.ErrNotFound
+	}
+
+	body, size, peer, err := c.Fetch(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	if size < 0 {
+		return "", fmt.Errorf("peer %s sent %s without a length", peer, key)
+	}
+
+	start := time.Now()
+	filePath, err := h.cache.PutResumable(ctx, key, cache.ObjectInfo{Size: size}, 0, body)
+	if err != nil {
+		// Peer copies aren't resumable; a partial download must come from S3
+		if !errors.Is(err, cache.ErrPartialBusy) {
+			h.cache.DiscardPartial(key)
+		}
+		return "", fmt.Errorf("failed to copy %s from peer %s: %w", key, peer, err)
+	}
+
+	logger.FromContext(ctx).Info().Emitf("Fetched %s (%.2f MB) from peer %s in %v", key, float64(size)/(1024*1024), peer, time.Since(start))
+	return filePath, nil
+}
+
+// HandlePeerStats serves this node's cache statistics to peer nodes:
+// GET /internal/stats
+func (h *Handler) HandlePeerStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	c := h.peers()
+	if c == nil {
+		NotFound(w, r)
+		return
+	}
+	if !peerAuthorized(c, r) {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.cache.GetStats())
+}
+
+// peerAuthorized reports whether r carries the cluster's shared secret.
+// Without one, no request is.
+func peerAuthorized(c *cluster.Cluster, r *http.Request) bool {
+	token := c.Token()
+	if token == "" {
+		return false
+	}
+	provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// HandleClusterStats merges the stats of every node in the cluster:
+// GET /stats/cluster
+func (h *Handler) HandleClusterStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	c := h.peers()
+	if c == nil {
+		writeError(w, http.StatusNotFound, "Cluster mode is not enabled")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Stats(ctx, h.cache.GetStats()))
+}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// KeysRequest is the body of POST /admin/prefetch, /admin/purge,
+// /admin/pin and /admin/unpin.
+type KeysRequest struct {
+	Keys   []string `json:"keys"`             // cache keys: bucket/path, optionally with ?versionId=
+	Prefix string   `json:"prefix,omitempty"` // purge only: remove every key starting with this
+	All    bool     `json:"all,omitempty"`    // purge only: remove every entry
+	Trash  *bool    `json:"trash,omitempty"`  // purge only: move entries to the trash, the default when it is enabled
+
+	OlderThan string `json:"olderThan,omitempty"` // purge only: skip entries cached more recently than this duration ago, e.g. 72h
+}
+
+// prefetchConcurrency is how many keys of one prefetch request are
+// downloaded at the same time
+const prefetchConcurrency = 4
+
+// HandlePrefetch downloads keys into the cache in the background:
+// POST /admin/prefetch
+func (h *Handler) HandlePrefetch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req KeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Keys) == 0 {
+		writeError(w, http.StatusBadRequest, "Body must be a JSON object with a non-empty keys list")
+		return
+	}
+
+	var queued []string
+	for _, key := range req.Keys {
+		key = strings.TrimPrefix(key, "/")
+		if key == "" || !h.bucketAllowed(key) {
+			writeKeyError(w, http.StatusForbidden, CodeBucketNotAllowed, key, "Bucket not allowed: "+key)
+			return
+		}
+		if !h.cache.Contains(key) {
+			queued = append(queued, key)
+		}
+	}
+
+	h.auditRequest(w, r, AuditRecord{Action: "prefetch", Keys: req.Keys, Detail: fmt.Sprintf("%d queued", len(queued))})
+
+	// Keep the request's logger but not its cancellation
+	go h.prefetch(context.WithoutCancel(r.Context()), queued, prefetchConcurrency, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{
+		"queued": len(queued),
+		"cached": len(req.Keys) - len(queued),
+	})
+}
+
+// prefetch downloads keys into the cache, concurrency at a time, and calls
+// done, if set, after each key. Keys that fail because S3 can't be reached
+// are queued to be retried once it can.
+func (h *Handler) prefetch(ctx context.Context, keys []string, concurrency int, done func(key string, err error)) {
+	log := logger.FromContext(ctx)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+
+			ctx, cancel := context.WithTimeout(withBackground(ctx), 30*time.Minute)
+			defer cancel()
+
+			start := time.Now()
+			_, _, err := h.downloadToCache(ctx, key)
+			switch {
+			case h.queueRetry(retryPrefetch, key, err):
+				log.Warn().Emitf("Prefetch of %s failed, queued for when S3 can be reached: %v", key, err)
+			case errors.Is(err, errDryRun):
+			case err != nil:
+				log.Error().Emitf("Prefetch of %s failed: %v", key, err)
+			default:
+				log.Info().Emitf("Prefetched %s in %v", key, time.Since(start))
+			}
+			if done != nil {
+				done(key, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// PurgeResult is the response of /admin/purge.
+type PurgeResult struct {
+	Purged     int   `json:"purged"`
+	BytesFreed int64 `json:"bytesFreed"` // moved to the trash, if it is enabled
+}
+
+// HandlePurge removes entries from the cache: POST /admin/purge, or
+// DELETE /admin/purge?prefix=bucket/path/&olderThan=72h. Namespace tokens
+// only purge entries of their namespace.
+func (h *Handler) HandlePurge(w http.ResponseWriter, r *http.Request) {
+	var req KeysRequest
+	switch r.Method {
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (len(req.Keys) == 0 && req.Prefix == "" && !req.All) {
+			writeError(w, http.StatusBadRequest, "Body must be a JSON object with a keys list, a prefix or all: true")
+			return
+		}
+	case http.MethodDelete:
+		query := r.URL.Query()
+		req.Prefix, req.OlderThan = query.Get("prefix"), query.Get("olderThan")
+		if req.Prefix == "" {
+			writeError(w, http.StatusBadRequest, "prefix is required")
+			return
+		}
+		if value := query.Get("trash"); value != "" {
+			trash, err := strconv.ParseBool(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "trash must be true or false")
+				return
+			}
+			req.Trash = &trash
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// Only entries cached longer ago than this, if set
+	var cutoff time.Time
+	if req.OlderThan != "" {
+		age, err := time.ParseDuration(req.OlderThan)
+		if err != nil || age <= 0 {
+			writeError(w, http.StatusBadRequest, "olderThan must be a positive duration, e.g. 72h")
+			return
+		}
+		cutoff = time.Now().Add(-age)
+	}
+
+	// Entries go to the trash, if enabled, unless the request opts out
+	trash := h.cache.TrashEnabled() && (req.Trash == nil || *req.Trash)
+	if req.Trash != nil && *req.Trash && !trash {
+		writeError(w, http.StatusBadRequest, "Trash is disabled")
+		return
+	}
+	remove := h.cache.Remove
+	if trash {
+		remove = h.cache.Trash
+	}
+
+	// Namespace tokens purge within their namespace only
+	ns := scopedNamespace(r.Context())
+
+	var result PurgeResult
+	purge := func(entry cache.Entry) {
+		if !cutoff.IsZero() && !entry.CreateTime.Before(cutoff) {
+			return
+		}
+		if remove(entry.Key) {
+			result.Purged++
+			result.BytesFreed += entry.Size
+		}
+	}
+	switch {
+	case req.All && ns == "" && !trash && cutoff.IsZero():
+		result.BytesFreed = h.cache.Size()
+		result.Purged = h.cache.Clear()
+		h.chunked.forget(func(string) bool { return true })
+	case req.All || req.Prefix != "":
+		// Evicted copies in the cold tier would otherwise be served again
+		prefix := cache.NamespacedKey(ns, req.Prefix)
+		for _, entry := range slices.Concat(h.cache.Entries(), h.cache.ColdEntries()) {
+			// Entries derived from objects go with them
+			if key, _ := cache.SplitDerived(entry.Key); strings.HasPrefix(key, prefix) {
+				purge(entry)
+			}
+		}
+		h.chunked.forget(func(key string) bool { return strings.HasPrefix(key, prefix) })
+	default:
+		var cold map[string]cache.Entry
+		var chunks map[string][]cache.Entry // object key -> its cached chunks
+		for _, key := range req.Keys {
+			key = cache.NamespacedKey(ns, strings.TrimPrefix(key, "/"))
+
+			// Objects served in chunks are cached as their chunks
+			if chunks == nil {
+				chunks = make(map[string][]cache.Entry)
+				for _, entry := range h.cache.Entries() {
+					if object, ok := cache.ChunkObject(entry.Key); ok {
+						chunks[object] = append(chunks[object], entry)
+					}
+				}
+			}
+			for _, entry := range chunks[key] {
+				purge(entry)
+			}
+			h.forgetChunked(key)
+
+			if entry, ok := h.cache.Peek(key); ok {
+				purge(entry)
+				continue
+			}
+			if cold == nil {
+				cold = make(map[string]cache.Entry)
+				for _, entry := range h.cache.ColdEntries() {
+					cold[entry.Key] = entry
+				}
+			}
+			if entry, ok := cold[key]; ok {
+				purge(entry)
+			}
+		}
+	}
+
+	record := AuditRecord{Action: "purge", Keys: req.Keys, Prefix: req.Prefix, Detail: fmt.Sprintf("%d purged", result.Purged)}
+	if req.All {
+		record.Keys, record.Prefix, record.Detail = nil, "", fmt.Sprintf("all %d entries purged", result.Purged)
+	}
+	if req.OlderThan != "" {
+		record.Detail += " older than " + req.OlderThan
+	}
+	if trash {
+		record.Detail += " to the trash"
+	}
+	if ns != "" {
+		record.Detail += " in namespace " + ns
+	}
+	h.auditRequest(w, r, record)
+
+	logger.FromContext(r.Context()).Info().Emitf("Purged %d cache entries (%.2f MB)", result.Purged, float64(result.BytesFreed)/(1024*1024))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// PreloadRequest is the body of POST /admin/preload.
+type PreloadRequest struct {
+	Prefix   string   `json:"prefix"`             // bucket/path/ to list in S3
+	Suffixes []string `json:"suffixes,omitempty"` // only keys ending in one of these, e.g. .apk; empty for all
+	MaxSize  int64    `json:"maxSize,omitempty"`  // only objects up to this many bytes, 0 for any size
+}
+
+// PreloadResult is the response of POST /admin/preload.
+type PreloadResult struct {
+	Listed  int `json:"listed"`  // objects under the prefix
+	Matched int `json:"matched"` // objects that passed the filters
+	Queued  int `json:"queued"`  // matched objects being downloaded in the background
+	Cached  int `json:"cached"`  // matched objects that were already cached
+}
+
+// HandlePreload lists the objects under a prefix in S3 and prefetches those
+// that match its filters: POST /admin/preload
+func (h *Handler) HandlePreload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req PreloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MaxSize < 0 {
+		writeError(w, http.StatusBadRequest, "Body must be a JSON object with a prefix, and optionally suffixes and a non-negative maxSize")
+		return
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(req.Prefix, "/"), "/")
+	if bucket == "" {
+		writeError(w, http.StatusBadRequest, "prefix must start with a bucket")
+		return
+	}
+	if !h.bucketAllowed(bucket + "/") {
+		writeKeyError(w, http.StatusForbidden, CodeBucketNotAllowed, bucket, "Bucket not allowed: "+bucket)
+		return
+	}
+
+	objects, err := h.downloader.List(r.Context(), bucket, prefix)
+	if err != nil {
+		logger.FromContext(r.Context()).Error().Emitf("Preload of %s failed: %v", req.Prefix, err)
+		writeFetchError(w, req.Prefix, fmt.Errorf("failed to list %s: %w", req.Prefix, err), http.StatusBadGateway)
+		return
+	}
+
+	result := PreloadResult{Listed: len(objects)}
+	var queued []string
+	for _, object := range objects {
+		if req.MaxSize > 0 && object.Size > req.MaxSize {
+			continue
+		}
+		if len(req.Suffixes) > 0 && !hasAnySuffix(object.Key, req.Suffixes) {
+			continue
+		}
+		result.Matched++
+		if h.cache.Contains(object.Key) {
+			result.Cached++
+			continue
+		}
+		queued = append(queued, object.Key)
+	}
+	result.Queued = len(queued)
+
+	h.auditRequest(w, r, AuditRecord{Action: "preload", Prefix: req.Prefix, Detail: fmt.Sprintf("%d listed, %d queued", result.Listed, result.Queued)})
+
+	// Keep the request's logger but not its cancellation
+	ctx := context.WithoutCancel(r.Context())
+	go func() {
+		start := time.Now()
+		var failed atomic.Int64
+		h.prefetch(ctx, queued, prefetchConcurrency, func(key string, err error) {
+			if err != nil {
+				failed.Add(1)
+			}
+		})
+		logger.FromContext(ctx).Info().Emitf("Preload of %s finished: %d/%d keys downloaded in %v", req.Prefix, len(queued)-int(failed.Load()), len(queued), time.Since(start).Round(time.Second))
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(result)
+}
+
+// hasAnySuffix reports whether key ends in one of suffixes, ignoring case
+func hasAnySuffix(key string, suffixes []string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(key, strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
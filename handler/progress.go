@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// DownloadProgress describes an in-flight S3 download in GET /admin/downloads.
+type DownloadProgress struct {
+	ID          int64     `json:"id"`
+	Key         string    `json:"key"`
+	BytesDone   int64     `json:"bytesDone"` // includes bytes from a resumed partial download
+	TotalBytes  int64     `json:"totalBytes"`
+	BytesPerSec float64   `json:"bytesPerSec"`
+	ETASeconds  float64   `json:"etaSeconds"` // -1 until the speed is known
+	StartedAt   time.Time `json:"startedAt"`
+}
+
+// downloadTracker records the S3 downloads currently in progress
+type downloadTracker struct {
+	mu     sync.Mutex
+	nextID int64
+	active map[int64]*trackedDownload
+}
+
+type trackedDownload struct {
+	id          int64
+	key         string
+	started     time.Time
+	cancel      context.CancelFunc
+	offset      atomic.Int64 // bytes already present when the download started
+	total       atomic.Int64
+	transferred atomic.Int64
+}
+
+// start registers a download and returns a context for its S3 requests that
+// is cancelled when an operator cancels it. The caller must call finish when
+// done.
+func (t *downloadTracker) start(ctx context.Context, key string) (context.Context, *trackedDownload) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.active == nil {
+		t.active = make(map[int64]*trackedDownload)
+	}
+	t.nextID++
+	dl := &trackedDownload{
+		id:      t.nextID,
+		key:     key,
+		started: time.Now(),
+		cancel:  cancel,
+	}
+	t.active[dl.id] = dl
+	return ctx, dl
+}
+
+// startDownload registers a download with the tracker and announces it on
+// the activity stream
+func (h *Handler) startDownload(ctx context.Context, key string) (context.Context, *trackedDownload) {
+	ctx, dl := h.downloads.start(ctx, key)
+	h.activity.publish(ActivityEvent{Type: "download_started", Key: key})
+	return ctx, dl
+}
+
+// finishDownload unregisters a download and announces how it ended
+func (h *Handler) finishDownload(dl *trackedDownload, err error) {
+	h.downloads.finish(dl)
+
+	e := ActivityEvent{
+		Type:     "download_completed",
+		Key:      dl.key,
+		Size:     dl.offset.Load() + dl.transferred.Load(),
+		Duration: float64(time.Since(dl.started).Microseconds()) / 1000,
+	}
+	if err != nil {
+		e.Type, e.Error = "download_failed", err.Error()
+	}
+	h.activity.publish(e)
+}
+
+func (t *downloadTracker) finish(dl *trackedDownload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, dl.id)
+	dl.cancel()
+}
+
+// cancel aborts the download with the given ID, returning false if there is none
+func (t *downloadTracker) cancel(id int64) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dl, ok := t.active[id]
+	if !ok {
+		return "", false
+	}
+	dl.cancel()
+	return dl.key, true
+}
+
+func (t *downloadTracker) list() []DownloadProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]DownloadProgress, 0, len(t.active))
+	for _, dl := range t.active {
+		list = append(list, dl.progress())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func (dl *trackedDownload) progress() DownloadProgress {
+	transferred := dl.transferred.Load()
+	p := DownloadProgress{
+		ID:         dl.id,
+		Key:        dl.key,
+		BytesDone:  dl.offset.Load() + transferred,
+		TotalBytes: dl.total.Load(),
+		ETASeconds: -1,
+		StartedAt:  dl.started,
+	}
+	if elapsed := time.Since(dl.started).Seconds(); elapsed > 0 {
+		p.BytesPerSec = float64(transferred) / elapsed
+	}
+	if p.BytesPerSec > 0 {
+		p.ETASeconds = float64(p.TotalBytes-p.BytesDone) / p.BytesPerSec
+	}
+	return p
+}
+
+// wrap records the size of the response body r and counts the bytes read
+// from it as transferred
+func (dl *trackedDownload) wrap(r io.ReadCloser, offset, total int64) io.ReadCloser {
+	dl.offset.Store(offset)
+	dl.total.Store(total)
+	dl.transferred.Store(0)
+	return &countingReader{ReadCloser: r, n: &dl.transferred}
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// HandleDownloads lists or cancels in-flight S3 downloads:
+// GET /admin/downloads, DELETE /admin/downloads?id=N
+func (h *Handler) HandleDownloads(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.downloads.list())
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "id must be an integer")
+			return
+		}
+		key, ok := h.downloads.cancel(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Download not found")
+			return
+		}
+
+		logger.FromContext(r.Context()).Info().Emitf("Cancelled download %d of %s", id, key)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "cancelled",
+			"key":    key,
+		})
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
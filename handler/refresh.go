@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// RefreshJob periodically revalidates the cached keys matching Pattern.
+type RefreshJob struct {
+	Pattern  string // glob over cache keys (bucket/path), as matched by path.Match
+	Interval time.Duration
+}
+
+// RunRefresh revalidates the keys matching job.Pattern every job.Interval
+// until ctx is done. Each matching key is checked against S3 with a HEAD
+// request and downloaded again if the object was modified after it was
+// cached, so frequently used artifacts stay current without waiting for
+// eviction. Version-pinned keys never change and are skipped.
+func (h *Handler) RunRefresh(ctx context.Context, job RefreshJob) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.refresh(ctx, job.Pattern)
+		}
+	}
+}
+
+// refresh revalidates the cached keys matching pattern once
+func (h *Handler) refresh(ctx context.Context, pattern string) {
+	start := time.Now()
+	checked, refreshed, failed := 0, 0, 0
+
+	// Objects served in chunks are looked up in S3 again on their next request
+	h.chunked.forget(func(key string) bool {
+		_, objectKey := cache.SplitNamespace(key)
+		ok, _ := path.Match(pattern, objectKey)
+		return ok
+	})
+
+	for _, entry := range h.cache.Entries() {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, versionID := cache.SplitVersion(entry.Key); versionID != "" {
+			continue
+		}
+		// Chunks are checked against the object's ETag as they are read,
+		// and other derived entries are keyed by what they were derived from
+		if _, derived := cache.SplitDerived(entry.Key); derived {
+			continue
+		}
+		// Patterns refer to objects, whichever namespace caches them
+		_, objectKey := cache.SplitNamespace(entry.Key)
+		if ok, _ := path.Match(pattern, objectKey); !ok {
+			continue
+		}
+		checked++
+
+		changed, err := h.refreshEntry(ctx, entry)
+		if h.queueRetry(retryRefresh, entry.Key, err) {
+			logger.Warn().Emitf("Refresh of %s failed, queued for when S3 can be reached: %v", entry.Key, err)
+			failed++
+		} else if err != nil {
+			logger.Warn().Emitf("Refresh of %s failed: %v", entry.Key, err)
+			failed++
+		} else if changed {
+			refreshed++
+		}
+	}
+
+	logger.Info().Emitf("Refreshed %s: %d keys checked, %d downloaded again, %d failed, in %v", pattern, checked, refreshed, failed, time.Since(start).Round(time.Millisecond))
+}
+
+// refreshEntry downloads entry again if its S3 object was modified after it
+// was cached. Pinned entries stay pinned.
+func (h *Handler) refreshEntry(ctx context.Context, entry cache.Entry) (bool, error) {
+	ctx, cancel := context.WithTimeout(withBackground(ctx), 30*time.Minute)
+	defer cancel()
+
+	info, err := h.downloader.Head(ctx, entry.Key)
+	if err != nil {
+		return false, err
+	}
+	if !info.LastModified.After(entry.CreateTime) {
+		return false, nil
+	}
+
+	if _, _, err := h.downloadToCache(ctx, entry.Key); err != nil {
+		return false, err
+	}
+	if entry.Pinned {
+		h.cache.Pin(entry.Key)
+	}
+	logger.Info().Emitf("Refreshed %s, modified in S3 at %s", entry.Key, info.LastModified.Format(time.RFC3339))
+	return true, nil
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/url"
+	"strings"
+)
+
+// ObjectRef is the S3 object a file request refers to.
+type ObjectRef struct {
+	Bucket    string
+	Key       string // may end in !/member for a file within an archive
+	VersionID string // empty for the latest version
+}
+
+// KeyResolver maps the paths of file requests to the objects they refer
+// to, so deployments can lay out their URLs differently from the default
+// bucket/key, such as a single bucket with the whole path as the key.
+type KeyResolver interface {
+	// ResolveKey maps the path of a file request, without the base path,
+	// any namespace path prefix or the leading slash, and its query to an
+	// object. It returns false for paths that refer to no object, which are
+	// not found.
+	ResolveKey(path string, query url.Values) (ObjectRef, bool)
+
+	// ObjectPath is the inverse of ResolveKey for the latest version of an
+	// object, used in links midway returns to clients.
+	ObjectPath(ref ObjectRef) string
+}
+
+// SetKeyResolver replaces how file request paths are mapped to objects.
+// The default applies the Rewrites and BucketAliases settings and takes
+// the first segment of the path as the bucket, the rest as the key, or the
+// whole path as a key in DefaultBucket, and the versionId query parameter
+// as the version; a resolver set here replaces all of that. Nil restores
+// the default.
+func (h *Handler) SetKeyResolver(r KeyResolver) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.resolver = r
+}
+
+// keyResolver returns the resolver file requests are mapped with
+func (h *Handler) keyResolver() KeyResolver {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.resolver != nil {
+		return h.resolver
+	}
+	return pathResolver{rewrites: h.settings.Rewrites, aliases: h.settings.BucketAliases, bucket: h.settings.DefaultBucket}
+}
+
+// pathResolver is the default key resolver, which addresses objects as
+// bucket/key after rewrite rules and bucket aliases, or as key in
+// single-bucket mode
+type pathResolver struct {
+	rewrites []Rewrite
+	aliases  map[string]string
+	bucket   string // the default bucket, if any
+}
+
+func (p pathResolver) ResolveKey(path string, query url.Values) (ObjectRef, bool) {
+	for _, rule := range p.rewrites {
+		if rule.Pattern.MatchString(path) {
+			path = rule.Pattern.ReplaceAllString(path, rule.Replacement)
+			break
+		}
+	}
+
+	version := query.Get("versionId")
+	if p.bucket != "" {
+		return ObjectRef{Bucket: p.bucket, Key: path, VersionID: version}, true
+	}
+
+	bucket, key, found := strings.Cut(path, "/")
+	if !found {
+		return ObjectRef{}, false
+	}
+	if real, ok := p.aliases[bucket]; ok {
+		bucket = real
+	}
+	return ObjectRef{Bucket: bucket, Key: key, VersionID: version}, true
+}
+
+func (p pathResolver) ObjectPath(ref ObjectRef) string {
+	if p.bucket != "" && ref.Bucket == p.bucket {
+		return ref.Key
+	}
+	return ref.Bucket + "/" + ref.Key
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// RestoreStatus describes an archived object being restored, in
+// GET /admin/restores and in 202 responses for archived objects.
+type RestoreStatus struct {
+	Key          string    `json:"key"`
+	StorageClass string    `json:"storageClass"`
+	Tier         string    `json:"tier"`
+	RequestedAt  time.Time `json:"requestedAt"`
+	ExpectedAt   time.Time `json:"expectedAt"` // typical completion time for the tier
+}
+
+// restoreTracker remembers restores started by this process, so repeated
+// requests for an archived object don't issue a new restore each time
+type restoreTracker struct {
+	mu     sync.Mutex
+	active map[string]RestoreStatus
+}
+
+func (t *restoreTracker) get(key string) (RestoreStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.active[key]
+	return status, ok
+}
+
+func (t *restoreTracker) add(status RestoreStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active == nil {
+		t.active = make(map[string]RestoreStatus)
+	}
+	t.active[status.Key] = status
+}
+
+// done forgets a restore once the object could be downloaded
+func (t *restoreTracker) done(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, key)
+}
+
+func (t *restoreTracker) list() []RestoreStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	list := make([]RestoreStatus, 0, len(t.active))
+	for _, status := range t.active {
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].RequestedAt.Before(list[j].RequestedAt)
+	})
+	return list
+}
+
+func (h *Handler) restoreSettings() (enabled bool, days int, tier string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.settings.RestoreArchived, h.settings.RestoreDays, h.settings.RestoreTier
+}
+
+// serveArchived responds to a request for an archived object. With restores
+// enabled it starts one (unless already started) and responds 202 with a
+// Retry-After estimate; otherwise it responds 409.
+func (h *Handler) serveArchived(w http.ResponseWriter, r *http.Request, key string, err error) {
+	log := logger.FromContext(r.Context())
+
+	var archived *cache.ArchivedError
+	errors.As(err, &archived)
+
+	enabled, days, tier := h.restoreSettings()
+	if !enabled {
+		log.Info().Emitf("%s is archived in %s and restores are disabled", key, archived.StorageClass)
+		writeKeyError(w, http.StatusConflict, CodeObjectArchived, key, archived.Error())
+		return
+	}
+
+	status, ok := h.restores.get(key)
+	if !ok {
+		ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+		defer cancel()
+
+		if err := h.downloader.Restore(ctx, key, archived.StorageClass, days, tier); err != nil {
+			log.Error().Emitf("Failed to restore %s: %v", key, err)
+			writeFetchError(w, key, err, http.StatusBadGateway)
+			return
+		}
+
+		now := time.Now()
+		status = RestoreStatus{
+			Key:          key,
+			StorageClass: archived.StorageClass,
+			Tier:         tier,
+			RequestedAt:  now,
+			ExpectedAt:   now.Add(cache.RestoreEstimate(archived.StorageClass, tier)),
+		}
+		h.restores.add(status)
+		log.Info().Emitf("Requested %s restore of %s from %s", tier, key, archived.StorageClass)
+	}
+
+	// Past the estimate, suggest polling every few minutes
+	retryAfter := max(time.Until(status.ExpectedAt), 5*time.Minute)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleRestores lists restores of archived objects started by this
+// process that have not completed yet: GET /admin/restores
+func (h *Handler) HandleRestores(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.restores.list())
+}
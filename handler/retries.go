@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// Kinds of work in the retry queue
+const (
+	retryPrefetch = "prefetch" // download a key that isn't cached
+	retryRefresh  = "refresh"  // revalidate a cached key
+)
+
+// retryQueueMax is the most items the retry queue holds; work failing
+// beyond it is dropped as before
+const retryQueueMax = 100000
+
+const (
+	retrySaveInterval = 5 * time.Second  // how often a changed queue is written out
+	retryMinDelay     = 30 * time.Second // first wait before trying S3 again
+	retryMaxDelay     = 10 * time.Minute // longest wait while S3 stays unreachable
+)
+
+// RetryItem is background work that failed because S3 couldn't be reached.
+type RetryItem struct {
+	Kind     string    `json:"kind"` // prefetch or refresh
+	Key      string    `json:"key"`
+	QueuedAt time.Time `json:"queuedAt"`
+	Attempts int       `json:"attempts"` // failed retries so far
+}
+
+// RetryQueueStats describes the retry queue in /stats.
+type RetryQueueStats struct {
+	Queued    int       `json:"queued"`
+	Oldest    time.Time `json:"oldest,omitzero"`    // when the oldest item was queued
+	NextRetry time.Time `json:"nextRetry,omitzero"` // when S3 is tried again, while items are queued
+	Retried   int64     `json:"retried"`            // items done since startup
+	Dropped   int64     `json:"dropped"`            // items that failed for other reasons on retry, or didn't fit
+}
+
+// retryQueue holds prefetch and refresh work that failed because S3
+// couldn't be reached, until it can be. It is saved to a file, when one is
+// configured, so the work survives a restart.
+type retryQueue struct {
+	mu        sync.Mutex
+	path      string
+	items     map[[2]string]*RetryItem // by kind and key
+	dirty     bool                     // changed since last saved
+	nextRetry time.Time
+	retried   int64
+	dropped   int64
+}
+
+// OpenRetryQueue keeps the retry queue in the file at path, loading the work
+// queued there before a restart. Without it, the queue is kept in memory
+// only.
+func (h *Handler) OpenRetryQueue(path string) error {
+	var items []RetryItem
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &items)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to load retry queue: %w", err)
+	}
+
+	q := &h.retries
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.path = path
+	for _, item := range items {
+		q.put(item)
+	}
+	if len(items) > 0 {
+		q.nextRetry = time.Now()
+		logger.Info().Emitf("Loaded %d prefetch and refresh keys waiting for S3", len(items))
+	}
+	return nil
+}
+
+// queueRetry adds work that failed with err to the retry queue if S3
+// couldn't be reached, and reports whether it did
+func (h *Handler) queueRetry(kind, key string, err error) bool {
+	if !cache.Unavailable(err) {
+		return false
+	}
+
+	q := &h.retries
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.items) >= retryQueueMax {
+		q.dropped++
+		return false
+	}
+	q.put(RetryItem{Kind: kind, Key: key, QueuedAt: time.Now().UTC()})
+	q.dirty = true
+	if q.nextRetry.IsZero() {
+		q.nextRetry = time.Now().Add(retryMinDelay)
+	}
+	return true
+}
+
+// put adds item unless the same work is queued already (must be called
+// with lock held)
+func (q *retryQueue) put(item RetryItem) {
+	if q.items == nil {
+		q.items = make(map[[2]string]*RetryItem)
+	}
+	id := [2]string{item.Kind, item.Key}
+	if _, ok := q.items[id]; !ok {
+		q.items[id] = &item
+	}
+}
+
+// RunRetries works through the retry queue until ctx is done. S3 is tried
+// with the oldest item first; while it stays unreachable, the wait before
+// the next try doubles up to retryMaxDelay.
+func (h *Handler) RunRetries(ctx context.Context) {
+	q := &h.retries
+	ticker := time.NewTicker(retrySaveInterval)
+	defer ticker.Stop()
+
+	delay := retryMinDelay
+	for {
+		select {
+		case <-ctx.Done():
+			q.save()
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		due := !q.nextRetry.IsZero() && time.Now().After(q.nextRetry)
+		q.mu.Unlock()
+		if due {
+			if h.drainRetries(ctx) {
+				delay = retryMinDelay
+			} else {
+				delay = min(delay*2, retryMaxDelay)
+			}
+			q.mu.Lock()
+			q.nextRetry = time.Time{}
+			if len(q.items) > 0 {
+				q.nextRetry = time.Now().Add(delay)
+			}
+			q.mu.Unlock()
+		}
+		q.save()
+	}
+}
+
+// drainRetries retries the queued work, and reports whether S3 could be
+// reached. Work that fails again because it couldn't stays queued.
+func (h *Handler) drainRetries(ctx context.Context) bool {
+	q := &h.retries
+	q.mu.Lock()
+	items := make([]RetryItem, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, *item)
+	}
+	q.mu.Unlock()
+	slices.SortFunc(items, func(a, b RetryItem) int { return a.QueuedAt.Compare(b.QueuedAt) })
+
+	// The oldest item finds out whether S3 is back before the rest follow
+	if len(items) == 0 || !h.retryItem(ctx, items[0]) {
+		return false
+	}
+	logger.Info().Emitf("S3 is reachable again, retrying %d queued prefetch and refresh keys", len(items)-1)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, prefetchConcurrency)
+	for _, item := range items[1:] {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			h.retryItem(ctx, item)
+		}()
+	}
+	wg.Wait()
+	return true
+}
+
+// retryItem retries item and updates the queue, reporting whether S3 could
+// be reached
+func (h *Handler) retryItem(ctx context.Context, item RetryItem) bool {
+	ctx, cancel := context.WithTimeout(withBackground(ctx), 30*time.Minute)
+	defer cancel()
+
+	var err error
+	switch item.Kind {
+	case retryRefresh:
+		if entry, ok := h.cache.Peek(item.Key); ok {
+			_, err = h.refreshEntry(ctx, entry)
+		}
+	default:
+		if !h.cache.Contains(item.Key) {
+			_, _, err = h.downloadToCache(ctx, item.Key)
+		}
+	}
+
+	q := &h.retries
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id := [2]string{item.Kind, item.Key}
+	switch {
+	case cache.Unavailable(err) || ctx.Err() != nil:
+		if queued, ok := q.items[id]; ok {
+			queued.Attempts++
+			q.dirty = true
+		}
+		return false
+	case err != nil:
+		logger.Error().Emitf("Retried %s of %s failed: %v", item.Kind, item.Key, err)
+		q.dropped++
+	default:
+		logger.Info().Emitf("Retried %s of %s", item.Kind, item.Key)
+		q.retried++
+	}
+	delete(q.items, id)
+	q.dirty = true
+	return true
+}
+
+// save writes the queue to its file if it changed
+func (q *retryQueue) save() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.dirty || q.path == "" {
+		return
+	}
+
+	items := make([]RetryItem, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, *item)
+	}
+	data, err := json.Marshal(items)
+	if err == nil {
+		tmp := q.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, q.path)
+		}
+	}
+	if err != nil {
+		logger.Warn().Emitf("Failed to save retry queue: %v", err)
+		return
+	}
+	q.dirty = false
+}
+
+// stats describes the queue
+func (q *retryQueue) stats() RetryQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	s := RetryQueueStats{Queued: len(q.items), NextRetry: q.nextRetry, Retried: q.retried, Dropped: q.dropped}
+	for _, item := range q.items {
+		if s.Oldest.IsZero() || item.QueuedAt.Before(s.Oldest) {
+			s.Oldest = item.QueuedAt
+		}
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// Rewrite maps request paths to S3 keys: the part of the path matching
+// Pattern is replaced with Replacement, which may refer to capture groups as
+// $1 or ${name}.
+type Rewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// resolvePath maps the path of a file request to the object it refers to:
+// the base path and any namespace path prefix are stripped, and the key
+// resolver maps the rest. Returns the namespace the path prefix selected,
+// if any, and false if the path is outside the base path or the resolver
+// doesn't recognize it.
+func (h *Handler) resolvePath(p string, query url.Values) (ObjectRef, *Namespace, bool) {
+	h.mu.RLock()
+	basePath := h.settings.BasePath
+	namespaces := h.settings.Namespaces
+	h.mu.RUnlock()
+
+	if basePath != "" {
+		rest, ok := strings.CutPrefix(p, basePath)
+		if !ok || (rest != "" && rest[0] != '/') {
+			return ObjectRef{}, nil, false
+		}
+		p = rest
+	}
+	ns, p := pathNamespace(namespaces, p)
+	ref, ok := h.keyResolver().ResolveKey(strings.TrimPrefix(p, "/"), query)
+	return ref, ns, ok
+}
+
+// publicPath returns the path clients request objectPath at, under the
+// base path
+func (h *Handler) publicPath(objectPath string) string {
+	h.mu.RLock()
+	basePath := h.settings.BasePath
+	namespaces := h.settings.Namespaces
+	h.mu.RUnlock()
+
+	// Namespaces without a path prefix are selected by token instead
+	name, objectPath := cache.SplitNamespace(objectPath)
+	prefix := ""
+	for _, ns := range namespaces {
+		if ns.Name == name {
+			prefix = ns.PathPrefix
+		}
+	}
+	bucket, key, _ := strings.Cut(objectPath, "/")
+	return basePath + prefix + "/" + h.keyResolver().ObjectPath(ObjectRef{Bucket: bucket, Key: key})
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// staleWarning is the Warning header sent with stale copies, as defined by
+// RFC 7234
+const staleWarning = `110 - "Response is Stale"`
+
+func (h *Handler) serveStaleEnabled() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.settings.ServeStale
+}
+
+// serveStale serves a stale copy of key in place of failing with err, if
+// enabled, err means S3 couldn't be reached and a copy is still in the
+// trash. Reports whether it responded.
+func (h *Handler) serveStale(w http.ResponseWriter, r *http.Request, key string, err error) bool {
+	if !h.serveStaleEnabled() || !cache.Unavailable(err) {
+		return false
+	}
+	filePath, ok := h.cache.GetStale(key)
+	if !ok {
+		return false
+	}
+
+	logger.FromContext(r.Context()).Warn().Emitf("Serving stale copy of %s, as S3 can't be reached: %v", key, err)
+	h.stale.Add(1)
+	w.Header().Set("Warning", staleWarning)
+	h.serveFile(w, r, key, filePath)
+	return true
+}
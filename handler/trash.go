@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// HandleTrash lists purged entries that can still be restored, most recently
+// purged first: GET /admin/trash?prefix=bucket/path/. Namespace tokens only
+// see their namespace's.
+func (h *Handler) HandleTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ns := scopedNamespace(r.Context())
+	prefix := cache.NamespacedKey(ns, r.URL.Query().Get("prefix"))
+
+	entries := []cache.TrashedEntry{}
+	for _, entry := range h.cache.TrashedEntries() {
+		if !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+		if ns != "" {
+			_, entry.Key = cache.SplitNamespace(entry.Key)
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// HandleTrashRestore moves purged entries back into the cache:
+// POST /admin/trash/restore. The body selects entries as for a purge.
+// Namespace tokens only restore their namespace's.
+func (h *Handler) HandleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req KeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (len(req.Keys) == 0 && req.Prefix == "" && !req.All) {
+		writeError(w, http.StatusBadRequest, "Body must be a JSON object with a keys list, a prefix or all: true")
+		return
+	}
+	if !h.cache.TrashEnabled() {
+		writeError(w, http.StatusNotFound, "Trash is disabled")
+		return
+	}
+
+	ns := scopedNamespace(r.Context())
+	var keys []string
+	if req.All || req.Prefix != "" {
+		prefix := cache.NamespacedKey(ns, req.Prefix)
+		for _, entry := range h.cache.TrashedEntries() {
+			if strings.HasPrefix(entry.Key, prefix) {
+				keys = append(keys, entry.Key)
+			}
+		}
+	} else {
+		for _, key := range req.Keys {
+			keys = append(keys, cache.NamespacedKey(ns, strings.TrimPrefix(key, "/")))
+		}
+	}
+
+	restored := 0
+	var failed []string
+	for _, key := range keys {
+		ok, err := h.cache.RestoreTrashed(key)
+		if err != nil {
+			logger.FromContext(r.Context()).Warn().Emitf("Failed to restore %s: %v", key, err)
+			failed = append(failed, key)
+			continue
+		}
+		if ok {
+			restored++
+		}
+	}
+
+	record := AuditRecord{Action: "untrash", Keys: req.Keys, Prefix: req.Prefix, Detail: fmt.Sprintf("%d restored", restored)}
+	if req.All {
+		record.Keys, record.Prefix = nil, ""
+	}
+	if len(failed) > 0 {
+		record.Error = fmt.Sprintf("failed to restore %s", strings.Join(failed, ", "))
+	}
+	if ns != "" {
+		record.Detail += " in namespace " + ns
+	}
+	h.auditRequest(w, r, record)
+
+	logger.FromContext(r.Context()).Info().Emitf("Restored %d cache entries from the trash", restored)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{
+		"restored": restored,
+	})
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+//go:embed ui/index.html
+var dashboardPage []byte
+
+// UIData is the body of GET /ui/data, everything the dashboard shows.
+type UIData struct {
+	Stats      cache.Stats        `json:"stats"`
+	Downloads  []DownloadProgress `json:"downloads"`
+	TopEntries []cache.Entry      `json:"topEntries"` // largest first
+	Misses     []Miss             `json:"misses"`     // most recent first
+	Queued     int                `json:"queued"`     // downloads waiting for a slot
+}
+
+// Miss is a file request that wasn't served from this node's cache.
+type Miss struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+// recentMisses remembers the last few cache misses for the dashboard
+type recentMisses struct {
+	mu     sync.Mutex
+	misses []Miss
+}
+
+// dashboardTop is how many entries and misses the dashboard lists
+const dashboardTop = 15
+
+func (m *recentMisses) add(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.misses = append(m.misses, Miss{Key: key, Time: time.Now()})
+	if len(m.misses) > dashboardTop {
+		m.misses = m.misses[len(m.misses)-dashboardTop:]
+	}
+}
+
+func (m *recentMisses) list() []Miss {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Miss, 0, len(m.misses))
+	for i := len(m.misses) - 1; i >= 0; i-- {
+		list = append(list, m.misses[i])
+	}
+	return list
+}
+
+// HandleUI serves the dashboard page: GET /ui
+//
+// The page holds no data itself; it polls /ui/data, sending the admin token
+// the operator enters.
+func (h *Handler) HandleUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if r.URL.Path != "/ui" && r.URL.Path != "/ui/" {
+		NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(dashboardPage)
+}
+
+// HandleUIData returns what the dashboard shows: GET /ui/data
+func (h *Handler) HandleUIData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	entries := h.cache.Entries()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Size > entries[j].Size
+	})
+	if len(entries) > dashboardTop {
+		entries = entries[:dashboardTop]
+	}
+	_, queued := h.slots.depth()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(UIData{
+		Stats:      h.cache.GetStats(),
+		Downloads:  h.downloads.list(),
+		TopEntries: entries,
+		Misses:     h.misses.list(),
+		Queued:     queued,
+	})
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// Warmup downloads keys into the cache, concurrency at a time, and logs
+// progress as it goes. Keys that are already cached are skipped, as are keys
+// in buckets that aren't allowed. It returns when every key has been tried
+// or ctx is done.
+func (h *Handler) Warmup(ctx context.Context, keys []string, concurrency int) {
+	var queued []string
+	skipped := 0
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, "/")
+		if !h.bucketAllowed(key) {
+			logger.Warn().Emitf("Warm-up skipping %s: bucket not allowed", key)
+			skipped++
+			continue
+		}
+		if h.cache.Contains(key) {
+			skipped++
+			continue
+		}
+		queued = append(queued, key)
+	}
+
+	logger.Info().Emitf("Warm-up started: %d keys to download, %d already cached or skipped", len(queued), skipped)
+	if len(queued) == 0 {
+		return
+	}
+
+	var mu sync.Mutex
+	completed, failed := 0, 0
+	nextReport := 1 // log at every 10% of the keys
+	start := time.Now()
+
+	h.prefetch(ctx, queued, concurrency, func(key string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		completed++
+		if err != nil {
+			failed++
+		}
+		if completed*10 >= nextReport*len(queued) && completed < len(queued) {
+			logger.Info().Emitf("Warm-up progress: %d/%d keys (%d failed) after %v", completed, len(queued), failed, time.Since(start).Round(time.Second))
+			nextReport = completed*10/len(queued) + 1
+		}
+	})
+
+	logger.Info().Emitf("Warm-up finished: %d/%d keys downloaded, %d failed, in %v", len(queued)-failed, len(queued), failed, time.Since(start).Round(time.Second))
+}
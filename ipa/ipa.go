@@ -0,0 +1,131 @@
+// Package ipa reads the app metadata of iOS .ipa archives and generates the
+// manifests iOS devices need to install them over the air.
+package ipa
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Info identifies the app packaged in an .ipa file.
+type Info struct {
+	BundleID string // CFBundleIdentifier
+	Version  string // CFBundleShortVersionString, or CFBundleVersion if unset
+	Title    string // CFBundleDisplayName, or CFBundleName if unset
+}
+
+// ErrNoInfoPlist is returned when an archive has no Payload/*.app/Info.plist.
+var ErrNoInfoPlist = errors.New("no Payload/*.app/Info.plist in archive")
+
+// maxInfoPlistSize bounds how much of Info.plist is read into memory
+const maxInfoPlistSize = 4 * 1024 * 1024
+
+// Read extracts the app metadata from an .ipa archive of size bytes. Only the
+// zip directory and Info.plist are read, not the whole archive.
+func Read(r io.ReaderAt, size int64) (Info, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	for _, file := range archive.File {
+		parts := strings.Split(file.Name, "/")
+		if len(parts) != 3 || parts[0] != "Payload" || !strings.HasSuffix(parts[1], ".app") || parts[2] != "Info.plist" {
+			continue
+		}
+		if file.UncompressedSize64 > maxInfoPlistSize {
+			return Info{}, fmt.Errorf("%s is too large", file.Name)
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return Info{}, fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxInfoPlistSize))
+		rc.Close()
+		if err != nil {
+			return Info{}, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+
+		values, err := parsePlist(data)
+		if err != nil {
+			return Info{}, fmt.Errorf("failed to parse %s: %w", file.Name, err)
+		}
+		info := Info{
+			BundleID: values["CFBundleIdentifier"],
+			Version:  firstNonEmpty(values["CFBundleShortVersionString"], values["CFBundleVersion"]),
+			Title:    firstNonEmpty(values["CFBundleDisplayName"], values["CFBundleName"], strings.TrimSuffix(parts[1], ".app")),
+		}
+		if info.BundleID == "" {
+			return Info{}, fmt.Errorf("%s has no CFBundleIdentifier", file.Name)
+		}
+		return info, nil
+	}
+	return Info{}, ErrNoInfoPlist
+}
+
+// Manifest returns the itms-services manifest that installs the app in info
+// from url. Devices only accept manifests and packages served over HTTPS.
+func Manifest(url string, info Info) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>items</key>
+	<array>
+		<dict>
+			<key>assets</key>
+			<array>
+				<dict>
+					<key>kind</key>
+					<string>software-package</string>
+					<key>url</key>
+					<string>`)
+	escape(&buf, url)
+	buf.WriteString(`</string>
+				</dict>
+			</array>
+			<key>metadata</key>
+			<dict>
+				<key>bundle-identifier</key>
+				<string>`)
+	escape(&buf, info.BundleID)
+	buf.WriteString(`</string>
+				<key>bundle-version</key>
+				<string>`)
+	escape(&buf, info.Version)
+	buf.WriteString(`</string>
+				<key>kind</key>
+				<string>software</string>
+				<key>title</key>
+				<string>`)
+	escape(&buf, info.Title)
+	buf.WriteString(`</string>
+			</dict>
+		</dict>
+	</array>
+</dict>
+</plist>
+`)
+	return buf.Bytes()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// escape writes s to buf with XML special characters escaped
+func escape(buf *bytes.Buffer, s string) {
+	xml.EscapeText(buf, []byte(s))
+}
@@ -0,0 +1,219 @@
+package ipa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// parsePlist returns the string values of the top-level dictionary of a
+// binary or XML property list. Values of other types are left out.
+func parsePlist(data []byte) (map[string]string, error) {
+	if bytes.HasPrefix(data, []byte("bplist00")) {
+		return parseBinaryPlist(data)
+	}
+	return parseXMLPlist(data)
+}
+
+// parseXMLPlist handles the XML format, which Info.plist files have before
+// Xcode compiles them
+func parseXMLPlist(data []byte) (map[string]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	values := make(map[string]string)
+
+	depth := 0 // nesting of dict and array elements
+	var key string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "dict", "array":
+				depth++
+			case "key", "string":
+				if depth != 1 {
+					continue
+				}
+				var text string
+				if err := dec.DecodeElement(&text, &t); err != nil {
+					return nil, err
+				}
+				if t.Name.Local == "key" {
+					key = text
+				} else if key != "" {
+					values[key] = text
+					key = ""
+				}
+			default:
+				if depth == 1 {
+					key = ""
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "dict" || t.Name.Local == "array" {
+				depth--
+				if depth == 1 {
+					key = ""
+				}
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unterminated plist")
+	}
+	return values, nil
+}
+
+var errBadBinaryPlist = errors.New("malformed binary plist")
+
+// binaryPlist is a bplist00 file, the format of Info.plist in built apps
+type binaryPlist struct {
+	data    []byte
+	offsets []uint64 // object index -> file offset
+	refSize int
+}
+
+// parseBinaryPlist handles the bplist00 format
+func parseBinaryPlist(data []byte) (map[string]string, error) {
+	if len(data) < 8+32 {
+		return nil, errBadBinaryPlist
+	}
+	trailer := data[len(data)-32:]
+	offsetSize := int(trailer[6])
+	refSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	topObject := binary.BigEndian.Uint64(trailer[16:])
+	tableOffset := binary.BigEndian.Uint64(trailer[24:])
+
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 ||
+		numObjects > uint64(len(data)) || tableOffset > uint64(len(data)) ||
+		uint64(len(data))-tableOffset < numObjects*uint64(offsetSize) {
+		return nil, errBadBinaryPlist
+	}
+
+	p := &binaryPlist{data: data, refSize: refSize, offsets: make([]uint64, numObjects)}
+	for i := range p.offsets {
+		start := tableOffset + uint64(i*offsetSize)
+		p.offsets[i] = readUint(data[start : start+uint64(offsetSize)])
+	}
+
+	keys, values, err := p.dict(topObject)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	for i := range keys {
+		key, err := p.str(keys[i])
+		if err != nil {
+			return nil, err
+		}
+		if value, err := p.str(values[i]); err == nil {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
+
+// object returns the marker byte of object ref and its contents
+func (p *binaryPlist) object(ref uint64) (byte, []byte, error) {
+	if ref >= uint64(len(p.offsets)) || p.offsets[ref] >= uint64(len(p.data)) {
+		return 0, nil, errBadBinaryPlist
+	}
+	off := p.offsets[ref]
+	return p.data[off], p.data[off+1:], nil
+}
+
+// length decodes the element count stored in a marker's low nibble, or in the
+// integer following it when the nibble is 0xF. Returns the count and the rest.
+func (p *binaryPlist) length(marker byte, rest []byte) (uint64, []byte, error) {
+	n := uint64(marker & 0x0f)
+	if n != 0x0f {
+		return n, rest, nil
+	}
+	if len(rest) < 1 || rest[0]>>4 != 0x1 {
+		return 0, nil, errBadBinaryPlist
+	}
+	size := 1 << (rest[0] & 0x0f)
+	if size > 8 || len(rest) < 1+size {
+		return 0, nil, errBadBinaryPlist
+	}
+	return readUint(rest[1 : 1+size]), rest[1+size:], nil
+}
+
+// dict returns the key and value refs of dictionary object ref
+func (p *binaryPlist) dict(ref uint64) (keys, values []uint64, err error) {
+	marker, rest, err := p.object(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	if marker>>4 != 0xd {
+		return nil, nil, fmt.Errorf("top-level object is not a dictionary")
+	}
+	n, rest, err := p.length(marker, rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Checked by division, as a huge n would overflow the product
+	if n > uint64(len(rest))/(2*uint64(p.refSize)) {
+		return nil, nil, errBadBinaryPlist
+	}
+
+	keys = make([]uint64, n)
+	values = make([]uint64, n)
+	for i := range n {
+		keys[i] = readUint(rest[i*uint64(p.refSize) : (i+1)*uint64(p.refSize)])
+		values[i] = readUint(rest[(n+i)*uint64(p.refSize) : (n+i+1)*uint64(p.refSize)])
+	}
+	return keys, values, nil
+}
+
+// str decodes string object ref, ASCII or UTF-16
+func (p *binaryPlist) str(ref uint64) (string, error) {
+	marker, rest, err := p.object(ref)
+	if err != nil {
+		return "", err
+	}
+	n, rest, err := p.length(marker, rest)
+	if err != nil {
+		return "", err
+	}
+
+	switch marker >> 4 {
+	case 0x5:
+		if uint64(len(rest)) < n {
+			return "", errBadBinaryPlist
+		}
+		return string(rest[:n]), nil
+	case 0x6:
+		if uint64(len(rest))/2 < n {
+			return "", errBadBinaryPlist
+		}
+		units := make([]uint16, n)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(rest[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	}
+	return "", fmt.Errorf("object %d is not a string", ref)
+}
+
+// readUint decodes a big-endian unsigned integer of up to 8 bytes
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
@@ -0,0 +1,227 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// Limits of a PutLogEvents call, which counts each event as its message plus
+// eventOverhead bytes
+const (
+	eventOverhead = 26
+	maxBatchBytes = 1048576
+	maxEventBytes = 256*1024 - eventOverhead
+)
+
+// maxShipAttempts bounds how often a batch is sent before it is dropped
+const maxShipAttempts = 3
+
+// CloudWatchConfig controls the CloudWatch Logs sink.
+type CloudWatchConfig struct {
+	LogGroup      string
+	LogStream     string
+	BatchSize     int           // max events per PutLogEvents call
+	FlushInterval time.Duration // max time an event waits before being shipped
+	BufferSize    int           // queued events before new lines are dropped
+}
+
+// CloudWatchSink is an io.Writer that ships each log line to CloudWatch Logs.
+// Lines are queued and sent in batches by a background goroutine; when the
+// queue is full, new lines are dropped (and counted) rather than blocking
+// the caller.
+type CloudWatchSink struct {
+	client   *cloudwatchlogs.Client
+	cfg      CloudWatchConfig
+	events   chan types.InputLogEvent
+	flushReq chan chan struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup
+	dropped  atomic.Int64
+
+	batchBytes int // size of the batch being collected, as PutLogEvents counts it
+}
+
+// NewCloudWatchSink creates the log group and stream if needed and starts
+// the background shipper.
+func NewCloudWatchSink(ctx context.Context, awsCfg aws.Config, cfg CloudWatchConfig) (*CloudWatchSink, error) {
+	if cfg.BatchSize <= 0 || cfg.BatchSize > 10000 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+
+	client := cloudwatchlogs.NewFromConfig(awsCfg)
+
+	var exists *types.ResourceAlreadyExistsException
+	_, err := client.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(cfg.LogGroup),
+	})
+	if err != nil && !errors.As(err, &exists) {
+		return nil, fmt.Errorf("failed to create log group: %w", err)
+	}
+
+	_, err = client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(cfg.LogGroup),
+		LogStreamName: aws.String(cfg.LogStream),
+	})
+	if err != nil && !errors.As(err, &exists) {
+		return nil, fmt.Errorf("failed to create log stream: %w", err)
+	}
+
+	s := &CloudWatchSink{
+		client:   client,
+		cfg:      cfg,
+		events:   make(chan types.InputLogEvent, cfg.BufferSize),
+		flushReq: make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+// Write queues a single log line, truncated to the largest event
+// CloudWatch accepts. It never blocks.
+func (s *CloudWatchSink) Write(p []byte) (int, error) {
+	message := strings.TrimRight(string(p), "\n")
+	if len(message) > maxEventBytes {
+		message = strings.ToValidUTF8(message[:maxEventBytes], "")
+	}
+	event := types.InputLogEvent{
+		Message:   aws.String(message),
+		Timestamp: aws.Int64(time.Now().UnixMilli()),
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		s.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Sync ships all queued events and waits for the upload to finish.
+func (s *CloudWatchSink) Sync() error {
+	reply := make(chan struct{})
+	select {
+	case s.flushReq <- reply:
+	case <-s.done:
+		return nil
+	}
+
+	select {
+	case <-reply:
+		return nil
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("timed out flushing CloudWatch logs")
+	}
+}
+
+// Close flushes remaining events and stops the background shipper.
+func (s *CloudWatchSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	return nil
+}
+
+// Dropped returns the number of lines discarded because the queue was full.
+func (s *CloudWatchSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+func (s *CloudWatchSink) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]types.InputLogEvent, 0, s.cfg.BatchSize)
+	for {
+		select {
+		case event := <-s.events:
+			batch = s.add(batch, event)
+		case <-ticker.C:
+			batch = s.ship(batch)
+		case reply := <-s.flushReq:
+			batch = s.ship(s.drain(batch))
+			close(reply)
+		case <-s.done:
+			s.ship(s.drain(batch))
+			return
+		}
+	}
+}
+
+// drain moves every queued event into batch, shipping full batches on the way
+func (s *CloudWatchSink) drain(batch []types.InputLogEvent) []types.InputLogEvent {
+	for {
+		select {
+		case event := <-s.events:
+			batch = s.add(batch, event)
+		default:
+			return batch
+		}
+	}
+}
+
+// add appends event to batch, shipping the batch first if the event would
+// take it over the byte limit, and after if it is then full
+func (s *CloudWatchSink) add(batch []types.InputLogEvent, event types.InputLogEvent) []types.InputLogEvent {
+	size := len(*event.Message) + eventOverhead
+	if s.batchBytes+size > maxBatchBytes {
+		batch = s.ship(batch)
+	}
+	batch = append(batch, event)
+	s.batchBytes += size
+	if len(batch) >= s.cfg.BatchSize {
+		batch = s.ship(batch)
+	}
+	return batch
+}
+
+// ship uploads batch, retrying throttling and other transient failures a
+// few times, and returns it emptied for reuse
+func (s *CloudWatchSink) ship(batch []types.InputLogEvent) []types.InputLogEvent {
+	s.batchBytes = 0
+	if len(batch) == 0 {
+		return batch
+	}
+
+	retryable := retry.IsErrorRetryables(retry.DefaultRetryables)
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		_, err := s.client.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(s.cfg.LogGroup),
+			LogStreamName: aws.String(s.cfg.LogStream),
+			LogEvents:     batch,
+		})
+		cancel()
+		if err == nil {
+			break
+		}
+		if attempt == maxShipAttempts || retryable.IsErrorRetryable(err) != aws.TrueTernary {
+			// Can't log through ourselves; count the batch as dropped instead
+			s.dropped.Add(int64(len(batch)))
+			break
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+
+	return batch[:0]
+}
@@ -6,8 +6,6 @@ import (
 	"io"
 	"log/slog"
 	"os"
-	"runtime"
-	"strconv"
 	"strings"
 	"sync"
 )
@@ -15,6 +13,16 @@ import (
 var (
 	once       sync.Once
 	defaultLog *slog.Logger
+	sinks      = &multiSink{}
+	exitCode   = 1
+	level      = new(slog.LevelVar)
+)
+
+// Levels above slog.LevelError. Fatal terminates the process after logging and
+// Panic panics with the message after logging.
+const (
+	LevelFatal = slog.Level(12)
+	LevelPanic = slog.Level(16)
 )
 
 type contextKey string
@@ -23,87 +31,229 @@ const loggerKey contextKey = "logger"
 
 func Init(ctx context.Context) {
 	once.Do(func() {
-		defaultLog = slog.New(&customHandler{out: os.Stdout})
+		sinks.add(os.Stdout)
+		defaultLog = slog.New(&customHandler{out: sinks})
 	})
 }
 
+// AddSink registers an additional destination for every log line.
+func AddSink(w io.Writer) {
+	sinks.add(w)
+}
+
+// multiSink fans each formatted line out to every registered writer.
+type multiSink struct {
+	mu      sync.Mutex
+	writers []io.Writer
+}
+
+func (m *multiSink) add(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writers = append(m.writers, w)
+}
+
+func (m *multiSink) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var firstErr error
+	for _, w := range m.writers {
+		if _, err := w.Write(p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return len(p), firstErr
+}
+
+func (m *multiSink) sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var firstErr error
+	for _, w := range m.writers {
+		if s, ok := w.(interface{ Sync() error }); ok {
+			if err := s.Sync(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// SetLevel sets the minimum level that is written. It is safe to call while
+// logging is in progress.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// ParseLevel parses a level name such as "debug", "info", "warn" or "error".
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return l, nil
+}
+
+// SetExitCode sets the process exit code used by Fatal. The default is 1.
+func SetExitCode(code int) {
+	exitCode = code
+}
+
+// Flush syncs every sink that supports it, so nothing buffered is lost when
+// the process exits.
+func Flush() error {
+	return sinks.sync()
+}
+
+func levelName(level slog.Level) string {
+	switch level {
+	case LevelFatal:
+		return "FATAL"
+	case LevelPanic:
+		return "PANIC"
+	}
+	return strings.ToUpper(level.String())
+}
+
 type customHandler struct {
-	out io.Writer
+	out   io.Writer
+	attrs []slog.Attr
 }
 
-func (h *customHandler) Enabled(_ context.Context, _ slog.Level) bool {
-	return true
+func (h *customHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
 }
 
 func (h *customHandler) Handle(_ context.Context, r slog.Record) error {
 	timestamp := r.Time.Format("2006-01-02 15:04:05")
-	level := strings.ToUpper(r.Level.String())
-	_, err := fmt.Fprintf(h.out, "[%s] [%s] %q\n", timestamp, level, r.Message)
+	level := levelName(r.Level)
+
+	var fields strings.Builder
+	for _, a := range h.attrs {
+		fmt.Fprintf(&fields, " %s=%s", a.Key, a.Value.String())
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&fields, " %s=%s", a.Key, a.Value.String())
+		return true
+	})
+
+	_, err := fmt.Fprintf(h.out, "[%s] [%s] %q%s\n", timestamp, level, r.Message, fields.String())
 	return err
 }
 
-func (h *customHandler) WithAttrs(_ []slog.Attr) slog.Handler {
-	return h
+func (h *customHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	merged := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	merged = append(merged, h.attrs...)
+	merged = append(merged, attrs...)
+	return &customHandler{out: h.out, attrs: merged}
 }
 
 func (h *customHandler) WithGroup(_ string) slog.Handler {
 	return h
 }
 
-var contextStorage sync.Map
+// Logger is a leveled logger that may carry request-scoped fields.
+type Logger struct {
+	log *slog.Logger
+}
+
+// With returns a Logger that includes the given key/value pairs on every line.
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{log: l.log.With(args...)}
+}
 
-func WithContext(ctx context.Context) func() {
-	gid := getGoroutineID()
-	newCtx := context.WithValue(ctx, loggerKey, defaultLog)
-	contextStorage.Store(gid, newCtx)
+func (l *Logger) Info() *LogEntry {
+	return &LogEntry{logger: l.log, level: slog.LevelInfo}
+}
 
-	return func() {
-		contextStorage.Delete(gid)
-	}
+func (l *Logger) Error() *LogEntry {
+	return &LogEntry{logger: l.log, level: slog.LevelError}
 }
 
-func getLogger() *slog.Logger {
-	if ctx, ok := contextStorage.Load(getGoroutineID()); ok {
-		if log, ok := ctx.(context.Context).Value(loggerKey).(*slog.Logger); ok {
-			return log
-		}
+func (l *Logger) Debug() *LogEntry {
+	return &LogEntry{logger: l.log, level: slog.LevelDebug}
+}
+
+func (l *Logger) Warn() *LogEntry {
+	return &LogEntry{logger: l.log, level: slog.LevelWarn}
+}
+
+// Fatal returns an entry that logs, flushes all sinks, and exits the process.
+func (l *Logger) Fatal() *LogEntry {
+	return &LogEntry{logger: l.log, level: LevelFatal, exitCode: exitCode}
+}
+
+// Panic returns an entry that logs, flushes all sinks, and panics.
+func (l *Logger) Panic() *LogEntry {
+	return &LogEntry{logger: l.log, level: LevelPanic}
+}
+
+// Default returns the process-wide logger without request-scoped fields.
+func Default() *Logger {
+	return &Logger{log: defaultLog}
+}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger if none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey).(*Logger); ok {
+		return l
 	}
-	return defaultLog
+	return Default()
 }
 
 type LogEntry struct {
-	logger *slog.Logger
-	level  slog.Level
+	logger   *slog.Logger
+	level    slog.Level
+	exitCode int
+}
+
+// ExitCode overrides the process exit code for a Fatal entry.
+func (e *LogEntry) ExitCode(code int) *LogEntry {
+	e.exitCode = code
+	return e
 }
 
 func (e *LogEntry) Emitf(format string, args ...interface{}) {
-	e.logger.Log(context.Background(), e.level, fmt.Sprintf(format, args...))
+	msg := fmt.Sprintf(format, args...)
+	e.logger.Log(context.Background(), e.level, msg)
+
+	switch e.level {
+	case LevelFatal:
+		Flush()
+		os.Exit(e.exitCode)
+	case LevelPanic:
+		Flush()
+		panic(msg)
+	}
 }
 
 func Info() *LogEntry {
-	return &LogEntry{logger: getLogger(), level: slog.LevelInfo}
+	return Default().Info()
 }
 
 func Error() *LogEntry {
-	return &LogEntry{logger: getLogger(), level: slog.LevelError}
+	return Default().Error()
 }
 
 func Debug() *LogEntry {
-	return &LogEntry{logger: getLogger(), level: slog.LevelDebug}
+	return Default().Debug()
 }
 
 func Warn() *LogEntry {
-	return &LogEntry{logger: getLogger(), level: slog.LevelWarn}
+	return Default().Warn()
 }
 
 func Fatal() *LogEntry {
-	return &LogEntry{logger: getLogger(), level: slog.LevelError}
+	return Default().Fatal()
 }
 
-func getGoroutineID() uint64 {
-	var buf [64]byte
-	n := runtime.Stack(buf[:], false)
-	idField := strings.Fields(strings.TrimPrefix(string(buf[:n]), "goroutine "))[0]
-	id, _ := strconv.ParseUint(idField, 10, 64)
-	return id
+func Panic() *LogEntry {
+	return Default().Panic()
 }
@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// rotateRetry is how long writes carry on to the current file after a failed
+// rotation before rotation is tried again
+const rotateRetry = time.Minute
+
+// RotateConfig controls file output and rotation.
+type RotateConfig struct {
+	Path       string        // log file path
+	MaxSizeMB  int           // rotate once the file exceeds this size; 0 disables
+	MaxAge     time.Duration // rotate once the file is older than this; 0 disables
+	MaxBackups int           // rotated files to keep; 0 keeps all
+}
+
+// RotatingFile is an io.Writer that appends to a file and rotates it by size
+// and age, pruning old backups beyond the configured retention.
+type RotatingFile struct {
+	mu       sync.Mutex
+	cfg      RotateConfig
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	retryAt  time.Time // no rotation before this, after one failed
+	failing  bool      // the last rotation failed, and was reported
+}
+
+// NewRotatingFile opens (or creates) the log file described by cfg.
+func NewRotatingFile(cfg RotateConfig) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	rf := &RotatingFile{cfg: cfg}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write appends p to the current file, rotating first if a limit was reached.
+// If rotation fails, p still goes to the current file, and rotation is tried
+// again after a while.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.shouldRotate(int64(len(p))) && !time.Now().Before(rf.retryAt) {
+		if err := rf.rotate(); err != nil {
+			// Can't log through ourselves, and once is enough until it recovers
+			if !rf.failing {
+				fmt.Fprintf(os.Stderr, "%v; writing on to %s\n", err, rf.cfg.Path)
+			}
+			rf.failing = true
+			rf.retryAt = time.Now().Add(rotateRetry)
+		} else {
+			rf.failing = false
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Sync flushes the current file to disk.
+func (rf *RotatingFile) Sync() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Sync()
+}
+
+// Close closes the current file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.file.Close()
+}
+
+func (rf *RotatingFile) shouldRotate(incoming int64) bool {
+	if rf.cfg.MaxSizeMB > 0 && rf.size+incoming > int64(rf.cfg.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	if rf.cfg.MaxAge > 0 && time.Since(rf.openedAt) > rf.cfg.MaxAge {
+		return true
+	}
+	return false
+}
+
+// open opens the log file for appending (must be called with lock held)
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one, carrying on
+// with the current file if that fails (must be called with lock held)
+func (rf *RotatingFile) rotate() error {
+	old := rf.file
+	backup := rf.cfg.Path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(rf.cfg.Path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	// open only replaces rf.file once the new file is open
+	if err := rf.open(); err != nil {
+		os.Rename(backup, rf.cfg.Path)
+		return err
+	}
+	old.Close()
+
+	rf.pruneBackups()
+	return nil
+}
+
+// pruneBackups removes the oldest rotated files beyond MaxBackups
+func (rf *RotatingFile) pruneBackups() {
+	if rf.cfg.MaxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(rf.cfg.Path + ".*")
+	if err != nil {
+		return
+	}
+	// Only files rotate named, not others that share the prefix
+	var backups []string
+	for _, path := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(path, rf.cfg.Path+".")); err == nil {
+			backups = append(backups, path)
+		}
+	}
+	if len(backups) <= rf.cfg.MaxBackups {
+		return
+	}
+
+	// Backup suffixes are timestamps, so lexical order is chronological
+	sort.Strings(backups)
+	for _, path := range backups[:len(backups)-rf.cfg.MaxBackups] {
+		os.Remove(path)
+	}
+}
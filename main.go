@@ -2,101 +2,112 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
-	"path/filepath"
-	"strconv"
-	"time"
+	"strings"
 
-	"github.com/autonoma-ai/midway/cache"
-	"github.com/autonoma-ai/midway/handler"
+	"github.com/autonoma-ai/midway/buildinfo"
+	"github.com/autonoma-ai/midway/config"
 	"github.com/autonoma-ai/midway/logger"
-
-	"github.com/aws/aws-sdk-go-v2/config"
 )
 
+const usage = `Usage: midway [command] [flags]
+
+Commands:
+  serve                 Run the caching proxy (default)
+  validate              Check configuration, cache directory and bucket access
+                        before a rollout, exiting non-zero on problems
+  validate-config       Load and validate configuration, then exit
+  simulate LOG...       Replay requests in midway logs against caches of
+                        several sizes and eviction policies
+  bench                 Measure cache directory and S3 download throughput
+  version               Print the build version, commit and date
+  cache ls              List cached entries
+  cache purge KEY...    Remove entries from the cache (--all removes everything)
+  cache import KEY FILE...
+                        Add local files to the cache (--link or --move avoid copying)
+
+Run "midway <command> -h" for command flags.
+`
+
 func main() {
 	ctx := context.Background()
 
 	logger.Init(ctx)
 
-	// Load configuration from environment
-	port := getEnv("PORT", "8900")
-	cacheDir := getEnv("CACHE_DIR", defaultCacheDir())
-	maxSizeGB := getEnvInt("CACHE_MAX_SIZE_GB", 50)
-
-	logger.Info().Emitf("Starting midway service on port %s", port)
-	logger.Info().Emitf("Cache directory: %s", cacheDir)
-	logger.Info().Emitf("Max cache size: %d GB", maxSizeGB)
-
-	// Initialize AWS config
-	awsCfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(getEnv("AWS_REGION", "us-east-1")),
-	)
-	if err != nil {
-		logger.Fatal().Emitf("Failed to load AWS config: %v", err)
-		os.Exit(1)
+	args := os.Args[1:]
+	cmd := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
 
-	// Initialize cache
-	diskCache, err := cache.NewDiskLRUCache(cacheDir, int64(maxSizeGB))
-	if err != nil {
-		logger.Fatal().Emitf("Failed to initialize cache: %v", err)
-		os.Exit(1)
+	switch cmd {
+	case "serve":
+		runServe(ctx, args)
+	case "validate":
+		runValidate(ctx, args)
+	case "validate-config":
+		runValidateConfig(args)
+	case "cache":
+		runCache(args)
+	case "bench":
+		runBench(ctx, args)
+	case "simulate":
+		runSimulate(args)
+	case "version":
+		fmt.Println("midway " + buildinfo.Get().String())
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
 	}
+}
 
-	stats := diskCache.GetStats()
-	logger.Info().Emitf("Cache loaded: %d entries, %.2f MB", stats.EntryCount, float64(stats.TotalBytes)/(1024*1024))
-
-	// Initialize S3 downloader
-	downloader := cache.NewS3Downloader(awsCfg)
-
-	// Initialize handler
-	h := handler.NewHandler(diskCache, downloader)
+// cliFlags holds flags shared by every command. Non-empty values override
+// both the config file and environment variables.
+type cliFlags struct {
+	configPath  string
+	port        string
+	host        string
+	cacheDir    string
+	cacheSizeGB int
+}
 
-	// Setup routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("/health", h.HandleHealth)
-	mux.HandleFunc("/stats", h.HandleStats)
-	mux.HandleFunc("/", h.HandleFile) // Catch-all for file requests
+func newFlagSet(name string) (*flag.FlagSet, *cliFlags) {
+	f := &cliFlags{}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&f.configPath, "config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	fs.StringVar(&f.port, "port", "", "HTTP server port")
+	fs.StringVar(&f.host, "host", "", "IP address, host name or network interface to bind the port to")
+	fs.StringVar(&f.cacheDir, "cache-dir", "", "cache directory path")
+	fs.IntVar(&f.cacheSizeGB, "cache-size-gb", 0, "maximum cache size in gigabytes")
+	return fs, f
+}
 
-	// Start server
-	server := &http.Server{
-		Addr:         fmt.Sprintf(":%s", port),
-		Handler:      mux,
-		ReadTimeout:  10 * time.Minute,
-		WriteTimeout: 10 * time.Minute,
-		IdleTimeout:  60 * time.Second,
+// load builds the effective configuration with flag overrides applied
+func (f *cliFlags) load() (*config.Config, error) {
+	cfg, err := config.Load(f.configPath)
+	if err != nil {
+		return nil, err
 	}
 
-	logger.Info().Emitf("midway service started on :%s", port)
-
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Fatal().Emitf("Server failed: %v", err)
-		os.Exit(1)
+	if f.port != "" {
+		cfg.Server.Port = f.port
 	}
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	if f.host != "" {
+		cfg.Server.Host = f.host
 	}
-	return defaultValue
-}
-
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
+	if f.cacheDir != "" {
+		cfg.Cache.Dir = f.cacheDir
+	}
+	if f.cacheSizeGB != 0 {
+		cfg.Cache.MaxSizeGB = f.cacheSizeGB
 	}
-	return defaultValue
-}
 
-func defaultCacheDir() string {
-	if cacheDir, err := os.UserCacheDir(); err == nil {
-		return filepath.Join(cacheDir, "midway")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
 	}
-	return filepath.Join(os.Getenv("HOME"), ".cache", "midway")
+	return cfg, nil
 }
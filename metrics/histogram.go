@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are latency bucket upper bounds in seconds, covering
+// sub-millisecond memory hits up to multi-minute downloads of large files.
+var DefaultBuckets = []float64{
+	0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5,
+	1, 2.5, 5, 10, 30, 60, 120, 300, 600,
+}
+
+// Histogram records durations into fixed buckets. It is safe for concurrent use.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64 // upper bounds in seconds, ascending
+	counts  []uint64  // per bucket, plus a final +Inf bucket
+	count   uint64
+	sumSecs float64
+}
+
+// Summary is a point-in-time view of a histogram with estimated percentiles.
+type Summary struct {
+	Count uint64  `json:"count"`
+	SumMs float64 `json:"sumMs"`
+	P50Ms float64 `json:"p50Ms"`
+	P95Ms float64 `json:"p95Ms"`
+	P99Ms float64 `json:"p99Ms"`
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds
+// (in seconds). DefaultBuckets is used if bounds is empty.
+func NewHistogram(bounds []float64) *Histogram {
+	if len(bounds) == 0 {
+		bounds = DefaultBuckets
+	}
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe records a single duration.
+func (h *Histogram) Observe(d time.Duration) {
+	secs := d.Seconds()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(h.bounds) && secs > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sumSecs += secs
+}
+
+// Since records the time elapsed since start.
+func (h *Histogram) Since(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// Summary returns the count, sum and estimated p50/p95/p99.
+func (h *Histogram) Summary() Summary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return Summary{
+		Count: h.count,
+		SumMs: h.sumSecs * 1000,
+		P50Ms: h.quantile(0.50) * 1000,
+		P95Ms: h.quantile(0.95) * 1000,
+		P99Ms: h.quantile(0.99) * 1000,
+	}
+}
+
+// quantile estimates the q-th quantile in seconds by linear interpolation
+// within the bucket that contains it (must be called with lock held)
+func (h *Histogram) quantile(q float64) float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	rank := q * float64(h.count)
+	var cumulative uint64
+	for i, c := range h.counts {
+		if float64(cumulative+c) >= rank && c > 0 {
+			lower := 0.0
+			if i > 0 {
+				lower = h.bounds[i-1]
+			}
+			if i == len(h.bounds) {
+				// +Inf bucket: report the largest finite bound
+				return h.bounds[len(h.bounds)-1]
+			}
+			upper := h.bounds[i]
+			fraction := (rank - float64(cumulative)) / float64(c)
+			return lower + (upper-lower)*math.Max(0, math.Min(1, fraction))
+		}
+		cumulative += c
+	}
+	return h.bounds[len(h.bounds)-1]
+}
+
+// snapshot returns cumulative bucket counts, total count and sum in seconds
+func (h *Histogram) snapshot() (bounds []float64, cumulative []uint64, count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative = make([]uint64, len(h.counts))
+	var running uint64
+	for i, c := range h.counts {
+		running += c
+		cumulative[i] = running
+	}
+	return h.bounds, cumulative, h.count, h.sumSecs
+}
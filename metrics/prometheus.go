@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels are Prometheus label name/value pairs.
+type Labels map[string]string
+
+// WriteHelp writes the HELP and TYPE lines for a metric family.
+func WriteHelp(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// WriteValue writes a single counter or gauge sample.
+func WriteValue(w io.Writer, name string, labels Labels, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, "", ""), formatFloat(value))
+}
+
+// WriteHistogram writes the bucket, sum and count samples of a histogram.
+func WriteHistogram(w io.Writer, name string, labels Labels, h *Histogram) {
+	bounds, cumulative, count, sum := h.snapshot()
+
+	for i, bound := range bounds {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, "le", formatFloat(bound)), cumulative[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(labels, "le", "+Inf"), cumulative[len(cumulative)-1])
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labels, "", ""), formatFloat(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labels, "", ""), count)
+}
+
+func formatLabels(labels Labels, extraName, extraValue string) string {
+	if len(labels) == 0 && extraName == "" {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", name, labels[name]))
+	}
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf("%s=%q", extraName, extraValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
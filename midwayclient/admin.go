@@ -0,0 +1,166 @@
+package midwayclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry describes a cached object.
+type Entry struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	AccessTime  time.Time `json:"accessTime"`
+	CreateTime  time.Time `json:"createTime"`
+	AccessCount int64     `json:"accessCount"`
+	Pinned      bool      `json:"pinned"`
+	Checksum    *Checksum `json:"checksum,omitempty"`
+}
+
+// Checksum is the S3 checksum a cached file was verified against.
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// Event is a change to the server's cache contents.
+type Event struct {
+	Type string    `json:"type"` // insert, evict or remove
+	Key  string    `json:"key"`
+	Size int64     `json:"size"`
+	Time time.Time `json:"time"`
+}
+
+// Entries lists cached entries whose keys start with prefix, most recently
+// used first. limit 0 returns all of them.
+func (c *Client) Entries(ctx context.Context, prefix string, limit int) ([]Entry, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/admin/entries", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var entries []Entry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode entries: %w", err)
+	}
+	return entries, nil
+}
+
+// PurgePrefix removes every cached key starting with prefix and returns how
+// many were removed.
+func (c *Client) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	var result struct {
+		Purged int `json:"purged"`
+	}
+	err := c.postJSON(ctx, "/admin/purge", map[string]any{"prefix": prefix}, &result)
+	return result.Purged, err
+}
+
+// PurgeResult is the body of a purge response.
+type PurgeResult struct {
+	Purged     int   `json:"purged"`
+	BytesFreed int64 `json:"bytesFreed"`
+}
+
+// PurgeOlderThan removes every cached key starting with prefix that was
+// cached more than age ago, or regardless of age if age is 0.
+func (c *Client) PurgeOlderThan(ctx context.Context, prefix string, age time.Duration) (PurgeResult, error) {
+	query := url.Values{"prefix": {prefix}}
+	if age > 0 {
+		query.Set("olderThan", age.String())
+	}
+
+	var result PurgeResult
+	resp, err := c.do(ctx, http.MethodDelete, "/admin/purge", query, nil, nil)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return result, responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result, nil
+}
+
+// Pin protects keys from eviction and returns how many were cached.
+func (c *Client) Pin(ctx context.Context, keys ...string) (int, error) {
+	var result struct {
+		Pinned int `json:"pinned"`
+	}
+	err := c.postJSON(ctx, "/admin/pin", map[string]any{"keys": keys}, &result)
+	return result.Pinned, err
+}
+
+// Unpin makes pinned keys evictable again and returns how many were cached.
+func (c *Client) Unpin(ctx context.Context, keys ...string) (int, error) {
+	var result struct {
+		Unpinned int `json:"unpinned"`
+	}
+	err := c.postJSON(ctx, "/admin/unpin", map[string]any{"keys": keys}, &result)
+	return result.Unpinned, err
+}
+
+// Events calls fn for every cache event on the server until ctx is done or
+// the connection drops. Events are dropped by the server if fn can't keep up.
+func (c *Client) Events(ctx context.Context, fn func(Event)) error {
+	resp, err := c.do(ctx, http.MethodGet, "/admin/events", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("failed to decode event: %w", err)
+		}
+		fn(e)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// ReadManifest parses a manifest of cache keys: one bucket/path per line,
+// with blank lines and lines starting with # ignored.
+func ReadManifest(r io.Reader) ([]string, error) {
+	var keys []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, strings.TrimPrefix(line, "/"))
+	}
+	return keys, scanner.Err()
+}
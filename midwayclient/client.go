@@ -0,0 +1,439 @@
+// Package midwayclient is a Go client for the midway caching proxy.
+//
+//	client := midwayclient.New("http://midway.lab:8900", midwayclient.WithToken(token))
+//	err := client.DownloadFile(ctx, "my-bucket", "builds/app.apk", "/tmp/app.apk")
+//
+// Requests that fail with a network error or a 429, 502, 503 or 504 response
+// are retried with exponential backoff. Connections are pooled and reused
+// across requests. Whole files are checked against the SHA-256 the server
+// sends, if it is configured to.
+package midwayclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to one midway server. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken sets the bearer token sent to admin endpoints.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient replaces the default pooled HTTP client.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithRetries sets how many times a failed request is retried (default 3)
+// and the delay before the first retry, which doubles after each attempt
+// (default 500ms).
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New creates a client for the midway server at baseURL, e.g.
+// http://localhost:8900, or unix:/run/midway.sock for a server listening on
+// a Unix socket.
+func New(baseURL string, opts ...Option) *Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	if socket, ok := strings.CutPrefix(baseURL, "unix:"); ok {
+		socket = strings.TrimPrefix(socket, "//")
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		baseURL = "http://midway"
+	}
+
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Transport: transport},
+		retries:    3,
+		backoff:    500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned when the server responds with an unexpected status.
+type Error struct {
+	StatusCode int
+	Code       string // e.g. object_not_found or s3_unavailable; empty from servers that predate error codes
+	Message    string
+	RequestID  string
+	RetryAfter time.Duration // from the Retry-After header, e.g. while an archived object is restored
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("midway responded %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// IsNotFound reports whether err is a 404 from the server.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == http.StatusNotFound
+}
+
+// GetOptions modifies a file request.
+type GetOptions struct {
+	VersionID string // fetch a specific object version
+	Offset    int64  // start reading at this byte
+	Priority  string // high, normal or low: how urgently a miss is needed when S3 downloads are queued
+}
+
+// GetFile returns the contents of bucket/key, fetched through the cache. The
+// caller must close the returned reader. A 202 response for an archived
+// object being restored is returned as an *Error with RetryAfter set. When
+// the server sends the file's SHA-256, reading the whole file fails if its
+// contents don't match it.
+func (c *Client) GetFile(ctx context.Context, bucket, key string, opts *GetOptions) (io.ReadCloser, error) {
+	query := url.Values{}
+	header := http.Header{}
+	if opts != nil {
+		if opts.VersionID != "" {
+			query.Set("versionId", opts.VersionID)
+		}
+		if opts.Offset > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", opts.Offset))
+		}
+		if opts.Priority != "" {
+			header.Set("X-Midway-Priority", opts.Priority)
+		}
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, objectPath(bucket, key), query, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	if want := resp.Header.Get("X-Content-Sha256"); want != "" && resp.StatusCode == http.StatusOK {
+		return &verifyingReader{ReadCloser: resp.Body, want: want, hash: sha256.New()}, nil
+	}
+	return resp.Body, nil
+}
+
+// verifyingReader hashes a file as it is read, and fails the read that
+// reaches its end if the hash doesn't match the one the server sent
+type verifyingReader struct {
+	io.ReadCloser
+	want string
+	hash hash.Hash
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := hex.EncodeToString(v.hash.Sum(nil)); got != v.want {
+			return n, fmt.Errorf("content SHA-256 is %s, server sent %s", got, v.want)
+		}
+	}
+	return n, err
+}
+
+// SHA256 returns the hex SHA-256 of bucket/key's contents, as the server
+// computed it when caching the file. The file is cached first if needed,
+// but not transferred.
+func (c *Client) SHA256(ctx context.Context, bucket, key string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, objectPath(bucket, key), url.Values{"hash": {"sha256"}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp)
+	}
+	var result struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+	return result.Digest, nil
+}
+
+// DownloadFile writes bucket/key to dstPath. The file is written to a
+// temporary file next to dstPath and renamed into place once complete.
+func (c *Client) DownloadFile(ctx context.Context, bucket, key, dstPath string) error {
+	body, err := c.GetFile(ctx, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to download %s/%s: %w", bucket, key, err)
+	}
+	if err := os.Rename(tmp.Name(), dstPath); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	return nil
+}
+
+// PrefetchResult is returned by Prefetch.
+type PrefetchResult struct {
+	Queued int `json:"queued"` // keys being downloaded in the background
+	Cached int `json:"cached"` // keys that were already cached
+}
+
+// Prefetch asks the server to download keys (bucket/path) into its cache in
+// the background. Requires the admin token if one is configured.
+func (c *Client) Prefetch(ctx context.Context, keys ...string) (PrefetchResult, error) {
+	var result PrefetchResult
+	err := c.postJSON(ctx, "/admin/prefetch", map[string]any{"keys": keys}, &result)
+	return result, err
+}
+
+// PreloadOptions filters the objects Preload downloads.
+type PreloadOptions struct {
+	Suffixes []string // only keys ending in one of these, e.g. .apk; empty for all
+	MaxSize  int64    // only objects up to this many bytes, 0 for any size
+}
+
+// PreloadResult is returned by Preload.
+type PreloadResult struct {
+	Listed  int `json:"listed"`  // objects under the prefix
+	Matched int `json:"matched"` // objects that passed the filters
+	Queued  int `json:"queued"`  // matched objects being downloaded in the background
+	Cached  int `json:"cached"`  // matched objects that were already cached
+}
+
+// Preload asks the server to list the objects under prefix (bucket/path/)
+// in S3 and download those matching opts into its cache in the background.
+// Requires the admin token if one is configured.
+func (c *Client) Preload(ctx context.Context, prefix string, opts PreloadOptions) (PreloadResult, error) {
+	var result PreloadResult
+	err := c.postJSON(ctx, "/admin/preload", map[string]any{
+		"prefix":   prefix,
+		"suffixes": opts.Suffixes,
+		"maxSize":  opts.MaxSize,
+	}, &result)
+	return result, err
+}
+
+// Purge removes keys (bucket/path) from the server's cache and returns how
+// many were cached. Requires the admin token if one is configured.
+func (c *Client) Purge(ctx context.Context, keys ...string) (int, error) {
+	var result struct {
+		Purged int `json:"purged"`
+	}
+	err := c.postJSON(ctx, "/admin/purge", map[string]any{"keys": keys}, &result)
+	return result.Purged, err
+}
+
+// Stats is the body of GET /stats.
+type Stats struct {
+	Hits          int64  `json:"hits"`
+	Misses        int64  `json:"misses"`
+	Evictions     int64  `json:"evictions"`
+	TotalBytes    int64  `json:"totalBytes"`
+	MaxBytes      int64  `json:"maxBytes"`
+	EntryCount    int    `json:"entryCount"`
+	MaxEntries    int    `json:"maxEntries"`
+	CacheDir      string `json:"cacheDir"`
+	Policy        string `json:"policy"`
+	MemoryHits    int64  `json:"memoryHits"`
+	MemoryBytes   int64  `json:"memoryBytes"`
+	MemoryEntries int    `json:"memoryEntries"`
+
+	Latency map[string]LatencySummary `json:"latency"` // hit, download, request
+}
+
+// LatencySummary summarizes one latency histogram.
+type LatencySummary struct {
+	Count int64   `json:"count"`
+	SumMs float64 `json:"sumMs"`
+	P50Ms float64 `json:"p50Ms"`
+	P95Ms float64 `json:"p95Ms"`
+	P99Ms float64 `json:"p99Ms"`
+}
+
+// Stats returns the server's cache statistics.
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/stats", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var stats Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// postJSON sends body to an admin endpoint and decodes the response into out
+func (c *Client) postJSON(ctx context.Context, path string, body, out any) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/json"}}
+
+	resp, err := c.do(ctx, http.MethodPost, path, nil, header, raw)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// do sends a request, retrying network errors and retryable statuses
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err == nil && !retryable(resp.StatusCode) {
+			return resp, nil
+		}
+		if attempt >= c.retries || ctx.Err() != nil {
+			return resp, err
+		}
+
+		wait := delay
+		if err == nil {
+			if after := retryAfter(resp); after > 0 {
+				wait = after
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// responseError reads an error response body into an *Error. Bodies that
+// aren't JSON are taken as the message.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		RequestID:  resp.Header.Get("X-Request-Id"),
+		RetryAfter: retryAfter(resp),
+	}
+	var parsed struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"requestId"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && parsed.Code != "" {
+		e.Code, e.Message = parsed.Code, parsed.Message
+		if parsed.RequestID != "" {
+			e.RequestID = parsed.RequestID
+		}
+	}
+	return e
+}
+
+// retryAfter parses a Retry-After header given in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// objectPath escapes bucket/key for use as a request path
+func objectPath(bucket, key string) string {
+	return (&url.URL{Path: "/" + bucket + "/" + strings.TrimPrefix(key, "/")}).EscapedPath()
+}
@@ -0,0 +1,64 @@
+package midwayclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/autonoma-ai/midway/delta"
+)
+
+// DownloadDelta updates oldPath, a local copy of bucket/fromKey, to
+// bucket/key by downloading only a patch between the two, and writes the
+// result to dstPath. If the server can't produce a patch, e.g. because it
+// never cached fromKey, or the patch doesn't apply to oldPath, the whole file
+// is downloaded instead. oldPath and dstPath may be the same file.
+func (c *Client) DownloadDelta(ctx context.Context, bucket, key, fromKey, oldPath, dstPath string) error {
+	err := c.applyDelta(ctx, bucket, key, fromKey, oldPath, dstPath)
+	var e *Error
+	if errors.As(err, &e) && (e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusUnprocessableEntity) ||
+		errors.Is(err, delta.ErrCorrupt) {
+		return c.DownloadFile(ctx, bucket, key, dstPath)
+	}
+	return err
+}
+
+func (c *Client) applyDelta(ctx context.Context, bucket, key, fromKey, oldPath, dstPath string) error {
+	old, err := os.Open(oldPath)
+	if err != nil {
+		return fmt.Errorf("failed to open previous version: %w", err)
+	}
+	defer old.Close()
+
+	query := url.Values{"deltaFrom": {fromKey}}
+	resp, err := c.do(ctx, http.MethodGet, objectPath(bucket, key), query, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), "."+filepath.Base(dstPath)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	err = delta.Apply(old, resp.Body, tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to apply delta to %s: %w", oldPath, err)
+	}
+	if err := os.Rename(tmp.Name(), dstPath); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	return nil
+}
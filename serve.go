@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/autonoma-ai/midway/buildinfo"
+	"github.com/autonoma-ai/midway/logger"
+	"github.com/autonoma-ai/midway/server"
+)
+
+// runServe starts the caching proxy and blocks until the server exits.
+func runServe(ctx context.Context, args []string) {
+	fs, flags := newFlagSet("serve")
+	fs.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		logger.Fatal().Emitf("Failed to load configuration: %v", err)
+	}
+
+	logLevel, _ := logger.ParseLevel(cfg.Log.Level)
+	logger.SetLevel(logLevel)
+
+	if cfg.Log.File != "" {
+		rf, err := logger.NewRotatingFile(logger.RotateConfig{
+			Path:       cfg.Log.File,
+			MaxSizeMB:  cfg.Log.MaxSizeMB,
+			MaxAge:     time.Duration(cfg.Log.MaxAgeHours) * time.Hour,
+			MaxBackups: cfg.Log.MaxBackups,
+		})
+		if err != nil {
+			logger.Fatal().Emitf("Failed to open log file: %v", err)
+		}
+		logger.AddSink(rf)
+	}
+
+	logger.Info().Emitf("Starting midway %s on port %s", buildinfo.Get(), cfg.Server.Port)
+	logger.Info().Emitf("Effective configuration:")
+	for _, line := range cfg.Lines() {
+		logger.Info().Emitf("  %s", line)
+	}
+
+	if cfg.Log.CloudWatch.LogGroup != "" {
+		awsCfg, err := server.LoadAWSConfig(ctx, cfg)
+		if err != nil {
+			logger.Fatal().Emitf("Failed to load AWS config: %v", err)
+		}
+		cw, err := logger.NewCloudWatchSink(ctx, awsCfg, logger.CloudWatchConfig{
+			LogGroup:      cfg.Log.CloudWatch.LogGroup,
+			LogStream:     cfg.Log.CloudWatch.LogStream,
+			BatchSize:     cfg.Log.CloudWatch.BatchSize,
+			FlushInterval: time.Duration(cfg.Log.CloudWatch.FlushSeconds) * time.Second,
+		})
+		if err != nil {
+			logger.Fatal().Emitf("Failed to initialize CloudWatch logs: %v", err)
+		}
+		logger.AddSink(cw)
+		defer func() {
+			cw.Close()
+			// Lines that never reached CloudWatch still get to the other
+			// outputs
+			if dropped := cw.Dropped(); dropped > 0 {
+				logger.Warn().Emitf("Dropped %d log lines bound for CloudWatch", dropped)
+			}
+		}()
+	}
+
+	srv, err := server.New(ctx, cfg)
+	if err != nil {
+		logger.Fatal().Emitf("%s", err)
+	}
+
+	// Reload runtime settings on SIGHUP or POST /admin/reload
+	srv.SetConfigLoader(flags.load)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Info().Emitf("Received SIGHUP, reloading configuration")
+			srv.Reload("signal:SIGHUP")
+		}
+	}()
+
+	// Finish in-flight requests on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := srv.Run(ctx); err != nil {
+		logger.Fatal().Emitf("Server failed: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// LoadAWSConfig loads the SDK configuration S3 and CloudWatch clients are
+// made from.
+func LoadAWSConfig(ctx context.Context, cfg *config.Config) (aws.Config, error) {
+	return awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(cfg.AWS.Region),
+		awsconfig.WithHTTPClient(awsHTTPClient(cfg.AWS.HTTP, cfg.AWS.Proxy)),
+	)
+}
+
+// awsHTTPClient builds the HTTP client for AWS requests. The SDK defaults
+// allow only 10 idle connections per host, which forces reconnects when many
+// ranged downloads run in parallel. Without a configured proxy, the SDK's
+// default of HTTPS_PROXY and NO_PROXY from the environment applies.
+func awsHTTPClient(cfg config.HTTPClientConfig, proxy config.ProxyConfig) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().
+		WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = time.Duration(cfg.ConnectTimeoutSeconds) * time.Second
+		}).
+		WithTransportOptions(func(tr *http.Transport) {
+			tr.MaxIdleConns = cfg.MaxIdleConns
+			tr.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+			tr.ResponseHeaderTimeout = time.Duration(cfg.ReadTimeoutSeconds) * time.Second
+			if proxy.URL != "" {
+				proxyURL, _ := url.Parse(proxy.URL) // validated by config.Load
+				tr.Proxy = cache.ProxyFunc(proxyURL, proxy.NoProxy)
+			}
+			if cfg.TLSSessionReuse {
+				tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+			}
+			if !cfg.HTTP2 {
+				// A non-nil empty map disables HTTP/2
+				tr.ForceAttemptHTTP2 = false
+				tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+			}
+		})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/autonoma-ai/midway/config"
+	"github.com/autonoma-ai/midway/logger"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// LoadEncryptionKey returns the cache encryption key, or nil if encryption
+// is disabled. Without KMS, the key file holds a 256-bit key, raw or base64
+// encoded. With KMS, the key file holds a data key encrypted under the KMS
+// key, which is generated on first use and decrypted through KMS at startup.
+func LoadEncryptionKey(ctx context.Context, cfg *config.Config) ([]byte, error) {
+	enc := cfg.Cache.Encryption
+	if enc.KeyFile == "" {
+		return nil, nil
+	}
+
+	if enc.KMSKeyID != "" {
+		return loadKMSDataKey(ctx, cfg)
+	}
+
+	data, err := os.ReadFile(enc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	if len(data) == 32 {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("encryption key file %s must hold a 256-bit key, raw or base64 encoded", enc.KeyFile)
+	}
+	return key, nil
+}
+
+// loadKMSDataKey decrypts the data key in the key file with KMS, generating
+// and storing a new one if the file doesn't exist yet
+func loadKMSDataKey(ctx context.Context, cfg *config.Config) ([]byte, error) {
+	enc := cfg.Cache.Encryption
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.AWS.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	client := kms.NewFromConfig(awsCfg)
+
+	blob, err := os.ReadFile(enc.KeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		out, err := client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+			KeyId:   aws.String(enc.KMSKeyID),
+			KeySpec: types.DataKeySpecAes256,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate data key: %w", err)
+		}
+		if err := os.WriteFile(enc.KeyFile, out.CiphertextBlob, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write encrypted data key: %w", err)
+		}
+		logger.Info().Emitf("Generated cache data key under KMS key %s in %s", enc.KMSKeyID, enc.KeyFile)
+		return out.Plaintext, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted data key: %w", err)
+	}
+
+	out, err := client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: blob,
+		KeyId:          aws.String(enc.KMSKeyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key with KMS: %w", err)
+	}
+	return out.Plaintext, nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/autonoma-ai/midway/handler"
+)
+
+// Middleware wraps the endpoints of every listener, for checks or
+// instrumentation of a program midway is embedded in.
+type Middleware func(http.Handler) http.Handler
+
+// route is an endpoint registered with Handle or HandleAdmin
+type route struct {
+	pattern string
+	handler http.Handler
+	admin   bool
+}
+
+// Use adds middlewares around every endpoint. They run in the order added,
+// after the request logger, so logger.FromContext gives the request's
+// logger. A middleware that wraps the ResponseWriter should implement
+// Unwrap, so streaming responses can extend their write deadlines. Use
+// must be called before Run or Handler.
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// Handle registers an endpoint alongside files, on every listener that
+// serves them. Patterns are those of http.ServeMux, and take precedence
+// over file requests, so a pattern shadows any bucket of the same name; one
+// that midway already serves panics. Handle must be called before Run or
+// Handler.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.routes = append(s.routes, route{pattern: pattern, handler: h})
+}
+
+// HandleAdmin registers an endpoint alongside the admin endpoints, protected
+// the same way on each listener: with the admin token, open, or not served
+// at all, as the listener's admin access says. HandleAdmin must be called
+// before Run or Handler.
+func (s *Server) HandleAdmin(pattern string, h http.Handler) {
+	s.routes = append(s.routes, route{pattern: pattern, handler: h, admin: true})
+}
+
+// serve builds the handler of listener l
+func (s *Server) serve(l listener) http.Handler {
+	var next http.Handler = routes(s.handler, l, s.cfg.Server, s.routes)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		next = s.middlewares[i](next)
+	}
+	return s.handler.WithRequestLogger(next)
+}
+
+// SetKeyResolver replaces how the paths of file requests are mapped to S3
+// objects, which by default is bucket/key after the configured rewrites
+// and bucket aliases.
+func (s *Server) SetKeyResolver(r handler.KeyResolver) {
+	s.handler.SetKeyResolver(r)
+}
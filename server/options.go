@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/url"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/config"
+)
+
+// CacheOptions translates configuration into cache construction options.
+// encryptionKey is the key from LoadEncryptionKey, nil to disable encryption.
+func CacheOptions(cfg *config.Config, encryptionKey []byte) []cache.Option {
+	policy, _ := cache.NewPolicy(cfg.Cache.Policy) // validated by config.Load
+	opts := []cache.Option{
+		cache.WithPolicy(policy),
+		cache.WithMaxEntries(cfg.Cache.MaxEntries),
+	}
+
+	if len(cfg.Cache.EvictionRules) > 0 {
+		rules := make([]cache.EvictionRule, 0, len(cfg.Cache.EvictionRules))
+		for _, rule := range cfg.Cache.EvictionRules {
+			action, _ := cache.ParseEvictionAction(rule.Action) // validated by config.Load
+			rules = append(rules, cache.EvictionRule{Pattern: rule.Pattern, Action: action})
+		}
+		opts = append(opts, cache.WithEvictionRules(rules))
+	}
+
+	if cfg.Cache.MemoryMB > 0 {
+		opts = append(opts, cache.WithMemoryTier(
+			int64(cfg.Cache.MemoryMB)*1024*1024,
+			int64(cfg.Cache.MemoryMaxEntryKB)*1024,
+			int64(cfg.Cache.MemoryPromoteAfter),
+		))
+	}
+
+	if encryptionKey != nil {
+		opts = append(opts, cache.WithEncryption(encryptionKey))
+	}
+	if cfg.Cache.Compress {
+		opts = append(opts, cache.WithCompression())
+	}
+	if cfg.Cache.Fsync {
+		opts = append(opts, cache.WithFsync())
+	}
+	if cfg.Cache.TrashRetentionHours > 0 {
+		opts = append(opts, cache.WithTrash(time.Duration(cfg.Cache.TrashRetentionHours)*time.Hour))
+	}
+	if cfg.Cache.Janitor.QuietHours != "" {
+		hours, _ := cache.ParseQuietHours(cfg.Cache.Janitor.QuietHours) // validated by config.Load
+		opts = append(opts, cache.WithJanitor(hours))
+	}
+	if cfg.Cache.Cold.Dir != "" {
+		opts = append(opts, cache.WithColdTier(cfg.Cache.Cold.Dir, int64(cfg.Cache.Cold.MaxSizeGB)*1024*1024*1024))
+	}
+	if len(cfg.Cache.Dirs) > 0 {
+		volumes := make([]cache.Volume, 0, len(cfg.Cache.Dirs))
+		for _, dir := range cfg.Cache.Dirs {
+			volumes = append(volumes, cache.Volume{Dir: dir.Path, MaxSize: int64(dir.MaxSizeGB) * 1024 * 1024 * 1024})
+		}
+		opts = append(opts, cache.WithVolumes(volumes))
+	}
+	if len(cfg.Cache.Storage) > 0 {
+		tiers := make([]cache.StorageTier, 0, len(cfg.Cache.Storage))
+		for _, tier := range cfg.Cache.Storage {
+			tiers = append(tiers, cache.StorageTier{
+				Name:    tier.Name,
+				Dirs:    tier.Dirs,
+				MinSize: int64(tier.MinEntrySizeMB) * 1024 * 1024,
+				MaxSize: int64(tier.MaxEntrySizeMB) * 1024 * 1024,
+			})
+		}
+		opts = append(opts, cache.WithStorageTiers(tiers))
+	}
+
+	return opts
+}
+
+// DownloaderOptions translates configuration into S3 downloader options.
+func DownloaderOptions(cfg *config.Config) []cache.DownloaderOption {
+	opts := []cache.DownloaderOption{
+		cache.WithBandwidthLimit(bandwidthLimits(cfg)),
+	}
+	if len(cfg.AWS.Proxy.Buckets) > 0 {
+		proxies := make([]cache.BucketProxy, 0, len(cfg.AWS.Proxy.Buckets))
+		for _, proxy := range cfg.AWS.Proxy.Buckets {
+			var proxyURL *url.URL
+			if proxy.URL != "direct" {
+				proxyURL, _ = url.Parse(proxy.URL) // validated by config.Load
+			}
+			proxies = append(proxies, cache.BucketProxy{Pattern: proxy.Bucket, URL: proxyURL})
+		}
+		opts = append(opts, cache.WithBucketProxies(proxies, cfg.AWS.Proxy.NoProxy))
+	}
+	if len(cfg.AWS.AccelerateBuckets) > 0 {
+		opts = append(opts, cache.WithAccelerate(cfg.AWS.AccelerateBuckets))
+	}
+	if len(cfg.AWS.DualStackBuckets) > 0 {
+		opts = append(opts, cache.WithDualStack(cfg.AWS.DualStackBuckets))
+	}
+	if breaker := cfg.AWS.CircuitBreaker; breaker.Failures > 0 {
+		opts = append(opts, cache.WithCircuitBreaker(breaker.Failures, time.Duration(breaker.CoolDownSeconds)*time.Second))
+	}
+	if len(cfg.AWS.BucketRegions) > 0 {
+		opts = append(opts, cache.WithBucketRegions(cfg.AWS.BucketRegions))
+	}
+	if len(cfg.AWS.Roles) > 0 {
+		roles := make([]cache.BucketRole, 0, len(cfg.AWS.Roles))
+		for _, role := range cfg.AWS.Roles {
+			roles = append(roles, cache.BucketRole{
+				Pattern:    role.Bucket,
+				RoleARN:    role.RoleARN,
+				ExternalID: role.ExternalID,
+			})
+		}
+		opts = append(opts, cache.WithBucketRoles(roles))
+	}
+	if len(cfg.AWS.CustomerKeys) > 0 {
+		keys := make([]cache.CustomerKey, 0, len(cfg.AWS.CustomerKeys))
+		for _, key := range cfg.AWS.CustomerKeys {
+			raw, _ := base64.StdEncoding.DecodeString(key.Key) // validated by config.Load
+			keys = append(keys, cache.CustomerKey{Pattern: key.Bucket, Key: raw})
+		}
+		opts = append(opts, cache.WithCustomerKeys(keys))
+	}
+	return opts
+}
+
+// bandwidthLimits converts the configured MB/s caps to bytes per second
+func bandwidthLimits(cfg *config.Config) (total, perRequest int64) {
+	return int64(cfg.AWS.MaxBandwidthMBps * 1024 * 1024), int64(cfg.AWS.MaxRequestBandwidthMBps * 1024 * 1024)
+}
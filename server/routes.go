@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/cluster"
+	"github.com/autonoma-ai/midway/config"
+	"github.com/autonoma-ai/midway/handler"
+)
+
+// listener is an address to serve on and the endpoints served there
+type listener struct {
+	config.ListenerConfig
+	files      bool // files and the internal peer API
+	management bool // stats, metrics, and admin and debug endpoints
+}
+
+func (l listener) describe() string {
+	switch {
+	case !l.files:
+		return "management only, admin endpoints: " + adminAccess(l.Admin)
+	case !l.management:
+		return "files only"
+	}
+	return "admin endpoints: " + adminAccess(l.Admin)
+}
+
+// routes builds the endpoints served on a listener, including the custom
+// ones registered with Handle and HandleAdmin. Its admin access is how
+// admin and debug endpoints are protected there: token requires the admin
+// token, none leaves them open, for listeners only trusted clients can
+// reach, and off doesn't serve them at all. Without an admin token, token
+// access refuses admin endpoints and doesn't serve debug ones. Files and
+// other streaming responses are bounded by the stall timeout, everything
+// else by the server's control timeout.
+func routes(h *handler.Handler, l listener, cfg config.ServerConfig, custom []route) *http.ServeMux {
+	t := cfg.Timeouts
+	streaming := func(next http.HandlerFunc) http.HandlerFunc {
+		stall := time.Duration(t.StallSeconds) * time.Second
+		return handler.WithStallTimeout(stall, time.Duration(t.FileMaxSeconds)*time.Second, next)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", h.HandleHealth)
+	mux.HandleFunc("/readyz", h.HandleReady)
+	mux.HandleFunc("/version", h.HandleVersion)
+
+	if l.files {
+		mux.HandleFunc(cluster.PeerPath, streaming(h.HandlePeer))
+		mux.HandleFunc(cluster.StatsPath, h.HandlePeerStats)
+		mux.HandleFunc("/", streaming(h.AllowCORS(h.CompressResponses(h.HandleFile)))) // Catch-all for file requests
+		for _, r := range custom {
+			if !r.admin {
+				mux.Handle(r.pattern, r.handler)
+			}
+		}
+	}
+	if !l.management {
+		// Not served here, rather than treated as file requests
+		for _, pattern := range []string{"/stats", "/stats/", "/metrics", "/admin/", "/debug/", "/ui", "/ui/", "/events"} {
+			mux.HandleFunc(pattern, handler.NotFound)
+		}
+		notFound(mux, custom)
+		return mux
+	}
+
+	mux.HandleFunc("/stats", h.AllowCORS(h.CompressResponses(h.HandleStats)))
+	mux.HandleFunc("/stats/cluster", h.AllowCORS(h.CompressResponses(h.HandleClusterStats)))
+	mux.HandleFunc("/metrics", h.HandleMetrics)
+
+	protect := h.RequireAdmin
+	switch adminAccess(l.Admin) {
+	case "off":
+		// Not served here, rather than treated as file requests
+		mux.HandleFunc("/admin/", handler.NotFound)
+		mux.HandleFunc("/debug/", handler.NotFound)
+		mux.HandleFunc("/ui", handler.NotFound)
+		mux.HandleFunc("/ui/", handler.NotFound)
+		mux.HandleFunc("/events", handler.NotFound)
+		notFound(mux, custom)
+		return mux
+	case "none":
+		protect = func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
+	mux.HandleFunc("/admin/reload", protect(h.HandleReload))
+	mux.HandleFunc("/admin/cache/resize", protect(h.HandleResize))
+	mux.HandleFunc("/admin/downloads", protect(h.HandleDownloads))
+	mux.HandleFunc("/admin/restores", protect(h.HandleRestores))
+	mux.HandleFunc("/admin/prefetch", protect(h.HandlePrefetch))
+	mux.HandleFunc("/admin/preload", protect(h.HandlePreload))
+	mux.HandleFunc("/admin/purge", h.AllowNamespace(protect, h.HandlePurge))
+	mux.HandleFunc("/admin/entries", streaming(h.AllowNamespace(protect, h.CompressResponses(h.HandleEntries))))
+	mux.HandleFunc("/admin/trash", h.AllowNamespace(protect, h.CompressResponses(h.HandleTrash)))
+	mux.HandleFunc("/admin/trash/restore", h.AllowNamespace(protect, h.HandleTrashRestore))
+	mux.HandleFunc("/admin/namespaces", protect(h.HandleNamespaces))
+	mux.HandleFunc("/admin/pin", protect(h.HandlePin))
+	mux.HandleFunc("/admin/unpin", protect(h.HandleUnpin))
+	mux.HandleFunc("/admin/events", streaming(protect(h.HandleEvents)))
+	mux.HandleFunc("/admin/audit", protect(h.HandleAudit))
+	mux.HandleFunc("/ui", h.HandleUI)
+	mux.HandleFunc("/ui/", h.HandleUI)
+	mux.HandleFunc("/ui/data", protect(h.CompressResponses(h.HandleUIData)))
+	mux.HandleFunc("/events", streaming(protect(h.HandleActivity)))
+	if adminAccess(l.Admin) == "token" && cfg.AdminToken == "" {
+		// Not served without a token to require, even once a reload sets one
+		mux.HandleFunc("/debug/", handler.NotFound)
+	} else {
+		h.RegisterDebug(mux, protect, streaming)
+	}
+	for _, r := range custom {
+		if r.admin {
+			mux.HandleFunc(r.pattern, protect(r.handler.ServeHTTP))
+		}
+	}
+	return mux
+}
+
+// notFound registers the custom admin endpoints as not found, on a
+// listener that doesn't serve admin endpoints, rather than leaving them to
+// be treated as file requests
+func notFound(mux *http.ServeMux, custom []route) {
+	for _, r := range custom {
+		if r.admin {
+			mux.HandleFunc(r.pattern, handler.NotFound)
+		}
+	}
+}
+
+// tokenAccess reports whether any listener of cfg protects its admin
+// endpoints with the admin token
+func tokenAccess(cfg config.ServerConfig) bool {
+	if adminAccess(cfg.Admin) == "token" {
+		return true
+	}
+	for _, l := range cfg.Listeners {
+		if adminAccess(l.Admin) == "token" {
+			return true
+		}
+	}
+	return false
+}
+
+// adminAccess returns a listener's admin access, which defaults to token
+func adminAccess(admin string) string {
+	if admin == "" {
+		return "token"
+	}
+	return admin
+}
+
+// listen opens a listener's address. A stale Unix socket left by a previous
+// run is replaced.
+func listen(l config.ListenerConfig) (net.Listener, error) {
+	network, address := config.ListenAddress(l.Address)
+	if network != "unix" {
+		return net.Listen(config.TCPNetwork(l.IPFamily), address)
+	}
+
+	if info, err := os.Lstat(address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(address)
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if l.SocketMode != "" {
+		mode, _ := strconv.ParseUint(l.SocketMode, 8, 32)
+		if err := os.Chmod(address, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// hostAddresses resolves the host port is bound to into the hosts to listen
+// on. A network interface name stands for its addresses in family, skipping
+// link-local ones; anything else is listened on as given.
+func hostAddresses(host, family string) ([]string, error) {
+	host = strings.Trim(host, "[]")
+	if host == "" || net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	iface, err := net.InterfaceByName(host)
+	if err != nil {
+		// A host name, resolved by net.Listen
+		return []string{host}, nil
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses of %s: %w", host, err)
+	}
+	var hosts []string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipv4 := ipnet.IP.To4() != nil; (family == "ipv4" && !ipv4) || (family == "ipv6" && ipv4) {
+			continue
+		}
+		hosts = append(hosts, ipnet.IP.String())
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("interface %s has no usable addresses for ipFamily %s", host, family)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,350 @@
+// Package server assembles midway's caching proxy from its configuration,
+// so it can run as the midway command or embedded in another Go service.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/cluster"
+	"github.com/autonoma-ai/midway/config"
+	"github.com/autonoma-ai/midway/handler"
+	"github.com/autonoma-ai/midway/logger"
+	"github.com/autonoma-ai/midway/midwayclient"
+	"github.com/autonoma-ai/midway/signing"
+)
+
+// shutdownTimeout bounds how long Run waits for in-flight requests once its
+// context is done
+const shutdownTimeout = 30 * time.Second
+
+// Server is a caching proxy built from a configuration: the S3 downloader,
+// the cache, the handler serving them, and the listeners and background jobs
+// the configuration asks for.
+type Server struct {
+	cfg        *config.Config
+	downloader *cache.S3Downloader
+	cache      cache.Cache
+	handler    *handler.Handler
+	peers      *cluster.Cluster
+	index      *cluster.RedisIndex
+
+	middlewares []Middleware
+	routes      []route
+
+	mu   sync.Mutex
+	load func() (*config.Config, error)
+}
+
+// New builds a server from cfg, which should have been validated. It opens
+// the cache directory and connects to what the configuration names, but
+// serves nothing and starts no background jobs until Run. midway logs to
+// stdout; add other destinations with logger.AddSink.
+func New(ctx context.Context, cfg *config.Config) (*Server, error) {
+	// A no-op unless midway is embedded in a program that hasn't logged yet
+	logger.Init(ctx)
+
+	awsCfg, err := LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	s := &Server{
+		cfg:        cfg,
+		downloader: cache.NewS3Downloader(awsCfg, DownloaderOptions(cfg)...),
+	}
+
+	encryptionKey, err := LoadEncryptionKey(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cache encryption key: %w", err)
+	}
+	opts := CacheOptions(cfg, encryptionKey)
+	if len(cfg.Cache.Verify.Certificates) > 0 {
+		certs, err := signing.LoadCertificates(cfg.Cache.Verify.Certificates)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load trusted signing certificates: %w", err)
+		}
+		opts = append(opts, cache.WithVerifier(signing.New(signing.Config{
+			Certificates: certs,
+			Buckets:      cfg.Cache.Verify.Buckets,
+			Detached:     cfg.Cache.Verify.Detached,
+			FetchSignature: func(ctx context.Context, key string) (io.ReadCloser, error) {
+				body, _, err := s.downloader.Download(ctx, key)
+				return body, err
+			},
+		})))
+		logger.Info().Emitf("Verifying artifact signatures against %d trusted certificates", len(certs))
+	}
+	if cfg.Cache.DryRun {
+		// Nothing is cached, so nothing is written to the cache directory
+		s.cache = cache.NewMemoryCache(0)
+	} else {
+		s.cache, err = cache.NewDiskLRUCache(cfg.Cache.Dir, int64(cfg.Cache.MaxSizeGB), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize cache: %w", err)
+		}
+		stats := s.cache.GetStats()
+		logger.Info().Emitf("Cache loaded: %d entries, %.2f MB", stats.EntryCount, float64(stats.TotalBytes)/(1024*1024))
+	}
+
+	s.handler = handler.NewHandler(s.cache, s.downloader)
+	s.handler.ApplySettings(handlerSettings(cfg))
+	if cfg.Server.AdminToken == "" && tokenAccess(cfg.Server) {
+		logger.Warn().Emitf("No admin token set: admin endpoints behind token access refuse every request, and debug endpoints there are not served")
+	}
+	if cfg.Cache.DryRun {
+		policy, _ := cache.NewPolicy(cfg.Cache.Policy) // validated by config.Load
+		s.handler.SetDryRun(cache.NewSimulation(int64(cfg.Cache.MaxSizeGB)*1024*1024*1024, policy))
+		logger.Info().Emitf("Dry run: streaming files from S3 and recording what a %d GB %s cache would hold", cfg.Cache.MaxSizeGB, policy.Name())
+	}
+	if cfg.Server.AuditLog != "" {
+		if err := s.handler.OpenAuditLog(cfg.Server.AuditLog); err != nil {
+			return nil, fmt.Errorf("failed to initialize audit log: %w", err)
+		}
+		logger.Info().Emitf("Recording admin actions in %s", cfg.Server.AuditLog)
+	}
+	if !cfg.Cache.DryRun {
+		if err := s.handler.OpenRetryQueue(filepath.Join(cfg.Cache.Dir, "retry-queue.json")); err != nil {
+			return nil, fmt.Errorf("failed to initialize retry queue: %w", err)
+		}
+	}
+
+	if len(cfg.Cluster.Peers) > 0 || cfg.Cluster.SRVRecord != "" {
+		s.peers = cluster.New(cluster.Config{
+			Peers: cfg.Cluster.Peers,
+			Self:  cfg.Cluster.Self,
+			Token: cfg.Cluster.Token,
+
+			ConsistentHash: cfg.Cluster.ConsistentHash,
+		})
+		s.handler.SetCluster(s.peers)
+		logger.Info().Emitf("Cluster mode: %d peers, consistent hashing %t", len(s.peers.Peers()), s.peers.ConsistentHash())
+	}
+
+	if cfg.Cluster.Redis.Addr != "" {
+		node := cfg.Cluster.Self
+		if node == "" {
+			hostname, _ := os.Hostname()
+			node = "http://" + net.JoinHostPort(hostname, cfg.Server.Port)
+		}
+		s.index, err = cluster.NewRedisIndex(ctx, cluster.RedisConfig{
+			Addr:     cfg.Cluster.Redis.Addr,
+			Password: cfg.Cluster.Redis.Password,
+			DB:       cfg.Cluster.Redis.DB,
+			Prefix:   cfg.Cluster.Redis.Prefix,
+			Node:     node,
+			TTL:      time.Duration(cfg.Cluster.Redis.TTLSeconds) * time.Second,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Redis index: %w", err)
+		}
+		logger.Info().Emitf("Publishing cache index to Redis at %s as %s", cfg.Cluster.Redis.Addr, node)
+	}
+	return s, nil
+}
+
+// SetConfigLoader registers how the configuration is reloaded, by Reload
+// and POST /admin/reload. Without one, reloading isn't supported.
+func (s *Server) SetConfigLoader(load func() (*config.Config, error)) {
+	s.mu.Lock()
+	s.load = load
+	s.mu.Unlock()
+	s.handler.SetReloadFunc(s.reload)
+}
+
+// Reload loads the configuration again and applies what can change without
+// a restart, recording it in the audit log as done by actor.
+func (s *Server) Reload(actor string) error {
+	record := handler.AuditRecord{Action: "reload", Actor: actor}
+	err := s.reload()
+	if err != nil {
+		logger.Error().Emitf("Config reload failed: %v", err)
+		record.Error = err.Error()
+	}
+	s.handler.Audit(record)
+	return err
+}
+
+func (s *Server) reload() error {
+	s.mu.Lock()
+	load := s.load
+	s.mu.Unlock()
+	if load == nil {
+		return errors.New("no configuration loader")
+	}
+
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+	s.apply(cfg)
+	return nil
+}
+
+// Handler returns the endpoints served on the configured port, for serving
+// the proxy from another program's HTTP server instead of Run's listeners.
+// Background jobs such as retries, refreshes and peer discovery only run
+// under Run, or RunBackground.
+func (s *Server) Handler() http.Handler {
+	return s.serve(s.portListener(""))
+}
+
+// portListener describes the listener on the port, bound to host
+func (s *Server) portListener(host string) listener {
+	cfg := s.cfg.Server
+	return listener{
+		ListenerConfig: config.ListenerConfig{Address: net.JoinHostPort(host, cfg.Port), Admin: cfg.Admin, IPFamily: cfg.IPFamily},
+		files:          true,
+		management:     cfg.AdminAddress == "",
+	}
+}
+
+// RunBackground starts the background jobs the configuration asks for, such
+// as download retries, cache warm-up, refreshes and alerts, until ctx is
+// done. Run calls it; call it directly only when serving Handler yourself.
+func (s *Server) RunBackground(ctx context.Context) {
+	cfg := s.cfg
+	h := s.handler
+
+	go h.RunRetries(ctx)
+	if s.peers != nil && cfg.Cluster.SRVRecord != "" {
+		interval := time.Duration(cfg.Cluster.DiscoveryIntervalSeconds) * time.Second
+		go s.peers.DiscoverSRV(ctx, cfg.Cluster.SRVRecord, interval, cfg.Server.Port)
+	}
+	if s.index != nil {
+		go s.index.Run(ctx, s.cache)
+	}
+
+	if cfg.Cache.Warmup.Manifest != "" {
+		go func() {
+			keys, err := loadManifest(ctx, cfg.Cache.Warmup.Manifest, s.downloader)
+			if err != nil {
+				logger.Error().Emitf("Failed to load warm-up manifest: %v", err)
+				return
+			}
+			h.Warmup(ctx, keys, cfg.Cache.Warmup.Concurrency)
+		}()
+	}
+
+	for _, job := range cfg.Cache.Refresh {
+		go h.RunRefresh(ctx, handler.RefreshJob{
+			Pattern:  strings.TrimPrefix(job.Pattern, "s3://"),
+			Interval: time.Duration(job.IntervalMinutes) * time.Minute,
+		})
+		logger.Info().Emitf("Refreshing %s every %d minutes", job.Pattern, job.IntervalMinutes)
+	}
+
+	go h.RunAlerts(ctx)
+}
+
+// Run starts the background jobs and serves on the port and any other
+// configured listeners until ctx is done, then shuts the listeners down,
+// letting in-flight requests finish. It returns nil after a shutdown, or the
+// error that stopped a listener.
+func (s *Server) Run(ctx context.Context) error {
+	cfg := s.cfg
+
+	// The port plus any additional listeners, each with its own admin access.
+	// With an admin address, management endpoints move off the port to it.
+	portAddresses, err := hostAddresses(cfg.Server.Host, cfg.Server.IPFamily)
+	if err != nil {
+		return fmt.Errorf("failed to resolve server.host: %w", err)
+	}
+	var listeners []listener
+	for _, host := range portAddresses {
+		listeners = append(listeners, s.portListener(host))
+	}
+	for _, l := range cfg.Server.Listeners {
+		listeners = append(listeners, listener{ListenerConfig: l, files: true, management: true})
+	}
+	if cfg.Server.AdminAddress != "" {
+		listeners = append(listeners, listener{
+			ListenerConfig: config.ListenerConfig{Address: cfg.Server.AdminAddress, Admin: cfg.Server.Admin},
+			management:     true,
+		})
+	}
+
+	var servers []*http.Server
+	defer func() {
+		for _, server := range servers {
+			server.Close()
+		}
+	}()
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		ln, err := listen(l.ListenerConfig)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", l.Address, err)
+		}
+		// The read and write timeouts bound control endpoints; streaming
+		// routes replace them with a stall timeout
+		t := cfg.Server.Timeouts
+		server := &http.Server{
+			Handler:           s.serve(l),
+			ReadHeaderTimeout: time.Duration(t.ReadHeaderSeconds) * time.Second,
+			ReadTimeout:       time.Duration(t.ControlSeconds) * time.Second,
+			WriteTimeout:      time.Duration(t.ControlSeconds) * time.Second,
+			IdleTimeout:       time.Duration(t.IdleSeconds) * time.Second,
+		}
+		servers = append(servers, server)
+		go func() {
+			errs <- fmt.Errorf("%s: %w", l.Address, server.Serve(ln))
+		}()
+		logger.Info().Emitf("midway service started on %s (%s)", l.Address, l.describe())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.RunBackground(ctx)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info().Emitf("Shutting down")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadManifest reads the keys listed in a local manifest file, or in an S3
+// object when location is s3://bucket/key
+func loadManifest(ctx context.Context, location string, d *cache.S3Downloader) ([]string, error) {
+	var r io.ReadCloser
+	if key, ok := strings.CutPrefix(location, "s3://"); ok {
+		body, _, err := d.Download(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		r = body
+	} else {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+
+	keys, err := midwayclient.ReadManifest(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", location, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/config"
+	"github.com/autonoma-ai/midway/handler"
+	"github.com/autonoma-ai/midway/logger"
+)
+
+// handlerSettings translates configuration into the handler's runtime settings
+func handlerSettings(cfg *config.Config) handler.Settings {
+	return handler.Settings{
+		AdminToken:     cfg.Server.AdminToken,
+		AllowedBuckets: cfg.Server.AllowedBuckets,
+		RateLimit:      cfg.Server.RateLimit,
+		RateBurst:      cfg.Server.RateBurst,
+		ChunkThreshold: int64(cfg.Cache.ChunkThresholdMB) * 1024 * 1024,
+		ChunkSize:      int64(cfg.Cache.ChunkSizeMB) * 1024 * 1024,
+		DeltaMaxSize:   int64(cfg.Cache.DeltaMaxSizeMB) * 1024 * 1024,
+
+		CacheDecompressed: cfg.Cache.Decompressed,
+
+		MaxDownloads:       cfg.Server.MaxDownloads,
+		MaxQueuedDownloads: cfg.Server.MaxQueuedDownloads,
+
+		CompleteOnDisconnect: cfg.Server.CompleteOnDisconnect,
+		CompressResponses:    cfg.Server.CompressResponses,
+		ContentSHA256:        cfg.Server.ContentSHA256,
+		ServeStale:           cfg.Server.ServeStale,
+		TrustProxy:           cfg.Server.TrustProxy,
+
+		RestoreArchived: cfg.AWS.Restore.Enabled,
+		RestoreDays:     cfg.AWS.Restore.Days,
+		RestoreTier:     cfg.AWS.Restore.Tier,
+
+		LatestAlias: cfg.Server.Latest.Alias,
+		LatestOrder: cfg.Server.Latest.Order,
+		LatestTTL:   time.Duration(cfg.Server.Latest.TTLSeconds) * time.Second,
+
+		BasePath: strings.TrimRight(cfg.Server.BasePath, "/"),
+		Rewrites: rewrites(cfg.Server.Rewrites),
+
+		BucketAliases: cfg.Server.BucketAliases,
+		DefaultBucket: cfg.Server.DefaultBucket,
+
+		CORSOrigins: cfg.Server.CORS.AllowedOrigins,
+		CORSMethods: cfg.Server.CORS.AllowedMethods,
+		CORSHeaders: cfg.Server.CORS.AllowedHeaders,
+		CORSMaxAge:  time.Duration(cfg.Server.CORS.MaxAgeSeconds) * time.Second,
+
+		CacheHeaders: cacheHeaders(cfg.Server.CacheHeaders),
+
+		Namespaces: namespaces(cfg.Cache.Namespaces),
+
+		AlertMinHitRate:     cfg.Server.Alerts.MinHitRatePercent,
+		AlertHitRateWindow:  time.Duration(cfg.Server.Alerts.HitRateWindowMinutes) * time.Minute,
+		AlertMaxDiskPercent: cfg.Server.Alerts.MaxDiskPercent,
+		AlertWebhook:        cfg.Server.Alerts.WebhookURL,
+	}
+}
+
+// cacheHeaders converts the configured caching header rules
+func cacheHeaders(rules []config.CacheHeaderRule) []handler.CacheHeaderRule {
+	converted := make([]handler.CacheHeaderRule, 0, len(rules))
+	for _, rule := range rules {
+		converted = append(converted, handler.CacheHeaderRule{
+			Prefix:                strings.TrimPrefix(rule.Prefix, "/"),
+			CacheControl:          rule.CacheControl,
+			VersionedCacheControl: rule.VersionedCacheControl,
+			Expires:               time.Duration(rule.ExpiresSeconds) * time.Second,
+		})
+	}
+	return converted
+}
+
+// namespaces converts the configured namespaces
+func namespaces(configs []config.NamespaceConfig) []handler.Namespace {
+	converted := make([]handler.Namespace, 0, len(configs))
+	for _, ns := range configs {
+		converted = append(converted, handler.Namespace{
+			Name:       ns.Name,
+			Tokens:     ns.Tokens,
+			PathPrefix: strings.TrimRight(ns.PathPrefix, "/"),
+			MaxBytes:   int64(ns.MaxSizeGB) * 1024 * 1024 * 1024,
+		})
+	}
+	return converted
+}
+
+// rewrites compiles the configured rewrite rules, which Validate has checked
+func rewrites(rules []config.RewriteRule) []handler.Rewrite {
+	compiled := make([]handler.Rewrite, 0, len(rules))
+	for _, rule := range rules {
+		compiled = append(compiled, handler.Rewrite{
+			Pattern:     regexp.MustCompile(rule.Match),
+			Replacement: rule.Replace,
+		})
+	}
+	return compiled
+}
+
+// apply applies the subset of configuration that can change without a
+// restart: log level, cache size and entry limits, S3 bandwidth limits, and
+// handler settings. Other changes (port, cache directory, log outputs) are
+// ignored until restart.
+func (s *Server) apply(cfg *config.Config) {
+	logLevel, _ := logger.ParseLevel(cfg.Log.Level)
+	logger.SetLevel(logLevel)
+
+	maxBytes := int64(cfg.Cache.MaxSizeGB) * 1024 * 1024 * 1024
+	if maxBytes != s.cache.GetStats().MaxBytes {
+		evicted := s.cache.Resize(maxBytes)
+		logger.Info().Emitf("Cache limit changed to %d GB (%d entries evicted)", cfg.Cache.MaxSizeGB, evicted)
+	}
+	if cfg.Cache.MaxEntries != s.cache.GetStats().MaxEntries {
+		evicted := s.cache.SetMaxEntries(cfg.Cache.MaxEntries)
+		logger.Info().Emitf("Cache entry limit changed to %d (%d entries evicted)", cfg.Cache.MaxEntries, evicted)
+	}
+
+	s.downloader.SetBandwidthLimit(bandwidthLimits(cfg))
+	s.handler.ApplySettings(handlerSettings(cfg))
+	logger.Info().Emitf("Configuration reloaded")
+}
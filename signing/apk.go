@@ -0,0 +1,372 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers crypto.SHA256
+	_ "crypto/sha512" // registers crypto.SHA512
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoAPKSignature is returned by VerifyAPK for files without an APK
+// Signature Scheme v2 or v3 signature, e.g. ones signed with v1 (JAR) only.
+var ErrNoAPKSignature = errors.New("no APK v2 or v3 signature")
+
+// IDs of the signature blocks in the APK Signing Block
+const (
+	apkSignatureSchemeV2 = 0x7109871a
+	apkSignatureSchemeV3 = 0xf05368c0
+)
+
+// apkSigBlockMagic ends the APK Signing Block, which sits right before the
+// zip central directory
+const apkSigBlockMagic = "APK Sig Block 42"
+
+// maxSigningBlockSize bounds how much of the APK is read as signing block
+const maxSigningBlockSize = 16 * 1024 * 1024
+
+// apkAlgorithm is a signature algorithm of APK Signature Scheme v2 and v3
+type apkAlgorithm struct {
+	hash crypto.Hash // of the signed data and of the content chunks
+	pss  bool        // RSASSA-PSS rather than PKCS#1 v1.5, for RSA keys
+}
+
+// apkAlgorithms are the supported algorithm IDs. DSA and the verity variants
+// are not supported; signers using only those are rejected.
+var apkAlgorithms = map[uint32]apkAlgorithm{
+	0x0101: {hash: crypto.SHA256, pss: true}, // RSASSA-PSS with SHA-256
+	0x0102: {hash: crypto.SHA512, pss: true}, // RSASSA-PSS with SHA-512
+	0x0103: {hash: crypto.SHA256},            // RSASSA-PKCS1-v1_5 with SHA-256
+	0x0104: {hash: crypto.SHA512},            // RSASSA-PKCS1-v1_5 with SHA-512
+	0x0201: {hash: crypto.SHA256},            // ECDSA with SHA-256
+	0x0202: {hash: crypto.SHA512},            // ECDSA with SHA-512
+}
+
+// VerifyAPK checks the APK Signature Scheme v3 signature of the APK in r, or
+// its v2 signature if it has no v3 one, and returns the certificate of each
+// signer. Every signer's signature over its signed data and the digest of
+// the APK's contents must be valid; any change to the contents after signing
+// invalidates them.
+func VerifyAPK(r io.ReaderAt, size int64) ([]*x509.Certificate, error) {
+	layout, err := readAPKLayout(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	block, ok := layout.blocks[apkSignatureSchemeV3]
+	v3 := ok
+	if !ok {
+		if block, ok = layout.blocks[apkSignatureSchemeV2]; !ok {
+			return nil, ErrNoAPKSignature
+		}
+	}
+
+	signers, err := lengthPrefixed(&block)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signer list: %w", err)
+	}
+
+	var certs []*x509.Certificate
+	digests := make(map[crypto.Hash][]byte) // content digests, computed once per hash
+	for len(signers) > 0 {
+		signer, err := lengthPrefixed(&signers)
+		if err != nil {
+			return nil, fmt.Errorf("malformed signer: %w", err)
+		}
+		cert, err := verifyAPKSigner(signer, v3, layout, r, digests)
+		if err != nil {
+			return nil, fmt.Errorf("signer %d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no signers")
+	}
+	return certs, nil
+}
+
+// verifyAPKSigner checks one signer and returns its certificate
+func verifyAPKSigner(signer []byte, v3 bool, layout *apkLayout, r io.ReaderAt, digests map[crypto.Hash][]byte) (*x509.Certificate, error) {
+	signedData, err := lengthPrefixed(&signer)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signed data: %w", err)
+	}
+	if v3 {
+		// minSdkVersion and maxSdkVersion
+		if len(signer) < 8 {
+			return nil, errors.New("malformed signer")
+		}
+		signer = signer[8:]
+	}
+	signatures, err := lengthPrefixed(&signer)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signatures: %w", err)
+	}
+	publicKeyDER, err := lengthPrefixed(&signer)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	// Verify the signatures over the signed data with the supported algorithms
+	verified := make(map[uint32]bool)
+	for len(signatures) > 0 {
+		entry, err := lengthPrefixed(&signatures)
+		if err != nil || len(entry) < 4 {
+			return nil, errors.New("malformed signature")
+		}
+		id := binary.LittleEndian.Uint32(entry)
+		entry = entry[4:]
+		sig, err := lengthPrefixed(&entry)
+		if err != nil {
+			return nil, errors.New("malformed signature")
+		}
+
+		alg, ok := apkAlgorithms[id]
+		if !ok {
+			continue
+		}
+		if err := verifySignature(publicKey, alg, signedData, sig); err != nil {
+			return nil, fmt.Errorf("signature 0x%04x: %w", id, err)
+		}
+		verified[id] = true
+	}
+	if len(verified) == 0 {
+		return nil, errors.New("no signature with a supported algorithm")
+	}
+
+	// The signed data is trusted from here on
+	digestList, err := lengthPrefixed(&signedData)
+	if err != nil {
+		return nil, fmt.Errorf("malformed digests: %w", err)
+	}
+	certList, err := lengthPrefixed(&signedData)
+	if err != nil {
+		return nil, fmt.Errorf("malformed certificates: %w", err)
+	}
+
+	certDER, err := lengthPrefixed(&certList)
+	if err != nil {
+		return nil, errors.New("no certificate")
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, publicKeyDER) {
+		return nil, errors.New("public key does not match certificate")
+	}
+
+	// The contents must match the signed digest of each verified algorithm
+	matched := 0
+	for len(digestList) > 0 {
+		entry, err := lengthPrefixed(&digestList)
+		if err != nil || len(entry) < 4 {
+			return nil, errors.New("malformed digest")
+		}
+		id := binary.LittleEndian.Uint32(entry)
+		entry = entry[4:]
+		want, err := lengthPrefixed(&entry)
+		if err != nil {
+			return nil, errors.New("malformed digest")
+		}
+		if !verified[id] {
+			continue
+		}
+
+		h := apkAlgorithms[id].hash
+		got, ok := digests[h]
+		if !ok {
+			if got, err = layout.contentDigest(r, h); err != nil {
+				return nil, err
+			}
+			digests[h] = got
+		}
+		if !bytes.Equal(got, want) {
+			return nil, errors.New("contents do not match the signed digest")
+		}
+		matched++
+	}
+	if matched != len(verified) {
+		return nil, errors.New("signed digests do not match the signatures")
+	}
+	return cert, nil
+}
+
+// verifySignature checks sig over data with an RSA or ECDSA public key
+func verifySignature(publicKey any, alg apkAlgorithm, data, sig []byte) error {
+	hashed := digest(alg.hash, data)
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if alg.pss {
+			return rsa.VerifyPSS(key, alg.hash, hashed, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(key, alg.hash, hashed, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// apkLayout locates the parts of an APK that the content digest covers
+type apkLayout struct {
+	signingBlockOffset int64 // start of the APK Signing Block
+	cdOffset           int64 // start of the zip central directory
+	eocdOffset         int64 // start of the end of central directory record
+	eocd               []byte
+	blocks             map[uint32][]byte // ID -> value of each signing block entry
+}
+
+// readAPKLayout finds the zip end of central directory record and the APK
+// Signing Block before the central directory
+func readAPKLayout(r io.ReaderAt, size int64) (*apkLayout, error) {
+	// The record is 22 bytes plus a comment of up to 65535 bytes
+	tailSize := min(size, 22+65535)
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read zip directory: %w", err)
+	}
+
+	eocdIndex := -1
+	for i := len(tail) - 22; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == 0x06054b50 && int(binary.LittleEndian.Uint16(tail[i+20:])) == len(tail)-i-22 {
+			eocdIndex = i
+			break
+		}
+	}
+	if eocdIndex < 0 {
+		return nil, errors.New("not a zip archive")
+	}
+
+	layout := &apkLayout{
+		eocdOffset: size - tailSize + int64(eocdIndex),
+		eocd:       tail[eocdIndex:],
+		cdOffset:   int64(binary.LittleEndian.Uint32(tail[eocdIndex+16:])),
+	}
+	if layout.cdOffset > layout.eocdOffset || layout.cdOffset < 32 {
+		return nil, ErrNoAPKSignature
+	}
+
+	// size of block (excluding this field), then the magic
+	footer := make([]byte, 24)
+	if _, err := r.ReadAt(footer, layout.cdOffset-24); err != nil {
+		return nil, fmt.Errorf("failed to read APK signing block: %w", err)
+	}
+	if string(footer[8:]) != apkSigBlockMagic {
+		return nil, ErrNoAPKSignature
+	}
+	blockSize := binary.LittleEndian.Uint64(footer)
+	if blockSize < 24 || blockSize > maxSigningBlockSize || int64(blockSize)+8 > layout.cdOffset {
+		return nil, errors.New("malformed APK signing block")
+	}
+	layout.signingBlockOffset = layout.cdOffset - int64(blockSize) - 8
+
+	block := make([]byte, blockSize+8)
+	if _, err := r.ReadAt(block, layout.signingBlockOffset); err != nil {
+		return nil, fmt.Errorf("failed to read APK signing block: %w", err)
+	}
+	if binary.LittleEndian.Uint64(block) != blockSize {
+		return nil, errors.New("malformed APK signing block")
+	}
+
+	// uint64-length-prefixed ID-value pairs
+	layout.blocks = make(map[uint32][]byte)
+	pairs := block[8 : len(block)-24]
+	for len(pairs) > 0 {
+		if len(pairs) < 8 {
+			return nil, errors.New("malformed APK signing block")
+		}
+		n := binary.LittleEndian.Uint64(pairs)
+		pairs = pairs[8:]
+		if n < 4 || n > uint64(len(pairs)) {
+			return nil, errors.New("malformed APK signing block")
+		}
+		layout.blocks[binary.LittleEndian.Uint32(pairs)] = pairs[4:n]
+		pairs = pairs[n:]
+	}
+	return layout, nil
+}
+
+// apkChunkSize is the size of the chunks the content digest is computed over
+const apkChunkSize = 1024 * 1024
+
+// contentDigest computes the digest signed by APK Signature Scheme v2 and v3:
+// a digest of the digests of each 1 MB chunk of the zip entries, the central
+// directory, and the end of central directory record with its central
+// directory offset pointing at the signing block
+func (l *apkLayout) contentDigest(r io.ReaderAt, h crypto.Hash) ([]byte, error) {
+	eocd := bytes.Clone(l.eocd)
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(l.signingBlockOffset))
+
+	sections := []*io.SectionReader{
+		io.NewSectionReader(r, 0, l.signingBlockOffset),
+		io.NewSectionReader(r, l.cdOffset, l.eocdOffset-l.cdOffset),
+		io.NewSectionReader(bytes.NewReader(eocd), 0, int64(len(eocd))),
+	}
+
+	var chunkDigests []byte
+	count := 0
+	chunk := make([]byte, apkChunkSize)
+	prefix := make([]byte, 5)
+	chunkHash := h.New()
+	for _, section := range sections {
+		for {
+			n, err := io.ReadFull(section, chunk)
+			if n > 0 {
+				prefix[0] = 0xa5
+				binary.LittleEndian.PutUint32(prefix[1:], uint32(n))
+				chunkHash.Reset()
+				chunkHash.Write(prefix)
+				chunkHash.Write(chunk[:n])
+				chunkDigests = chunkHash.Sum(chunkDigests)
+				count++
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read APK contents: %w", err)
+			}
+		}
+	}
+
+	prefix[0] = 0x5a
+	binary.LittleEndian.PutUint32(prefix[1:], uint32(count))
+	top := h.New()
+	top.Write(prefix)
+	top.Write(chunkDigests)
+	return top.Sum(nil), nil
+}
+
+// lengthPrefixed returns the next uint32-length-prefixed value in b and
+// advances b past it
+func lengthPrefixed(b *[]byte) ([]byte, error) {
+	if len(*b) < 4 {
+		return nil, errors.New("truncated")
+	}
+	n := binary.LittleEndian.Uint32(*b)
+	if uint64(n) > uint64(len(*b)-4) {
+		return nil, errors.New("truncated")
+	}
+	value := (*b)[4 : 4+n]
+	*b = (*b)[4+n:]
+	return value, nil
+}
+
+func digest(h crypto.Hash, data []byte) []byte {
+	hasher := h.New()
+	hasher.Write(data)
+	return hasher.Sum(nil)
+}
@@ -0,0 +1,205 @@
+// Package signing checks that artifacts were signed by a trusted party
+// before midway caches them, rejecting tampered builds.
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+)
+
+// SignatureSuffix is appended to an object's key to find its detached
+// signature.
+const SignatureSuffix = ".sig"
+
+// maxSignatureSize bounds the size of a detached signature file
+const maxSignatureSize = 64 * 1024
+
+// Config describes which objects are verified and who is trusted.
+type Config struct {
+	Certificates []*x509.Certificate // trusted signing certificates
+	Buckets      []string            // glob patterns of buckets to verify, empty for all
+	Detached     bool                // verify files without an APK signature with a detached signature
+
+	// FetchSignature downloads the object at key, a detached signature
+	FetchSignature func(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// Verifier checks APK signatures and detached signatures against a set of
+// trusted certificates. It implements cache.Verifier.
+//
+// APKs (.apk) must carry an APK Signature Scheme v2 or v3 signature by a
+// trusted certificate. With Detached set, an APK without one is accepted if
+// its detached signature is valid, and every other file must have a valid
+// detached signature: the object {key}.sig next to it, holding a signature
+// of the file's SHA-256 digest by a trusted RSA (PKCS #1 v1.5) or ECDSA key,
+// as produced by `openssl dgst -sha256 -sign key.pem`, raw or base64.
+// Without Detached, other files are not verified.
+type Verifier struct {
+	cfg Config
+}
+
+// New creates a Verifier.
+func New(cfg Config) *Verifier {
+	return &Verifier{cfg: cfg}
+}
+
+// LoadCertificates reads PEM-encoded certificates from files. A file may hold
+// several certificates.
+func LoadCertificates(paths []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate file: %w", err)
+		}
+
+		found := 0
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse certificate in %s: %w", p, err)
+			}
+			certs = append(certs, cert)
+			found++
+		}
+		if found == 0 {
+			return nil, fmt.Errorf("no PEM certificates in %s", p)
+		}
+	}
+	return certs, nil
+}
+
+// Applies reports whether key is verified.
+func (v *Verifier) Applies(key string) bool {
+	objectPath, _ := cache.SplitVersion(key)
+	if strings.HasSuffix(objectPath, SignatureSuffix) {
+		return false
+	}
+
+	bucket, _, _ := strings.Cut(objectPath, "/")
+	if len(v.cfg.Buckets) > 0 && !matchAny(v.cfg.Buckets, bucket) {
+		return false
+	}
+	return isAPK(objectPath) || v.cfg.Detached
+}
+
+// Verify checks the signature of key, whose contents are in r.
+func (v *Verifier) Verify(key string, r io.ReaderAt, size int64) error {
+	objectPath, _ := cache.SplitVersion(key)
+
+	var apkErr error
+	if isAPK(objectPath) {
+		apkErr = v.verifyAPK(r, size)
+		if apkErr == nil || !v.cfg.Detached {
+			return apkErr
+		}
+	}
+
+	if err := v.verifyDetached(objectPath, r, size); err != nil {
+		if apkErr != nil {
+			return fmt.Errorf("%v; %v", apkErr, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// verifyAPK checks that the APK was signed by a trusted certificate
+func (v *Verifier) verifyAPK(r io.ReaderAt, size int64) error {
+	certs, err := VerifyAPK(r, size)
+	if err != nil {
+		return fmt.Errorf("APK signature: %w", err)
+	}
+	for _, cert := range certs {
+		if v.trusted(cert) {
+			return nil
+		}
+	}
+	return fmt.Errorf("APK signature: signed by untrusted certificate %q", certs[0].Subject.String())
+}
+
+// verifyDetached checks the signature stored next to objectPath
+func (v *Verifier) verifyDetached(objectPath string, r io.ReaderAt, size int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	body, err := v.cfg.FetchSignature(ctx, objectPath+SignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("detached signature: %w", err)
+	}
+	sig, err := io.ReadAll(io.LimitReader(body, maxSignatureSize))
+	body.Close()
+	if err != nil {
+		return fmt.Errorf("detached signature: %w", err)
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig))); err == nil {
+		sig = decoded
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return fmt.Errorf("detached signature: failed to read contents: %w", err)
+	}
+	hashed := h.Sum(nil)
+
+	for _, cert := range v.cfg.Certificates {
+		switch key := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed, sig) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, hashed, sig) {
+				return nil
+			}
+		}
+	}
+	return errors.New("detached signature: not signed by a trusted certificate")
+}
+
+// trusted reports whether cert is one of the trusted certificates
+func (v *Verifier) trusted(cert *x509.Certificate) bool {
+	for _, trusted := range v.cfg.Certificates {
+		if cert.Equal(trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAPK(objectPath string) bool {
+	return strings.EqualFold(path.Ext(objectPath), ".apk")
+}
+
+func matchAny(patterns []string, bucket string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, bucket); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/autonoma-ai/midway/cache/simulator"
+)
+
+// runSimulate replays the requests in midway logs against caches of each
+// combination of the given sizes and policies, and prints how each would
+// have done.
+func runSimulate(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	sizes := fs.String("sizes", "25,50,100,200", "comma-separated cache sizes in gigabytes")
+	policies := fs.String("policies", "lru,lfu,arc,gdsf", "comma-separated eviction policies")
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: midway simulate [flags] LOG... (- for stdin)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var configs []simulator.Config
+	for _, size := range strings.Split(*sizes, ",") {
+		gb, err := strconv.ParseFloat(strings.TrimSpace(size), 64)
+		if err != nil || gb <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid cache size %q\n", size)
+			os.Exit(2)
+		}
+		for _, policy := range strings.Split(*policies, ",") {
+			configs = append(configs, simulator.Config{MaxBytes: int64(gb * (1 << 30)), Policy: strings.TrimSpace(policy)})
+		}
+	}
+
+	var requests []simulator.Request
+	unsized := 0
+	for _, name := range fs.Args() {
+		log, err := readSimulatorLog(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		requests = append(requests, log.Requests...)
+		unsized += log.Unsized
+	}
+	// Logs of several nodes or rotated files are replayed as one stream
+	slices.SortStableFunc(requests, func(a, b simulator.Request) int { return a.Time.Compare(b.Time) })
+
+	results, err := simulator.Run(requests, configs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+
+	fmt.Printf("%d requests replayed", len(requests))
+	if unsized > 0 {
+		fmt.Printf(", %d left out as their objects' sizes weren't logged", unsized)
+	}
+	fmt.Print("\n\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "SIZE\tPOLICY\tHIT RATE\tBYTE HIT RATE\tDOWNLOADED\tEVICTIONS\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%.0f GB\t%s\t%.1f%%\t%.1f%%\t%.2f GB\t%d\t\n",
+			float64(r.MaxBytes)/(1<<30), r.Policy, r.HitRate*100, r.ByteHitRate*100, float64(r.DownloadedBytes)/(1<<30), r.Evictions)
+	}
+	w.Flush()
+}
+
+// readSimulatorLog reads the requests in the log file name, or stdin for -
+func readSimulatorLog(name string) (*simulator.Log, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return simulator.ReadLog(r)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/autonoma-ai/midway/cache"
+	"github.com/autonoma-ai/midway/config"
+	"github.com/autonoma-ai/midway/server"
+	"github.com/autonoma-ai/midway/signing"
+)
+
+// validateTimeout bounds each check that talks to AWS
+const validateTimeout = 30 * time.Second
+
+// runValidate checks that a node could start and serve with the
+// configuration: that it is valid, the cache directory is usable and large
+// enough, and the credentials can list the buckets. It exits non-zero if any
+// check fails, so deployment pipelines can run it before a rollout.
+func runValidate(ctx context.Context, args []string) {
+	flagSet, flags := newFlagSet("validate")
+	buckets := flagSet.String("buckets", "", "comma-separated buckets to check access to, besides server.allowedBuckets")
+	skipS3 := flagSet.Bool("skip-s3", false, "don't check AWS credentials and bucket access")
+	flagSet.Parse(args)
+
+	cfg, err := flags.load()
+	if err != nil {
+		fmt.Printf("FAIL  configuration: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("ok    configuration")
+
+	failed := false
+	report := func(name string, err error, hint string) {
+		if err == nil {
+			fmt.Printf("ok    %s\n", name)
+			return
+		}
+		failed = true
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+		if hint != "" {
+			fmt.Printf("      %s\n", hint)
+		}
+	}
+
+	if enc := cfg.Cache.Encryption; enc.KeyFile != "" {
+		if _, err := os.Stat(enc.KeyFile); enc.KMSKeyID != "" && errors.Is(err, os.ErrNotExist) {
+			// Loading it would generate and store a new data key
+			fmt.Println("skip  cache encryption key: a data key is generated with KMS at first start")
+		} else {
+			_, err := server.LoadEncryptionKey(ctx, cfg)
+			report("cache encryption key", err, "")
+		}
+	}
+	if len(cfg.Cache.Verify.Certificates) > 0 {
+		_, err := signing.LoadCertificates(cfg.Cache.Verify.Certificates)
+		report("signing certificates", err, "check the files in cache.verify.certificates are readable PEM certificates")
+	}
+
+	name := "cache directory " + cfg.Cache.Dir
+	if err := checkCacheDir(cfg.Cache.Dir); err != nil {
+		report(name, err, "create the directory, or give the user midway runs as write access to it")
+	} else {
+		report(name, checkCacheCapacity(cfg), "lower cache.maxSizeGB, or put the cache on a larger volume")
+	}
+
+	if !*skipS3 {
+		checkS3(ctx, cfg, bucketsToCheck(cfg, *buckets), report)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+	fmt.Println("configuration is ready to deploy")
+}
+
+// checkCacheDir creates dir if needed and checks files can be written in it
+func checkCacheDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".validate-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkCacheCapacity checks the filesystem holding the cache can fit its
+// size limit
+func checkCacheCapacity(cfg *config.Config) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(cfg.Cache.Dir, &stat); err != nil {
+		return err
+	}
+	const gb = 1 << 30
+	limit := uint64(cfg.Cache.MaxSizeGB) * gb
+	total := stat.Blocks * uint64(stat.Bsize)
+	if total < limit {
+		return fmt.Errorf("the filesystem holds %.1f GB, less than cache.maxSizeGB of %d GB", float64(total)/gb, cfg.Cache.MaxSizeGB)
+	}
+
+	// Space the cache already takes counts towards its limit
+	used := dirSize(cfg.Cache.Dir)
+	if free := stat.Bavail * uint64(stat.Bsize); free+used < limit {
+		return fmt.Errorf("%.1f GB is free and the cache holds %.1f GB, less than cache.maxSizeGB of %d GB", float64(free)/gb, float64(used)/gb, cfg.Cache.MaxSizeGB)
+	}
+	return nil
+}
+
+// dirSize returns the bytes taken by the files under dir
+func dirSize(dir string) uint64 {
+	var size uint64
+	filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += uint64(info.Size())
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// bucketsToCheck returns the allowed buckets and those named by the
+// -buckets flag
+func bucketsToCheck(cfg *config.Config, flag string) []string {
+	buckets := slices.Clone(cfg.Server.AllowedBuckets)
+	for _, bucket := range strings.Split(flag, ",") {
+		if bucket = strings.TrimSpace(bucket); bucket != "" {
+			buckets = append(buckets, bucket)
+		}
+	}
+	slices.Sort(buckets)
+	return slices.Compact(buckets)
+}
+
+// checkS3 checks credentials can be found and list each of buckets
+func checkS3(ctx context.Context, cfg *config.Config, buckets []string, report func(name string, err error, hint string)) {
+	awsCfg, err := server.LoadAWSConfig(ctx, cfg)
+	if err != nil {
+		report("AWS configuration", err, "check the AWS_* environment variables and shared config files")
+		return
+	}
+
+	credCtx, cancel := context.WithTimeout(ctx, validateTimeout)
+	creds, err := awsCfg.Credentials.Retrieve(credCtx)
+	cancel()
+	if err != nil {
+		report("AWS credentials", err, "give the node an IAM role, or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or AWS_PROFILE")
+		return
+	}
+	report("AWS credentials from "+creds.Source, nil, "")
+
+	if len(buckets) == 0 {
+		fmt.Println("skip  bucket access: server.allowedBuckets is empty; name buckets to check with -buckets")
+		return
+	}
+
+	downloader := cache.NewS3Downloader(awsCfg, server.DownloaderOptions(cfg)...)
+	for _, bucket := range buckets {
+		checkCtx, cancel := context.WithTimeout(ctx, validateTimeout)
+		err := downloader.CheckAccess(checkCtx, bucket)
+		cancel()
+		report("bucket "+bucket, err, accessHint(bucket, err))
+	}
+}
+
+// accessHint suggests how to fix a failure to list bucket
+func accessHint(bucket string, err error) string {
+	if err == nil {
+		return ""
+	}
+	switch cache.ClassifyError(err) {
+	case cache.ErrorAccessDenied:
+		return fmt.Sprintf("grant s3:ListBucket on arn:aws:s3:::%s and s3:GetObject on arn:aws:s3:::%s/* to the node's credentials, or configure an aws.roles entry for the bucket", bucket, bucket)
+	case cache.ErrorNotFound:
+		return "check the bucket name; it doesn't exist"
+	case cache.ErrorUnavailable, cache.ErrorTimeout:
+		return "check the node can reach S3, directly or through aws.proxy"
+	}
+	return ""
+}